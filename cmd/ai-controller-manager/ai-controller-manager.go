@@ -8,10 +8,12 @@ import (
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
+	aiserverconfig "github.com/wongearl/go-restful-template/pkg/aiserver/config"
 	corev1 "github.com/wongearl/go-restful-template/pkg/api/core.ai.io/v1"
 	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
 	"github.com/wongearl/go-restful-template/pkg/controllers/common"
 	"github.com/wongearl/go-restful-template/pkg/controllers/core"
+	globalrolectrl "github.com/wongearl/go-restful-template/pkg/controllers/globalrole"
 	loginrecordctrl "github.com/wongearl/go-restful-template/pkg/controllers/loginrecord"
 	userctrl "github.com/wongearl/go-restful-template/pkg/controllers/user"
 	"github.com/wongearl/go-restful-template/pkg/utils/sliceutil"
@@ -112,12 +114,18 @@ func main() {
 }
 
 func addDefaults(mgr ctrl.Manager) {
-	var err error
+	// the limits of failed login attempts are shared with ai-server
+	conf, err := aiserverconfig.TryLoadFromDisk()
+	if err != nil {
+		setupLog.Error(err, "unable to load configuration, the default authentication options are used")
+		conf = aiserverconfig.New()
+	}
 
 	if err = (&userctrl.UserReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("User"),
-		Scheme: mgr.GetScheme(),
+		Client:                mgr.GetClient(),
+		Log:                   ctrl.Log.WithName("controllers").WithName("User"),
+		Scheme:                mgr.GetScheme(),
+		AuthenticationOptions: conf.AuthenticationOptions,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "User")
 		os.Exit(1)
@@ -131,6 +139,15 @@ func addDefaults(mgr ctrl.Manager) {
 		setupLog.Error(err, "unable to create controller", "controller", "LoginRecord")
 		os.Exit(1)
 	}
+
+	if err = (&globalrolectrl.GlobalRoleReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("GlobalRole"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "GlobalRole")
+		os.Exit(1)
+	}
 }
 
 func putFeaturedReconciler(controllers map[string][]common.FeaturedReconciler, reconciler common.FeaturedReconciler) {
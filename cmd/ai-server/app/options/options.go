@@ -8,6 +8,11 @@ import (
 	"strings"
 
 	aiserver "github.com/wongearl/go-restful-template/pkg/aiserver"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/identityprovider"
+	_ "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/identityprovider/github"
+	_ "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/identityprovider/ldap"
+	_ "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/identityprovider/oidc"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/token"
 	aiserverconfig "github.com/wongearl/go-restful-template/pkg/aiserver/config"
 	"github.com/wongearl/go-restful-template/pkg/client/cache"
 	"github.com/wongearl/go-restful-template/pkg/client/informers"
@@ -68,6 +73,16 @@ func (s *ServerRunOptions) NewAPIServer(stopCh <-chan struct{}) (*aiserver.APISe
 	}
 	apiServer.CacheClient = cacheClient
 
+	signingKeys, err := token.LoadKeySet(s.AuthenticationOptions.SigningKeys, kubernetesClient.Kubernetes().CoreV1())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing keys, error: %v", err)
+	}
+	apiServer.SigningKeys = signingKeys
+
+	if err = identityprovider.SetupWithOptions(s.AuthenticationOptions.OAuthOptions.IdentityProviders); err != nil {
+		return nil, fmt.Errorf("failed to setup identity providers, error: %v", err)
+	}
+
 	server := &http.Server{
 		Addr: fmt.Sprintf(":%d", s.GenericServerRunOptions.InsecurePort),
 	}
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authorization/authorizer"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authorization/rbac"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/config"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/query"
 	apirequest "github.com/wongearl/go-restful-template/pkg/aiserver/request"
@@ -32,6 +33,10 @@ type PasswordReset struct {
 	Password        string `json:"password"`
 }
 
+type MFAVerification struct {
+	Code string `json:"code"`
+}
+
 type UserDetails struct {
 	Username       string            `json:"username"`
 	Email          string            `json:"email"`
@@ -40,18 +45,27 @@ type UserDetails struct {
 }
 
 type iamHandler struct {
-	am         am.AccessManagementInterface
-	im         im.IdentityManagementInterface
-	option     *config.AiOptions
-	authorizer authorizer.Authorizer
+	am                  am.AccessManagementInterface
+	im                  im.IdentityManagementInterface
+	option              *config.AiOptions
+	authorizer          authorizer.Authorizer
+	ruleResolver        authorizer.RuleResolver
+	tokenOperator       auth.TokenManagementInterface
+	mfaOperator         auth.MFAManagementInterface
+	accessTokenOperator auth.AccessTokenManagementInterface
 }
 
-func newIAMHandler(im im.IdentityManagementInterface, am am.AccessManagementInterface, option *config.AiOptions, authorizer authorizer.Authorizer) *iamHandler {
+func newIAMHandler(im im.IdentityManagementInterface, am am.AccessManagementInterface, option *config.AiOptions, authorizer authorizer.Authorizer,
+	ruleResolver authorizer.RuleResolver, tokenOperator auth.TokenManagementInterface, mfaOperator auth.MFAManagementInterface, accessTokenOperator auth.AccessTokenManagementInterface) *iamHandler {
 	return &iamHandler{
-		am:         am,
-		im:         im,
-		option:     option,
-		authorizer: authorizer,
+		am:                  am,
+		im:                  im,
+		option:              option,
+		authorizer:          authorizer,
+		ruleResolver:        ruleResolver,
+		tokenOperator:       tokenOperator,
+		mfaOperator:         mfaOperator,
+		accessTokenOperator: accessTokenOperator,
 	}
 }
 
@@ -198,7 +212,7 @@ func (h *iamHandler) CreateUser(req *restful.Request, resp *restful.Response) {
 
 	created, err := h.im.CreateUser(&user)
 	if err != nil {
-		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		writePasswordError(resp, err)
 		return
 	}
 
@@ -292,11 +306,120 @@ func (h *iamHandler) ModifyPassword(req *restful.Request, resp *restful.Response
 		}
 	}
 
-	err = h.im.ModifyPassword(username, passwordReset.Password)
+	if err = h.im.ModifyPassword(username, passwordReset.Password); err != nil {
+		writePasswordError(resp, err)
+		return
+	}
+	api.NewEmptyResult().WriteTo(resp)
+	return
+}
+
+// writePasswordError responds the violations of the password policy as the data of the result
+func writePasswordError(resp *restful.Response, err error) {
+	if policyErr, ok := err.(*auth.PasswordPolicyError); ok {
+		result := api.NewResult[*auth.PasswordPolicyError]().WithError(err)
+		result.Data = policyErr
+		result.WriteTo(resp)
+		return
+	}
 	api.NewEmptyResult().WithError(err).WriteTo(resp)
+}
+
+func (h *iamHandler) UnlockUser(req *restful.Request, resp *restful.Response) {
+	username := req.PathParameter("user")
+	unlocked, err := h.im.UnlockUser(username)
+	api.NewResult[*iamv1.User]().WithObject(unlocked).WithError(err).WriteTo(resp)
 	return
 }
 
+func (h *iamHandler) DescribeMFA(req *restful.Request, resp *restful.Response) {
+	username := req.PathParameter("user")
+	status, err := h.mfaOperator.Status(username)
+	api.NewResult[*auth.MFAStatus]().WithObject(status).WithError(err).WriteTo(resp)
+}
+
+func (h *iamHandler) EnrollMFA(req *restful.Request, resp *restful.Response) {
+	username := req.PathParameter("user")
+	enrollment, err := h.mfaOperator.Enroll(username)
+	api.NewResult[*auth.MFAEnrollment]().WithObject(enrollment).WithError(err).WriteTo(resp)
+}
+
+func (h *iamHandler) VerifyMFA(req *restful.Request, resp *restful.Response) {
+	username := req.PathParameter("user")
+	var verification MFAVerification
+	if err := req.ReadEntity(&verification); err != nil {
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+	if err := h.mfaOperator.Activate(username, verification.Code); err != nil {
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+	status, err := h.mfaOperator.Status(username)
+	api.NewResult[*auth.MFAStatus]().WithObject(status).WithError(err).WriteTo(resp)
+}
+
+func (h *iamHandler) DisableMFA(req *restful.Request, resp *restful.Response) {
+	username := req.PathParameter("user")
+	// the enrollment not activated yet can be cancelled without the code
+	var verification MFAVerification
+	if req.Request.ContentLength != 0 {
+		if err := req.ReadEntity(&verification); err != nil {
+			api.NewEmptyResult().WithError(err).WriteTo(resp)
+			return
+		}
+	}
+	err := h.mfaOperator.Disable(username, verification.Code)
+	api.NewEmptyResult().WithError(err).WriteTo(resp)
+}
+
+// CreateAccessToken creates the token for the current user only, since the token is returned to the operator
+func (h *iamHandler) CreateAccessToken(req *restful.Request, resp *restful.Response) {
+	username := req.PathParameter("user")
+	var request auth.AccessTokenRequest
+	if err := req.ReadEntity(&request); err != nil {
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+
+	operator, ok := apirequest.UserFrom(req.Request.Context())
+	if !ok {
+		err := errors.NewInternalError(fmt.Errorf("cannot obtain user info"))
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+	if operator.GetName() != username {
+		err := errors.NewForbidden(iamv1.Resource(iamv1.ResourcePluralAccessToken), "", fmt.Errorf("access tokens can only be created by the owner"))
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+	// a scoped token can not create a token with more permissions
+	if _, scoped := operator.GetExtra()[iamv1.AccessTokenScopesExtraKey]; scoped && len(request.Scopes) == 0 {
+		err := errors.NewForbidden(iamv1.Resource(iamv1.ResourcePluralAccessToken), "", fmt.Errorf("scopes are required to create access tokens with a scoped access token"))
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+	if err := rbac.ScopesCovered(h.authorizer, operator, request.Scopes); err != nil {
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+
+	issued, err := h.accessTokenOperator.Create(username, &request)
+	api.NewResult[*auth.IssuedAccessToken]().WithObject(issued).WithError(err).WriteTo(resp)
+}
+
+func (h *iamHandler) ListAccessTokens(req *restful.Request, resp *restful.Response) {
+	username := req.PathParameter("user")
+	accessTokens, err := h.accessTokenOperator.List(username)
+	api.NewResult[iamv1.AccessToken]().WithList(accessTokens).WithError(err).WriteTo(resp)
+}
+
+func (h *iamHandler) RevokeAccessToken(req *restful.Request, resp *restful.Response) {
+	username := req.PathParameter("user")
+	err := h.accessTokenOperator.Revoke(username, req.PathParameter("accesstoken"))
+	api.NewEmptyResult().WithError(err).WriteTo(resp)
+}
+
 func (h *iamHandler) DeleteUser(req *restful.Request, resp *restful.Response) {
 	username := req.PathParameter("user")
 
@@ -427,3 +550,25 @@ func (h *iamHandler) ListUserLoginRecords(req *restful.Request, resp *restful.Re
 	api.NewResult[iamv1.LoginRecord]().WithListAndFilter(result.Items, req).WithError(err).WriteTo(resp)
 	return
 }
+
+func (h *iamHandler) ListUserSessions(req *restful.Request, resp *restful.Response) {
+	username := req.PathParameter("user")
+	if _, err := h.im.DescribeUser(username); err != nil {
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+	sessions, err := h.tokenOperator.ListSessions(username)
+	api.NewResult[auth.Session]().WithList(sessions).WithError(err).WriteTo(resp)
+	return
+}
+
+func (h *iamHandler) RevokeUserSessions(req *restful.Request, resp *restful.Response) {
+	username := req.PathParameter("user")
+	if _, err := h.im.DescribeUser(username); err != nil {
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+	_, err := h.tokenOperator.RevokeAllFor(username)
+	api.NewEmptyResult().WithError(err).WriteTo(resp)
+	return
+}
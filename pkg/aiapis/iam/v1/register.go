@@ -1,14 +1,19 @@
 package v1
 
 import (
+	"net/http"
+
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authorization/authorizer"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/config"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/runtime"
+	"github.com/wongearl/go-restful-template/pkg/api"
 	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	"github.com/wongearl/go-restful-template/pkg/middles/auth"
 	"github.com/wongearl/go-restful-template/pkg/middles/iam/am"
 	"github.com/wongearl/go-restful-template/pkg/middles/iam/im"
 
 	"github.com/emicklei/go-restful"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
@@ -18,9 +23,10 @@ const (
 
 var GroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1"}
 
-func AddToContainer(container *restful.Container, im im.IdentityManagementInterface, am am.AccessManagementInterface, option *config.AiOptions, authorizer authorizer.Authorizer) error {
+func AddToContainer(container *restful.Container, im im.IdentityManagementInterface, am am.AccessManagementInterface, option *config.AiOptions, authorizer authorizer.Authorizer,
+	ruleResolver authorizer.RuleResolver, tokenOperator auth.TokenManagementInterface, mfaOperator auth.MFAManagementInterface, accessTokenOperator auth.AccessTokenManagementInterface) error {
 	ws := runtime.NewWebService(GroupVersion)
-	handler := newIAMHandler(im, am, option, authorizer)
+	handler := newIAMHandler(im, am, option, authorizer, ruleResolver, tokenOperator, mfaOperator, accessTokenOperator)
 
 	// users
 	ws.Route(ws.POST("/users").
@@ -41,6 +47,30 @@ func AddToContainer(container *restful.Container, im im.IdentityManagementInterf
 		Reads(PasswordReset{}).
 		Param(ws.PathParameter("user", "username")).
 		Doc("Reset password of the specified user."))
+	ws.Route(ws.POST("/users/{user}/unlock").
+		To(handler.UnlockUser).
+		Param(ws.PathParameter("user", "username")).
+		Doc("Unlock the user blocked by failed login attempts."))
+	ws.Route(ws.GET("/users/{user}/mfa").
+		To(handler.DescribeMFA).
+		Param(ws.PathParameter("user", "username")).
+		Doc("Retrieve the multi-factor authentication status of the specified user."))
+	ws.Route(ws.POST("/users/{user}/mfa").
+		To(handler.EnrollMFA).
+		Param(ws.PathParameter("user", "username")).
+		Doc("Enroll a TOTP authenticator, the secret and the recovery codes are only returned once. " +
+			"MFA is enabled after the code of the authenticator is verified."))
+	ws.Route(ws.POST("/users/{user}/mfa/verify").
+		To(handler.VerifyMFA).
+		Reads(MFAVerification{}).
+		Param(ws.PathParameter("user", "username")).
+		Doc("Verify the code of the enrolled TOTP authenticator to enable multi-factor authentication."))
+	ws.Route(ws.DELETE("/users/{user}/mfa").
+		To(handler.DisableMFA).
+		Reads(MFAVerification{}).
+		Param(ws.PathParameter("user", "username")).
+		Doc("Disable multi-factor authentication of the specified user, the current code of the TOTP authenticator " +
+			"or a recovery code is required once MFA is enabled."))
 	ws.Route(ws.GET("/users/{user}").
 		To(handler.DescribeUser).
 		Param(ws.PathParameter("user", "username")).
@@ -59,6 +89,33 @@ func AddToContainer(container *restful.Container, im im.IdentityManagementInterf
 		Param(ws.PathParameter("user", "username of the user")).
 		Doc("List login records of the specified user."))
 
+	// sessions
+	ws.Route(ws.GET("/users/{user}/sessions").
+		To(handler.ListUserSessions).
+		Param(ws.PathParameter("user", "username of the user")).
+		Doc("List the active sessions of the specified user."))
+	ws.Route(ws.DELETE("/users/{user}/sessions").
+		To(handler.RevokeUserSessions).
+		Param(ws.PathParameter("user", "username of the user")).
+		Doc("Revoke all the sessions of the specified user, the user has to login again."))
+
+	// accesstokens
+	ws.Route(ws.POST("/users/{user}/accesstokens").
+		To(handler.CreateAccessToken).
+		Reads(auth.AccessTokenRequest{}).
+		Param(ws.PathParameter("user", "username of the user")).
+		Doc("Create a personal access token for the current user, the token is only returned once. " +
+			"The scopes must be a subset of the permissions of the user."))
+	ws.Route(ws.GET("/users/{user}/accesstokens").
+		To(handler.ListAccessTokens).
+		Param(ws.PathParameter("user", "username of the user")).
+		Doc("List the personal access tokens of the specified user."))
+	ws.Route(ws.DELETE("/users/{user}/accesstokens/{accesstoken}").
+		To(handler.RevokeAccessToken).
+		Param(ws.PathParameter("user", "username of the user")).
+		Param(ws.PathParameter("accesstoken", "name of the access token")).
+		Doc("Revoke the personal access token of the specified user."))
+
 	// namespacemembers
 	ws.Route(ws.GET("/namespaces/{namespace}/members").
 		To(handler.ListNamespaceMembers).
@@ -91,6 +148,19 @@ func AddToContainer(container *restful.Container, im im.IdentityManagementInterf
 		To(handler.ListGlobalRoles).
 		Doc("List all global roles."))
 
+	// reviews
+	ws.Route(ws.POST("/subjectaccessreviews").
+		To(handler.CreateSubjectAccessReview).
+		Reads(authorizationv1.SubjectAccessReview{}).
+		Returns(http.StatusOK, api.StatusOK, authorizationv1.SubjectAccessReview{}).
+		Doc("Check whether the user is allowed to perform the action, the reason of the decision and the evaluation error are returned in the status."))
+	ws.Route(ws.POST("/selfsubjectrulesreviews").
+		To(handler.CreateSelfSubjectRulesReview).
+		Reads(SelfSubjectRulesReview{}).
+		Returns(http.StatusOK, api.StatusOK, SelfSubjectRulesReview{}).
+		Doc("List the rules and the rego policies granted to the current user in the namespace, " +
+			"or the global and cluster wide ones if the namespace is empty."))
+
 	// roles
 	ws.Route(ws.GET("/namespaces/{namespace}/roles").
 		To(handler.ListRoles).
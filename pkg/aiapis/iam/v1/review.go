@@ -0,0 +1,154 @@
+package v1
+
+import (
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authorization/authorizer"
+	apirequest "github.com/wongearl/go-restful-template/pkg/aiserver/request"
+	"github.com/wongearl/go-restful-template/pkg/api"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+
+	restful "github.com/emicklei/go-restful"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	authuser "k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/klog"
+)
+
+// SelfSubjectRulesReview enumerates the actions the current user can perform within a namespace,
+// the rego policies of the roles are returned as well since they can not be expressed as rules.
+type SelfSubjectRulesReview struct {
+	Spec   authorizationv1.SelfSubjectRulesReviewSpec `json:"spec"`
+	Status SubjectRulesReviewStatus                   `json:"status,omitempty"`
+}
+
+type SubjectRulesReviewStatus struct {
+	authorizationv1.SubjectRulesReviewStatus `json:",inline"`
+	RegoPolicies                             []string `json:"regoPolicies,omitempty"`
+}
+
+// CreateSubjectAccessReview checks whether the user of the review is allowed to perform the action,
+// the groups of the user account are used if no groups are specified.
+func (h *iamHandler) CreateSubjectAccessReview(req *restful.Request, resp *restful.Response) {
+	review := &authorizationv1.SubjectAccessReview{}
+	if err := req.ReadEntity(review); err != nil {
+		api.NewEmptyResult().WithError(errors.NewBadRequest(err.Error())).WriteTo(resp)
+		return
+	}
+
+	spec := review.Spec
+	if spec.User == "" {
+		api.NewEmptyResult().WithError(errors.NewBadRequest("spec.user is required")).WriteTo(resp)
+		return
+	}
+	if (spec.ResourceAttributes == nil) == (spec.NonResourceAttributes == nil) {
+		err := errors.NewBadRequest("exactly one of spec.resourceAttributes and spec.nonResourceAttributes must be specified")
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+
+	groups := spec.Groups
+	if len(groups) == 0 {
+		user, err := h.im.DescribeUser(spec.User)
+		if err != nil && !errors.IsNotFound(err) {
+			klog.Error(err)
+			api.NewEmptyResult().WithError(err).WriteTo(resp)
+			return
+		}
+		if user != nil {
+			groups = append(append([]string{}, user.Spec.Groups...), authuser.AllAuthenticated)
+		}
+	}
+	extra := make(map[string][]string, len(spec.Extra))
+	for key, value := range spec.Extra {
+		extra[key] = value
+	}
+
+	attributes := authorizer.AttributesRecord{
+		User: &authuser.DefaultInfo{Name: spec.User, UID: spec.UID, Groups: groups, Extra: extra},
+	}
+	if resourceAttributes := spec.ResourceAttributes; resourceAttributes != nil {
+		attributes.Verb = resourceAttributes.Verb
+		attributes.Namespace = resourceAttributes.Namespace
+		attributes.APIGroup = resourceAttributes.Group
+		attributes.APIVersion = resourceAttributes.Version
+		attributes.Resource = resourceAttributes.Resource
+		attributes.Subresource = resourceAttributes.Subresource
+		attributes.Name = resourceAttributes.Name
+		attributes.ResourceRequest = true
+		attributes.ResourceScope = resourceScopeOf(resourceAttributes)
+	} else {
+		attributes.Verb = spec.NonResourceAttributes.Verb
+		attributes.Path = spec.NonResourceAttributes.Path
+	}
+
+	decision, reason, err := h.authorizer.Authorize(attributes)
+	review.Status = authorizationv1.SubjectAccessReviewStatus{
+		Allowed: decision == authorizer.DecisionAllow,
+		Denied:  decision == authorizer.DecisionDeny,
+		Reason:  reason,
+	}
+	if err != nil {
+		review.Status.EvaluationError = err.Error()
+	}
+	api.NewResult[*authorizationv1.SubjectAccessReview]().WithObject(review).WriteTo(resp)
+}
+
+// CreateSelfSubjectRulesReview returns the rules and the rego policies granted to the current user in the namespace,
+// or the global and cluster wide ones if the namespace is empty.
+func (h *iamHandler) CreateSelfSubjectRulesReview(req *restful.Request, resp *restful.Response) {
+	operator, ok := apirequest.UserFrom(req.Request.Context())
+	if !ok || operator.GetName() == authuser.Anonymous {
+		api.NewEmptyResult().WithError(errors.NewUnauthorized("Unauthorized: user is not logged in")).WriteTo(resp)
+		return
+	}
+
+	review := &SelfSubjectRulesReview{}
+	if err := req.ReadEntity(review); err != nil {
+		api.NewEmptyResult().WithError(errors.NewBadRequest(err.Error())).WriteTo(resp)
+		return
+	}
+
+	resourceRules, nonResourceRules, incomplete, err := h.ruleResolver.RulesFor(operator, review.Spec.Namespace)
+	review.Status = SubjectRulesReviewStatus{
+		SubjectRulesReviewStatus: authorizationv1.SubjectRulesReviewStatus{
+			ResourceRules:    []authorizationv1.ResourceRule{},
+			NonResourceRules: []authorizationv1.NonResourceRule{},
+			Incomplete:       incomplete,
+		},
+	}
+	if err != nil {
+		review.Status.EvaluationError = err.Error()
+	}
+	for _, rule := range resourceRules {
+		review.Status.ResourceRules = append(review.Status.ResourceRules, authorizationv1.ResourceRule{
+			Verbs:         rule.GetVerbs(),
+			APIGroups:     rule.GetAPIGroups(),
+			Resources:     rule.GetResources(),
+			ResourceNames: rule.GetResourceNames(),
+		})
+	}
+	for _, rule := range nonResourceRules {
+		review.Status.NonResourceRules = append(review.Status.NonResourceRules, authorizationv1.NonResourceRule{
+			Verbs:           rule.GetVerbs(),
+			NonResourceURLs: rule.GetNonResourceURLs(),
+		})
+	}
+
+	if regoPolicyResolver, ok := h.ruleResolver.(authorizer.RegoPolicyResolver); ok {
+		// the rules and the policies are resolved from the same bindings, so are the errors
+		review.Status.RegoPolicies, _ = regoPolicyResolver.RegoPoliciesFor(operator, review.Spec.Namespace)
+	}
+	api.NewResult[*SelfSubjectRulesReview]().WithObject(review).WriteTo(resp)
+}
+
+// resourceScopeOf resolves the scope of the resource like the RequestInfoFactory does for the requests
+func resourceScopeOf(resourceAttributes *authorizationv1.ResourceAttributes) string {
+	if resourceAttributes.Namespace != "" {
+		return apirequest.NamespaceScope
+	}
+	for _, globalResource := range iamv1.GlobalResources {
+		if globalResource.Group == resourceAttributes.Group && globalResource.Resource == resourceAttributes.Resource {
+			return apirequest.GlobalScope
+		}
+	}
+	return apirequest.ClusterScope
+}
@@ -0,0 +1,103 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authorization/authorizer"
+	apirequest "github.com/wongearl/go-restful-template/pkg/aiserver/request"
+	"github.com/wongearl/go-restful-template/pkg/api"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/stretchr/testify/assert"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	authuser "k8s.io/apiserver/pkg/authentication/user"
+)
+
+type fakeRuleResolver struct {
+	regoPolicies []string
+}
+
+func (f *fakeRuleResolver) RulesFor(user authuser.Info, namespace string) ([]authorizer.ResourceRuleInfo, []authorizer.NonResourceRuleInfo, bool, error) {
+	resourceRules := []authorizer.ResourceRuleInfo{&authorizer.DefaultResourceRuleInfo{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}}
+	return resourceRules, nil, len(f.regoPolicies) > 0, fmt.Errorf("role %q not found", "missing")
+}
+
+func (f *fakeRuleResolver) RegoPoliciesFor(user authuser.Info, namespace string) ([]string, error) {
+	return f.regoPolicies, nil
+}
+
+func postReview(t *testing.T, handle restful.RouteFunction, operator authuser.Info, review interface{}) *httptest.ResponseRecorder {
+	body, err := json.Marshal(review)
+	assert.Nil(t, err)
+	httpReq := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", restful.MIME_JSON)
+	httpReq = httpReq.WithContext(apirequest.WithUser(httpReq.Context(), operator))
+	recorder := httptest.NewRecorder()
+	handle(restful.NewRequest(httpReq), restful.NewResponse(recorder))
+	return recorder
+}
+
+func TestCreateSubjectAccessReview(t *testing.T) {
+	var authorized authorizer.Attributes
+	handler := &iamHandler{authorizer: authorizer.AuthorizerFunc(func(a authorizer.Attributes) (authorizer.Decision, string, error) {
+		authorized = a
+		if a.GetVerb() == "get" {
+			return authorizer.DecisionAllow, "RBAC: allowed by ClusterRoleBinding \"view\"", nil
+		}
+		return authorizer.DecisionNoOpinion, "", fmt.Errorf("failed to compile rego policy")
+	})}
+	admin := &authuser.DefaultInfo{Name: "admin"}
+
+	recorder := postReview(t, handler.CreateSubjectAccessReview, admin, authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{User: "alice", Groups: []string{"dev"},
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "get", Resource: "pods", Namespace: "default"}},
+	})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	result, err := api.ParseResult[authorizationv1.SubjectAccessReview](recorder.Body.Bytes())
+	assert.Nil(t, err)
+	assert.True(t, result.GetData().Status.Allowed)
+	assert.Equal(t, "RBAC: allowed by ClusterRoleBinding \"view\"", result.GetData().Status.Reason)
+	assert.Equal(t, "alice", authorized.GetUser().GetName())
+	assert.Equal(t, []string{"dev"}, authorized.GetUser().GetGroups())
+	assert.Equal(t, apirequest.NamespaceScope, authorized.GetResourceScope())
+
+	recorder = postReview(t, handler.CreateSubjectAccessReview, admin, authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{User: "alice", Groups: []string{"dev"},
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "delete", Group: "iam.ai.io", Resource: "users"}},
+	})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	result, err = api.ParseResult[authorizationv1.SubjectAccessReview](recorder.Body.Bytes())
+	assert.Nil(t, err)
+	assert.False(t, result.GetData().Status.Allowed)
+	assert.Equal(t, "failed to compile rego policy", result.GetData().Status.EvaluationError)
+	assert.Equal(t, apirequest.GlobalScope, authorized.GetResourceScope())
+
+	recorder = postReview(t, handler.CreateSubjectAccessReview, admin, authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{User: "alice"},
+	})
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestCreateSelfSubjectRulesReview(t *testing.T) {
+	handler := &iamHandler{ruleResolver: &fakeRuleResolver{regoPolicies: []string{"package authz"}}}
+
+	recorder := postReview(t, handler.CreateSelfSubjectRulesReview, &authuser.DefaultInfo{Name: "alice"},
+		SelfSubjectRulesReview{Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: "default"}})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	result, err := api.ParseResult[SelfSubjectRulesReview](recorder.Body.Bytes())
+	assert.Nil(t, err)
+	status := result.GetData().Status
+	assert.Equal(t, []authorizationv1.ResourceRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}}, status.ResourceRules)
+	assert.Equal(t, []string{"package authz"}, status.RegoPolicies)
+	assert.True(t, status.Incomplete)
+	assert.Equal(t, `role "missing" not found`, status.EvaluationError)
+
+	recorder = postReview(t, handler.CreateSelfSubjectRulesReview, &authuser.DefaultInfo{Name: authuser.Anonymous},
+		SelfSubjectRulesReview{})
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
@@ -0,0 +1,188 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/identityprovider"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeIdentity struct {
+	uid      string
+	username string
+	email    string
+}
+
+func (f fakeIdentity) GetUserID() string   { return f.uid }
+func (f fakeIdentity) GetUsername() string { return f.username }
+func (f fakeIdentity) GetEmail() string    { return f.email }
+
+// fakeOAuthProvider exchanges the code "<uid>" for the identity of uid
+type fakeOAuthProvider struct {
+	identities map[string]fakeIdentity
+}
+
+func (f *fakeOAuthProvider) AuthCodeURL(state string) string {
+	return "https://idp.ai.io/authorize?state=" + state
+}
+
+func (f *fakeOAuthProvider) IdentityExchange(code string) (identityprovider.Identity, error) {
+	if identity, ok := f.identities[code]; ok {
+		return identity, nil
+	}
+	return nil, fmt.Errorf("invalid code")
+}
+
+type fakeOAuthProviderFactory struct{}
+
+func (f *fakeOAuthProviderFactory) Type() string {
+	return "FakeOAuthProvider"
+}
+
+func (f *fakeOAuthProviderFactory) Create(options oauth.DynamicOptions) (identityprovider.OAuthProvider, error) {
+	return &fakeOAuthProvider{identities: map[string]fakeIdentity{
+		"1001": {uid: "1001", username: "Alice", email: "alice@ai.io"},
+		"1002": {uid: "1002", username: "admin", email: "bob@ai.io"},
+		"1003": {uid: "1003", username: "carol", email: "carol@ai.io"},
+	}}, nil
+}
+
+func init() {
+	identityprovider.RegisterOAuthProvider(&fakeOAuthProviderFactory{})
+}
+
+func setupOAuthProviders(t *testing.T, server *testServer) {
+	server.authOptions.OAuthOptions.IdentityProviders = []oauth.IdentityProviderOptions{
+		{Name: "auto", Type: "FakeOAuthProvider", MappingMethod: oauth.MappingMethodAuto},
+		{Name: "lookup", Type: "FakeOAuthProvider", MappingMethod: oauth.MappingMethodLookup},
+	}
+	assert.Nil(t, identityprovider.SetupWithOptions(server.authOptions.OAuthOptions.IdentityProviders))
+	t.Cleanup(func() { _ = identityprovider.SetupWithOptions(nil) })
+}
+
+// oauthLogin redirects to the provider by /oauth/login, and calls back with the state issued
+func oauthLogin(t *testing.T, server *testServer, provider, code string) *http.Response {
+	resp := server.get(t, "/oauth/login/"+provider, nil)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	assert.Nil(t, err)
+	return server.get(t, "/oauth/callback/"+provider+"?"+url.Values{"code": {code}, "state": location.Query()["state"]}.Encode(), nil)
+}
+
+func TestOAuthCallbackState(t *testing.T) {
+	server := newTestServer(t, testUser)
+	setupOAuthProviders(t, server)
+
+	resp := server.get(t, "/oauth/login/auto", nil)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	assert.Nil(t, err)
+	assert.Equal(t, "idp.ai.io", location.Host)
+	state := location.Query().Get("state")
+	assert.Len(t, state, 43)
+
+	// the callback without the state issued is rejected
+	resp = server.get(t, "/oauth/callback/auto?code=1001", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = server.get(t, "/oauth/callback/auto?code=1001&state=forged", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	// the state is bound to the provider
+	resp = server.get(t, "/oauth/callback/lookup?code=1001&state="+state, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Empty(t, server.loginRecorder.records)
+
+	// the state is consumed by the callback
+	resp = server.get(t, "/oauth/login/auto", nil)
+	location, _ = url.Parse(resp.Header.Get("Location"))
+	state = location.Query().Get("state")
+	resp = server.get(t, "/oauth/callback/auto?code=1001&state="+state, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = server.get(t, "/oauth/callback/auto?code=1001&state="+state, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestOAuthCallbackAutoMapping(t *testing.T) {
+	server := newTestServer(t, testUser)
+	setupOAuthProviders(t, server)
+
+	resp := oauthLogin(t, server, "auto", "1001")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	result := struct {
+		Data oauth.Token `json:"data"`
+	}{}
+	decode(t, resp, &result)
+	authenticated, err := server.tokenOperator.Verify(result.Data.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", authenticated.GetName())
+
+	user, err := server.aiClient.IamV1().Users().Get(context.Background(), "alice", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "alice@ai.io", user.Spec.Email)
+	assert.Equal(t, "auto", user.Labels[iamv1.IdentifyProviderLabel])
+	assert.Equal(t, "1001", user.Labels[iamv1.OriginUIDLabel])
+
+	assert.Len(t, server.loginRecorder.records, 1)
+	assert.Equal(t, loginRecord{username: "alice", loginType: iamv1.OAuth, provider: "auto", success: true,
+		reason: iamv1.AuthenticatedSuccessfully}, server.loginRecorder.records[0])
+
+	// the mapped user is used once it is active
+	assert.Eventually(t, func() bool {
+		_, err := server.userLister.Get("alice")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	resp = oauthLogin(t, server, "auto", "1001")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	active := iamv1.UserActive
+	user.Status.State = &active
+	_, err = server.aiClient.IamV1().Users().Update(context.Background(), user, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		user, err := server.userLister.Get("alice")
+		return err == nil && user.Status.State != nil
+	}, time.Second, 10*time.Millisecond)
+	resp = oauthLogin(t, server, "auto", "1001")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the username is taken by a user not mapped to the identity
+	resp = oauthLogin(t, server, "auto", "1002")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = oauthLogin(t, server, "auto", "invalid")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = server.get(t, "/oauth/login/unknown", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestOAuthCallbackLookupMapping(t *testing.T) {
+	active := iamv1.UserActive
+	carol := &iamv1.User{
+		ObjectMeta: metav1.ObjectMeta{Name: "carol", Labels: map[string]string{
+			iamv1.IdentifyProviderLabel: "lookup",
+			iamv1.OriginUIDLabel:        "1003",
+		}},
+		Status: iamv1.UserStatus{State: &active},
+	}
+	server := newTestServer(t, carol)
+	setupOAuthProviders(t, server)
+
+	resp := oauthLogin(t, server, "lookup", "1003")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// users are not provisioned by lookup
+	resp = oauthLogin(t, server, "lookup", "1001")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_, err := server.aiClient.IamV1().Users().Get(context.Background(), "alice", metav1.GetOptions{})
+	assert.NotNil(t, err)
+
+	assert.Len(t, server.loginRecorder.records, 1)
+	assert.Equal(t, "lookup", server.loginRecorder.records[0].provider)
+}
@@ -0,0 +1,162 @@
+package oauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/request"
+	"github.com/wongearl/go-restful-template/pkg/api"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	"github.com/wongearl/go-restful-template/pkg/middles/auth"
+
+	restful "github.com/emicklei/go-restful"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	authuser "k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/klog"
+)
+
+const (
+	// deviceCodeGrantType exchanges the device code approved by the user for tokens, see RFC 8628
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// error codes of the device access token response, see RFC 8628 section 3.5
+	errorAuthorizationPending = "authorization_pending"
+	errorSlowDown             = "slow_down"
+	errorExpiredToken         = "expired_token"
+)
+
+// deviceAuthorizationResponse is defined in RFC 8628 section 3.2
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// deviceVerificationRequest is returned to the user agent to confirm the device authorization,
+// the user agent sends the user code with approve=true by POST to grant the authorization.
+type deviceVerificationRequest struct {
+	Client   string `json:"client"`
+	UserCode string `json:"userCode"`
+}
+
+// DeviceAuthorization starts the device authorization flow of the clients without a browser,
+// e.g. a CLI, the user approves the request on another device with the user code.
+// The scope parameter is ignored, the tokens issued are only restricted by the RBAC rules of the user.
+func (h *handler) DeviceAuthorization(req *restful.Request, resp *restful.Response) {
+	client, err := h.authenticateClient(req)
+	if err != nil {
+		writeOAuthError(resp, http.StatusUnauthorized, errorInvalidClient, err.Error())
+		return
+	}
+	if client.GrantMethod == oauth.GrantHandlerDeny {
+		writeOAuthError(resp, http.StatusBadRequest, errorAccessDenied, "the client is not allowed to be granted")
+		return
+	}
+
+	deviceCode, authorization, err := h.deviceStore.Issue(client.Name)
+	if err != nil {
+		writeOAuthError(resp, http.StatusInternalServerError, errorServerError, err.Error())
+		return
+	}
+
+	verificationURI := h.issuer(req) + "/oauth/device"
+	userCode := auth.FormatUserCode(authorization.UserCode)
+	resp.AddHeader("Cache-Control", "no-store")
+	_ = resp.WriteAsJson(deviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {userCode}}.Encode(),
+		ExpiresIn:               int(auth.DeviceCodeMaxAge.Seconds()),
+		Interval:                int(authorization.Interval.Seconds()),
+	})
+}
+
+// DeviceVerification shows the pending device authorization of the user code to the current user by GET,
+// and approves or denies it by POST.
+func (h *handler) DeviceVerification(req *restful.Request, resp *restful.Response) {
+	authenticated, ok := request.UserFrom(req.Request.Context())
+	if !ok || authenticated.GetName() == authuser.Anonymous {
+		api.NewEmptyResult().WithError(apierrors.NewUnauthorized("Unauthorized: user is not logged in")).WriteTo(resp)
+		return
+	}
+	if scopedAccessToken(authenticated) {
+		err := apierrors.NewForbidden(iamv1.Resource(iamv1.ResourcePluralAccessToken), "", fmt.Errorf("devices can not be verified with a scoped access token"))
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+
+	userCode := authorizeParameter(req, "user_code")
+	if userCode == "" {
+		api.NewEmptyResult().WithError(apierrors.NewBadRequest("user_code is required")).WriteTo(resp)
+		return
+	}
+	authorization, err := h.deviceStore.Lookup(userCode)
+	if err != nil {
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+
+	if req.Request.Method != http.MethodPost {
+		api.NewResult[*deviceVerificationRequest]().WithObject(&deviceVerificationRequest{
+			Client:   authorization.ClientID,
+			UserCode: auth.FormatUserCode(authorization.UserCode),
+		}).WriteTo(resp)
+		return
+	}
+
+	approve, _ := req.BodyParameter("approve")
+	if err = h.deviceStore.Decide(userCode, authenticated, approve == "true"); err != nil {
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+	api.NewEmptyResult().WriteTo(resp)
+}
+
+// deviceCodeGrant exchanges the device code for tokens once the user approves it, see RFC 8628 section 3.4.
+func (h *handler) deviceCodeGrant(req *restful.Request, resp *restful.Response) {
+	client, err := h.authenticateClient(req)
+	if err != nil {
+		writeOAuthError(resp, http.StatusUnauthorized, errorInvalidClient, err.Error())
+		return
+	}
+
+	deviceCode, _ := req.BodyParameter("device_code")
+	if deviceCode == "" {
+		writeOAuthError(resp, http.StatusBadRequest, errorInvalidRequest, "device_code is required")
+		return
+	}
+	authorization, err := h.deviceStore.Poll(deviceCode, client.Name)
+	if err != nil {
+		switch err {
+		case auth.AuthorizationPendingError:
+			writeOAuthError(resp, http.StatusBadRequest, errorAuthorizationPending, err.Error())
+		case auth.SlowDownError:
+			writeOAuthError(resp, http.StatusBadRequest, errorSlowDown, err.Error())
+		case auth.ExpiredDeviceCodeError:
+			writeOAuthError(resp, http.StatusBadRequest, errorExpiredToken, err.Error())
+		case auth.DeviceAccessDeniedError:
+			writeOAuthError(resp, http.StatusBadRequest, errorAccessDenied, err.Error())
+		case auth.DeviceCodeClientMismatchError:
+			writeOAuthError(resp, http.StatusBadRequest, errorInvalidGrant, err.Error())
+		default:
+			klog.Error(err)
+			writeOAuthError(resp, http.StatusInternalServerError, errorServerError, err.Error())
+		}
+		return
+	}
+
+	result, err := h.issueTokenTo(authorization.User(), iamv1.OAuth, "", req)
+	if err != nil {
+		writeOAuthError(resp, http.StatusInternalServerError, errorServerError, fmt.Sprintf("failed to issue token: %v", err))
+		return
+	}
+	resp.AddHeader("Cache-Control", "no-store")
+	resp.AddHeader("Pragma", "no-cache")
+	_ = resp.WriteAsJson(result)
+}
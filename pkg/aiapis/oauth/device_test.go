@@ -0,0 +1,118 @@
+package oauth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPublicClient = oauth.Client{
+	Name:        "cli",
+	GrantMethod: oauth.GrantHandlerAuto,
+}
+
+func verifyDevice(t *testing.T, server *testServer, form url.Values, header http.Header) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/oauth/device", strings.NewReader(form.Encode()))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := server.client().Do(req)
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestDeviceAuthorizationFlow(t *testing.T) {
+	server := newTestServer(t, testUser)
+	server.authOptions.OAuthOptions.Clients = []oauth.Client{testPublicClient}
+
+	resp := server.postForm(t, "/oauth/device_authorization", url.Values{"client_id": {"unknown"}})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = server.postForm(t, "/oauth/device_authorization", url.Values{"client_id": {"default"}, "client_secret": {"ai"}})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = server.postForm(t, "/oauth/device_authorization", url.Values{"client_id": {"cli"}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	authorization := deviceAuthorizationResponse{}
+	decode(t, resp, &authorization)
+	assert.NotEmpty(t, authorization.DeviceCode)
+	assert.Len(t, authorization.UserCode, 9)
+	assert.Equal(t, server.URL+"/oauth/device", authorization.VerificationURI)
+	assert.Equal(t, server.URL+"/oauth/device?user_code="+authorization.UserCode, authorization.VerificationURIComplete)
+	assert.Equal(t, 600, authorization.ExpiresIn)
+	assert.Equal(t, 5, authorization.Interval)
+
+	poll := url.Values{
+		"grant_type":  {deviceCodeGrantType},
+		"device_code": {authorization.DeviceCode},
+		"client_id":   {"cli"},
+	}
+	pollError := func(expected string) {
+		resp := server.postForm(t, "/oauth/token", poll)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		oauthErr := oauthError{}
+		decode(t, resp, &oauthErr)
+		assert.Equal(t, expected, oauthErr.Error)
+	}
+	pollError(errorAuthorizationPending)
+	pollError(errorSlowDown)
+
+	// the user must log in to verify the device
+	resp = server.get(t, "/oauth/device?user_code="+authorization.UserCode, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = server.get(t, "/oauth/device?user_code="+authorization.UserCode, basicAuth("admin"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the scoped access tokens can not approve the device
+	resp = verifyDevice(t, server, url.Values{"user_code": {authorization.UserCode}, "approve": {"true"}}, scopedBearer("admin"))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = server.get(t, "/oauth/device?user_code="+authorization.UserCode, scopedBearer("admin"))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = verifyDevice(t, server, url.Values{"user_code": {"BCDF-GHJK"}, "approve": {"true"}}, basicAuth("admin"))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = verifyDevice(t, server, url.Values{"user_code": {authorization.UserCode}, "approve": {"true"}}, basicAuth("admin"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = server.postForm(t, "/oauth/token", poll)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	result := &oauth.Token{}
+	decode(t, resp, result)
+	assert.NotEmpty(t, result.AccessToken)
+	authenticated, err := server.tokenOperator.Verify(result.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", authenticated.GetName())
+	assert.Len(t, server.loginRecorder.records, 1)
+	assert.Equal(t, iamv1.OAuth, server.loginRecorder.records[0].loginType)
+
+	// the device code can only be exchanged once
+	pollError(errorExpiredToken)
+}
+
+func TestDeviceAuthorizationDenied(t *testing.T) {
+	server := newTestServer(t, testUser)
+	server.authOptions.OAuthOptions.Clients = []oauth.Client{testPublicClient}
+
+	authorization := deviceAuthorizationResponse{}
+	decode(t, server.postForm(t, "/oauth/device_authorization", url.Values{"client_id": {"cli"}}), &authorization)
+	resp := verifyDevice(t, server, url.Values{"user_code": {authorization.UserCode}, "approve": {"false"}}, basicAuth("admin"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = server.postForm(t, "/oauth/token", url.Values{
+		"grant_type":  {deviceCodeGrantType},
+		"device_code": {authorization.DeviceCode},
+		"client_id":   {"cli"},
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	oauthErr := oauthError{}
+	decode(t, resp, &oauthErr)
+	assert.Equal(t, errorAccessDenied, oauthErr.Error)
+}
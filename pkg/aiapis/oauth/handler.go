@@ -2,10 +2,16 @@ package oauth
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/identityprovider"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/token"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/config"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/request"
 	"github.com/wongearl/go-restful-template/pkg/api"
@@ -26,27 +32,59 @@ import (
 const (
 	passwordGrantType     = "password"
 	refreshTokenGrantType = "refresh_token"
+	// mfaOTPGrantType exchanges the challenge token of the password grant and the
+	// one-time password or a recovery code for tokens
+	mfaOTPGrantType = "mfa_otp"
+	// clientCredentialsGrantType issues tokens to the service identities of the OAuth clients
+	clientCredentialsGrantType = "client_credentials"
 )
 
+// mfaRequired is returned by the password grant if the user has enabled MFA
+type mfaRequired struct {
+	oauthError
+	MFAToken  string `json:"mfa_token"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
 type handler struct {
 	im                    im.IdentityManagementInterface
 	tokenOperator         auth.TokenManagementInterface
+	signingKeys           *token.KeySet
+	codeStore             auth.AuthorizationCodeStore
+	deviceStore           auth.DeviceAuthorizationStore
+	stateStore            auth.OAuthStateStore
 	passwordAuthenticator auth.PasswordAuthenticator
+	oauthAuthenticator    auth.OAuthAuthenticator
 	loginRecorder         auth.LoginRecorder
+	mfaOperator           auth.MFAManagementInterface
 	k8sclient             kubernetes.Interface
 	option                *config.AiOptions
+	authOptions           *authoptions.AuthenticationOptions
 }
 
 func newHandler(im im.IdentityManagementInterface,
 	tokenOperator auth.TokenManagementInterface,
+	signingKeys *token.KeySet,
+	codeStore auth.AuthorizationCodeStore,
+	deviceStore auth.DeviceAuthorizationStore,
+	stateStore auth.OAuthStateStore,
 	passwordAuthenticator auth.PasswordAuthenticator,
+	oauthAuthenticator auth.OAuthAuthenticator,
 	loginRecorder auth.LoginRecorder,
-	option *config.AiOptions, k8sclient kubernetes.Interface) *handler {
+	mfaOperator auth.MFAManagementInterface,
+	option *config.AiOptions, authOptions *authoptions.AuthenticationOptions, k8sclient kubernetes.Interface) *handler {
 	return &handler{im: im,
 		tokenOperator:         tokenOperator,
+		signingKeys:           signingKeys,
+		codeStore:             codeStore,
+		deviceStore:           deviceStore,
+		stateStore:            stateStore,
 		passwordAuthenticator: passwordAuthenticator,
+		oauthAuthenticator:    oauthAuthenticator,
 		loginRecorder:         loginRecorder,
+		mfaOperator:           mfaOperator,
 		option:                option,
+		authOptions:           authOptions,
 		k8sclient:             k8sclient,
 	}
 }
@@ -76,6 +114,18 @@ func (h *handler) Token(req *restful.Request, resp *restful.Response) {
 	case refreshTokenGrantType:
 		h.refreshTokenGrant(req, resp)
 		break
+	case authorizationCodeGrantType:
+		h.authorizationCodeGrant(req, resp)
+		break
+	case mfaOTPGrantType:
+		h.mfaOTPGrant(req, resp)
+		break
+	case clientCredentialsGrantType:
+		h.clientCredentialsGrant(req, resp)
+		break
+	case deviceCodeGrantType:
+		h.deviceCodeGrant(req, resp)
+		break
 	default:
 		err = apierrors.NewBadRequest(fmt.Sprintf("Grant type %s is not supported", grantType))
 		api.NewEmptyResult().WithError(err).WriteTo(resp)
@@ -84,8 +134,9 @@ func (h *handler) Token(req *restful.Request, resp *restful.Response) {
 }
 
 func (h *handler) passwordGrant(username string, password string, req *restful.Request, resp *restful.Response) {
-	_, err := h.im.DescribeUser(username)
-	if err != nil {
+	// the users unknown yet may be provisioned by the identity providers
+	user, err := h.im.DescribeUser(username)
+	if err != nil && !apierrors.IsNotFound(err) {
 		api.NewEmptyResult().WithError(err).WriteTo(resp)
 		return
 	}
@@ -93,29 +144,219 @@ func (h *handler) passwordGrant(username string, password string, req *restful.R
 	if err != nil {
 		switch err {
 		case auth.IncorrectPasswordError:
+			if user == nil {
+				break
+			}
 			requestInfo, _ := request.RequestInfoFrom(req.Request.Context())
 			if err := h.loginRecorder.RecordLogin(username, iamv1.Token, provider, requestInfo.SourceIP, requestInfo.UserAgent, err); err != nil {
 				klog.Errorf("Failed to record unsuccessful login attempt for user %s, error: %v", username, err)
 			}
+		case auth.PasswordExpiredError:
+			// the expired local password has to be changed with basic authentication
+			writeOAuthError(resp, http.StatusForbidden, errorPasswordChangeRequired, err.Error())
+			return
+		}
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+
+	// the user may login with the email, MFA is looked up by the authenticated name,
+	// the users provisioned by this login have not enabled MFA yet
+	if authenticated.GetName() != username {
+		if user, err = h.im.DescribeUser(authenticated.GetName()); err != nil && !apierrors.IsNotFound(err) {
+			api.NewEmptyResult().WithError(err).WriteTo(resp)
+			return
 		}
+	}
+	if auth.MFAEnabled(user) {
+		mfaToken, err := h.mfaOperator.Challenge(authenticated, provider)
+		if err != nil {
+			api.NewEmptyResult().WithError(err).WriteTo(resp)
+			return
+		}
+		_ = resp.WriteHeaderAndJson(http.StatusForbidden, mfaRequired{
+			oauthError: oauthError{Error: errorMFARequired, Description: "Multi-factor authentication is required"},
+			MFAToken:   mfaToken,
+			ExpiresIn:  int(auth.MFAChallengeMaxAge.Seconds()),
+		}, restful.MIME_JSON)
+		return
+	}
+
+	result, err := h.issueTokenTo(authenticated, iamv1.Token, provider, req)
+	if err != nil {
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+
+	api.NewResult[*oauth.Token]().WithObject(result).WithError(err).WriteTo(resp)
+}
+
+// mfaOTPGrant completes the password grant of the users who have enabled MFA,
+// the incorrect codes are recorded as failed login attempts.
+func (h *handler) mfaOTPGrant(req *restful.Request, resp *restful.Response) {
+	mfaToken, _ := req.BodyParameter("mfa_token")
+	otp, _ := req.BodyParameter("otp")
+	if mfaToken == "" || otp == "" {
+		api.NewEmptyResult().WithError(apierrors.NewBadRequest("mfa_token and otp are required")).WriteTo(resp)
+		return
+	}
+	challenge, err := h.mfaOperator.VerifyChallenge(mfaToken, otp)
+	if err != nil {
+		if err == auth.InvalidMFACodeError {
+			requestInfo, _ := request.RequestInfoFrom(req.Request.Context())
+			if err := h.loginRecorder.RecordLogin(challenge.Username, iamv1.Token, challenge.Provider, requestInfo.SourceIP, requestInfo.UserAgent, err); err != nil {
+				klog.Errorf("Failed to record unsuccessful login attempt for user %s, error: %v", challenge.Username, err)
+			}
+		}
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+	// the user may be disabled or blocked since the challenge was created
+	user, err := h.im.DescribeUser(challenge.Username)
+	if err == nil {
+		err = auth.CheckUserState(user)
+	}
+	if err != nil {
 		api.NewEmptyResult().WithError(err).WriteTo(resp)
 		return
 	}
 
-	result, err := h.tokenOperator.IssueTo(authenticated, nil, nil)
+	result, err := h.issueTokenTo(challenge.User(), iamv1.Token, challenge.Provider, req)
 	if err != nil {
 		api.NewEmptyResult().WithError(err).WriteTo(resp)
 		return
 	}
+	api.NewResult[*oauth.Token]().WithObject(result).WriteTo(resp)
+}
 
+// clientCredentialsGrant issues an access token to the service identity of the client, see RFC 6749 section 4.4.
+// No refresh token is issued since the client can request a new token with its credentials at any time.
+// The requested scopes are only checked against the ScopeRestrictions of the client, they do not restrict
+// the token, the permissions of the service identity are granted by the RBAC rules bound to it.
+func (h *handler) clientCredentialsGrant(req *restful.Request, resp *restful.Response) {
+	clientID, _ := req.BodyParameter("client_id")
+	clientSecret, _ := req.BodyParameter("client_secret")
+	client, err := h.authOptions.OAuthOptions.ServiceClient(clientID)
+	if err != nil {
+		writeOAuthError(resp, http.StatusUnauthorized, errorInvalidClient, err.Error())
+		return
+	}
+
+	identity := client.ServiceIdentity()
 	requestInfo, _ := request.RequestInfoFrom(req.Request.Context())
-	if err = h.loginRecorder.RecordLogin(authenticated.GetName(), iamv1.Token, provider, requestInfo.SourceIP, requestInfo.UserAgent, nil); err != nil {
-		klog.Errorf("Failed to record successful login for user %s, error: %v", username, err)
+	if subtle.ConstantTimeCompare([]byte(client.Secret), []byte(clientSecret)) != 1 {
+		err = fmt.Errorf("client authentication failed")
+		if err := h.loginRecorder.RecordLogin(identity, iamv1.ClientCredentials, "", requestInfo.SourceIP, requestInfo.UserAgent, err); err != nil {
+			klog.Errorf("Failed to record unsuccessful login attempt for client %s, error: %v", client.Name, err)
+		}
+		writeOAuthError(resp, http.StatusUnauthorized, errorInvalidClient, err.Error())
+		return
 	}
 
-	api.NewResult[*oauth.Token]().WithObject(result).WithError(err).WriteTo(resp)
+	scope, _ := req.BodyParameter("scope")
+	scopes, err := client.AllowedScopes(strings.Fields(scope))
+	if err != nil {
+		writeOAuthError(resp, http.StatusBadRequest, errorInvalidScope, err.Error())
+		return
+	}
+
+	// the tokens of the clients always expire
+	expiresIn := h.authOptions.OAuthOptions.AccessTokenMaxAge
+	if client.AccessTokenMaxAge != nil && *client.AccessTokenMaxAge > 0 {
+		expiresIn = *client.AccessTokenMaxAge
+	}
+	if expiresIn <= 0 {
+		expiresIn = oauth.DefaultTokenMaxAge
+	}
+	authenticated := &authuser.DefaultInfo{Name: identity, Groups: []string{oauth.ServiceIdentityGroup}}
+	result, err := h.tokenOperator.IssueAccessToken(authenticated, expiresIn)
+	if err != nil {
+		writeOAuthError(resp, http.StatusInternalServerError, errorServerError, err.Error())
+		return
+	}
+	result.Scope = strings.Join(scopes, " ")
+
+	if err = h.loginRecorder.RecordLogin(identity, iamv1.ClientCredentials, "", requestInfo.SourceIP, requestInfo.UserAgent, nil); err != nil {
+		klog.Errorf("Failed to record successful login for client %s, error: %v", client.Name, err)
+	}
+	api.NewResult[*oauth.Token]().WithObject(result).WriteTo(resp)
+}
+
+// issueTokenTo issues a new token pair to the authenticated user and records the successful login.
+// The earlier sessions are superseded if multiple login is not allowed.
+func (h *handler) issueTokenTo(authenticated authuser.Info, loginType iamv1.LoginType, provider string, req *restful.Request) (*oauth.Token, error) {
+	result, revoked, err := h.tokenOperator.IssueTo(authenticated, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	reason := iamv1.AuthenticatedSuccessfully
+	if revoked > 0 {
+		reason = iamv1.SessionSuperseded
+	}
+	requestInfo, _ := request.RequestInfoFrom(req.Request.Context())
+	if err = h.loginRecorder.RecordLoginWithReason(authenticated.GetName(), loginType, provider, requestInfo.SourceIP, requestInfo.UserAgent, true, reason); err != nil {
+		klog.Errorf("Failed to record successful login for user %s, error: %v", authenticated.GetName(), err)
+	}
+	return result, nil
+}
+
+// oauthLogin redirects the user agent to the OAuth identity provider to log in,
+// the state sent to the provider must be returned to the callback.
+func (h *handler) oauthLogin(req *restful.Request, resp *restful.Response) {
+	providerName := req.PathParameter("callback")
+	if _, err := h.authOptions.OAuthOptions.IdentityProviderOptions(providerName); err != nil {
+		api.NewEmptyResult().WithError(apierrors.NewBadRequest(err.Error())).WriteTo(resp)
+		return
+	}
+	provider, err := identityprovider.GetOAuthProvider(providerName)
+	if err != nil {
+		api.NewEmptyResult().WithError(apierrors.NewBadRequest(err.Error())).WriteTo(resp)
+		return
+	}
+	state, err := h.stateStore.Issue(providerName)
+	if err != nil {
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+	http.Redirect(resp.ResponseWriter, req.Request, provider.AuthCodeURL(state), http.StatusFound)
 }
 
+// oauthCallback handles the redirection from the OAuth identity provider, the authorization code
+// is exchanged for the identity, and a token pair is issued to the user mapped to it.
+// The state must be the one issued by oauthLogin for the same provider.
+func (h *handler) oauthCallback(req *restful.Request, resp *restful.Response) {
+	provider := req.PathParameter("callback")
+	code := req.QueryParameter("code")
+	if code == "" {
+		err := apierrors.NewBadRequest("code is required")
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+	if err := h.stateStore.Consume(provider, req.QueryParameter("state")); err != nil {
+		err := apierrors.NewUnauthorized(fmt.Sprintf("Unauthorized: %s", err))
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+
+	authenticated, provider, err := h.oauthAuthenticator.Authenticate(provider, code)
+	if err != nil {
+		err := apierrors.NewUnauthorized(fmt.Sprintf("Unauthorized: %s", err))
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+
+	result, err := h.issueTokenTo(authenticated, iamv1.OAuth, provider, req)
+	if err != nil {
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+
+	api.NewResult[*oauth.Token]().WithObject(result).WriteTo(resp)
+}
+
+// refreshTokenGrant rotates the refresh token, see RFC 6819 section 5.2.2.3. Presenting a used refresh token
+// revokes the sessions refreshed from it and is recorded as a failed login.
 func (h *handler) refreshTokenGrant(req *restful.Request, resp *restful.Response) {
 	refreshToken, err := req.BodyParameter("refresh_token")
 	if err != nil {
@@ -123,15 +364,139 @@ func (h *handler) refreshTokenGrant(req *restful.Request, resp *restful.Response
 		return
 	}
 
-	authenticated, err := h.tokenOperator.Verify(refreshToken)
+	authenticated, result, err := h.tokenOperator.Refresh(refreshToken)
+	if err == auth.RefreshTokenReusedError {
+		requestInfo, _ := request.RequestInfoFrom(req.Request.Context())
+		if err := h.loginRecorder.RecordLoginWithReason(authenticated.GetName(), iamv1.Token, "", requestInfo.SourceIP, requestInfo.UserAgent, false, iamv1.RefreshTokenReused); err != nil {
+			klog.Errorf("Failed to record refresh token reuse of user %s, error: %v", authenticated.GetName(), err)
+		}
+	}
 	if err != nil {
 		err := apierrors.NewUnauthorized(fmt.Sprintf("Unauthorized: %s", err))
 		api.NewEmptyResult().WithError(err).WriteTo(resp)
 		return
 	}
+	api.NewResult[*oauth.Token]().WithObject(result).WriteTo(resp)
+}
 
-	result, err := h.tokenOperator.IssueTo(authenticated, nil, nil)
-	api.NewResult[*oauth.Token]().WithObject(result).WithError(err).WriteTo(resp)
+// Revoke invalidates the given access token or refresh token, see RFC 7009.
+// The client must be identified, and authenticated if it has a secret. The tokens issued to the users are not
+// bound to a client, so the possession of the token is the proof that the client is allowed to revoke it.
+// Invalid tokens do not cause an error response, they are unusable anyway.
+func (h *handler) Revoke(req *restful.Request, resp *restful.Response) {
+	clientID, _ := req.BodyParameter("client_id")
+	clientSecret, _ := req.BodyParameter("client_secret")
+	client, err := h.authOptions.OAuthOptions.RegisteredClient(clientID)
+	if err != nil || subtle.ConstantTimeCompare([]byte(client.Secret), []byte(clientSecret)) != 1 {
+		writeOAuthError(resp, http.StatusUnauthorized, errorInvalidClient, "client authentication failed")
+		return
+	}
+
+	token, _ := req.BodyParameter("token")
+	if token == "" {
+		writeOAuthError(resp, http.StatusBadRequest, errorInvalidRequest, "token is required")
+		return
+	}
+	err = h.tokenOperator.Revoke(token)
+	api.NewEmptyResult().WithError(err).WriteTo(resp)
+}
+
+// introspectionResponse is defined in RFC 7662 section 2.2, only active is returned for the inactive tokens
+type introspectionResponse struct {
+	Active    bool                `json:"active"`
+	Username  string              `json:"username,omitempty"`
+	Groups    []string            `json:"groups,omitempty"`
+	Extra     map[string][]string `json:"extra,omitempty"`
+	TokenType string              `json:"token_type,omitempty"`
+	IssuedAt  int64               `json:"iat,omitempty"`
+	ExpiresAt int64               `json:"exp,omitempty"`
+}
+
+// Introspect tells the resource servers whether the token is active, see RFC 7662.
+// Only the confidential clients are allowed, so the tokens can not be probed anonymously.
+func (h *handler) Introspect(req *restful.Request, resp *restful.Response) {
+	clientID, _ := req.BodyParameter("client_id")
+	clientSecret, _ := req.BodyParameter("client_secret")
+	client, err := h.authOptions.OAuthOptions.ServiceClient(clientID)
+	if err != nil || subtle.ConstantTimeCompare([]byte(client.Secret), []byte(clientSecret)) != 1 {
+		writeOAuthError(resp, http.StatusUnauthorized, errorInvalidClient, "client authentication failed")
+		return
+	}
+
+	tokenStr, _ := req.BodyParameter("token")
+	if tokenStr == "" {
+		writeOAuthError(resp, http.StatusBadRequest, errorInvalidRequest, "token is required")
+		return
+	}
+
+	resp.AddHeader("Cache-Control", "no-store")
+	introspection, err := h.tokenOperator.Introspect(tokenStr)
+	if err != nil {
+		klog.V(4).Info(err)
+		_ = resp.WriteAsJson(introspectionResponse{Active: false})
+		return
+	}
+	result := introspectionResponse{
+		Active:    true,
+		Username:  introspection.User.GetName(),
+		Groups:    introspection.User.GetGroups(),
+		Extra:     introspection.User.GetExtra(),
+		TokenType: string(introspection.TokenType),
+	}
+	if !introspection.IssuedAt.IsZero() {
+		result.IssuedAt = introspection.IssuedAt.Unix()
+	}
+	if !introspection.ExpiresAt.IsZero() {
+		result.ExpiresAt = introspection.ExpiresAt.Unix()
+	}
+	_ = resp.WriteAsJson(result)
+}
+
+// Logout invalidates the access token of the current request, and the refresh token if provided.
+func (h *handler) Logout(req *restful.Request, resp *restful.Response) {
+	authenticated, ok := request.UserFrom(req.Request.Context())
+	if !ok || authenticated.GetName() == authuser.Anonymous {
+		err := apierrors.NewUnauthorized("Unauthorized: user is not logged in")
+		api.NewEmptyResult().WithError(err).WriteTo(resp)
+		return
+	}
+
+	if accessToken := bearerToken(req); accessToken != "" {
+		if err := h.tokenOperator.Revoke(accessToken); err != nil {
+			api.NewEmptyResult().WithError(err).WriteTo(resp)
+			return
+		}
+	}
+
+	if refreshToken, _ := req.BodyParameter("refresh_token"); refreshToken != "" {
+		if err := h.tokenOperator.Revoke(refreshToken); err != nil {
+			api.NewEmptyResult().WithError(err).WriteTo(resp)
+			return
+		}
+	}
+
+	api.NewEmptyResult().WriteTo(resp)
+}
+
+// Keys publishes the public signing keys, the response is a plain JWKS document
+// so it can be consumed by standard jwt libraries.
+func (h *handler) Keys(req *restful.Request, resp *restful.Response) {
+	_ = resp.WriteAsJson(h.signingKeys.JWKS())
+}
+
+// scopedAccessToken returns true if the user is authenticated by an access token restricted to scopes,
+// which must not be exchanged for the tokens without the restriction.
+func scopedAccessToken(user authuser.Info) bool {
+	_, scoped := user.GetExtra()[iamv1.AccessTokenScopesExtraKey]
+	return scoped
+}
+
+func bearerToken(req *restful.Request) string {
+	parts := strings.Split(strings.TrimSpace(req.HeaderParameter("Authorization")), " ")
+	if len(parts) < 2 || strings.ToLower(parts[0]) != "bearer" {
+		return ""
+	}
+	return parts[1]
 }
 
 func (h *handler) createToken(req *restful.Request, resp *restful.Response) {
@@ -154,5 +519,6 @@ func createToken(tokenOperator auth.TokenManagementInterface, ctx context.Contex
 	}
 	accessTokenMaxAgeSecond := time.Second * 0
 	accessTokenInactivityTimeoutSecond := time.Second * 0
-	return tokenOperator.IssueTo(user, &accessTokenMaxAgeSecond, &accessTokenInactivityTimeoutSecond)
+	result, _, err := tokenOperator.IssueTo(user, &accessTokenMaxAgeSecond, &accessTokenInactivityTimeoutSecond)
+	return result, err
 }
@@ -0,0 +1,423 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/config"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/request"
+	"github.com/wongearl/go-restful-template/pkg/api"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	aifake "github.com/wongearl/go-restful-template/pkg/client/ai/clientset/versioned/fake"
+	aiinformers "github.com/wongearl/go-restful-template/pkg/client/ai/informers/externalversions"
+	iamv1listers "github.com/wongearl/go-restful-template/pkg/client/ai/listers/iam.ai.io/v1"
+	"github.com/wongearl/go-restful-template/pkg/client/cache"
+	"github.com/wongearl/go-restful-template/pkg/client/informers"
+	"github.com/wongearl/go-restful-template/pkg/middles/auth"
+	"github.com/wongearl/go-restful-template/pkg/middles/iam/im"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	authuser "k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type fakeIdentityManager struct {
+	im.IdentityManagementInterface
+	users map[string]*iamv1.User
+}
+
+func (f *fakeIdentityManager) DescribeUser(username string) (*iamv1.User, error) {
+	if user, ok := f.users[username]; ok {
+		return user.DeepCopy(), nil
+	}
+	return nil, apierrors.NewNotFound(iamv1.Resource(iamv1.ResourcesSingularUser), username)
+}
+
+type loginRecord struct {
+	username  string
+	loginType iamv1.LoginType
+	provider  string
+	success   bool
+	reason    string
+}
+
+type fakeLoginRecorder struct {
+	sync.Mutex
+	records []loginRecord
+}
+
+func (f *fakeLoginRecorder) RecordLogin(username string, loginType iamv1.LoginType, provider string, sourceIP string, userAgent string, authErr error) error {
+	if authErr != nil {
+		return f.RecordLoginWithReason(username, loginType, provider, sourceIP, userAgent, false, authErr.Error())
+	}
+	return f.RecordLoginWithReason(username, loginType, provider, sourceIP, userAgent, true, iamv1.AuthenticatedSuccessfully)
+}
+
+func (f *fakeLoginRecorder) RecordLoginWithReason(username string, loginType iamv1.LoginType, provider string, sourceIP string, userAgent string, success bool, reason string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.records = append(f.records, loginRecord{username: username, loginType: loginType, provider: provider, success: success, reason: reason})
+	return nil
+}
+
+type testServer struct {
+	*httptest.Server
+	authOptions   *authoptions.AuthenticationOptions
+	tokenOperator auth.TokenManagementInterface
+	loginRecorder *fakeLoginRecorder
+	aiClient      *aifake.Clientset
+	userLister    iamv1listers.UserLister
+	mfaOperator   auth.MFAManagementInterface
+}
+
+// newTestServer serves the oauth APIs, bearer tokens are verified by the token operator
+// and the basic auth username is trusted, like the authentication filter does.
+func newTestServer(t *testing.T, users ...*iamv1.User) *testServer {
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	cacheClient, err := cache.NewInMemoryCache(nil, stopCh)
+	assert.Nil(t, err)
+
+	authOptions := authoptions.NewAuthenticateOptions()
+	authOptions.JwtSecret = "secret"
+	authOptions.MultipleLogin = true
+	option := &config.AiOptions{Namespace: "ai-system"}
+	k8sclient := fake.NewSimpleClientset(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "ai-system", Name: "ai-config"}})
+	identityManager := &fakeIdentityManager{users: make(map[string]*iamv1.User)}
+	var objects []runtime.Object
+	for _, user := range users {
+		identityManager.users[user.Name] = user
+		objects = append(objects, user)
+	}
+	aiClient := aifake.NewSimpleClientset(objects...)
+	informerFactory := aiinformers.NewSharedInformerFactory(aiClient, 0)
+	assert.Nil(t, informers.AddAiIndexers(informerFactory))
+	userLister := informerFactory.Iam().V1().Users().Lister()
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+
+	tokenOperator := auth.NewTokenOperator(cacheClient, nil, authOptions)
+	oauthAuthenticator := auth.NewOAuthAuthenticator(aiClient, informerFactory.Iam().V1().Users(), authOptions)
+	passwordAuthenticator := auth.NewPasswordAuthenticator(aiClient, informerFactory.Iam().V1().Users(), authOptions, option)
+	loginRecorder := &fakeLoginRecorder{}
+	mfaOperator := auth.NewMFAOperator(aiClient, k8sclient, cacheClient, option, authOptions)
+
+	container := restful.NewContainer()
+	assert.Nil(t, AddToContainer(container, identityManager, option, authOptions, k8sclient, tokenOperator, nil,
+		auth.NewAuthorizationCodeStore(cacheClient), auth.NewDeviceAuthorizationStore(cacheClient), auth.NewOAuthStateStore(cacheClient), passwordAuthenticator, oauthAuthenticator, loginRecorder, mfaOperator))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var user authuser.Info = &authuser.DefaultInfo{Name: authuser.Anonymous}
+		if username, _, ok := req.BasicAuth(); ok {
+			user = &authuser.DefaultInfo{Name: username}
+		} else if username := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "+scopedAccessTokenPrefix); username != req.Header.Get("Authorization") {
+			user = &authuser.DefaultInfo{Name: username, Extra: map[string][]string{iamv1.AccessTokenScopesExtraKey: {"[]"}}}
+		} else if strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+			authenticated, err := tokenOperator.Verify(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			user = authenticated
+		}
+		ctx := request.WithUser(req.Context(), user)
+		ctx = request.WithRequestInfo(ctx, &request.RequestInfo{SourceIP: "127.0.0.1", UserAgent: req.UserAgent()})
+		container.ServeHTTP(w, req.WithContext(ctx))
+	}))
+	t.Cleanup(server.Close)
+
+	return &testServer{Server: server, authOptions: authOptions, tokenOperator: tokenOperator, loginRecorder: loginRecorder,
+		aiClient: aiClient, userLister: userLister, mfaOperator: mfaOperator}
+}
+
+func (s *testServer) client() *http.Client {
+	return &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+}
+
+func (s *testServer) get(t *testing.T, path string, header http.Header) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, s.URL+path, nil)
+	assert.Nil(t, err)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := s.client().Do(req)
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func (s *testServer) postForm(t *testing.T, path string, form url.Values) *http.Response {
+	resp, err := s.client().PostForm(s.URL+path, form)
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func decode(t *testing.T, resp *http.Response, into interface{}) {
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(into))
+}
+
+func basicAuth(username string) http.Header {
+	req := &http.Request{Header: http.Header{}}
+	req.SetBasicAuth(username, "P@88w0rd")
+	return req.Header
+}
+
+// scopedAccessTokenPrefix marks the bearer tokens authenticated as the scoped access tokens of the user
+const scopedAccessTokenPrefix = "scoped-"
+
+func scopedBearer(username string) http.Header {
+	return bearer(scopedAccessTokenPrefix + username)
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func newPasswordUser(t *testing.T, name string) *iamv1.User {
+	encrypted, err := bcrypt.GenerateFromPassword([]byte("P@88w0rd"), bcrypt.MinCost)
+	assert.Nil(t, err)
+	active := iamv1.UserActive
+	return &iamv1.User{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       iamv1.UserSpec{Email: name + "@ai.io", EncryptedPassword: string(encrypted)},
+		Status:     iamv1.UserStatus{State: &active},
+	}
+}
+
+func passwordGrant(t *testing.T, server *testServer, username string) *http.Response {
+	return server.postForm(t, "/oauth/token", url.Values{
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {"P@88w0rd"},
+	})
+}
+
+func TestPasswordGrantWithExpiredPassword(t *testing.T) {
+	expired := newPasswordUser(t, "alice")
+	expired.Status.MustChangePassword = true
+	server := newTestServer(t, expired)
+
+	resp := passwordGrant(t, server, "alice")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	oauthErr := oauthError{}
+	decode(t, resp, &oauthErr)
+	assert.Equal(t, errorPasswordChangeRequired, oauthErr.Error)
+	assert.Empty(t, server.loginRecorder.records)
+
+	// the incorrect password is not told to be expired
+	resp = server.postForm(t, "/oauth/token", url.Values{
+		"grant_type": {"password"},
+		"username":   {"alice"},
+		"password":   {"incorrect"},
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	server := newTestServer(t)
+	maxAge := time.Hour
+	server.authOptions.OAuthOptions.Clients = []oauth.Client{
+		{Name: "ci", Secret: "ci-secret", ScopeRestrictions: []string{"deploy", "read"}, AccessTokenMaxAge: &maxAge},
+		{Name: "spa"},
+	}
+
+	resp := server.postForm(t, "/oauth/token", url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {"ci"},
+		"client_secret": {"ci-secret"},
+		"scope":         {"read"},
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	result := &api.CommonSingleResult[oauth.Token]{}
+	decode(t, resp, result)
+	assert.NotEmpty(t, result.Data.AccessToken)
+	assert.Empty(t, result.Data.RefreshToken)
+	assert.Equal(t, "read", result.Data.Scope)
+	assert.Equal(t, 3600, result.Data.ExpiresIn)
+	authenticated, err := server.tokenOperator.Verify(result.Data.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "system:oauth-client:ci", authenticated.GetName())
+	assert.Equal(t, []string{oauth.ServiceIdentityGroup}, authenticated.GetGroups())
+	// the scopes are not enforced, so they are not carried by the token
+	assert.Empty(t, authenticated.GetExtra())
+	assert.Equal(t, []loginRecord{{username: "system:oauth-client:ci", loginType: iamv1.ClientCredentials,
+		success: true, reason: iamv1.AuthenticatedSuccessfully}}, server.loginRecorder.records)
+
+	// the tokens of the client are not bound to a login session
+	server.authOptions.MultipleLogin = false
+	resp = server.postForm(t, "/oauth/token", url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {"ci"},
+		"client_secret": {"ci-secret"},
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	result = &api.CommonSingleResult[oauth.Token]{}
+	decode(t, resp, result)
+	assert.Equal(t, "deploy read", result.Data.Scope)
+	_, err = server.tokenOperator.Verify(result.Data.AccessToken)
+	assert.Nil(t, err)
+	sessions, err := server.tokenOperator.ListSessions("system:oauth-client:ci")
+	assert.Nil(t, err)
+	assert.Len(t, sessions, 2)
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		status int
+		code   string
+	}{
+		{"incorrect secret", url.Values{"client_id": {"ci"}, "client_secret": {"incorrect"}}, http.StatusUnauthorized, errorInvalidClient},
+		{"scope not allowed", url.Values{"client_id": {"ci"}, "client_secret": {"ci-secret"}, "scope": {"read admin"}}, http.StatusBadRequest, errorInvalidScope},
+		{"public client", url.Values{"client_id": {"spa"}}, http.StatusUnauthorized, errorInvalidClient},
+		{"default client", url.Values{"client_id": {"default"}, "client_secret": {"ai"}}, http.StatusUnauthorized, errorInvalidClient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.form.Set("grant_type", "client_credentials")
+			resp := server.postForm(t, "/oauth/token", tt.form)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			oauthErr := oauthError{}
+			decode(t, resp, &oauthErr)
+			assert.Equal(t, tt.code, oauthErr.Error)
+		})
+	}
+	// the failed authentication of the registered client is recorded
+	assert.Len(t, server.loginRecorder.records, 3)
+	assert.False(t, server.loginRecorder.records[2].success)
+}
+
+func TestIntrospect(t *testing.T) {
+	server := newTestServer(t)
+	server.authOptions.OAuthOptions.Clients = []oauth.Client{
+		{Name: "sidecar", Secret: "sidecar-secret"},
+		{Name: "spa"},
+	}
+	issued, _, err := server.tokenOperator.IssueTo(&authuser.DefaultInfo{Name: "admin", Groups: []string{"ops"}}, nil, nil)
+	assert.Nil(t, err)
+
+	introspect := func(clientID, clientSecret, token string) (*http.Response, introspectionResponse) {
+		resp := server.postForm(t, "/oauth/introspect", url.Values{
+			"client_id":     {clientID},
+			"client_secret": {clientSecret},
+			"token":         {token},
+		})
+		result := introspectionResponse{}
+		if resp.StatusCode == http.StatusOK {
+			decode(t, resp, &result)
+		}
+		return resp, result
+	}
+
+	// only the confidential clients are allowed
+	resp, _ := introspect("sidecar", "invalid", issued.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, _ = introspect("spa", "", issued.AccessToken)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, result := introspect("sidecar", "sidecar-secret", issued.AccessToken)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	assert.True(t, result.Active)
+	assert.Equal(t, "admin", result.Username)
+	assert.Equal(t, []string{"ops"}, result.Groups)
+	assert.Equal(t, "access_token", result.TokenType)
+	assert.Equal(t, int64(issued.ExpiresIn), result.ExpiresAt-result.IssuedAt)
+
+	assert.Nil(t, server.tokenOperator.Revoke(issued.AccessToken))
+	_, result = introspect("sidecar", "sidecar-secret", issued.AccessToken)
+	assert.Equal(t, introspectionResponse{Active: false}, result)
+	_, result = introspect("sidecar", "sidecar-secret", "invalid")
+	assert.Equal(t, introspectionResponse{Active: false}, result)
+}
+
+func TestRevoke(t *testing.T) {
+	server := newTestServer(t)
+	server.authOptions.OAuthOptions.Clients = []oauth.Client{
+		{Name: "sidecar", Secret: "sidecar-secret"},
+		{Name: "spa"},
+	}
+	issued, _, err := server.tokenOperator.IssueTo(&authuser.DefaultInfo{Name: "admin"}, nil, nil)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		status int
+	}{
+		{"unknown client", url.Values{"client_id": {"unknown"}}, http.StatusUnauthorized},
+		{"missing client", url.Values{}, http.StatusUnauthorized},
+		{"incorrect secret", url.Values{"client_id": {"sidecar"}, "client_secret": {"incorrect"}}, http.StatusUnauthorized},
+		{"secret of public client", url.Values{"client_id": {"spa"}, "client_secret": {"sidecar-secret"}}, http.StatusUnauthorized},
+		{"default client", url.Values{"client_id": {"default"}, "client_secret": {"ai"}}, http.StatusUnauthorized},
+		{"missing token", url.Values{"client_id": {"spa"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.status != http.StatusBadRequest {
+				tt.form.Set("token", issued.AccessToken)
+			}
+			resp := server.postForm(t, "/oauth/revoke", tt.form)
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+	_, err = server.tokenOperator.Verify(issued.AccessToken)
+	assert.Nil(t, err)
+
+	// the public clients are identified only
+	resp := server.postForm(t, "/oauth/revoke", url.Values{"client_id": {"spa"}, "token": {issued.AccessToken}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = server.tokenOperator.Verify(issued.AccessToken)
+	assert.NotNil(t, err)
+
+	resp = server.postForm(t, "/oauth/revoke", url.Values{"client_id": {"sidecar"}, "client_secret": {"sidecar-secret"}, "token": {issued.RefreshToken}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = server.tokenOperator.Verify(issued.RefreshToken)
+	assert.NotNil(t, err)
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	server := newTestServer(t)
+	issued, _, err := server.tokenOperator.IssueTo(&authuser.DefaultInfo{Name: "admin"}, nil, nil)
+	assert.Nil(t, err)
+	refresh := func(refreshToken string) *http.Response {
+		return server.postForm(t, "/oauth/token", url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refreshToken},
+		})
+	}
+
+	resp := refresh(issued.RefreshToken)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	result := &api.CommonSingleResult[oauth.Token]{}
+	decode(t, resp, result)
+	assert.NotEmpty(t, result.Data.RefreshToken)
+	assert.Empty(t, server.loginRecorder.records)
+
+	resp = refresh(issued.RefreshToken)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, []loginRecord{{username: "admin", loginType: iamv1.Token,
+		success: false, reason: iamv1.RefreshTokenReused}}, server.loginRecorder.records)
+	// the tokens refreshed from the reused one are revoked
+	_, err = server.tokenOperator.Verify(result.Data.AccessToken)
+	assert.NotNil(t, err)
+	resp = refresh(result.Data.RefreshToken)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Len(t, server.loginRecorder.records, 1)
+}
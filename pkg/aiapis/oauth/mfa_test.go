@@ -0,0 +1,93 @@
+package oauth
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+	"github.com/wongearl/go-restful-template/pkg/api"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	"github.com/wongearl/go-restful-template/pkg/middles/auth"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordGrantWithMFA(t *testing.T) {
+	alice := newPasswordUser(t, "alice")
+	server := newTestServer(t, alice, newPasswordUser(t, "bob"))
+
+	// the tokens are issued directly without MFA
+	resp := passwordGrant(t, server, "bob")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	result := &api.CommonSingleResult[oauth.Token]{}
+	decode(t, resp, result)
+	assert.NotEmpty(t, result.Data.AccessToken)
+
+	enrollment, err := server.mfaOperator.Enroll("alice")
+	assert.Nil(t, err)
+	code, err := auth.TOTPCode(enrollment.Secret, time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, server.mfaOperator.Activate("alice", code))
+	// the identity manager reads the annotation set on activation
+	alice.Annotations = map[string]string{iamv1.MFASecretAnnotation: "mfa-alice"}
+
+	resp = passwordGrant(t, server, "alice")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	challenge := mfaRequired{}
+	decode(t, resp, &challenge)
+	assert.Equal(t, "mfa_required", challenge.Error)
+	assert.NotEmpty(t, challenge.MFAToken)
+	assert.Equal(t, 300, challenge.ExpiresIn)
+	assert.Len(t, server.loginRecorder.records, 1)
+
+	resp = server.postForm(t, "/oauth/token", url.Values{
+		"grant_type": {"mfa_otp"},
+		"mfa_token":  {challenge.MFAToken},
+		"otp":        {"abcde-fghij"},
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Len(t, server.loginRecorder.records, 2)
+	assert.False(t, server.loginRecorder.records[1].success)
+	assert.Equal(t, "alice", server.loginRecorder.records[1].username)
+
+	resp = server.postForm(t, "/oauth/token", url.Values{
+		"grant_type": {"mfa_otp"},
+		"mfa_token":  {challenge.MFAToken},
+		"otp":        {enrollment.RecoveryCodes[0]},
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	result = &api.CommonSingleResult[oauth.Token]{}
+	decode(t, resp, result)
+	assert.NotEmpty(t, result.Data.AccessToken)
+	authenticated, err := server.tokenOperator.Verify(result.Data.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", authenticated.GetName())
+	assert.Len(t, server.loginRecorder.records, 3)
+	assert.True(t, server.loginRecorder.records[2].success)
+
+	// the challenge token can only be used once
+	resp = server.postForm(t, "/oauth/token", url.Values{
+		"grant_type": {"mfa_otp"},
+		"mfa_token":  {challenge.MFAToken},
+		"otp":        {enrollment.RecoveryCodes[1]},
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Len(t, server.loginRecorder.records, 3)
+
+	// the user blocked since the challenge was created is not issued tokens
+	resp = passwordGrant(t, server, "alice")
+	decode(t, resp, &challenge)
+	blocked := iamv1.UserAuthLimitExceeded
+	alice.Status.State = &blocked
+	resp = server.postForm(t, "/oauth/token", url.Values{
+		"grant_type": {"mfa_otp"},
+		"mfa_token":  {challenge.MFAToken},
+		"otp":        {enrollment.RecoveryCodes[1]},
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	result = &api.CommonSingleResult[oauth.Token]{}
+	decode(t, resp, result)
+	assert.Empty(t, result.Data.AccessToken)
+}
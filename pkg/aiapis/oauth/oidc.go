@@ -0,0 +1,404 @@
+package oauth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/token"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/request"
+	"github.com/wongearl/go-restful-template/pkg/api"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	"github.com/wongearl/go-restful-template/pkg/middles/auth"
+	"github.com/wongearl/go-restful-template/pkg/utils/sliceutil"
+
+	"github.com/dgrijalva/jwt-go"
+	restful "github.com/emicklei/go-restful"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	authuser "k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/klog"
+)
+
+const (
+	authorizationCodeGrantType = "authorization_code"
+	responseTypeCode           = "code"
+
+	scopeOpenID  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+
+	// error codes defined in RFC 6749 and OpenID Connect
+	errorInvalidRequest          = "invalid_request"
+	errorInvalidClient           = "invalid_client"
+	errorInvalidGrant            = "invalid_grant"
+	errorInvalidScope            = "invalid_scope"
+	errorUnsupportedResponseType = "unsupported_response_type"
+	errorLoginRequired           = "login_required"
+	errorServerError             = "server_error"
+	errorInvalidToken            = "invalid_token"
+	errorAccessDenied            = "access_denied"
+
+	// errors of the password grant which require further steps of the user
+	errorMFARequired            = "mfa_required"
+	errorPasswordChangeRequired = "password_change_required"
+)
+
+// providerMetadata is the OpenID Connect discovery document,
+// see https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type providerMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+// userInfo is the response of the userinfo endpoint, the claims are the same as the ID token
+type userInfo struct {
+	Subject           string   `json:"sub"`
+	Name              string   `json:"name,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	Locale            string   `json:"locale,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+}
+
+// oauthError is the error response defined in RFC 6749 section 5.2,
+// the endpoints consumed by standard OAuth clients respond with it instead of api.Result.
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func writeOAuthError(resp *restful.Response, statusCode int, code string, description string) {
+	_ = resp.WriteHeaderAndJson(statusCode, oauthError{Error: code, Description: description}, restful.MIME_JSON)
+}
+
+// redirectWithParams redirects the user agent back to the client
+func redirectWithParams(req *restful.Request, resp *restful.Response, redirectURL string, params url.Values) {
+	redirect, err := url.Parse(redirectURL)
+	if err != nil {
+		api.NewEmptyResult().WithError(apierrors.NewBadRequest(err.Error())).WriteTo(resp)
+		return
+	}
+	query := redirect.Query()
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+	redirect.RawQuery = query.Encode()
+	http.Redirect(resp.ResponseWriter, req.Request, redirect.String(), http.StatusFound)
+}
+
+func redirectWithError(req *restful.Request, resp *restful.Response, redirectURL, state, code, description string) {
+	redirectWithParams(req, resp, redirectURL, url.Values{
+		"error":             {code},
+		"error_description": {description},
+		"state":             {state},
+	})
+}
+
+// issuer returns the configured issuer URL, or the URL the request is sent to.
+// The forwarded headers are only honored for the requests from the trusted proxies.
+func (h *handler) issuer(req *restful.Request) string {
+	if h.authOptions.OAuthOptions.Issuer != "" {
+		return strings.TrimSuffix(h.authOptions.OAuthOptions.Issuer, "/")
+	}
+	scheme := "http"
+	if req.Request.TLS != nil {
+		scheme = "https"
+	}
+	host := req.Request.Host
+	if h.authOptions.OAuthOptions.IsTrustedProxy(req.Request.RemoteAddr) {
+		if proto := req.HeaderParameter("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwarded := req.HeaderParameter("X-Forwarded-Host"); forwarded != "" {
+			host = forwarded
+		}
+	}
+	return fmt.Sprintf("%s://%s", scheme, host)
+}
+
+// Discovery serves the OpenID Connect discovery document
+func (h *handler) Discovery(req *restful.Request, resp *restful.Response) {
+	issuer := h.issuer(req)
+	_ = resp.WriteAsJson(providerMetadata{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/oauth/userinfo",
+		JWKSURI:                           issuer + "/oauth/keys",
+		RevocationEndpoint:                issuer + "/oauth/revoke",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		DeviceAuthorizationEndpoint:       issuer + "/oauth/device_authorization",
+		ResponseTypesSupported:            []string{responseTypeCode},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{token.IDTokenSigningAlg(h.signingKeys)},
+		ScopesSupported:                   []string{scopeOpenID, scopeProfile, scopeEmail},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{auth.CodeChallengeMethodS256},
+		GrantTypesSupported:               []string{authorizationCodeGrantType, passwordGrantType, refreshTokenGrantType, clientCredentialsGrantType, deviceCodeGrantType},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "name", "preferred_username", "email", "locale", "groups"},
+	})
+}
+
+// UserInfo returns the claims of the user the access token is issued to
+func (h *handler) UserInfo(req *restful.Request, resp *restful.Response) {
+	authenticated, ok := request.UserFrom(req.Request.Context())
+	if !ok || authenticated.GetName() == authuser.Anonymous {
+		resp.AddHeader("WWW-Authenticate", fmt.Sprintf("Bearer error=%q", errorInvalidToken))
+		writeOAuthError(resp, http.StatusUnauthorized, errorInvalidToken, "access token is required")
+		return
+	}
+
+	user, err := h.im.DescribeUser(authenticated.GetName())
+	if err != nil {
+		klog.Error(err)
+		writeOAuthError(resp, http.StatusUnauthorized, errorInvalidToken, err.Error())
+		return
+	}
+
+	_ = resp.WriteAsJson(userInfo{
+		Subject:           user.Name,
+		Name:              user.Spec.DisplayName,
+		PreferredUsername: user.Name,
+		Email:             user.Spec.Email,
+		Locale:            user.Spec.Lang,
+		Groups:            user.Spec.Groups,
+	})
+}
+
+// authorizeParameter reads the parameter from the query of GET requests, or the form of POST requests
+func authorizeParameter(req *restful.Request, name string) string {
+	if req.Request.Method == http.MethodPost {
+		value, _ := req.BodyParameter(name)
+		return value
+	}
+	return req.QueryParameter(name)
+}
+
+// consentRequest is returned to the user agent if the client requires the user to approve the grant,
+// the user agent sends the same parameters with approve=true by POST to grant the authorization.
+type consentRequest struct {
+	Client      string   `json:"client"`
+	RedirectURI string   `json:"redirectURI"`
+	Scopes      []string `json:"scopes,omitempty"`
+}
+
+// Authorize issues an authorization code to the client on behalf of the current user,
+// the code is sent back to the client by redirecting the user agent to the redirect URI.
+func (h *handler) Authorize(req *restful.Request, resp *restful.Response) {
+	clientID := authorizeParameter(req, "client_id")
+	redirectURI := authorizeParameter(req, "redirect_uri")
+	state := authorizeParameter(req, "state")
+	scopes := strings.Fields(authorizeParameter(req, "scope"))
+
+	client, err := h.authOptions.OAuthOptions.RegisteredClient(clientID)
+	if err != nil {
+		api.NewEmptyResult().WithError(apierrors.NewBadRequest(err.Error())).WriteTo(resp)
+		return
+	}
+	// the errors before the redirect URI is verified must not be sent back to it
+	if redirectURI == "" {
+		api.NewEmptyResult().WithError(apierrors.NewBadRequest("redirect_uri is required")).WriteTo(resp)
+		return
+	}
+	redirectURL, err := client.ResolveAuthorizationRedirectURL(redirectURI)
+	if err != nil {
+		api.NewEmptyResult().WithError(apierrors.NewBadRequest(err.Error())).WriteTo(resp)
+		return
+	}
+
+	if responseType := authorizeParameter(req, "response_type"); responseType != responseTypeCode {
+		redirectWithError(req, resp, redirectURL, state, errorUnsupportedResponseType, fmt.Sprintf("response type %s is not supported", responseType))
+		return
+	}
+
+	codeChallenge := authorizeParameter(req, "code_challenge")
+	codeChallengeMethod := authorizeParameter(req, "code_challenge_method")
+	if codeChallenge != "" && codeChallengeMethod != auth.CodeChallengeMethodS256 {
+		redirectWithError(req, resp, redirectURL, state, errorInvalidRequest, fmt.Sprintf("code challenge method %q is not supported, use S256", codeChallengeMethod))
+		return
+	}
+	// public clients can not keep a secret, the code is bound to the client instance by PKCE instead
+	if codeChallenge == "" && client.Secret == "" {
+		redirectWithError(req, resp, redirectURL, state, errorInvalidRequest, "code_challenge is required for public clients")
+		return
+	}
+	// the ID token is signed with the client secret if no signing key is configured
+	if sliceutil.HasString(scopes, scopeOpenID) && !token.CanSignIDToken(h.signingKeys, client.Secret) {
+		redirectWithError(req, resp, redirectURL, state, errorInvalidScope, "openid scope requires signing keys for public clients")
+		return
+	}
+
+	authenticated, ok := request.UserFrom(req.Request.Context())
+	if !ok || authenticated.GetName() == authuser.Anonymous {
+		if client.RespondWithChallenges {
+			resp.AddHeader("WWW-Authenticate", `Basic realm="ai"`)
+			api.NewEmptyResult().WithError(apierrors.NewUnauthorized("Unauthorized: user is not logged in")).WriteTo(resp)
+			return
+		}
+		redirectWithError(req, resp, redirectURL, state, errorLoginRequired, "user is not logged in")
+		return
+	}
+	if scopedAccessToken(authenticated) {
+		redirectWithError(req, resp, redirectURL, state, errorAccessDenied, "clients can not be authorized with a scoped access token")
+		return
+	}
+
+	switch client.GrantMethod {
+	case oauth.GrantHandlerDeny:
+		redirectWithError(req, resp, redirectURL, state, errorAccessDenied, "the client is not allowed to be granted")
+		return
+	case oauth.GrantHandlerPrompt:
+		if req.Request.Method != http.MethodPost {
+			api.NewResult[*consentRequest]().WithObject(&consentRequest{Client: client.Name, RedirectURI: redirectURL, Scopes: scopes}).WriteTo(resp)
+			return
+		}
+		if approve, _ := req.BodyParameter("approve"); approve != "true" {
+			redirectWithError(req, resp, redirectURL, state, errorAccessDenied, "the user denied the request")
+			return
+		}
+	}
+
+	code, err := h.codeStore.Issue(&auth.AuthorizationCode{
+		ClientID:            client.Name,
+		RedirectURI:         redirectURI,
+		Username:            authenticated.GetName(),
+		Groups:              authenticated.GetGroups(),
+		Scopes:              scopes,
+		Nonce:               authorizeParameter(req, "nonce"),
+		CodeChallenge:       codeChallenge,
+		CodeChallengeMethod: codeChallengeMethod,
+		AuthTime:            time.Now(),
+	})
+	if err != nil {
+		redirectWithError(req, resp, redirectURL, state, errorServerError, err.Error())
+		return
+	}
+
+	redirectWithParams(req, resp, redirectURL, url.Values{"code": {code}, "state": {state}})
+}
+
+// authenticateClient verifies the client credentials sent in the request body,
+// HTTP basic authentication is reserved for users by the authentication filter.
+// Public clients, which have no secret, are identified by client_id only.
+// The default clients are not accepted since anyone knows their secrets.
+func (h *handler) authenticateClient(req *restful.Request) (oauth.Client, error) {
+	clientID, _ := req.BodyParameter("client_id")
+	clientSecret, _ := req.BodyParameter("client_secret")
+	client, err := h.authOptions.OAuthOptions.RegisteredClient(clientID)
+	if err != nil {
+		return oauth.Client{}, err
+	}
+	if client.Secret != "" && subtle.ConstantTimeCompare([]byte(client.Secret), []byte(clientSecret)) != 1 {
+		return oauth.Client{}, fmt.Errorf("client authentication failed")
+	}
+	return client, nil
+}
+
+// authorizationCodeGrant exchanges the authorization code for tokens, see RFC 6749 section 4.1.3.
+// An ID token is issued along with the token pair if the openid scope is authorized.
+func (h *handler) authorizationCodeGrant(req *restful.Request, resp *restful.Response) {
+	client, err := h.authenticateClient(req)
+	if err != nil {
+		writeOAuthError(resp, http.StatusUnauthorized, errorInvalidClient, err.Error())
+		return
+	}
+
+	codeStr, _ := req.BodyParameter("code")
+	if codeStr == "" {
+		writeOAuthError(resp, http.StatusBadRequest, errorInvalidRequest, "code is required")
+		return
+	}
+	code, err := h.codeStore.Exchange(codeStr)
+	if err != nil {
+		if err == auth.InvalidAuthorizationCodeError {
+			writeOAuthError(resp, http.StatusBadRequest, errorInvalidGrant, err.Error())
+			return
+		}
+		writeOAuthError(resp, http.StatusInternalServerError, errorServerError, err.Error())
+		return
+	}
+	redirectURI, _ := req.BodyParameter("redirect_uri")
+	if code.ClientID != client.Name || code.RedirectURI != redirectURI {
+		writeOAuthError(resp, http.StatusBadRequest, errorInvalidGrant, "authorization code was issued to another client or redirect URI")
+		return
+	}
+	codeVerifier, _ := req.BodyParameter("code_verifier")
+	if err = code.VerifyCodeVerifier(codeVerifier); err != nil {
+		writeOAuthError(resp, http.StatusBadRequest, errorInvalidGrant, err.Error())
+		return
+	}
+
+	result, err := h.issueTokenTo(&authuser.DefaultInfo{Name: code.Username, Groups: code.Groups}, iamv1.OAuth, "", req)
+	if err != nil {
+		writeOAuthError(resp, http.StatusInternalServerError, errorServerError, err.Error())
+		return
+	}
+
+	if sliceutil.HasString(code.Scopes, scopeOpenID) {
+		if result.IDToken, err = h.issueIDToken(req, client, code, result); err != nil {
+			writeOAuthError(resp, http.StatusInternalServerError, errorServerError, err.Error())
+			return
+		}
+	}
+
+	resp.AddHeader("Cache-Control", "no-store")
+	resp.AddHeader("Pragma", "no-cache")
+	_ = resp.WriteAsJson(result)
+}
+
+func (h *handler) issueIDToken(req *restful.Request, client oauth.Client, code *auth.AuthorizationCode, result *oauth.Token) (string, error) {
+	user, err := h.im.DescribeUser(code.Username)
+	if err != nil {
+		klog.Error(err)
+		return "", err
+	}
+
+	now := time.Now()
+	expiresIn := time.Duration(result.ExpiresIn) * time.Second
+	if expiresIn == 0 {
+		expiresIn = oauth.DefaultTokenMaxAge
+	}
+	claims := &token.IDTokenClaims{
+		Nonce:    code.Nonce,
+		AuthTime: code.AuthTime.Unix(),
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    h.issuer(req),
+			Subject:   user.Name,
+			Audience:  client.Name,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(expiresIn).Unix(),
+		},
+	}
+	if sliceutil.HasString(code.Scopes, scopeProfile) {
+		claims.Name = user.Spec.DisplayName
+		claims.PreferredUsername = user.Name
+		claims.Locale = user.Spec.Lang
+		claims.Groups = user.Spec.Groups
+	}
+	if sliceutil.HasString(code.Scopes, scopeEmail) {
+		claims.Email = user.Spec.Email
+	}
+	return token.SignIDToken(claims, h.signingKeys, client.Secret)
+}
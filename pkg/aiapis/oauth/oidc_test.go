@@ -0,0 +1,333 @@
+package oauth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/token"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var testUser = &iamv1.User{
+	ObjectMeta: metav1.ObjectMeta{Name: "admin"},
+	Spec: iamv1.UserSpec{
+		Email:       "admin@ai.io",
+		Lang:        "en",
+		DisplayName: "Administrator",
+		Groups:      []string{"ops"},
+	},
+}
+
+var testClient = oauth.Client{
+	Name:         "dashboard",
+	Secret:       "dashboard-secret",
+	RedirectURIs: []string{"https://dashboard.ai.io/callback"},
+	GrantMethod:  oauth.GrantHandlerAuto,
+}
+
+func authorize(t *testing.T, server *testServer, params url.Values, header http.Header) *url.URL {
+	resp := server.get(t, "/oauth/authorize?"+params.Encode(), header)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	assert.Nil(t, err)
+	return location
+}
+
+func TestDiscovery(t *testing.T) {
+	server := newTestServer(t)
+
+	metadata := providerMetadata{}
+	decode(t, server.get(t, "/.well-known/openid-configuration", nil), &metadata)
+	assert.Equal(t, server.URL, metadata.Issuer)
+	assert.Equal(t, server.URL+"/oauth/authorize", metadata.AuthorizationEndpoint)
+	assert.Equal(t, server.URL+"/oauth/keys", metadata.JWKSURI)
+	assert.Equal(t, []string{"HS256"}, metadata.IDTokenSigningAlgValuesSupported)
+
+	// the forwarded headers are ignored unless the request comes from a trusted proxy
+	forwarded := http.Header{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"ai.example.com"}}
+	decode(t, server.get(t, "/.well-known/openid-configuration", forwarded), &metadata)
+	assert.Equal(t, server.URL, metadata.Issuer)
+	server.authOptions.OAuthOptions.TrustedProxies = []string{"127.0.0.0/8"}
+	decode(t, server.get(t, "/.well-known/openid-configuration", forwarded), &metadata)
+	assert.Equal(t, "https://ai.example.com", metadata.Issuer)
+
+	server.authOptions.OAuthOptions.Issuer = "https://ai.io/"
+	decode(t, server.get(t, "/.well-known/openid-configuration", nil), &metadata)
+	assert.Equal(t, "https://ai.io", metadata.Issuer)
+	assert.Equal(t, "https://ai.io/oauth/token", metadata.TokenEndpoint)
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	server := newTestServer(t, testUser)
+	server.authOptions.OAuthOptions.Clients = []oauth.Client{testClient}
+
+	location := authorize(t, server, url.Values{
+		"response_type": {"code"},
+		"client_id":     {"dashboard"},
+		"redirect_uri":  {"https://dashboard.ai.io/callback"},
+		"scope":         {"openid profile email"},
+		"state":         {"xyz"},
+		"nonce":         {"n-0S6_WzA2Mj"},
+	}, basicAuth("admin"))
+	assert.Equal(t, "dashboard.ai.io", location.Host)
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+	code := location.Query().Get("code")
+	assert.NotEmpty(t, code)
+
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {"https://dashboard.ai.io/callback"},
+		"client_id":     {"dashboard"},
+		"client_secret": {"dashboard-secret"},
+	}
+
+	// wrong client secret
+	form := url.Values{}
+	for key, values := range exchange {
+		form[key] = values
+	}
+	form.Set("client_secret", "invalid")
+	resp := server.postForm(t, "/oauth/token", form)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = server.postForm(t, "/oauth/token", exchange)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	result := &oauth.Token{}
+	decode(t, resp, result)
+	assert.NotEmpty(t, result.AccessToken)
+	assert.NotEmpty(t, result.RefreshToken)
+
+	claims := &token.IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(result.IDToken, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte("dashboard-secret"), nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, server.URL, claims.Issuer)
+	assert.Equal(t, "admin", claims.Subject)
+	assert.Equal(t, "dashboard", claims.Audience)
+	assert.Equal(t, "n-0S6_WzA2Mj", claims.Nonce)
+	assert.Equal(t, "admin@ai.io", claims.Email)
+	assert.Equal(t, "Administrator", claims.Name)
+
+	// codes can only be exchanged once
+	resp = server.postForm(t, "/oauth/token", exchange)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	oauthErr := oauthError{}
+	decode(t, resp, &oauthErr)
+	assert.Equal(t, errorInvalidGrant, oauthErr.Error)
+
+	info := userInfo{}
+	resp = server.get(t, "/oauth/userinfo", bearer(result.AccessToken))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	decode(t, resp, &info)
+	assert.Equal(t, userInfo{Subject: "admin", Name: "Administrator", PreferredUsername: "admin",
+		Email: "admin@ai.io", Locale: "en", Groups: []string{"ops"}}, info)
+
+	resp = server.get(t, "/oauth/userinfo", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	assert.Len(t, server.loginRecorder.records, 1)
+	assert.Equal(t, iamv1.OAuth, server.loginRecorder.records[0].loginType)
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+	server := newTestServer(t, testUser)
+	server.authOptions.OAuthOptions.Clients = []oauth.Client{testClient}
+
+	// errors are not redirected to unverified redirect URIs
+	resp := server.get(t, "/oauth/authorize?"+url.Values{
+		"response_type": {"code"},
+		"client_id":     {"dashboard"},
+		"redirect_uri":  {"https://evil.io/callback"},
+	}.Encode(), basicAuth("admin"))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = server.get(t, "/oauth/authorize?"+url.Values{
+		"response_type": {"code"},
+		"client_id":     {"unknown"},
+		"redirect_uri":  {"https://dashboard.ai.io/callback"},
+	}.Encode(), basicAuth("admin"))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// the default client has a well known secret and redirects anywhere
+	resp = server.get(t, "/oauth/authorize?"+url.Values{
+		"response_type": {"code"},
+		"client_id":     {"default"},
+		"redirect_uri":  {"https://evil.io/callback"},
+	}.Encode(), basicAuth("admin"))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Location"))
+
+	// the code is never sent to any redirect URI
+	server.authOptions.OAuthOptions.Clients = append(server.authOptions.OAuthOptions.Clients,
+		oauth.Client{Name: "any", Secret: "any-secret", RedirectURIs: []string{oauth.AllowAllRedirectURI}, GrantMethod: oauth.GrantHandlerAuto})
+	for _, redirectURI := range []string{"https://evil.io/callback", oauth.AllowAllRedirectURI} {
+		resp = server.get(t, "/oauth/authorize?"+url.Values{
+			"response_type": {"code"},
+			"client_id":     {"any"},
+			"redirect_uri":  {redirectURI},
+		}.Encode(), basicAuth("admin"))
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Location"))
+	}
+
+	location := authorize(t, server, url.Values{
+		"response_type": {"token"},
+		"client_id":     {"dashboard"},
+		"redirect_uri":  {"https://dashboard.ai.io/callback"},
+		"state":         {"xyz"},
+	}, basicAuth("admin"))
+	assert.Equal(t, errorUnsupportedResponseType, location.Query().Get("error"))
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+
+	location = authorize(t, server, url.Values{
+		"response_type": {"code"},
+		"client_id":     {"dashboard"},
+		"redirect_uri":  {"https://dashboard.ai.io/callback"},
+	}, nil)
+	assert.Equal(t, errorLoginRequired, location.Query().Get("error"))
+
+	// the scoped access tokens can not be exchanged for the tokens without the scopes
+	location = authorize(t, server, url.Values{
+		"response_type": {"code"},
+		"client_id":     {"dashboard"},
+		"redirect_uri":  {"https://dashboard.ai.io/callback"},
+	}, scopedBearer("admin"))
+	assert.Equal(t, errorAccessDenied, location.Query().Get("error"))
+	assert.Empty(t, location.Query().Get("code"))
+
+	// the code is bound to the redirect URI
+	server.authOptions.OAuthOptions.Clients[0].RedirectURIs = append(server.authOptions.OAuthOptions.Clients[0].RedirectURIs, "https://dashboard.ai.io/other")
+	location = authorize(t, server, url.Values{
+		"response_type": {"code"},
+		"client_id":     {"dashboard"},
+		"redirect_uri":  {"https://dashboard.ai.io/callback"},
+	}, basicAuth("admin"))
+	resp = server.postForm(t, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {"https://dashboard.ai.io/other"},
+		"client_id":     {"dashboard"},
+		"client_secret": {"dashboard-secret"},
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestAuthorizationCodeWithPKCE(t *testing.T) {
+	server := newTestServer(t, testUser)
+	server.authOptions.OAuthOptions.Clients = []oauth.Client{{
+		Name:         "spa",
+		RedirectURIs: []string{"https://spa.ai.io/callback"},
+		GrantMethod:  oauth.GrantHandlerAuto,
+	}}
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {"spa"},
+		"redirect_uri":  {"https://spa.ai.io/callback"},
+		"state":         {"xyz"},
+	}
+
+	// public clients must use PKCE
+	location := authorize(t, server, params, basicAuth("admin"))
+	assert.Equal(t, errorInvalidRequest, location.Query().Get("error"))
+
+	// the ID token can not be signed for public clients without signing keys
+	params.Set("scope", "openid")
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	location = authorize(t, server, params, basicAuth("admin"))
+	assert.Equal(t, errorInvalidScope, location.Query().Get("error"))
+	params.Del("scope")
+
+	params.Set("code_challenge", verifier)
+	params.Set("code_challenge_method", "plain")
+	location = authorize(t, server, params, basicAuth("admin"))
+	assert.Equal(t, errorInvalidRequest, location.Query().Get("error"))
+
+	params.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+	params.Set("code_challenge_method", "S256")
+	exchange := func(verifier string) *http.Response {
+		location := authorize(t, server, params, basicAuth("admin"))
+		return server.postForm(t, "/oauth/token", url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {location.Query().Get("code")},
+			"redirect_uri":  {"https://spa.ai.io/callback"},
+			"client_id":     {"spa"},
+			"code_verifier": {verifier},
+		})
+	}
+
+	resp := exchange("")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = exchange("M25iVXpKU3puUjFaYWg3T1NDTDQtcW1ROUY5YXlwalNoc0hhakxifmZHag")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = exchange(verifier)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	result := &oauth.Token{}
+	decode(t, resp, result)
+	assert.NotEmpty(t, result.AccessToken)
+	// no ID token without the openid scope
+	assert.Empty(t, result.IDToken)
+}
+
+func TestAuthorizeGrantMethod(t *testing.T) {
+	server := newTestServer(t, testUser)
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {"dashboard"},
+		"redirect_uri":  {"https://dashboard.ai.io/callback"},
+		"scope":         {"openid"},
+		"state":         {"xyz"},
+	}
+
+	client := testClient
+	client.GrantMethod = oauth.GrantHandlerDeny
+	server.authOptions.OAuthOptions.Clients = []oauth.Client{client}
+	location := authorize(t, server, params, basicAuth("admin"))
+	assert.Equal(t, errorAccessDenied, location.Query().Get("error"))
+	assert.Empty(t, location.Query().Get("code"))
+
+	client.GrantMethod = oauth.GrantHandlerPrompt
+	server.authOptions.OAuthOptions.Clients = []oauth.Client{client}
+	resp := server.get(t, "/oauth/authorize?"+params.Encode(), basicAuth("admin"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	consent := struct {
+		Data consentRequest `json:"data"`
+	}{}
+	decode(t, resp, &consent)
+	assert.Equal(t, consentRequest{Client: "dashboard", RedirectURI: "https://dashboard.ai.io/callback", Scopes: []string{"openid"}}, consent.Data)
+
+	approve := func(approve string) *url.URL {
+		form := url.Values{"approve": {approve}}
+		for key, values := range params {
+			form[key] = values
+		}
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/oauth/authorize", strings.NewReader(form.Encode()))
+		assert.Nil(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("admin", "P@88w0rd")
+		resp, err := server.client().Do(req)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+		location, err := url.Parse(resp.Header.Get("Location"))
+		assert.Nil(t, err)
+		return location
+	}
+	location = approve("false")
+	assert.Equal(t, errorAccessDenied, location.Query().Get("error"))
+	location = approve("true")
+	assert.NotEmpty(t, location.Query().Get("code"))
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+}
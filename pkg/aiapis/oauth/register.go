@@ -1,7 +1,13 @@
 package oauth
 
 import (
+	"net/http"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/token"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/config"
+	"github.com/wongearl/go-restful-template/pkg/api"
 	"github.com/wongearl/go-restful-template/pkg/middles/auth"
 	"github.com/wongearl/go-restful-template/pkg/middles/iam/im"
 	"k8s.io/client-go/kubernetes"
@@ -9,36 +15,152 @@ import (
 	"github.com/emicklei/go-restful"
 )
 
-func AddToContainer(c *restful.Container, im im.IdentityManagementInterface, option *config.AiOptions, k8sclient kubernetes.Interface,
+func AddToContainer(c *restful.Container, im im.IdentityManagementInterface, option *config.AiOptions, authOptions *authoptions.AuthenticationOptions, k8sclient kubernetes.Interface,
 	tokenOperator auth.TokenManagementInterface,
+	signingKeys *token.KeySet,
+	codeStore auth.AuthorizationCodeStore,
+	deviceStore auth.DeviceAuthorizationStore,
+	stateStore auth.OAuthStateStore,
 	passwordAuthenticator auth.PasswordAuthenticator,
-	loginRecorder auth.LoginRecorder) error {
+	oauthAuthenticator auth.OAuthAuthenticator,
+	loginRecorder auth.LoginRecorder,
+	mfaOperator auth.MFAManagementInterface) error {
 
 	ws := &restful.WebService{}
 	ws.Path("/oauth").
 		Consumes(restful.MIME_JSON).
 		Produces(restful.MIME_JSON)
 
-	handler := newHandler(im, tokenOperator, passwordAuthenticator, loginRecorder, option, k8sclient)
+	handler := newHandler(im, tokenOperator, signingKeys, codeStore, deviceStore, stateStore, passwordAuthenticator, oauthAuthenticator, loginRecorder, mfaOperator, option, authOptions, k8sclient)
 	ws.Route(ws.POST("/token").
 		To(handler.Token).
 		Consumes("application/x-www-form-urlencoded").
-		Doc("The resource owner password credentials grant type is suitable in\n" +
-			"cases where the resource owner has a trust relationship with the\n" +
-			"client, such as the device operating system or a highly privileged application.").
-		Param(ws.FormParameter("grant_type", "Value MUST be set to \"password\".").Required(true)).
-		Param(ws.FormParameter("username", "The resource owner username.").Required(true)).
-		Param(ws.FormParameter("password", "The resource owner password.").Required(true)).
-		Doc("The resource owner password credentials grant type is suitable in\n" +
-			"cases where the resource owner has a trust relationship with the\n" +
-			"client, such as the device operating system or a highly privileged application."))
+		Doc("The token endpoint, see RFC 6749 section 3.2. The password grant authenticates the resource owner by "+
+			"the username and password, refresh_token rotates the token pair, authorization_code exchanges the code "+
+			"issued by /oauth/authorize, mfa_otp completes the password grant of the users with MFA enabled, "+
+			"client_credentials issues an access token to the service identity of a confidential client, "+
+			"and the device_code grant polls the device authorization approved by the user.").
+		Param(ws.FormParameter("grant_type", "One of \"password\", \"refresh_token\", \"authorization_code\", \"mfa_otp\", \"client_credentials\" and \"urn:ietf:params:oauth:grant-type:device_code\".").Required(true)).
+		Param(ws.FormParameter("username", "The resource owner username, required by the password grant.")).
+		Param(ws.FormParameter("password", "The resource owner password, required by the password grant.")).
+		Param(ws.FormParameter("code", "The authorization code, required by the authorization_code grant.")).
+		Param(ws.FormParameter("redirect_uri", "The redirect URI of the authorization request, required by the authorization_code grant.")).
+		Param(ws.FormParameter("client_id", "The client identifier, required by the authorization_code, client_credentials and device_code grants.")).
+		Param(ws.FormParameter("client_secret", "The client secret, required by the client_credentials grant and the authorization_code grant unless the client is public.")).
+		Param(ws.FormParameter("scope", "Space separated scopes requested by the client_credentials grant, all the allowed scopes are granted if it is empty. "+
+			"The scopes do not restrict the token, the permissions of the client are granted by RBAC.")).
+		Param(ws.FormParameter("code_verifier", "The PKCE code verifier, required if the authorization request has a code challenge.")).
+		Param(ws.FormParameter("mfa_token", "The challenge token returned by the password grant with the mfa_required error, required by the mfa_otp grant.")).
+		Param(ws.FormParameter("otp", "The one-time password or a recovery code, required by the mfa_otp grant.")).
+		Param(ws.FormParameter("device_code", "The device code returned by /oauth/device_authorization, required by the device_code grant.")).
+		Returns(http.StatusOK, api.StatusOK, oauth.Token{}))
+
+	ws.Route(ws.GET("/authorize").
+		To(handler.Authorize).
+		Param(ws.QueryParameter("response_type", "Value MUST be set to \"code\".").Required(true)).
+		Param(ws.QueryParameter("client_id", "The client identifier.").Required(true)).
+		Param(ws.QueryParameter("redirect_uri", "The URI the user agent is redirected to with the authorization code.").Required(true)).
+		Param(ws.QueryParameter("scope", "Space separated scopes, \"openid\" requests an ID token.")).
+		Param(ws.QueryParameter("state", "An opaque value passed back to the client.")).
+		Param(ws.QueryParameter("nonce", "The value included in the ID token to mitigate replay attacks.")).
+		Param(ws.QueryParameter("code_challenge", "PKCE code challenge, required by public clients, see RFC 7636.")).
+		Param(ws.QueryParameter("code_challenge_method", "Value MUST be set to \"S256\".")).
+		Doc("The authorization endpoint of the authorization code flow, see RFC 6749 section 4.1. " +
+			"Clients with the prompt grant method get the consent request, which is approved by POST."))
+
+	ws.Route(ws.POST("/authorize").
+		To(handler.Authorize).
+		Consumes("application/x-www-form-urlencoded").
+		Param(ws.FormParameter("approve", "Set to \"true\" to approve the consent request, the other parameters are the same as GET.").Required(true)).
+		Doc("Approve or deny the authorization request of a client with the prompt grant method."))
+
+	ws.Route(ws.POST("/device_authorization").
+		To(handler.DeviceAuthorization).
+		Consumes("application/x-www-form-urlencoded").
+		Param(ws.FormParameter("client_id", "The client identifier.").Required(true)).
+		Param(ws.FormParameter("client_secret", "The client secret, required unless the client is public.")).
+		Doc("The device authorization endpoint, see RFC 8628 section 3.1. The device polls /oauth/token with the " +
+			"device code returned, while the user approves the request at the verification URI with the user code. " +
+			"Scopes are not supported, the tokens have all the permissions of the user."))
+
+	ws.Route(ws.GET("/device").
+		To(handler.DeviceVerification).
+		Param(ws.QueryParameter("user_code", "The user code displayed on the device.").Required(true)).
+		Doc("Describe the pending device authorization of the user code to the current user."))
+
+	ws.Route(ws.POST("/device").
+		To(handler.DeviceVerification).
+		Consumes("application/x-www-form-urlencoded").
+		Param(ws.FormParameter("user_code", "The user code displayed on the device.").Required(true)).
+		Param(ws.FormParameter("approve", "Set to \"true\" to approve the device authorization, otherwise it is denied.").Required(true)).
+		Doc("Approve or deny the device authorization of the user code on behalf of the current user."))
+
+	ws.Route(ws.GET("/userinfo").
+		To(handler.UserInfo).
+		Doc("Returns the claims about the user the access token is issued to, see OpenID Connect Core 1.0 section 5.3."))
+
+	ws.Route(ws.POST("/userinfo").
+		To(handler.UserInfo).
+		Doc("Returns the claims about the user the access token is issued to, see OpenID Connect Core 1.0 section 5.3."))
+
+	ws.Route(ws.GET("/login/{callback}").
+		To(handler.oauthLogin).
+		Param(ws.PathParameter("callback", "The name of the OAuth identity provider.")).
+		Doc("Redirect the user agent to the OAuth identity provider to log in, the provider redirects back to the callback."))
+
+	ws.Route(ws.GET("/callback/{callback}").
+		To(handler.oauthCallback).
+		Param(ws.PathParameter("callback", "The name of the OAuth identity provider.")).
+		Param(ws.QueryParameter("code", "The authorization code issued by the identity provider.").Required(true)).
+		Param(ws.QueryParameter("state", "The state sent to the identity provider by /oauth/login.").Required(true)).
+		Doc("The callback of OAuth identity providers, the identity is mapped to a user and the token pair of the user is returned.").
+		Returns(http.StatusOK, api.StatusOK, oauth.Token{}))
+
+	ws.Route(ws.POST("/revoke").
+		To(handler.Revoke).
+		Consumes("application/x-www-form-urlencoded").
+		Param(ws.FormParameter("token", "The access token or refresh token to be revoked.").Required(true)).
+		Param(ws.FormParameter("token_type_hint", "A hint about the type of the token, access_token or refresh_token.")).
+		Param(ws.FormParameter("client_id", "The identifier of the client.").Required(true)).
+		Param(ws.FormParameter("client_secret", "The client secret, required if the client has one.")).
+		Doc("Revoke an access token or refresh token, see RFC 7009."))
+
+	ws.Route(ws.POST("/introspect").
+		To(handler.Introspect).
+		Consumes("application/x-www-form-urlencoded").
+		Param(ws.FormParameter("token", "The access token or refresh token to be introspected.").Required(true)).
+		Param(ws.FormParameter("client_id", "The identifier of a confidential client.").Required(true)).
+		Param(ws.FormParameter("client_secret", "The client secret.").Required(true)).
+		Doc("Tell whether the token is active and who it is issued to, see RFC 7662. " +
+			"The revoked and expired tokens are inactive."))
+
+	ws.Route(ws.POST("/logout").
+		To(handler.Logout).
+		Consumes("application/x-www-form-urlencoded").
+		Param(ws.FormParameter("refresh_token", "The refresh token issued along with the access token.")).
+		Doc("Revoke the access token of the current user, and the refresh token if provided."))
+
+	ws.Route(ws.GET("/keys").
+		To(handler.Keys).
+		Doc("The public keys used to verify the signature of tokens, in JWKS format, see RFC 7517.").
+		Returns(http.StatusOK, api.StatusOK, token.JSONWebKeySet{}))
 
 	ws.Route(ws.POST("/statictoken").
 		To(handler.createToken).
 		Consumes("application/x-www-form-urlencoded").
-		Doc("Get access token"))
+		Deprecate().
+		Doc("Get access token. Deprecated: the token never expires and is not recorded, " +
+			"use the personal access tokens of /ai-apis/iam.ai.io/v1/users/{user}/accesstokens instead."))
 
 	c.Add(ws)
 
+	wellKnown := &restful.WebService{}
+	wellKnown.Path("/.well-known").
+		Produces(restful.MIME_JSON)
+	wellKnown.Route(wellKnown.GET("/openid-configuration").
+		To(handler.Discovery).
+		Doc("The OpenID Connect discovery document."))
+	c.Add(wellKnown)
+
 	return nil
 }
@@ -13,9 +13,11 @@ import (
 	iamapi "github.com/wongearl/go-restful-template/pkg/aiapis/iam/v1"
 	"github.com/wongearl/go-restful-template/pkg/aiapis/oauth"
 	"github.com/wongearl/go-restful-template/pkg/aiapis/version"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/authoricators/accesstoken"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/authoricators/basic"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/authoricators/jwttoken"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/request/basictoken"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/token"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authorization/authorizer"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authorization/path"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authorization/rbac"
@@ -64,6 +66,8 @@ type APIServer struct {
 	InformerFactory  informers.InformerFactory
 	CacheClient      cacheclient.Interface
 	ClusterClient    clusterclient.ClusterClients
+	// SigningKeys signs jwt tokens, JwtSecret is used if not configured
+	SigningKeys *token.KeySet
 }
 
 func (s *APIServer) PrepareRun(stopCh <-chan struct{}) error {
@@ -101,15 +105,16 @@ func enrichSwaggerObject(swo *spec.Swagger) {
 	}
 }
 
+// excludedPaths are allowed without the RBAC rules
+var excludedPaths = []string{"/oauth/token", "/oauth/revoke", "/oauth/introspect", "/oauth/logout", "/oauth/keys", "/oauth/authorize", "/oauth/device_authorization", "/oauth/device", "/oauth/userinfo", "/oauth/login/*", "/oauth/callback/*", "/.well-known/openid-configuration", "/ai-apis/register.ai.io/*", "/ai-apis/config.ai.io/*", "/ai-apis/version", "/ai-apis/metrics",
+	"/ai-apis/storage.ai.io/v1/s3/health", "/ai-apis/iam.ai.io/v1/selfsubjectrulesreviews",
+	"/apidocs", "/apidocs/*", "/apidocs.json", "/debug/pprof"}
+
 func (s *APIServer) buildHandlerChain(stopCh <-chan struct{}) {
 	requestInfoResolver := &request.RequestInfoFactory{
 		APIPrefixes:          sets.NewString("api", "apis", "ai-apis", "ai-api"),
 		GrouplessAPIPrefixes: sets.NewString("api", "ai-api"),
-		GlobalResources: []schema.GroupResource{
-			iamiov1.Resource(iamiov1.ResourcePluralUser),
-			iamiov1.Resource(iamiov1.ResourcePluralGlobalRole),
-			iamiov1.Resource(iamiov1.ResourcePluralGlobalRoleBinding),
-		},
+		GlobalResources:      iamiov1.GlobalResources,
 	}
 
 	handler := s.Server.Handler
@@ -119,12 +124,9 @@ func (s *APIServer) buildHandlerChain(stopCh <-chan struct{}) {
 	// this is useful for the test use cases
 	if !s.Config.AuthenticationOptions.Disabled {
 		var authorizers authorizer.Authorizer
-		excludedPaths := []string{"/oauth/token", "/ai-apis/register.ai.io/*", "/ai-apis/config.ai.io/*", "/ai-apis/version", "/ai-apis/metrics",
-			"/ai-apis/storage.ai.io/v1/s3/health",
-			"/apidocs", "/apidocs/*", "/apidocs.json", "/debug/pprof"}
 		pathAuthorizer, _ := path.NewAuthorizer(excludedPaths)
 		amOperator := am.NewReadOnlyOperator(s.InformerFactory)
-		authorizers = unionauthorizer.New(pathAuthorizer, rbac.NewRBACAuthorizer(amOperator))
+		authorizers = unionauthorizer.New(pathAuthorizer, rbac.NewRBACAuthorizer(amOperator, s.InformerFactory))
 		handler = filters.WithAuthorization(handler, authorizers)
 	}
 
@@ -132,10 +134,14 @@ func (s *APIServer) buildHandlerChain(stopCh <-chan struct{}) {
 	// authenticators are unordered
 	authn := unionauth.New(anonymous.NewAuthenticator(),
 		basictoken.New(basic.NewBasicAuthenticator(auth.NewPasswordAuthenticator(s.KubernetesClient.Ai(),
-			s.InformerFactory.AiSharedInformerFactory().Iam().V1().Users().Lister(),
-			s.Config.AuthenticationOptions, s.Config.AiOptions), loginRecorder)),
-		bearertoken.New(jwttoken.NewTokenAuthenticator(auth.NewTokenOperator(s.CacheClient, s.Config.AuthenticationOptions),
-			s.InformerFactory.AiSharedInformerFactory().Iam().V1().Users().Lister())))
+			s.InformerFactory.AiSharedInformerFactory().Iam().V1().Users(),
+			s.Config.AuthenticationOptions, s.Config.AiOptions),
+			s.InformerFactory.AiSharedInformerFactory().Iam().V1().Users().Lister(), loginRecorder)),
+		bearertoken.New(accesstoken.NewTokenAuthenticator(auth.NewAccessTokenOperator(s.KubernetesClient.Ai(),
+			s.InformerFactory.AiSharedInformerFactory().Iam().V1().AccessTokens().Lister(), s.Config.AuthenticationOptions),
+			s.InformerFactory.AiSharedInformerFactory().Iam().V1().Users().Lister())),
+		bearertoken.New(jwttoken.NewTokenAuthenticator(auth.NewTokenOperator(s.CacheClient, s.SigningKeys, s.Config.AuthenticationOptions),
+			s.InformerFactory.AiSharedInformerFactory().Iam().V1().Users().Lister(), s.Config.AuthenticationOptions.OAuthOptions)))
 	handler = filters.WithAuthentication(handler, authn)
 
 	handler = filters.WithRequestInfo(handler, requestInfoResolver)
@@ -269,6 +275,7 @@ func (s *APIServer) waitForResourceSync(ctx context.Context) error {
 			"users",
 			"globalroles",
 			"globalrolebindings",
+			"accesstokens",
 
 			"loginrecords",
 		},
@@ -310,16 +317,28 @@ func (s *APIServer) installAPIs(stop <-chan struct{}) {
 	amOperator := am.NewOperator(s.KubernetesClient.Ai(),
 		s.KubernetesClient.Kubernetes(),
 		s.InformerFactory)
-	rbacAuthorizer := rbac.NewRBACAuthorizer(amOperator)
-	urlruntime.Must(iamapi.AddToContainer(s.container, imOperator, amOperator, s.Config.AiOptions, rbacAuthorizer))
-	urlruntime.Must(oauth.AddToContainer(s.container, imOperator, s.Config.AiOptions, s.KubernetesClient.Kubernetes(),
-		auth.NewTokenOperator(s.CacheClient,
-			s.Config.AuthenticationOptions),
+	rbacAuthorizer := rbac.NewRBACAuthorizer(amOperator, s.InformerFactory)
+	tokenOperator := auth.NewTokenOperator(s.CacheClient, s.SigningKeys, s.Config.AuthenticationOptions)
+	mfaOperator := auth.NewMFAOperator(s.KubernetesClient.Ai(), s.KubernetesClient.Kubernetes(), s.CacheClient,
+		s.Config.AiOptions, s.Config.AuthenticationOptions)
+	accessTokenOperator := auth.NewAccessTokenOperator(s.KubernetesClient.Ai(),
+		s.InformerFactory.AiSharedInformerFactory().Iam().V1().AccessTokens().Lister(), s.Config.AuthenticationOptions)
+	pathAuthorizer, _ := path.NewAuthorizer(excludedPaths)
+	urlruntime.Must(iamapi.AddToContainer(s.container, imOperator, amOperator, s.Config.AiOptions,
+		unionauthorizer.New(pathAuthorizer, rbacAuthorizer), rbacAuthorizer, tokenOperator, mfaOperator, accessTokenOperator))
+	urlruntime.Must(oauth.AddToContainer(s.container, imOperator, s.Config.AiOptions, s.Config.AuthenticationOptions, s.KubernetesClient.Kubernetes(),
+		tokenOperator, s.SigningKeys, auth.NewAuthorizationCodeStore(s.CacheClient), auth.NewDeviceAuthorizationStore(s.CacheClient),
+		auth.NewOAuthStateStore(s.CacheClient),
 		auth.NewPasswordAuthenticator(
 			s.KubernetesClient.Ai(),
-			s.InformerFactory.AiSharedInformerFactory().Iam().V1().Users().Lister(),
+			s.InformerFactory.AiSharedInformerFactory().Iam().V1().Users(),
 			s.Config.AuthenticationOptions, s.Config.AiOptions),
-		auth.NewLoginRecorder(s.KubernetesClient.Ai())))
+		auth.NewOAuthAuthenticator(
+			s.KubernetesClient.Ai(),
+			s.InformerFactory.AiSharedInformerFactory().Iam().V1().Users(),
+			s.Config.AuthenticationOptions),
+		auth.NewLoginRecorder(s.KubernetesClient.Ai()),
+		mfaOperator))
 
 	urlruntime.Must(version.AddToContainer(s.container))
 	swagger.AddToContainer("docs/swagger-ui", s.container)
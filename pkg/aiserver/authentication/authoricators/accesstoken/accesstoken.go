@@ -0,0 +1,56 @@
+package accesstoken
+
+import (
+	"context"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authorization/rbac"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	iamv1listers "github.com/wongearl/go-restful-template/pkg/client/ai/listers/iam.ai.io/v1"
+	"github.com/wongearl/go-restful-template/pkg/middles/auth"
+
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/klog"
+)
+
+type tokenAuthenticator struct {
+	accessTokenOperator auth.AccessTokenManagementInterface
+	userLister          iamv1listers.UserLister
+}
+
+// NewTokenAuthenticator authenticates the personal access tokens, other bearer tokens are left to the other authenticators
+func NewTokenAuthenticator(accessTokenOperator auth.AccessTokenManagementInterface, userLister iamv1listers.UserLister) authenticator.Token {
+	return &tokenAuthenticator{
+		accessTokenOperator: accessTokenOperator,
+		userLister:          userLister,
+	}
+}
+
+func (t *tokenAuthenticator) AuthenticateToken(ctx context.Context, token string) (*authenticator.Response, bool, error) {
+	if !auth.IsAccessToken(token) {
+		return nil, false, nil
+	}
+	accessToken, err := t.accessTokenOperator.Verify(token)
+	if err != nil {
+		klog.Error(err)
+		return nil, false, err
+	}
+
+	dbUser, err := t.userLister.Get(accessToken.Spec.Username)
+	if err != nil {
+		return nil, false, err
+	}
+	// the users blocked by failed logins can not use their tokens either
+	if err = auth.CheckUserState(dbUser); err != nil {
+		return nil, false, err
+	}
+
+	info := &user.DefaultInfo{
+		Name:   dbUser.GetName(),
+		Groups: append(dbUser.Spec.Groups, user.AllAuthenticated),
+	}
+	if len(accessToken.Spec.Scopes) > 0 {
+		info.Extra = map[string][]string{iamv1.AccessTokenScopesExtraKey: rbac.EncodeScopes(accessToken.Spec.Scopes)}
+	}
+	return &authenticator.Response{User: info}, true, nil
+}
@@ -6,8 +6,10 @@ import (
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/authoricators"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/request"
 	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	iamv1listers "github.com/wongearl/go-restful-template/pkg/client/ai/listers/iam.ai.io/v1"
 	"github.com/wongearl/go-restful-template/pkg/middles/auth"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apiserver/pkg/authentication/authenticator"
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/klog"
@@ -20,12 +22,14 @@ import (
 // because some resources are public accessible.
 type basicAuthenticator struct {
 	authenticator auth.PasswordAuthenticator
+	userLister    iamv1listers.UserLister
 	loginRecorder auth.LoginRecorder
 }
 
-func NewBasicAuthenticator(authenticator auth.PasswordAuthenticator, loginRecorder auth.LoginRecorder) authoricators.Password {
+func NewBasicAuthenticator(authenticator auth.PasswordAuthenticator, userLister iamv1listers.UserLister, loginRecorder auth.LoginRecorder) authoricators.Password {
 	return &basicAuthenticator{
 		authenticator: authenticator,
+		userLister:    userLister,
 		loginRecorder: loginRecorder,
 	}
 }
@@ -43,6 +47,18 @@ func (t *basicAuthenticator) AuthenticatePassword(ctx context.Context, username,
 				klog.Errorf("Failed to record unsuccessful login attempt for user %s, error: %v", username, err)
 			}
 		}
+		// the user can only change the expired password, see PasswordExpiredError
+		if err == auth.PasswordExpiredError && isPasswordChangeOf(ctx, username) {
+			if err := t.checkMFA(username); err != nil {
+				return nil, false, err
+			}
+			return &authenticator.Response{
+				User: &user.DefaultInfo{Name: username, Groups: []string{user.AllAuthenticated}},
+			}, true, nil
+		}
+		return nil, false, err
+	}
+	if err = t.checkMFA(authenticated.GetName()); err != nil {
 		return nil, false, err
 	}
 	return &authenticator.Response{
@@ -52,3 +68,30 @@ func (t *basicAuthenticator) AuthenticatePassword(ctx context.Context, username,
 		},
 	}, true, nil
 }
+
+// checkMFA refuses the users who have enabled MFA, since the second factor can not be verified
+// with basic authentication, the users have to log in by the password grant instead.
+func (t *basicAuthenticator) checkMFA(username string) error {
+	dbUser, err := t.userLister.Get(username)
+	if err != nil {
+		// the users provisioned by the identity providers may not be synced yet, who have not enabled MFA
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		klog.Error(err)
+		return err
+	}
+	if auth.MFAEnabled(dbUser) {
+		klog.Errorf("%s, username: %s", auth.MFARequiredError, username)
+		return auth.MFARequiredError
+	}
+	return nil
+}
+
+// isPasswordChangeOf returns whether the request changes the password of the user
+func isPasswordChangeOf(ctx context.Context, username string) bool {
+	requestInfo, ok := request.RequestInfoFrom(ctx)
+	return ok && requestInfo.IsResourceRequest && requestInfo.APIGroup == iamv1.SchemeGroupVersion.Group &&
+		requestInfo.Verb == "update" && requestInfo.Resource == iamv1.ResourcesPluralUser &&
+		requestInfo.Subresource == "password" && requestInfo.Name == username
+}
@@ -3,6 +3,7 @@ package jwttoken
 import (
 	"context"
 
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
 	iamv1listers "github.com/wongearl/go-restful-template/pkg/client/ai/listers/iam.ai.io/v1"
 	"github.com/wongearl/go-restful-template/pkg/middles/auth"
 
@@ -14,12 +15,14 @@ import (
 type tokenAuthenticator struct {
 	tokenOperator auth.TokenManagementInterface
 	userLister    iamv1listers.UserLister
+	oauthOptions  *oauth.Options
 }
 
-func NewTokenAuthenticator(tokenOperator auth.TokenManagementInterface, userLister iamv1listers.UserLister) authenticator.Token {
+func NewTokenAuthenticator(tokenOperator auth.TokenManagementInterface, userLister iamv1listers.UserLister, oauthOptions *oauth.Options) authenticator.Token {
 	return &tokenAuthenticator{
 		tokenOperator: tokenOperator,
 		userLister:    userLister,
+		oauthOptions:  oauthOptions,
 	}
 }
 
@@ -30,6 +33,20 @@ func (t *tokenAuthenticator) AuthenticateToken(ctx context.Context, token string
 		return nil, false, err
 	}
 
+	// the tokens of the clients are invalidated once the client is unregistered
+	if clientName, ok := oauth.ClientOfServiceIdentity(providedUser.GetName()); ok {
+		if _, err := t.oauthOptions.ServiceClient(clientName); err != nil {
+			return nil, false, err
+		}
+		return &authenticator.Response{
+			User: &user.DefaultInfo{
+				Name:   providedUser.GetName(),
+				Groups: []string{oauth.ServiceIdentityGroup, user.AllAuthenticated},
+				Extra:  providedUser.GetExtra(),
+			},
+		}, true, nil
+	}
+
 	dbUser, err := t.userLister.Get(providedUser.GetName())
 	if err != nil {
 		return nil, false, err
@@ -0,0 +1,238 @@
+package hasher
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+
+	argon2idPrefix = "$argon2id$"
+
+	// the bounds of the argon2id parameters, which protect the verification from the hashes
+	// of insane costs, the first recommended option of RFC 9106 uses 2 GiB memory
+	argon2idMaxMemory  = 2 * 1024 * 1024
+	argon2idMaxTime    = 16
+	argon2idMaxThreads = 64
+	// the salt and the key are between the min length and 64 bytes
+	argon2idMinSaltLength = 8
+	argon2idMinKeyLength  = 16
+	argon2idMaxLength     = 64
+)
+
+var (
+	// ErrMismatchedPassword is returned if the password does not match the hash
+	ErrMismatchedPassword = errors.New("password does not match the hash")
+	// ErrUnknownAlgorithm is returned if the algorithm of the hash is not recognised
+	ErrUnknownAlgorithm = errors.New("unknown password hashing algorithm")
+)
+
+// Options defines the algorithm and the cost of hashing passwords.
+// The passwords hashed by a weaker algorithm or cost are rehashed on login.
+type Options struct {
+	// Algorithm is one of "bcrypt" and "argon2id", default to bcrypt
+	Algorithm string `json:"algorithm" yaml:"algorithm"`
+	// BcryptCost is the cost of bcrypt hashes, between 4 and 31
+	BcryptCost int `json:"bcryptCost" yaml:"bcryptCost"`
+	// Argon2id defines the parameters of argon2id hashes, see RFC 9106
+	Argon2id *Argon2idOptions `json:"argon2id,omitempty" yaml:"argon2id,omitempty"`
+}
+
+type Argon2idOptions struct {
+	// Time is the number of passes over the memory
+	Time uint32 `json:"time" yaml:"time"`
+	// Memory is the size of the memory in KiB
+	Memory uint32 `json:"memory" yaml:"memory"`
+	// Threads is the degree of parallelism
+	Threads uint8 `json:"threads" yaml:"threads"`
+	// KeyLength and SaltLength are the length of the hash and the salt in bytes
+	KeyLength  uint32 `json:"keyLength" yaml:"keyLength"`
+	SaltLength uint32 `json:"saltLength" yaml:"saltLength"`
+}
+
+func NewOptions() *Options {
+	return &Options{
+		Algorithm:  AlgorithmBcrypt,
+		BcryptCost: bcrypt.DefaultCost,
+		Argon2id:   NewArgon2idOptions(),
+	}
+}
+
+// NewArgon2idOptions returns the second recommended option of RFC 9106 with 64 MiB memory
+func NewArgon2idOptions() *Argon2idOptions {
+	return &Argon2idOptions{Time: 3, Memory: 64 * 1024, Threads: 4, KeyLength: 32, SaltLength: 16}
+}
+
+// valid checks the costs against the bounds, the memory is at least 8 KiB per thread
+func (o *Argon2idOptions) valid() bool {
+	return o.Time >= 1 && o.Time <= argon2idMaxTime && o.Threads >= 1 && o.Threads <= argon2idMaxThreads &&
+		o.Memory >= 8*uint32(o.Threads) && o.Memory <= argon2idMaxMemory
+}
+
+func (o *Options) Validate() error {
+	switch o.Algorithm {
+	case AlgorithmBcrypt:
+		if o.BcryptCost < bcrypt.MinCost || o.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case AlgorithmArgon2id:
+		if o.Argon2id == nil {
+			return fmt.Errorf("argon2id options are required")
+		}
+		if !o.Argon2id.valid() || o.Argon2id.KeyLength < argon2idMinKeyLength || o.Argon2id.KeyLength > argon2idMaxLength ||
+			o.Argon2id.SaltLength < argon2idMinSaltLength || o.Argon2id.SaltLength > argon2idMaxLength {
+			return fmt.Errorf("argon2id options are invalid")
+		}
+	default:
+		return fmt.Errorf("password hashing algorithm %q is not supported", o.Algorithm)
+	}
+	return nil
+}
+
+// Hasher hashes the passwords with the configured algorithm
+type Hasher interface {
+	// Hash returns the encoded hash, which records the algorithm and the parameters
+	Hash(password string) (string, error)
+	// NeedsRehash returns true if the hash uses a weaker algorithm or cost than the configured one
+	NeedsRehash(encoded string) bool
+}
+
+// New returns the hasher of the options, the default options are used if it is nil
+func New(options *Options) Hasher {
+	if options == nil {
+		options = NewOptions()
+	}
+	if options.Algorithm == AlgorithmArgon2id && options.Argon2id != nil {
+		return &argon2idHasher{options: *options.Argon2id}
+	}
+	cost := options.BcryptCost
+	if cost < bcrypt.MinCost {
+		cost = bcrypt.DefaultCost
+	}
+	return &bcryptHasher{cost: cost}
+}
+
+// IsHash returns true if the password is hashed by one of the supported algorithms
+func IsHash(encoded string) bool {
+	return algorithmOf(encoded) != ""
+}
+
+// Verify compares the password with the hash of any supported algorithm
+func Verify(encoded, password string) error {
+	switch algorithmOf(encoded) {
+	case AlgorithmBcrypt:
+		if err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)); err != nil {
+			return ErrMismatchedPassword
+		}
+		return nil
+	case AlgorithmArgon2id:
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return err
+		}
+		computed := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return ErrMismatchedPassword
+		}
+		return nil
+	}
+	return ErrUnknownAlgorithm
+}
+
+// algorithmOf returns the algorithm of the hash, the argon2id hash is only recognised if it is well-formed
+func algorithmOf(encoded string) string {
+	if strings.HasPrefix(encoded, argon2idPrefix) {
+		if _, _, _, err := decodeArgon2id(encoded); err != nil {
+			return ""
+		}
+		return AlgorithmArgon2id
+	}
+	// bcrypt.Cost returns the hashing cost used to create the given hashed
+	if cost, err := bcrypt.Cost([]byte(encoded)); err == nil && cost > 0 {
+		return AlgorithmBcrypt
+	}
+	return ""
+}
+
+type bcryptHasher struct {
+	cost int
+}
+
+func (b *bcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	return string(hashed), err
+}
+
+func (b *bcryptHasher) NeedsRehash(encoded string) bool {
+	switch algorithmOf(encoded) {
+	case AlgorithmBcrypt:
+		cost, _ := bcrypt.Cost([]byte(encoded))
+		return cost < b.cost
+	case AlgorithmArgon2id:
+		// argon2id is not downgraded
+		return false
+	}
+	return true
+}
+
+type argon2idHasher struct {
+	options Argon2idOptions
+}
+
+func (a *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.options.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.options.Time, a.options.Memory, a.options.Threads, a.options.KeyLength)
+	// the PHC string format used by the reference implementation
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		a.options.Memory, a.options.Time, a.options.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a *argon2idHasher) NeedsRehash(encoded string) bool {
+	if algorithmOf(encoded) != AlgorithmArgon2id {
+		return true
+	}
+	params, _, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Time < a.options.Time || params.Memory < a.options.Memory ||
+		params.Threads < a.options.Threads || uint32(len(key)) < a.options.KeyLength
+}
+
+// decodeArgon2id parses the hash like $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>
+func decodeArgon2id(encoded string) (*Argon2idOptions, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return nil, nil, nil, fmt.Errorf("argon2id hash is malformed")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("argon2id version is not supported")
+	}
+	params := &Argon2idOptions{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil || !params.valid() {
+		return nil, nil, nil, fmt.Errorf("argon2id parameters are malformed")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) < argon2idMinSaltLength || len(salt) > argon2idMaxLength {
+		return nil, nil, nil, fmt.Errorf("argon2id salt is malformed")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < argon2idMinKeyLength || len(key) > argon2idMaxLength {
+		return nil, nil, nil, fmt.Errorf("argon2id hash is malformed")
+	}
+	return params, salt, key, nil
+}
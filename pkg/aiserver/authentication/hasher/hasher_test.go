@@ -0,0 +1,129 @@
+package hasher
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// the parameters are reduced to keep the tests fast
+func newArgon2idOptions() *Options {
+	return &Options{
+		Algorithm: AlgorithmArgon2id,
+		Argon2id:  &Argon2idOptions{Time: 1, Memory: 1024, Threads: 1, KeyLength: 32, SaltLength: 16},
+	}
+}
+
+func TestHashAndVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *Options
+		prefix  string
+	}{
+		{"bcrypt", &Options{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost}, "$2a$04$"},
+		{"argon2id", newArgon2idOptions(), "$argon2id$v=19$m=1024,t=1,p=1$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasher := New(tt.options)
+			encoded, err := hasher.Hash("P@88w0rd")
+			assert.Nil(t, err)
+			assert.True(t, strings.HasPrefix(encoded, tt.prefix), encoded)
+			assert.True(t, IsHash(encoded))
+			assert.Nil(t, Verify(encoded, "P@88w0rd"))
+			assert.Equal(t, ErrMismatchedPassword, Verify(encoded, "P@88w0rd!"))
+			assert.False(t, hasher.NeedsRehash(encoded))
+
+			// the salt is random
+			another, err := hasher.Hash("P@88w0rd")
+			assert.Nil(t, err)
+			assert.NotEqual(t, encoded, another)
+		})
+	}
+
+	assert.False(t, IsHash("P@88w0rd"))
+	assert.Equal(t, ErrUnknownAlgorithm, Verify("P@88w0rd", "P@88w0rd"))
+	assert.NotNil(t, Verify("$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$", "P@88w0rd"))
+	assert.NotNil(t, Verify("$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$aGFzaA", "P@88w0rd"))
+	assert.NotNil(t, Verify("$argon2id$v=19$m=1024$c2FsdA$aGFzaA", "P@88w0rd"))
+}
+
+func TestArgon2idBounds(t *testing.T) {
+	salt := base64.RawStdEncoding.EncodeToString(make([]byte, 16))
+	key := base64.RawStdEncoding.EncodeToString(make([]byte, 32))
+	tests := []struct {
+		name    string
+		encoded string
+		valid   bool
+	}{
+		{"valid", "$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$" + key, true},
+		{"no threads", "$argon2id$v=19$m=1024,t=1,p=0$" + salt + "$" + key, false},
+		{"no passes", "$argon2id$v=19$m=1024,t=0,p=1$" + salt + "$" + key, false},
+		{"huge memory", "$argon2id$v=19$m=4294967295,t=1,p=1$" + salt + "$" + key, false},
+		{"too many passes", "$argon2id$v=19$m=1024,t=1000000,p=1$" + salt + "$" + key, false},
+		{"too many threads", "$argon2id$v=19$m=1024,t=1,p=255$" + salt + "$" + key, false},
+		{"short salt", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$" + key, false},
+		{"short key", "$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$aGFzaA", false},
+		{"long key", "$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$" + base64.RawStdEncoding.EncodeToString(make([]byte, 128)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the malformed hashes are not recognised as hashes, which are hashed as plain text passwords
+			assert.Equal(t, tt.valid, IsHash(tt.encoded))
+			if tt.valid {
+				assert.Equal(t, ErrMismatchedPassword, Verify(tt.encoded, "P@88w0rd"))
+			} else {
+				assert.Equal(t, ErrUnknownAlgorithm, Verify(tt.encoded, "P@88w0rd"))
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	weakBcrypt, _ := New(&Options{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost}).Hash("P@88w0rd")
+	strongBcrypt, _ := New(&Options{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost + 1}).Hash("P@88w0rd")
+	argon2id, _ := New(newArgon2idOptions()).Hash("P@88w0rd")
+
+	bcryptHasher := New(&Options{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost + 1})
+	assert.True(t, bcryptHasher.NeedsRehash(weakBcrypt))
+	assert.False(t, bcryptHasher.NeedsRehash(strongBcrypt))
+	// argon2id is stronger than bcrypt
+	assert.False(t, bcryptHasher.NeedsRehash(argon2id))
+
+	argon2idHasher := New(newArgon2idOptions())
+	assert.True(t, argon2idHasher.NeedsRehash(strongBcrypt))
+	assert.False(t, argon2idHasher.NeedsRehash(argon2id))
+	stronger := newArgon2idOptions()
+	stronger.Argon2id.Memory = 2048
+	assert.True(t, New(stronger).NeedsRehash(argon2id))
+	stronger = newArgon2idOptions()
+	stronger.Argon2id.Time = 2
+	assert.True(t, New(stronger).NeedsRehash(argon2id))
+}
+
+func TestValidate(t *testing.T) {
+	assert.Nil(t, NewOptions().Validate())
+	assert.Nil(t, newArgon2idOptions().Validate())
+	assert.NotNil(t, (&Options{Algorithm: AlgorithmBcrypt, BcryptCost: 3}).Validate())
+	assert.NotNil(t, (&Options{Algorithm: "scrypt"}).Validate())
+	assert.NotNil(t, (&Options{Algorithm: AlgorithmArgon2id}).Validate())
+	invalid := newArgon2idOptions()
+	invalid.Argon2id.Threads = 0
+	assert.NotNil(t, invalid.Validate())
+	invalid = newArgon2idOptions()
+	invalid.Argon2id.Memory = argon2idMaxMemory + 1
+	assert.NotNil(t, invalid.Validate())
+	invalid = newArgon2idOptions()
+	invalid.Argon2id.SaltLength = argon2idMaxLength + 1
+	assert.NotNil(t, invalid.Validate())
+
+	// the default algorithm is bcrypt
+	encoded, err := New(nil).Hash("P@88w0rd")
+	assert.Nil(t, err)
+	cost, err := bcrypt.Cost([]byte(encoded))
+	assert.Nil(t, err)
+	assert.Equal(t, bcrypt.DefaultCost, cost)
+}
@@ -0,0 +1,166 @@
+package github
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/identityprovider"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+
+	"github.com/mitchellh/mapstructure"
+	"golang.org/x/oauth2"
+)
+
+const (
+	userInfoURL = "https://api.github.com/user"
+	authURL     = "https://github.com/login/oauth/authorize"
+	tokenURL    = "https://github.com/login/oauth/access_token"
+)
+
+func init() {
+	identityprovider.RegisterOAuthProvider(&githubProviderFactory{})
+}
+
+type github struct {
+	// ClientID is the application's ID.
+	ClientID string `json:"clientID" yaml:"clientID" mapstructure:"clientID"`
+
+	// ClientSecret is the application's secret.
+	ClientSecret string `json:"-" yaml:"clientSecret" mapstructure:"clientSecret"`
+
+	// Endpoint overrides the authorize, token and userinfo URLs, e.g. for GitHub Enterprise,
+	// the URLs of github.com are used if not set.
+	Endpoint endpoint `json:"endpoint" yaml:"endpoint" mapstructure:"endpoint"`
+
+	// RedirectURL is the callback URL of ai-server registered in the GitHub OAuth app,
+	// e.g. https://ai.example.com/oauth/callback/github.
+	RedirectURL string `json:"redirectURL" yaml:"redirectURL" mapstructure:"redirectURL"`
+
+	// Used to turn off TLS certificate checks
+	InsecureSkipVerify bool `json:"insecureSkipVerify" yaml:"insecureSkipVerify" mapstructure:"insecureSkipVerify"`
+
+	// Scope specifies optional requested permissions.
+	Scopes []string `json:"scopes" yaml:"scopes" mapstructure:"scopes"`
+
+	Config *oauth2.Config `json:"-" yaml:"-"`
+}
+
+// endpoint represents the authorize, token and userinfo URLs of GitHub.
+type endpoint struct {
+	AuthURL     string `json:"authURL" yaml:"authURL" mapstructure:"authURL"`
+	TokenURL    string `json:"tokenURL" yaml:"tokenURL" mapstructure:"tokenURL"`
+	UserInfoURL string `json:"userInfoURL" yaml:"userInfoURL" mapstructure:"userInfoURL"`
+}
+
+type githubIdentity struct {
+	Login     string    `json:"login"`
+	ID        int64     `json:"id"`
+	NodeID    string    `json:"node_id"`
+	AvatarURL string    `json:"avatar_url"`
+	HTMLURL   string    `json:"html_url"`
+	Type      string    `json:"type"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type githubProviderFactory struct {
+}
+
+func (g *githubProviderFactory) Type() string {
+	return "GitHubIdentityProvider"
+}
+
+func (g *githubProviderFactory) Create(options oauth.DynamicOptions) (identityprovider.OAuthProvider, error) {
+	var github github
+	if err := mapstructure.Decode(options, &github); err != nil {
+		return nil, err
+	}
+	if github.ClientID == "" || github.ClientSecret == "" {
+		return nil, fmt.Errorf("clientID and clientSecret of GitHub identity provider are required")
+	}
+
+	if github.Endpoint.AuthURL == "" {
+		github.Endpoint.AuthURL = authURL
+	}
+	if github.Endpoint.TokenURL == "" {
+		github.Endpoint.TokenURL = tokenURL
+	}
+	if github.Endpoint.UserInfoURL == "" {
+		github.Endpoint.UserInfoURL = userInfoURL
+	}
+	github.Config = &oauth2.Config{
+		ClientID:     github.ClientID,
+		ClientSecret: github.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  github.Endpoint.AuthURL,
+			TokenURL: github.Endpoint.TokenURL,
+		},
+		RedirectURL: github.RedirectURL,
+		Scopes:      github.Scopes,
+	}
+	return &github, nil
+}
+
+func (g githubIdentity) GetUserID() string {
+	return strconv.FormatInt(g.ID, 10)
+}
+
+func (g githubIdentity) GetUsername() string {
+	return g.Login
+}
+
+func (g githubIdentity) GetEmail() string {
+	return g.Email
+}
+
+func (g *github) AuthCodeURL(state string) string {
+	return g.Config.AuthCodeURL(state)
+}
+
+func (g *github) IdentityExchange(code string) (identityprovider.Identity, error) {
+	ctx := context.TODO()
+	if g.InsecureSkipVerify {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+				},
+			},
+		}
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	}
+	token, err := g.Config.Exchange(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := oauth2.NewClient(ctx, oauth2.StaticTokenSource(token)).Get(g.Endpoint.UserInfoURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get user info from GitHub, status: %d, body: %s", resp.StatusCode, data)
+	}
+
+	var githubIdentity githubIdentity
+	if err = json.Unmarshal(data, &githubIdentity); err != nil {
+		return nil, err
+	}
+	if githubIdentity.ID == 0 {
+		return nil, fmt.Errorf("user id is missing in the user info of GitHub")
+	}
+	return githubIdentity, nil
+}
@@ -0,0 +1,79 @@
+package github
+
+import (
+	"testing"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+
+	"github.com/h2non/gock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreate(t *testing.T) {
+	factory := &githubProviderFactory{}
+	assert.Equal(t, "GitHubIdentityProvider", factory.Type())
+
+	provider, err := factory.Create(oauth.DynamicOptions{
+		"clientID":     "client",
+		"clientSecret": "secret",
+		"redirectURL":  "http://ai.io/oauth/callback/github",
+		"scopes":       []interface{}{"user"},
+	})
+	assert.Nil(t, err)
+	github := provider.(*github)
+	assert.Equal(t, endpoint{AuthURL: authURL, TokenURL: tokenURL, UserInfoURL: userInfoURL}, github.Endpoint)
+	assert.Equal(t, "http://ai.io/oauth/callback/github", github.Config.RedirectURL)
+	assert.Equal(t, []string{"user"}, github.Config.Scopes)
+
+	_, err = factory.Create(oauth.DynamicOptions{"clientID": "client"})
+	assert.NotNil(t, err)
+}
+
+func TestIdentityExchange(t *testing.T) {
+	defer gock.Off()
+	provider, err := (&githubProviderFactory{}).Create(oauth.DynamicOptions{
+		"clientID":     "client",
+		"clientSecret": "secret",
+		"endpoint": oauth.DynamicOptions{
+			"tokenURL":    "http://github.local/login/oauth/access_token",
+			"userInfoURL": "http://api.github.local/user",
+		},
+	})
+	assert.Nil(t, err)
+
+	gock.New("http://github.local").
+		Post("/login/oauth/access_token").
+		BodyString("code=valid").
+		Reply(200).
+		JSON(map[string]interface{}{"access_token": "token", "token_type": "bearer"})
+	gock.New("http://api.github.local").
+		Get("/user").
+		MatchHeader("Authorization", "Bearer token").
+		Reply(200).
+		JSON(map[string]interface{}{"id": 1001, "login": "Alice", "email": "alice@ai.io"})
+
+	identity, err := provider.IdentityExchange("valid")
+	assert.Nil(t, err)
+	assert.Equal(t, "1001", identity.GetUserID())
+	assert.Equal(t, "Alice", identity.GetUsername())
+	assert.Equal(t, "alice@ai.io", identity.GetEmail())
+	assert.True(t, gock.IsDone())
+
+	gock.New("http://github.local").
+		Post("/login/oauth/access_token").
+		Reply(401).
+		JSON(map[string]interface{}{"error": "bad_verification_code"})
+	_, err = provider.IdentityExchange("invalid")
+	assert.NotNil(t, err)
+
+	gock.New("http://github.local").
+		Post("/login/oauth/access_token").
+		Reply(200).
+		JSON(map[string]interface{}{"access_token": "token", "token_type": "bearer"})
+	gock.New("http://api.github.local").
+		Get("/user").
+		Reply(401).
+		JSON(map[string]interface{}{"message": "Bad credentials"})
+	_, err = provider.IdentityExchange("valid")
+	assert.NotNil(t, err)
+}
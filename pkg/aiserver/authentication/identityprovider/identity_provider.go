@@ -3,6 +3,7 @@ package identityprovider
 import (
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
 
@@ -15,6 +16,8 @@ var (
 	identityProviderNotFound = errors.New("identity provider not found")
 	oauthProviders           = make(map[string]OAuthProvider)
 	genericProviders         = make(map[string]GenericProvider)
+	// providersLock guards oauthProviders and genericProviders
+	providersLock sync.RWMutex
 )
 
 // Identity represents the account mapped to ai
@@ -29,10 +32,20 @@ type Identity interface {
 	GetEmail() string
 }
 
-// SetupWithOptions will verify the configuration and initialize the identityProviders
+// GroupsIdentity is implemented by the identities that carry the groups of the account
+type GroupsIdentity interface {
+	Identity
+	// The groups of the End-User at the Issuer.
+	GetGroups() []string
+}
+
+// SetupWithOptions will verify the configuration and initialize the identityProviders,
+// the providers created before are replaced, so it can be called again when the configuration is reloaded.
 func SetupWithOptions(options []oauth.IdentityProviderOptions) error {
+	newOAuthProviders := make(map[string]OAuthProvider)
+	newGenericProviders := make(map[string]GenericProvider)
 	for _, o := range options {
-		if oauthProviders[o.Name] != nil || genericProviders[o.Name] != nil {
+		if newOAuthProviders[o.Name] != nil || newGenericProviders[o.Name] != nil {
 			err := fmt.Errorf("duplicate identity provider found: %s, name must be unique", o.Name)
 			klog.Error(err)
 			return err
@@ -47,7 +60,7 @@ func SetupWithOptions(options []oauth.IdentityProviderOptions) error {
 				// don’t return errors, decoupling external dependencies
 				klog.Error(fmt.Sprintf("failed to create identity provider %s: %s", o.Name, err))
 			} else {
-				oauthProviders[o.Name] = provider
+				newOAuthProviders[o.Name] = provider
 				klog.V(4).Infof("create identity provider %s successfully", o.Name)
 			}
 		}
@@ -55,16 +68,22 @@ func SetupWithOptions(options []oauth.IdentityProviderOptions) error {
 			if provider, err := factory.Create(o.Provider); err != nil {
 				klog.Error(fmt.Sprintf("failed to create identity provider %s: %s", o.Name, err))
 			} else {
-				genericProviders[o.Name] = provider
+				newGenericProviders[o.Name] = provider
 				klog.V(4).Infof("create identity provider %s successfully", o.Name)
 			}
 		}
 	}
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	oauthProviders = newOAuthProviders
+	genericProviders = newGenericProviders
 	return nil
 }
 
 // GetGenericProvider returns GenericProvider with given name
 func GetGenericProvider(providerName string) (GenericProvider, error) {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
 	if provider, ok := genericProviders[providerName]; ok {
 		return provider, nil
 	}
@@ -73,6 +92,8 @@ func GetGenericProvider(providerName string) (GenericProvider, error) {
 
 // GetGenericProvider returns OAuthProvider with given name
 func GetOAuthProvider(providerName string) (OAuthProvider, error) {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
 	if provider, ok := oauthProviders[providerName]; ok {
 		return provider, nil
 	}
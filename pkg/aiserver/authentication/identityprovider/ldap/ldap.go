@@ -0,0 +1,299 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/identityprovider"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/mitchellh/mapstructure"
+	"k8s.io/klog"
+)
+
+const (
+	ldapIdentityProvider = "LDAPIdentityProvider"
+	defaultReadTimeout   = 15000
+	defaultLoginAttr     = "uid"
+	defaultMailAttr      = "mail"
+	defaultGroupNameAttr = "cn"
+)
+
+var (
+	// IncorrectPasswordError is returned if the account is not found or the password is incorrect
+	IncorrectPasswordError = fmt.Errorf("incorrect password")
+)
+
+func init() {
+	identityprovider.RegisterGenericProvider(&ldapProviderFactory{})
+}
+
+type ldapProvider struct {
+	// Host and optional port of the LDAP server in the form "host:port".
+	// If the port is not supplied, 389 for insecure or StartTLS connections, 636 otherwise.
+	Host string `json:"host,omitempty" yaml:"host" mapstructure:"host"`
+	// Timeout duration when reading data from remote server. Default to 15s.
+	ReadTimeout int `json:"readTimeout" yaml:"readTimeout" mapstructure:"readTimeout"`
+	// If specified, connections will use the ldaps:// protocol.
+	UseTLS bool `json:"useTLS,omitempty" yaml:"useTLS" mapstructure:"useTLS"`
+	// If specified, connections will be upgraded by StartTLS after they are established.
+	StartTLS bool `json:"startTLS,omitempty" yaml:"startTLS" mapstructure:"startTLS"`
+	// Used to turn off TLS certificate checks
+	InsecureSkipVerify bool `json:"insecureSkipVerify" yaml:"insecureSkipVerify" mapstructure:"insecureSkipVerify"`
+	// Path to a trusted root certificate file. Default: use the host's root CA.
+	RootCA string `json:"rootCA,omitempty" yaml:"rootCA" mapstructure:"rootCA"`
+	// A raw certificate file can also be provided inline. Base64 encoded PEM file
+	RootCAData string `json:"rootCAData,omitempty" yaml:"rootCAData" mapstructure:"rootCAData"`
+	// Username (DN) of the "manager" user identity.
+	ManagerDN string `json:"managerDN,omitempty" yaml:"managerDN" mapstructure:"managerDN"`
+	// The password for the manager DN.
+	ManagerPassword string `json:"-" yaml:"managerPassword" mapstructure:"managerPassword"`
+	// User search scope.
+	UserSearchBase string `json:"userSearchBase,omitempty" yaml:"userSearchBase" mapstructure:"userSearchBase"`
+	// LDAP filter used to identify objects of type user. e.g. (objectClass=person)
+	UserSearchFilter string `json:"userSearchFilter,omitempty" yaml:"userSearchFilter" mapstructure:"userSearchFilter"`
+	// Group search scope, the groups of the user are not searched if it is empty.
+	GroupSearchBase string `json:"groupSearchBase,omitempty" yaml:"groupSearchBase" mapstructure:"groupSearchBase"`
+	// LDAP filter used to identify objects of type group. e.g. (objectclass=group)
+	GroupSearchFilter string `json:"groupSearchFilter,omitempty" yaml:"groupSearchFilter" mapstructure:"groupSearchFilter"`
+	// The attribute of the user entry that refers to the groups, e.g. memberOf.
+	// The groups are read from it instead of searching the groups if it is set.
+	UserMemberAttribute string `json:"userMemberAttribute,omitempty" yaml:"userMemberAttribute" mapstructure:"userMemberAttribute"`
+	// The attribute of the group entry that refers to the members. e.g. member
+	GroupMemberAttribute string `json:"groupMemberAttribute,omitempty" yaml:"groupMemberAttribute" mapstructure:"groupMemberAttribute"`
+	// The attribute of the group entry used as the group name, default to cn.
+	GroupNameAttribute string `json:"groupNameAttribute,omitempty" yaml:"groupNameAttribute" mapstructure:"groupNameAttribute"`
+	// login attribute used for comparing user entries.
+	// The following two fields are direct mappings of attributes on the user entry.
+	LoginAttribute string `json:"loginAttribute" yaml:"loginAttribute" mapstructure:"loginAttribute"`
+	MailAttribute  string `json:"mailAttribute" yaml:"mailAttribute" mapstructure:"mailAttribute"`
+
+	tlsConfig *tls.Config
+	// dial connects to the LDAP server, replaced in tests
+	dial func() (ldap.Client, error)
+}
+
+type ldapIdentity struct {
+	Username string
+	Email    string
+	Groups   []string
+}
+
+func (l *ldapIdentity) GetUserID() string {
+	return l.Username
+}
+
+func (l *ldapIdentity) GetUsername() string {
+	return l.Username
+}
+
+func (l *ldapIdentity) GetEmail() string {
+	return l.Email
+}
+
+func (l *ldapIdentity) GetGroups() []string {
+	return l.Groups
+}
+
+type ldapProviderFactory struct {
+}
+
+func (l *ldapProviderFactory) Type() string {
+	return ldapIdentityProvider
+}
+
+func (l *ldapProviderFactory) Create(options oauth.DynamicOptions) (identityprovider.GenericProvider, error) {
+	var ldapProvider ldapProvider
+	if err := mapstructure.Decode(options, &ldapProvider); err != nil {
+		return nil, err
+	}
+	if ldapProvider.Host == "" {
+		return nil, fmt.Errorf("host of LDAP identity provider is required")
+	}
+	if ldapProvider.UseTLS && ldapProvider.StartTLS {
+		return nil, fmt.Errorf("useTLS and startTLS of LDAP identity provider are mutually exclusive")
+	}
+	if ldapProvider.ReadTimeout <= 0 {
+		ldapProvider.ReadTimeout = defaultReadTimeout
+	}
+	if ldapProvider.LoginAttribute == "" {
+		ldapProvider.LoginAttribute = defaultLoginAttr
+	}
+	if ldapProvider.MailAttribute == "" {
+		ldapProvider.MailAttribute = defaultMailAttr
+	}
+	if ldapProvider.GroupNameAttribute == "" {
+		ldapProvider.GroupNameAttribute = defaultGroupNameAttr
+	}
+	if ldapProvider.UseTLS || ldapProvider.StartTLS {
+		tlsConfig, err := ldapProvider.newTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		ldapProvider.tlsConfig = tlsConfig
+	}
+	ldapProvider.dial = ldapProvider.newConn
+	return &ldapProvider, nil
+}
+
+func (l *ldapProvider) Authenticate(username string, password string) (identityprovider.Identity, error) {
+	if username == "" || password == "" {
+		return nil, IncorrectPasswordError
+	}
+	conn, err := l.dial()
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	defer conn.Close()
+
+	if l.ManagerDN != "" {
+		if err = conn.Bind(l.ManagerDN, l.ManagerPassword); err != nil {
+			klog.Error(err)
+			return nil, err
+		}
+	}
+
+	attributes := []string{l.LoginAttribute, l.MailAttribute}
+	if l.UserMemberAttribute != "" {
+		attributes = append(attributes, l.UserMemberAttribute)
+	}
+	filter := fmt.Sprintf("(%s=%s)", l.LoginAttribute, ldap.EscapeFilter(username))
+	if l.UserSearchFilter != "" {
+		filter = fmt.Sprintf("(&%s%s)", filter, l.UserSearchFilter)
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.UserSearchBase,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		attributes,
+		nil,
+	))
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		klog.V(4).Infof("%d entries of LDAP user %s are found", len(result.Entries), username)
+		return nil, IncorrectPasswordError
+	}
+
+	entry := result.Entries[0]
+	if err = conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, IncorrectPasswordError
+		}
+		klog.Error(err)
+		return nil, err
+	}
+
+	// the groups are searched by the manager, users may not be allowed to
+	if l.ManagerDN != "" {
+		if err = conn.Bind(l.ManagerDN, l.ManagerPassword); err != nil {
+			klog.Error(err)
+			return nil, err
+		}
+	}
+	groups, err := l.groupsOf(conn, entry)
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	return &ldapIdentity{
+		Username: entry.GetAttributeValue(l.LoginAttribute),
+		Email:    entry.GetAttributeValue(l.MailAttribute),
+		Groups:   groups,
+	}, nil
+}
+
+// groupsOf returns the names of the groups which the user entry is a member of
+func (l *ldapProvider) groupsOf(conn ldap.Client, entry *ldap.Entry) ([]string, error) {
+	var groups []string
+	if l.UserMemberAttribute != "" {
+		for _, dn := range entry.GetAttributeValues(l.UserMemberAttribute) {
+			parsed, err := ldap.ParseDN(dn)
+			if err != nil || len(parsed.RDNs) == 0 {
+				groups = append(groups, dn)
+				continue
+			}
+			groups = append(groups, parsed.RDNs[0].Attributes[0].Value)
+		}
+		return groups, nil
+	}
+	if l.GroupSearchBase == "" || l.GroupMemberAttribute == "" {
+		return nil, nil
+	}
+
+	filter := fmt.Sprintf("(%s=%s)", l.GroupMemberAttribute, ldap.EscapeFilter(entry.DN))
+	if l.GroupSearchFilter != "" {
+		filter = fmt.Sprintf("(&%s%s)", filter, l.GroupSearchFilter)
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.GroupSearchBase,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		[]string{l.GroupNameAttribute},
+		nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range result.Entries {
+		groups = append(groups, group.GetAttributeValue(l.GroupNameAttribute))
+	}
+	return groups, nil
+}
+
+func (l *ldapProvider) newConn() (ldap.Client, error) {
+	var conn *ldap.Conn
+	var err error
+	if l.UseTLS {
+		conn, err = ldap.DialURL("ldaps://"+l.Host, ldap.DialWithTLSConfig(l.tlsConfig))
+	} else {
+		conn, err = ldap.DialURL("ldap://" + l.Host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if l.StartTLS {
+		if err = conn.StartTLS(l.tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	conn.SetTimeout(time.Duration(l.ReadTimeout) * time.Millisecond)
+	return conn, nil
+}
+
+func (l *ldapProvider) newTLSConfig() (*tls.Config, error) {
+	serverName := l.Host
+	if host, _, err := net.SplitHostPort(l.Host); err == nil {
+		serverName = host
+	}
+	tlsConfig := &tls.Config{ServerName: serverName, InsecureSkipVerify: l.InsecureSkipVerify}
+	if l.RootCA == "" && l.RootCAData == "" {
+		return tlsConfig, nil
+	}
+
+	var data []byte
+	var err error
+	if l.RootCAData != "" {
+		if data, err = base64.StdEncoding.DecodeString(l.RootCAData); err != nil {
+			return nil, err
+		}
+	} else if data, err = os.ReadFile(l.RootCA); err != nil {
+		return nil, err
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate is found in the root CA of LDAP identity provider")
+	}
+	tlsConfig.RootCAs = rootCAs
+	return tlsConfig, nil
+}
@@ -0,0 +1,188 @@
+package ldap
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/identityprovider"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConn is an in memory directory, entries are looked up by the value of the filter
+type fakeConn struct {
+	ldap.Client
+	passwords map[string]string
+	entries   []*ldap.Entry
+	bound     string
+	filters   []string
+	closed    bool
+}
+
+func (f *fakeConn) Bind(username, password string) error {
+	if p, ok := f.passwords[username]; !ok || p != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, fmt.Errorf("invalid credentials"))
+	}
+	f.bound = username
+	return nil
+}
+
+func (f *fakeConn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if f.bound != "cn=admin,dc=ai,dc=io" {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, fmt.Errorf("insufficient access"))
+	}
+	f.filters = append(f.filters, req.Filter)
+	if _, err := ldap.CompileFilter(req.Filter); err != nil {
+		return nil, err
+	}
+	result := &ldap.SearchResult{}
+	for _, entry := range f.entries {
+		if matches(req.Filter, entry) {
+			result.Entries = append(result.Entries, entry)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeConn) Close() {
+	f.closed = true
+}
+
+var equalityMatch = regexp.MustCompile(`\(([^()&|!=]+)=([^()]*)\)`)
+
+// matches supports the conjunction of equality matches only
+func matches(filter string, entry *ldap.Entry) bool {
+	for _, match := range equalityMatch.FindAllStringSubmatch(filter, -1) {
+		found := false
+		for _, value := range entry.GetAttributeValues(match[1]) {
+			if value == match[2] {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		passwords: map[string]string{
+			"cn=admin,dc=ai,dc=io":           "admin",
+			"uid=alice,ou=users,dc=ai,dc=io": "P@88w0rd",
+			"uid=bob,ou=users,dc=ai,dc=io":   "P@88w0rd",
+		},
+		entries: []*ldap.Entry{
+			ldap.NewEntry("uid=alice,ou=users,dc=ai,dc=io", map[string][]string{
+				"objectClass": {"inetOrgPerson"},
+				"uid":         {"alice"},
+				"mail":        {"alice@ai.io"},
+				"memberOf":    {"cn=developers,ou=groups,dc=ai,dc=io", "cn=admins,ou=groups,dc=ai,dc=io"},
+			}),
+			ldap.NewEntry("uid=bob,ou=users,dc=ai,dc=io", map[string][]string{
+				"objectClass": {"account"},
+				"uid":         {"bob"},
+			}),
+			ldap.NewEntry("cn=developers,ou=groups,dc=ai,dc=io", map[string][]string{
+				"objectClass": {"groupOfNames"},
+				"cn":          {"developers"},
+				"member":      {"uid=alice,ou=users,dc=ai,dc=io", "uid=bob,ou=users,dc=ai,dc=io"},
+			}),
+		},
+	}
+}
+
+func newProvider(t *testing.T, options oauth.DynamicOptions, conn *fakeConn) identityprovider.GenericProvider {
+	provider, err := (&ldapProviderFactory{}).Create(options)
+	assert.Nil(t, err)
+	provider.(*ldapProvider).dial = func() (ldap.Client, error) {
+		return conn, nil
+	}
+	return provider
+}
+
+func TestCreate(t *testing.T) {
+	factory := &ldapProviderFactory{}
+	assert.Equal(t, "LDAPIdentityProvider", factory.Type())
+
+	provider, err := factory.Create(oauth.DynamicOptions{"host": "ldap.ai.io:389", "startTLS": true})
+	assert.Nil(t, err)
+	ldapProvider := provider.(*ldapProvider)
+	assert.Equal(t, "uid", ldapProvider.LoginAttribute)
+	assert.Equal(t, "mail", ldapProvider.MailAttribute)
+	assert.Equal(t, defaultReadTimeout, ldapProvider.ReadTimeout)
+	assert.Equal(t, "ldap.ai.io", ldapProvider.tlsConfig.ServerName)
+
+	_, err = factory.Create(oauth.DynamicOptions{})
+	assert.NotNil(t, err)
+	_, err = factory.Create(oauth.DynamicOptions{"host": "ldap.ai.io", "useTLS": true, "startTLS": true})
+	assert.NotNil(t, err)
+	_, err = factory.Create(oauth.DynamicOptions{"host": "ldap.ai.io", "useTLS": true, "rootCAData": "aW52YWxpZA=="})
+	assert.NotNil(t, err)
+}
+
+func TestAuthenticate(t *testing.T) {
+	options := oauth.DynamicOptions{
+		"host":             "ldap.ai.io:389",
+		"managerDN":        "cn=admin,dc=ai,dc=io",
+		"managerPassword":  "admin",
+		"userSearchBase":   "ou=users,dc=ai,dc=io",
+		"userSearchFilter": "(objectClass=inetOrgPerson)",
+	}
+	conn := newFakeConn()
+	provider := newProvider(t, options, conn)
+
+	identity, err := provider.Authenticate("alice", "P@88w0rd")
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", identity.GetUserID())
+	assert.Equal(t, "alice", identity.GetUsername())
+	assert.Equal(t, "alice@ai.io", identity.GetEmail())
+	assert.Empty(t, identity.(identityprovider.GroupsIdentity).GetGroups())
+	assert.Equal(t, "(&(uid=alice)(objectClass=inetOrgPerson))", conn.filters[0])
+	assert.True(t, conn.closed)
+
+	_, err = provider.Authenticate("alice", "incorrect")
+	assert.Equal(t, IncorrectPasswordError, err)
+	// bob is not an inetOrgPerson
+	_, err = provider.Authenticate("bob", "P@88w0rd")
+	assert.Equal(t, IncorrectPasswordError, err)
+	_, err = provider.Authenticate("*", "P@88w0rd")
+	assert.Equal(t, IncorrectPasswordError, err)
+	assert.Equal(t, "(&(uid=\\2a)(objectClass=inetOrgPerson))", conn.filters[len(conn.filters)-1])
+
+	options["managerPassword"] = "incorrect"
+	_, err = newProvider(t, options, newFakeConn()).Authenticate("alice", "P@88w0rd")
+	assert.NotNil(t, err)
+}
+
+func TestGroups(t *testing.T) {
+	options := oauth.DynamicOptions{
+		"host":                "ldap.ai.io:389",
+		"managerDN":           "cn=admin,dc=ai,dc=io",
+		"managerPassword":     "admin",
+		"userSearchBase":      "ou=users,dc=ai,dc=io",
+		"userMemberAttribute": "memberOf",
+	}
+	identity, err := newProvider(t, options, newFakeConn()).Authenticate("alice", "P@88w0rd")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"developers", "admins"}, identity.(identityprovider.GroupsIdentity).GetGroups())
+
+	options = oauth.DynamicOptions{
+		"host":                 "ldap.ai.io:389",
+		"managerDN":            "cn=admin,dc=ai,dc=io",
+		"managerPassword":      "admin",
+		"userSearchBase":       "ou=users,dc=ai,dc=io",
+		"groupSearchBase":      "ou=groups,dc=ai,dc=io",
+		"groupSearchFilter":    "(objectClass=groupOfNames)",
+		"groupMemberAttribute": "member",
+	}
+	conn := newFakeConn()
+	identity, err = newProvider(t, options, conn).Authenticate("bob", "P@88w0rd")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"developers"}, identity.(identityprovider.GroupsIdentity).GetGroups())
+	assert.Equal(t, "(&(member=uid=bob,ou=users,dc=ai,dc=io)(objectClass=groupOfNames))", conn.filters[1])
+}
@@ -5,6 +5,8 @@ import (
 )
 
 type OAuthProvider interface {
+	// AuthCodeURL returns the URL of the provider to redirect the user to log in, the state is sent back to the callback
+	AuthCodeURL(state string) string
 	// IdentityExchange exchange identity from remote server
 	IdentityExchange(code string) (Identity, error)
 }
@@ -0,0 +1,241 @@
+package oidc
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/identityprovider"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+
+	"github.com/mitchellh/mapstructure"
+	"golang.org/x/oauth2"
+)
+
+const (
+	defaultPreferredUsernameKey = "preferred_username"
+	defaultEmailKey             = "email"
+	discoveryTimeout            = 10 * time.Second
+)
+
+func init() {
+	identityprovider.RegisterOAuthProvider(&oidcProviderFactory{})
+}
+
+type oidcProvider struct {
+	// Defines how Clients dynamically discover information about OpenID Providers
+	// See also, https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfig
+	Issuer string `json:"issuer" yaml:"issuer" mapstructure:"issuer"`
+
+	// ClientID is the application's ID.
+	ClientID string `json:"clientID" yaml:"clientID" mapstructure:"clientID"`
+
+	// ClientSecret is the application's secret.
+	ClientSecret string `json:"-" yaml:"clientSecret" mapstructure:"clientSecret"`
+
+	// Endpoint overrides the endpoints discovered from the issuer.
+	Endpoint endpoint `json:"endpoint" yaml:"endpoint" mapstructure:"endpoint"`
+
+	// RedirectURL is the callback URL of ai-server registered in the OpenID provider,
+	// e.g. https://ai.example.com/oauth/callback/<provider name>.
+	RedirectURL string `json:"redirectURL" yaml:"redirectURL" mapstructure:"redirectURL"`
+
+	// Scope specifies optional requested permissions, "openid" is always requested.
+	Scopes []string `json:"scopes" yaml:"scopes" mapstructure:"scopes"`
+
+	// Used to turn off TLS certificate checks
+	InsecureSkipVerify bool `json:"insecureSkipVerify" yaml:"insecureSkipVerify" mapstructure:"insecureSkipVerify"`
+
+	// The claim used as the username, preferred_username by default.
+	PreferredUsernameKey string `json:"preferredUsernameKey" yaml:"preferredUsernameKey" mapstructure:"preferredUsernameKey"`
+
+	// The claim used as the email, email by default.
+	EmailKey string `json:"emailKey" yaml:"emailKey" mapstructure:"emailKey"`
+
+	Config *oauth2.Config `json:"-" yaml:"-"`
+
+	httpClient *http.Client
+}
+
+// endpoint represents an OpenID provider's authorization, token and userinfo endpoint URLs.
+type endpoint struct {
+	AuthURL     string `json:"authURL" yaml:"authURL" mapstructure:"authURL"`
+	TokenURL    string `json:"tokenURL" yaml:"tokenURL" mapstructure:"tokenURL"`
+	UserInfoURL string `json:"userInfoURL" yaml:"userInfoURL" mapstructure:"userInfoURL"`
+}
+
+// providerMetadata is the subset of the OpenID provider metadata used by the provider
+type providerMetadata struct {
+	Issuer      string `json:"issuer"`
+	AuthURL     string `json:"authorization_endpoint"`
+	TokenURL    string `json:"token_endpoint"`
+	UserInfoURL string `json:"userinfo_endpoint"`
+}
+
+type oidcIdentity struct {
+	Sub               string
+	PreferredUsername string
+	Email             string
+}
+
+func (o oidcIdentity) GetUserID() string {
+	return o.Sub
+}
+
+func (o oidcIdentity) GetUsername() string {
+	return o.PreferredUsername
+}
+
+func (o oidcIdentity) GetEmail() string {
+	return o.Email
+}
+
+type oidcProviderFactory struct {
+}
+
+func (f *oidcProviderFactory) Type() string {
+	return "OpenIDIdentityProvider"
+}
+
+func (f *oidcProviderFactory) Create(options oauth.DynamicOptions) (identityprovider.OAuthProvider, error) {
+	var oidcProvider oidcProvider
+	if err := mapstructure.Decode(options, &oidcProvider); err != nil {
+		return nil, err
+	}
+	if oidcProvider.Issuer == "" || oidcProvider.ClientID == "" {
+		return nil, fmt.Errorf("issuer and clientID of OIDC identity provider are required")
+	}
+	if oidcProvider.PreferredUsernameKey == "" {
+		oidcProvider.PreferredUsernameKey = defaultPreferredUsernameKey
+	}
+	if oidcProvider.EmailKey == "" {
+		oidcProvider.EmailKey = defaultEmailKey
+	}
+	oidcProvider.httpClient = http.DefaultClient
+	if oidcProvider.InsecureSkipVerify {
+		oidcProvider.httpClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+				},
+			},
+		}
+	}
+
+	// the endpoints configured explicitly take precedence over the discovered ones
+	if oidcProvider.Endpoint.AuthURL == "" || oidcProvider.Endpoint.TokenURL == "" || oidcProvider.Endpoint.UserInfoURL == "" {
+		metadata, err := oidcProvider.discover()
+		if err != nil {
+			return nil, err
+		}
+		if oidcProvider.Endpoint.AuthURL == "" {
+			oidcProvider.Endpoint.AuthURL = metadata.AuthURL
+		}
+		if oidcProvider.Endpoint.TokenURL == "" {
+			oidcProvider.Endpoint.TokenURL = metadata.TokenURL
+		}
+		if oidcProvider.Endpoint.UserInfoURL == "" {
+			oidcProvider.Endpoint.UserInfoURL = metadata.UserInfoURL
+		}
+	}
+	if oidcProvider.Endpoint.TokenURL == "" || oidcProvider.Endpoint.UserInfoURL == "" {
+		return nil, fmt.Errorf("token and userinfo endpoints of issuer %s are not found", oidcProvider.Issuer)
+	}
+
+	scopes := []string{"openid"}
+	for _, scope := range oidcProvider.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+	oidcProvider.Config = &oauth2.Config{
+		ClientID:     oidcProvider.ClientID,
+		ClientSecret: oidcProvider.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  oidcProvider.Endpoint.AuthURL,
+			TokenURL: oidcProvider.Endpoint.TokenURL,
+		},
+		RedirectURL: oidcProvider.RedirectURL,
+		Scopes:      scopes,
+	}
+	return &oidcProvider, nil
+}
+
+// discover fetches the provider metadata from the well-known location of the issuer,
+// see also https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfig
+func (o *oidcProvider) discover() (*providerMetadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	wellKnown := strings.TrimSuffix(o.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to discover issuer %s, status: %d, body: %s", o.Issuer, resp.StatusCode, data)
+	}
+
+	var metadata providerMetadata
+	if err = json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	// the issuer returned must be identical to the one configured
+	if metadata.Issuer != o.Issuer {
+		return nil, fmt.Errorf("issuer did not match the issuer returned by provider, expected %q got %q", o.Issuer, metadata.Issuer)
+	}
+	return &metadata, nil
+}
+
+// AuthCodeURL returns the URL of the provider to log in, the state is sent back to the callback
+func (o *oidcProvider) AuthCodeURL(state string) string {
+	return o.Config.AuthCodeURL(state)
+}
+
+// IdentityExchange exchanges the code for an access token, the identity is read from the userinfo endpoint
+func (o *oidcProvider) IdentityExchange(code string) (identityprovider.Identity, error) {
+	ctx := context.WithValue(context.TODO(), oauth2.HTTPClient, o.httpClient)
+	token, err := o.Config.Exchange(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := oauth2.NewClient(ctx, oauth2.StaticTokenSource(token)).Get(o.Endpoint.UserInfoURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get user info from issuer %s, status: %d, body: %s", o.Issuer, resp.StatusCode, data)
+	}
+
+	var claims map[string]interface{}
+	if err = json.Unmarshal(data, &claims); err != nil {
+		return nil, err
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, fmt.Errorf("sub claim is missing in the user info of issuer %s", o.Issuer)
+	}
+	username, _ := claims[o.PreferredUsernameKey].(string)
+	email, _ := claims[o.EmailKey].(string)
+	return oidcIdentity{Sub: sub, PreferredUsername: username, Email: email}, nil
+}
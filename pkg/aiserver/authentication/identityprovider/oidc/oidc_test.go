@@ -0,0 +1,121 @@
+package oidc
+
+import (
+	"testing"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+
+	"github.com/h2non/gock"
+	"github.com/stretchr/testify/assert"
+)
+
+const issuer = "http://idp.local"
+
+func mockDiscovery(issuer string) {
+	gock.New("http://idp.local").
+		Get("/.well-known/openid-configuration").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"issuer":                 issuer,
+			"authorization_endpoint": "http://idp.local/authorize",
+			"token_endpoint":         "http://idp.local/token",
+			"userinfo_endpoint":      "http://idp.local/userinfo",
+		})
+}
+
+func TestCreate(t *testing.T) {
+	defer gock.Off()
+	factory := &oidcProviderFactory{}
+	assert.Equal(t, "OpenIDIdentityProvider", factory.Type())
+
+	mockDiscovery(issuer)
+	provider, err := factory.Create(oauth.DynamicOptions{
+		"issuer":       issuer,
+		"clientID":     "client",
+		"clientSecret": "secret",
+		"scopes":       []interface{}{"email", "profile"},
+		"endpoint":     oauth.DynamicOptions{"authURL": "http://login.idp.local/authorize"},
+	})
+	assert.Nil(t, err)
+	oidcProvider := provider.(*oidcProvider)
+	assert.Equal(t, endpoint{
+		AuthURL:     "http://login.idp.local/authorize",
+		TokenURL:    "http://idp.local/token",
+		UserInfoURL: "http://idp.local/userinfo",
+	}, oidcProvider.Endpoint)
+	assert.Equal(t, []string{"openid", "email", "profile"}, oidcProvider.Config.Scopes)
+	assert.True(t, gock.IsDone())
+
+	// no discovery if all the endpoints are configured
+	_, err = factory.Create(oauth.DynamicOptions{
+		"issuer":   issuer,
+		"clientID": "client",
+		"endpoint": oauth.DynamicOptions{
+			"authURL":     "http://idp.local/authorize",
+			"tokenURL":    "http://idp.local/token",
+			"userInfoURL": "http://idp.local/userinfo",
+		},
+	})
+	assert.Nil(t, err)
+
+	mockDiscovery("http://another.local")
+	_, err = factory.Create(oauth.DynamicOptions{"issuer": issuer, "clientID": "client"})
+	assert.NotNil(t, err)
+
+	gock.New("http://idp.local").
+		Get("/.well-known/openid-configuration").
+		Reply(404)
+	_, err = factory.Create(oauth.DynamicOptions{"issuer": issuer, "clientID": "client"})
+	assert.NotNil(t, err)
+
+	_, err = factory.Create(oauth.DynamicOptions{"clientID": "client"})
+	assert.NotNil(t, err)
+}
+
+func TestIdentityExchange(t *testing.T) {
+	defer gock.Off()
+	mockDiscovery(issuer)
+	provider, err := (&oidcProviderFactory{}).Create(oauth.DynamicOptions{
+		"issuer":               issuer,
+		"clientID":             "client",
+		"clientSecret":         "secret",
+		"preferredUsernameKey": "name",
+	})
+	assert.Nil(t, err)
+
+	gock.New("http://idp.local").
+		Post("/token").
+		BodyString("code=valid").
+		Reply(200).
+		JSON(map[string]interface{}{"access_token": "token", "token_type": "Bearer", "id_token": "id-token"})
+	gock.New("http://idp.local").
+		Get("/userinfo").
+		MatchHeader("Authorization", "Bearer token").
+		Reply(200).
+		JSON(map[string]interface{}{"sub": "110169484474386276334", "name": "alice", "email": "alice@ai.io"})
+
+	identity, err := provider.IdentityExchange("valid")
+	assert.Nil(t, err)
+	assert.Equal(t, "110169484474386276334", identity.GetUserID())
+	assert.Equal(t, "alice", identity.GetUsername())
+	assert.Equal(t, "alice@ai.io", identity.GetEmail())
+	assert.True(t, gock.IsDone())
+
+	gock.New("http://idp.local").
+		Post("/token").
+		Reply(400).
+		JSON(map[string]interface{}{"error": "invalid_grant"})
+	_, err = provider.IdentityExchange("invalid")
+	assert.NotNil(t, err)
+
+	gock.New("http://idp.local").
+		Post("/token").
+		Reply(200).
+		JSON(map[string]interface{}{"access_token": "token", "token_type": "Bearer"})
+	gock.New("http://idp.local").
+		Get("/userinfo").
+		Reply(200).
+		JSON(map[string]interface{}{"name": "alice"})
+	_, err = provider.IdentityExchange("valid")
+	assert.NotNil(t, err)
+}
@@ -3,6 +3,8 @@ package oauth
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
 	"net/url"
 	"strings"
 	"time"
@@ -32,6 +34,14 @@ const (
 	MappingMethodMixed MappingMethod = "mixed"
 )
 
+const (
+	// ServiceIdentityPrefix is the username prefix of the clients authenticated by the client_credentials grant,
+	// the colon is not allowed in the names of the users so the service identities never collide with them.
+	ServiceIdentityPrefix = "system:oauth-client:"
+	// ServiceIdentityGroup is the group of all the service identities
+	ServiceIdentityGroup = "system:oauth-clients"
+)
+
 var (
 	ErrorClientNotFound        = errors.New("the OAuth client was not found")
 	ErrorProviderNotFound      = errors.New("the identity provider was not found")
@@ -39,6 +49,15 @@ var (
 )
 
 type Options struct {
+	// Issuer is the URL of ai-server as an OpenID Connect provider, e.g. https://ai.example.com,
+	// it is used as the iss claim of ID tokens. The URL of the request is used if not set.
+	Issuer string `json:"issuer,omitempty" yaml:"issuer,omitempty"`
+
+	// TrustedProxies are the CIDRs of the reverse proxies in front of ai-server, e.g. 10.0.0.0/8.
+	// The X-Forwarded-Proto and X-Forwarded-Host headers build the issuer URL only if the request
+	// comes from one of them, since the other callers can set the headers to anything.
+	TrustedProxies []string `json:"trustedProxies,omitempty" yaml:"trustedProxies,omitempty"`
+
 	// Register identity providers.
 	IdentityProviders []IdentityProviderOptions `json:"identityProviders,omitempty" yaml:"identityProviders,omitempty"`
 
@@ -59,6 +78,7 @@ type Options struct {
 	// - 0: Tokens for this client never time out
 	// - X: Tokens time out if there is no activity
 	// The current minimum allowed value for X is 5 minutes
+	// The access tokens are tracked in the cache only if AccessTokenMaxAge is not 0.
 	AccessTokenInactivityTimeout time.Duration `json:"accessTokenInactivityTimeout" yaml:"accessTokenInactivityTimeout"`
 }
 
@@ -149,6 +169,12 @@ type Token struct {
 
 	// ExpiresIn is the optional expiration second of the access token.
 	ExpiresIn int `json:"expires_in,omitempty"`
+
+	// IDToken is the OpenID Connect ID token, issued if the openid scope is requested.
+	IDToken string `json:"id_token,omitempty"`
+
+	// Scope is the space separated scopes granted to the client.
+	Scope string `json:"scope,omitempty"`
 }
 
 type Client struct {
@@ -217,6 +243,84 @@ func (o *Options) OAuthClient(name string) (Client, error) {
 	return Client{}, ErrorClientNotFound
 }
 
+// RegisteredClient returns the client registered in the options, the default clients are excluded
+// since their secrets are well known and they are allowed to redirect to any URI.
+func (o *Options) RegisteredClient(name string) (Client, error) {
+	for _, found := range o.Clients {
+		if found.Name == name {
+			return found, nil
+		}
+	}
+	return Client{}, ErrorClientNotFound
+}
+
+// ServiceClient returns the registered client which is allowed to use the client_credentials grant,
+// only the confidential clients are allowed and the default clients are excluded since their secrets are well known.
+func (o *Options) ServiceClient(name string) (Client, error) {
+	for _, found := range o.Clients {
+		if found.Name == name && found.Secret != "" {
+			return found, nil
+		}
+	}
+	return Client{}, ErrorClientNotFound
+}
+
+// ServiceIdentity returns the username of the client authenticated by the client_credentials grant,
+// GlobalRoleBindings grant permissions to the client with a User subject of this name.
+func (c Client) ServiceIdentity() string {
+	return ServiceIdentityPrefix + c.Name
+}
+
+// ClientOfServiceIdentity returns the name of the client if the username is a service identity
+func ClientOfServiceIdentity(username string) (string, bool) {
+	if !strings.HasPrefix(username, ServiceIdentityPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(username, ServiceIdentityPrefix), true
+}
+
+// AllowedScopes returns the requested scopes if all of them are allowed by the scope restrictions,
+// all the allowed scopes are granted if none is requested.
+func (c Client) AllowedScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return c.ScopeRestrictions, nil
+	}
+	for _, scope := range requested {
+		if !sliceutil.HasString(c.ScopeRestrictions, scope) {
+			return nil, fmt.Errorf("scope %q is not allowed for client %s", scope, c.Name)
+		}
+	}
+	return requested, nil
+}
+
+// ValidateTrustedProxies checks all the trusted proxies are valid CIDRs
+func (o *Options) ValidateTrustedProxies() error {
+	for _, cidr := range o.TrustedProxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %v", cidr, err)
+		}
+	}
+	return nil
+}
+
+// IsTrustedProxy returns whether the remote address of the request belongs to a trusted proxy
+func (o *Options) IsTrustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range o.TrustedProxies {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func (o *Options) IdentityProviderOptions(name string) (*IdentityProviderOptions, error) {
 	for _, found := range o.IdentityProviders {
 		if found.Name == name {
@@ -260,6 +364,15 @@ func (c Client) ResolveRedirectURL(expectURL string) (string, error) {
 	return "", ErrorRedirectURLNotAllowed
 }
 
+// ResolveAuthorizationRedirectURL verifies the redirect URI of the authorization code flow, it must be registered
+// exactly since the code is sent to it. AllowAllRedirectURI is refused, otherwise the code can be sent to anywhere.
+func (c Client) ResolveAuthorizationRedirectURL(expectURL string) (string, error) {
+	if expectURL == "" || expectURL == AllowAllRedirectURI || !sliceutil.HasString(c.anyRedirectAbleURI(), expectURL) {
+		return "", ErrorRedirectURLNotAllowed
+	}
+	return expectURL, nil
+}
+
 func NewOptions() *Options {
 	return &Options{
 		IdentityProviders:            make([]IdentityProviderOptions, 0),
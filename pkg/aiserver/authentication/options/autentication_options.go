@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/hasher"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/identityprovider"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/token"
 
 	"github.com/spf13/pflag"
 )
@@ -27,13 +29,56 @@ type AuthenticationOptions struct {
 	MultipleLogin bool `json:"multipleLogin" yaml:"multipleLogin"`
 	// secret to sign jwt token
 	JwtSecret string `json:"-" yaml:"jwtSecret"`
+	// SigningKeys defines the RSA or ECDSA keys to sign jwt token,
+	// JwtSecret is only used to verify the tokens signed before if SigningKeys is set.
+	SigningKeys *token.SigningKeyOptions `json:"signingKeys,omitempty" yaml:"signingKeys,omitempty"`
 	// OAuthOptions defines options needed for integrated oauth plugins
 	OAuthOptions *oauth.Options `json:"oauthOptions" yaml:"oauthOptions"`
+	// PasswordHashing defines the algorithm used to hash the passwords of the users
+	PasswordHashing *hasher.Options `json:"passwordHashing,omitempty" yaml:"passwordHashing,omitempty"`
+	// PasswordPolicy defines the requirements of the passwords of the users
+	PasswordPolicy *PasswordPolicyOptions `json:"passwordPolicy,omitempty" yaml:"passwordPolicy,omitempty"`
+	// MFAOptions defines options of the TOTP second factor
+	MFAOptions *MFAOptions `json:"mfa,omitempty" yaml:"mfa,omitempty"`
+	// PersonalAccessTokenMaxAge limits the lifetime of the personal access tokens, 0 means the tokens may never expire
+	PersonalAccessTokenMaxAge time.Duration `json:"personalAccessTokenMaxAge" yaml:"personalAccessTokenMaxAge"`
 	// KubectlImage is the image address we use to create kubectl pod for users who have admin access to the cluster.
 	KubectlImage string `json:"kubectlImage" yaml:"kubectlImage"`
 	Disabled     bool   `json:"disabled" yaml:"disabled"`
 }
 
+type PasswordPolicyOptions struct {
+	// MinLength is the minimum number of characters
+	MinLength int `json:"minLength" yaml:"minLength"`
+	// The character classes passwords must contain
+	RequireUppercase bool `json:"requireUppercase" yaml:"requireUppercase"`
+	RequireLowercase bool `json:"requireLowercase" yaml:"requireLowercase"`
+	RequireDigit     bool `json:"requireDigit" yaml:"requireDigit"`
+	RequireSymbol    bool `json:"requireSymbol" yaml:"requireSymbol"`
+	// Denylist contains the passwords not allowed, compared case-insensitively
+	Denylist []string `json:"denylist,omitempty" yaml:"denylist,omitempty"`
+	// HistorySize is the number of the recent passwords which can not be reused, including the current one
+	HistorySize int `json:"historySize" yaml:"historySize"`
+	// MaxAge is the lifetime of passwords, users have to change the expired password before login.
+	// 0 means passwords never expire.
+	MaxAge time.Duration `json:"maxAge" yaml:"maxAge"`
+}
+
+func NewPasswordPolicyOptions() *PasswordPolicyOptions {
+	return &PasswordPolicyOptions{MinLength: 8}
+}
+
+type MFAOptions struct {
+	// Issuer is the account issuer shown by the authenticator apps, default to "ai"
+	Issuer string `json:"issuer" yaml:"issuer"`
+	// EncryptionKey is used to encrypt the TOTP secrets of the users, default to JwtSecret
+	EncryptionKey string `json:"-" yaml:"encryptionKey"`
+}
+
+func NewMFAOptions() *MFAOptions {
+	return &MFAOptions{Issuer: "ai"}
+}
+
 func NewAuthenticateOptions() *AuthenticationOptions {
 	return &AuthenticationOptions{
 		AuthenticateRateLimiterMaxTries: 5,
@@ -41,6 +86,10 @@ func NewAuthenticateOptions() *AuthenticationOptions {
 		MaximumClockSkew:                10 * time.Second,
 		LoginHistoryRetentionPeriod:     time.Hour * 24 * 7,
 		OAuthOptions:                    oauth.NewOptions(),
+		PasswordHashing:                 hasher.NewOptions(),
+		PasswordPolicy:                  NewPasswordPolicyOptions(),
+		MFAOptions:                      NewMFAOptions(),
+		PersonalAccessTokenMaxAge:       time.Hour * 24 * 365,
 		MultipleLogin:                   false,
 		JwtSecret:                       "",
 		KubectlImage:                    "ai/kubectl:v1.0.0",
@@ -49,9 +98,23 @@ func NewAuthenticateOptions() *AuthenticationOptions {
 
 func (options *AuthenticationOptions) Validate() []error {
 	var errs []error
-	if len(options.JwtSecret) == 0 {
+	if len(options.JwtSecret) == 0 && options.SigningKeys.IsEmpty() {
 		errs = append(errs, fmt.Errorf("jwt secret is empty"))
 	}
+	if !options.SigningKeys.IsEmpty() && options.SigningKeys.ActiveKeyID == "" {
+		errs = append(errs, fmt.Errorf("active signing key id is empty"))
+	}
+	if options.PasswordHashing != nil {
+		if err := options.PasswordHashing.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if options.PasswordPolicy != nil && options.PasswordPolicy.MinLength < 1 {
+		errs = append(errs, fmt.Errorf("minimum length of passwords must be positive"))
+	}
+	if err := options.OAuthOptions.ValidateTrustedProxies(); err != nil {
+		errs = append(errs, err)
+	}
 	if err := identityprovider.SetupWithOptions(options.OAuthOptions.IdentityProviders); err != nil {
 		errs = append(errs, err)
 	}
@@ -62,9 +125,10 @@ func (options *AuthenticationOptions) AddFlags(fs *pflag.FlagSet, s *Authenticat
 	fs.IntVar(&options.AuthenticateRateLimiterMaxTries, "authenticate-rate-limiter-max-retries", s.AuthenticateRateLimiterMaxTries, "")
 	fs.DurationVar(&options.AuthenticateRateLimiterDuration, "authenticate-rate-limiter-duration", s.AuthenticateRateLimiterDuration, "")
 	fs.BoolVar(&options.MultipleLogin, "multiple-login", s.MultipleLogin, "Allow multiple login with the same account, disable means only one user can login at the same time.")
-	fs.StringVar(&options.JwtSecret, "jwt-secret", s.JwtSecret, "Secret to sign jwt token, must not be empty.")
+	fs.StringVar(&options.JwtSecret, "jwt-secret", s.JwtSecret, "Secret to sign jwt token, must not be empty unless signing keys are configured.")
 	fs.DurationVar(&options.LoginHistoryRetentionPeriod, "login-history-retention-period", s.LoginHistoryRetentionPeriod, "login-history-retention-period defines how long login history should be kept.")
 	fs.DurationVar(&options.OAuthOptions.AccessTokenMaxAge, "access-token-max-age", s.OAuthOptions.AccessTokenMaxAge, "access-token-max-age control the lifetime of access tokens, 0 means no expiration.")
+	fs.DurationVar(&options.PersonalAccessTokenMaxAge, "personal-access-token-max-age", s.PersonalAccessTokenMaxAge, "personal-access-token-max-age limits the lifetime of personal access tokens, 0 means no limit.")
 	fs.StringVar(&s.KubectlImage, "kubectl-image", s.KubectlImage, "Setup the image used by kubectl terminal pod")
 	fs.DurationVar(&options.MaximumClockSkew, "maximum-clock-skew", s.MaximumClockSkew, "The maximum time difference between the system clocks of the ks-apiserver that issued a JWT and the ks-apiserver that verified the JWT.")
 }
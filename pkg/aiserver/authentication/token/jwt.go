@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/klog"
 )
@@ -18,13 +19,14 @@ type Claims struct {
 	Groups    []string            `json:"groups,omitempty"`
 	Extra     map[string][]string `json:"extra,omitempty"`
 	TokenType TokenType           `json:"token_type"`
-	// Currently, we are not using any field in jwt.StandardClaims
 	jwt.StandardClaims
 }
 
 type jwtTokenIssuer struct {
 	name   string
 	secret []byte
+	// keys signs tokens with asymmetric keys if configured, secret is used otherwise
+	keys *KeySet
 	// Maximum time difference
 	maximumClockSkew time.Duration
 }
@@ -49,6 +51,8 @@ func (s *jwtTokenIssuer) IssueTo(user user.Info, tokenType TokenType, expiresIn
 		Extra:     user.GetExtra(),
 		TokenType: tokenType,
 		StandardClaims: jwt.StandardClaims{
+			// unique id, tokens issued within the same second are distinguishable
+			Id:        string(uuid.NewUUID()),
 			IssuedAt:  issueAt,
 			Issuer:    s.name,
 			NotBefore: notBefore,
@@ -59,9 +63,16 @@ func (s *jwtTokenIssuer) IssueTo(user user.Info, tokenType TokenType, expiresIn
 		clm.ExpiresAt = clm.IssuedAt + int64(expiresIn.Seconds())
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, clm)
-
-	tokenString, err := token.SignedString(s.secret)
+	var tokenString string
+	var err error
+	if active := s.keys.Active(); active != nil {
+		token := jwt.NewWithClaims(active.Method, clm)
+		token.Header["kid"] = active.ID
+		tokenString, err = token.SignedString(active.Private)
+	} else {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, clm)
+		tokenString, err = token.SignedString(s.secret)
+	}
 
 	if err != nil {
 		klog.Error(err)
@@ -72,17 +83,35 @@ func (s *jwtTokenIssuer) IssueTo(user user.Info, tokenType TokenType, expiresIn
 }
 
 func (s *jwtTokenIssuer) keyFunc(token *jwt.Token) (i interface{}, err error) {
-	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		// tokens signed before the asymmetric keys are configured remain valid while the secret is kept
+		if len(s.secret) == 0 {
+			return nil, fmt.Errorf("token signed with HMAC is not accepted")
+		}
 		return s.secret, nil
-	} else {
-		return nil, fmt.Errorf("expect token signed with HMAC but got %v", token.Header["alg"])
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.keys.Get(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if key.Method.Alg() != token.Method.Alg() {
+			return nil, fmt.Errorf("signing key %s expects %s but got %v", kid, key.Method.Alg(), token.Header["alg"])
+		}
+		return key.Public, nil
+	default:
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
 	}
 }
 
-func NewTokenIssuer(secret string, maximumClockSkew time.Duration) Issuer {
+// NewTokenIssuer returns an Issuer signing tokens with the active key of keys,
+// HS256 with secret is used if keys is nil.
+func NewTokenIssuer(secret string, keys *KeySet, maximumClockSkew time.Duration) Issuer {
 	return &jwtTokenIssuer{
 		name:             DefaultIssuerName,
 		secret:           []byte(secret),
+		keys:             keys,
 		maximumClockSkew: maximumClockSkew,
 	}
 }
@@ -0,0 +1,238 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/dgrijalva/jwt-go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/klog"
+)
+
+// SigningKeyOptions defines the asymmetric keys used to sign jwt token.
+// Keys are identified by kid, several keys can be configured at the same time, so the
+// signing key can be rotated without invalidating the tokens issued before.
+type SigningKeyOptions struct {
+	// ActiveKeyID is the kid of the key used to sign new tokens,
+	// tokens signed by the other keys are valid until they expire.
+	ActiveKeyID string `json:"activeKeyID" yaml:"activeKeyID"`
+	// KeyFiles maps kid to the PEM encoded key file. RSA keys are used with RS256, ECDSA keys with ES256.
+	// A retired key can be provided as public key, it will only be used to verify tokens.
+	KeyFiles map[string]string `json:"keyFiles,omitempty" yaml:"keyFiles,omitempty"`
+	// SecretNamespace and SecretName refer to a Kubernetes Secret which holds the keys,
+	// each data entry of the Secret maps kid to the PEM encoded key.
+	SecretNamespace string `json:"secretNamespace,omitempty" yaml:"secretNamespace,omitempty"`
+	SecretName      string `json:"secretName,omitempty" yaml:"secretName,omitempty"`
+}
+
+// IsEmpty returns true if no asymmetric key is configured
+func (o *SigningKeyOptions) IsEmpty() bool {
+	return o == nil || (len(o.KeyFiles) == 0 && o.SecretName == "")
+}
+
+// SigningKey is a key identified by kid, Private is nil if the key can only be used for verification
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// KeySet holds the keys used to sign and verify tokens
+type KeySet struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// NewKeySet returns a KeySet signing tokens with the key of activeKeyID
+func NewKeySet(activeKeyID string, keys ...*SigningKey) (*KeySet, error) {
+	keySet := &KeySet{keys: make(map[string]*SigningKey)}
+	for _, key := range keys {
+		if _, ok := keySet.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate signing key %s", key.ID)
+		}
+		keySet.keys[key.ID] = key
+	}
+	active, ok := keySet.keys[activeKeyID]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %q not found", activeKeyID)
+	}
+	if active.Private == nil {
+		return nil, fmt.Errorf("active signing key %q must be a private key", activeKeyID)
+	}
+	keySet.active = active
+	return keySet, nil
+}
+
+// Active returns the key used to sign new tokens
+func (k *KeySet) Active() *SigningKey {
+	if k == nil {
+		return nil
+	}
+	return k.active
+}
+
+// Get returns the key with the given kid
+func (k *KeySet) Get(kid string) (*SigningKey, bool) {
+	if k == nil {
+		return nil, false
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// JSONWebKey is the public part of a signing key, see RFC 7517
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA public key
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// ECDSA public key
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is published at the JWKS endpoint
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys in JWKS format, sorted by kid
+func (k *KeySet) JWKS() JSONWebKeySet {
+	jwks := JSONWebKeySet{Keys: make([]JSONWebKey, 0)}
+	if k == nil {
+		return jwks
+	}
+	for _, key := range k.keys {
+		jwk := JSONWebKey{KeyID: key.ID, Use: "sig", Algorithm: key.Method.Alg()}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.KeyType = "EC"
+			jwk.Curve = public.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, size)))
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID
+	})
+	return jwks
+}
+
+// ParseSigningKey parses a PEM encoded private key or public key
+func ParseSigningKey(kid string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", kid)
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block type %s", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %v", kid, err)
+	}
+
+	key := &SigningKey{ID: kid}
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.Private = signer
+		key.Public = signer.Public()
+	} else {
+		key.Public = parsed
+	}
+
+	switch public := key.Public.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch public.Curve {
+		case elliptic.P256():
+			key.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.Method = jwt.SigningMethodES384
+		case elliptic.P521():
+			key.Method = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("unsupported curve of signing key %s", kid)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signing key %s, only RSA and ECDSA keys are supported", kid)
+	}
+	return key, nil
+}
+
+// LoadKeySet loads keys from the files and the Kubernetes Secret,
+// nil is returned if no asymmetric key is configured.
+func LoadKeySet(options *SigningKeyOptions, secrets corev1client.SecretsGetter) (*KeySet, error) {
+	if options.IsEmpty() {
+		return nil, nil
+	}
+
+	var keys []*SigningKey
+	for kid, file := range options.KeyFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			klog.Error(err)
+			return nil, err
+		}
+		key, err := ParseSigningKey(kid, data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if options.SecretName != "" {
+		if secrets == nil {
+			return nil, fmt.Errorf("unable to load signing keys from secret %s/%s", options.SecretNamespace, options.SecretName)
+		}
+		secret, err := secrets.Secrets(options.SecretNamespace).Get(context.Background(), options.SecretName, metav1.GetOptions{})
+		if err != nil {
+			klog.Error(err)
+			return nil, err
+		}
+		for kid, data := range secret.Data {
+			key, err := ParseSigningKey(kid, data)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	return NewKeySet(options.ActiveKeyID, keys...)
+}
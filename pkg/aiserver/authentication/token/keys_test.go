@@ -0,0 +1,217 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func rsaKeyPEM(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func ecKeyPEM(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func publicKeyPEM(t *testing.T, key *SigningKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key.Public)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestParseSigningKey(t *testing.T) {
+	rsaKey, err := ParseSigningKey("rsa", rsaKeyPEM(t))
+	assert.Nil(t, err)
+	assert.Equal(t, "RS256", rsaKey.Method.Alg())
+	assert.NotNil(t, rsaKey.Private)
+
+	ecKey, err := ParseSigningKey("ec", ecKeyPEM(t))
+	assert.Nil(t, err)
+	assert.Equal(t, "ES256", ecKey.Method.Alg())
+
+	public, err := ParseSigningKey("public", publicKeyPEM(t, ecKey))
+	assert.Nil(t, err)
+	assert.Equal(t, "ES256", public.Method.Alg())
+	assert.Nil(t, public.Private)
+
+	_, err = ParseSigningKey("invalid", []byte("invalid"))
+	assert.NotNil(t, err)
+
+	_, err = NewKeySet("public", public)
+	assert.NotNil(t, err)
+	_, err = NewKeySet("none", ecKey)
+	assert.NotNil(t, err)
+}
+
+func TestAsymmetricTokenIssuer(t *testing.T) {
+	rsaKey, err := ParseSigningKey("rsa", rsaKeyPEM(t))
+	assert.Nil(t, err)
+	ecKey, err := ParseSigningKey("ec", ecKeyPEM(t))
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name string
+		key  *SigningKey
+	}{
+		{name: "RS256", key: rsaKey},
+		{name: "ES256", key: ecKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := NewKeySet(tt.key.ID, rsaKey, ecKey)
+			assert.Nil(t, err)
+			issuer := NewTokenIssuer("", keys, 0)
+
+			tokenString, err := issuer.IssueTo(&user.DefaultInfo{Name: "admin"}, AccessToken, time.Hour)
+			assert.Nil(t, err)
+			parsed, _, err := new(jwt.Parser).ParseUnverified(tokenString, &Claims{})
+			assert.Nil(t, err)
+			assert.Equal(t, tt.name, parsed.Header["alg"])
+			assert.Equal(t, tt.key.ID, parsed.Header["kid"])
+
+			authenticated, tokenType, err := issuer.Verify(tokenString)
+			assert.Nil(t, err)
+			assert.Equal(t, "admin", authenticated.GetName())
+			assert.Equal(t, AccessToken, tokenType)
+		})
+	}
+}
+
+func TestSignIDToken(t *testing.T) {
+	ecKey, err := ParseSigningKey("ec", ecKeyPEM(t))
+	assert.Nil(t, err)
+	keys, err := NewKeySet("ec", ecKey)
+	assert.Nil(t, err)
+	claims := &IDTokenClaims{StandardClaims: jwt.StandardClaims{Subject: "admin", Audience: "spa"}}
+
+	// the configured key is used for all the clients, with or without a secret
+	for _, secret := range []string{"", "secret"} {
+		tokenString, err := SignIDToken(claims, keys, secret)
+		assert.Nil(t, err)
+		parsed, err := jwt.ParseWithClaims(tokenString, &IDTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
+			return ecKey.Public, nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "ES256", parsed.Header["alg"])
+		assert.Equal(t, "ec", parsed.Header["kid"])
+	}
+
+	tokenString, err := SignIDToken(claims, nil, "secret")
+	assert.Nil(t, err)
+	parsed, _, err := new(jwt.Parser).ParseUnverified(tokenString, &IDTokenClaims{})
+	assert.Nil(t, err)
+	assert.Equal(t, "HS256", parsed.Header["alg"])
+
+	_, err = SignIDToken(claims, nil, "")
+	assert.NotNil(t, err)
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	oldKey, err := ParseSigningKey("old", rsaKeyPEM(t))
+	assert.Nil(t, err)
+	newKey, err := ParseSigningKey("new", ecKeyPEM(t))
+	assert.Nil(t, err)
+
+	hmacToken, err := NewTokenIssuer("secret", nil, 0).IssueTo(&user.DefaultInfo{Name: "admin"}, AccessToken, time.Hour)
+	assert.Nil(t, err)
+	keys, err := NewKeySet("old", oldKey)
+	assert.Nil(t, err)
+	oldToken, err := NewTokenIssuer("secret", keys, 0).IssueTo(&user.DefaultInfo{Name: "admin"}, AccessToken, time.Hour)
+	assert.Nil(t, err)
+
+	// the old key is retired, only the public key is kept
+	retired, err := ParseSigningKey("old", publicKeyPEM(t, oldKey))
+	assert.Nil(t, err)
+	keys, err = NewKeySet("new", newKey, retired)
+	assert.Nil(t, err)
+	issuer := NewTokenIssuer("secret", keys, 0)
+	newToken, err := issuer.IssueTo(&user.DefaultInfo{Name: "admin"}, AccessToken, time.Hour)
+	assert.Nil(t, err)
+
+	for _, tokenString := range []string{hmacToken, oldToken, newToken} {
+		_, _, err = issuer.Verify(tokenString)
+		assert.Nil(t, err)
+	}
+
+	// HMAC tokens are rejected once the secret is removed
+	_, _, err = NewTokenIssuer("", keys, 0).Verify(hmacToken)
+	assert.NotNil(t, err)
+
+	// tokens signed by unknown keys are rejected
+	keys, err = NewKeySet("new", newKey)
+	assert.Nil(t, err)
+	_, _, err = NewTokenIssuer("", keys, 0).Verify(oldToken)
+	assert.NotNil(t, err)
+}
+
+func TestJWKS(t *testing.T) {
+	assert.Len(t, (*KeySet)(nil).JWKS().Keys, 0)
+
+	rsaKey, err := ParseSigningKey("b", rsaKeyPEM(t))
+	assert.Nil(t, err)
+	ecKey, err := ParseSigningKey("a", ecKeyPEM(t))
+	assert.Nil(t, err)
+	keys, err := NewKeySet("b", rsaKey, ecKey)
+	assert.Nil(t, err)
+
+	jwks := keys.JWKS()
+	assert.Len(t, jwks.Keys, 2)
+	assert.Equal(t, JSONWebKey{KeyType: "EC", KeyID: "a", Use: "sig", Algorithm: "ES256", Curve: "P-256",
+		X: jwks.Keys[0].X, Y: jwks.Keys[0].Y}, jwks.Keys[0])
+	assert.Len(t, jwks.Keys[0].X, 43)
+	assert.Equal(t, "RSA", jwks.Keys[1].KeyType)
+	assert.Equal(t, "RS256", jwks.Keys[1].Algorithm)
+	assert.Equal(t, "AQAB", jwks.Keys[1].E)
+	assert.NotEmpty(t, jwks.Keys[1].N)
+}
+
+func TestLoadKeySet(t *testing.T) {
+	keySet, err := LoadKeySet(nil, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, keySet)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.pem")
+	assert.Nil(t, os.WriteFile(file, rsaKeyPEM(t), 0600))
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ai-system", Name: "signing-keys"},
+		Data:       map[string][]byte{"secret": ecKeyPEM(t)},
+	})
+
+	keySet, err = LoadKeySet(&SigningKeyOptions{
+		ActiveKeyID:     "secret",
+		KeyFiles:        map[string]string{"file": file},
+		SecretNamespace: "ai-system",
+		SecretName:      "signing-keys",
+	}, client.CoreV1())
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", keySet.Active().ID)
+	_, ok := keySet.Get("file")
+	assert.True(t, ok)
+
+	_, err = LoadKeySet(&SigningKeyOptions{ActiveKeyID: "file", KeyFiles: map[string]string{"file": filepath.Join(dir, "none")}}, nil)
+	assert.NotNil(t, err)
+	_, err = LoadKeySet(&SigningKeyOptions{ActiveKeyID: "secret", SecretNamespace: "ai-system", SecretName: "none"}, client.CoreV1())
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,57 @@
+package token
+
+import (
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+	"k8s.io/klog"
+)
+
+// IDTokenClaims are the claims of an OpenID Connect ID token,
+// see https://openid.net/specs/openid-connect-core-1_0.html#IDToken
+type IDTokenClaims struct {
+	Nonce             string   `json:"nonce,omitempty"`
+	AuthTime          int64    `json:"auth_time,omitempty"`
+	Name              string   `json:"name,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	Locale            string   `json:"locale,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+	jwt.StandardClaims
+}
+
+// SignIDToken signs the ID token with the active key of keys, so relying parties can verify it with the JWKS.
+// The client secret is only used with HS256 if no asymmetric key is configured, which is allowed by OpenID Connect
+// for the confidential clients, see CanSignIDToken.
+func SignIDToken(claims *IDTokenClaims, keys *KeySet, clientSecret string) (string, error) {
+	if !CanSignIDToken(keys, clientSecret) {
+		return "", fmt.Errorf("unable to sign ID token for client %s without signing keys or secret", claims.Audience)
+	}
+	var tokenString string
+	var err error
+	if active := keys.Active(); active != nil {
+		token := jwt.NewWithClaims(active.Method, claims)
+		token.Header["kid"] = active.ID
+		tokenString, err = token.SignedString(active.Private)
+	} else {
+		tokenString, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(clientSecret))
+	}
+	if err != nil {
+		klog.Error(err)
+		return "", err
+	}
+	return tokenString, nil
+}
+
+// CanSignIDToken returns true if an asymmetric key is configured, or the client has a secret
+func CanSignIDToken(keys *KeySet, clientSecret string) bool {
+	return keys.Active() != nil || clientSecret != ""
+}
+
+// IDTokenSigningAlg returns the algorithm used by SignIDToken
+func IDTokenSigningAlg(keys *KeySet) string {
+	if active := keys.Active(); active != nil {
+		return active.Method.Alg()
+	}
+	return jwt.SigningMethodHS256.Alg()
+}
@@ -77,6 +77,12 @@ type RuleResolver interface {
 	RulesFor(user user.Info, namespace string) ([]ResourceRuleInfo, []NonResourceRuleInfo, bool, error)
 }
 
+// RegoPolicyResolver provides a mechanism for resolving the rego policies that apply to a given user within a namespace,
+// the policies are evaluated besides the rules returned by the RuleResolver.
+type RegoPolicyResolver interface {
+	RegoPoliciesFor(user user.Info, namespace string) ([]string, error)
+}
+
 // RequestAttributesGetter provides a function that extracts Attributes from an http.Request
 type RequestAttributesGetter interface {
 	GetRequestAttributes(user.Info, *http.Request) Attributes
@@ -2,16 +2,16 @@ package rbac
 
 import (
 	"bytes"
-	"context"
 	"fmt"
+	"strings"
 
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authorization/authorizer"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/request"
 	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	"github.com/wongearl/go-restful-template/pkg/client/informers"
 	"github.com/wongearl/go-restful-template/pkg/middles/iam/am"
 	"github.com/wongearl/go-restful-template/pkg/utils/sliceutil"
 
-	"github.com/open-policy-agent/opa/rego"
 	rbacv1 "k8s.io/api/rbac/v1"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apiserver/pkg/authentication/serviceaccount"
@@ -25,23 +25,33 @@ const (
 )
 
 type RBACAuthorizer struct {
-	am am.AccessManagementInterface
+	am       am.AccessManagementInterface
+	policies *regoPolicyCache
 }
 
 // authorizingVisitor short-circuits once allowed, and collects any resolution errors encountered
 type authorizingVisitor struct {
 	requestAttributes authorizer.Attributes
+	policies          *regoPolicyCache
 
 	allowed bool
 	reason  string
 	errors  []error
+	// policyErrors are the errors of the broken rego policies, which are returned as the authorizer error
+	policyErrors []error
 }
 
 func (v *authorizingVisitor) visit(source fmt.Stringer, regoPolicy string, rule *rbacv1.PolicyRule, err error) bool {
-	if regoPolicy != "" && regoPolicyAllows(v.requestAttributes, regoPolicy) {
-		v.allowed = true
-		v.reason = fmt.Sprintf("RBAC: allowed by %s", source.String())
-		return false
+	if regoPolicy != "" {
+		allowed, err := v.policies.allows(v.requestAttributes, regoPolicy)
+		if err != nil {
+			v.policyErrors = append(v.policyErrors, fmt.Errorf("%s: %v", source.String(), err))
+		}
+		if allowed {
+			v.allowed = true
+			v.reason = fmt.Sprintf("RBAC: allowed by %s", source.String())
+			return false
+		}
 	}
 	if rule != nil && ruleAllows(v.requestAttributes, rule) {
 		v.allowed = true
@@ -55,11 +65,15 @@ func (v *authorizingVisitor) visit(source fmt.Stringer, regoPolicy string, rule
 }
 
 type ruleAccumulator struct {
-	rules  []rbacv1.PolicyRule
-	errors []error
+	rules        []rbacv1.PolicyRule
+	regoPolicies []string
+	errors       []error
 }
 
-func (r *ruleAccumulator) visit(_ fmt.Stringer, _ string, rule *rbacv1.PolicyRule, err error) bool {
+func (r *ruleAccumulator) visit(_ fmt.Stringer, regoPolicy string, rule *rbacv1.PolicyRule, err error) bool {
+	if regoPolicy != "" && !sliceutil.HasString(r.regoPolicies, regoPolicy) {
+		r.regoPolicies = append(r.regoPolicies, regoPolicy)
+	}
 	if rule != nil {
 		r.rules = append(r.rules, *rule)
 	}
@@ -70,7 +84,12 @@ func (r *ruleAccumulator) visit(_ fmt.Stringer, _ string, rule *rbacv1.PolicyRul
 }
 
 func (r *RBACAuthorizer) Authorize(requestAttributes authorizer.Attributes) (authorizer.Decision, string, error) {
-	ruleCheckingVisitor := &authorizingVisitor{requestAttributes: requestAttributes}
+	// the access tokens are restricted to their scopes besides the rules of the owner
+	if !scopesAllow(requestAttributes) {
+		return authorizer.DecisionNoOpinion, "RBAC: not allowed by the scopes of the access token", nil
+	}
+
+	ruleCheckingVisitor := &authorizingVisitor{requestAttributes: requestAttributes, policies: r.policies}
 
 	r.visitRulesFor(requestAttributes, ruleCheckingVisitor.visit)
 
@@ -112,22 +131,29 @@ func (r *RBACAuthorizer) Authorize(requestAttributes authorizer.Attributes) (aut
 			scope = fmt.Sprintf("in namespace %q", ns)
 		} else if ws := requestAttributes.GetWorkspace(); len(ws) > 0 {
 			scope = fmt.Sprintf("in workspace %q", ws)
+		} else if requestAttributes.GetResourceScope() == request.ClusterScope {
+			scope = "cluster-wide"
 		} else {
 			scope = "global-wide"
 		}
 
-		klog.Infof("RBAC: no rules authorize user %q with groups %q to %s %s", requestAttributes.GetUser().GetName(), requestAttributes.GetUser().GetGroups(), operation, scope)
+		klog.Infof("RBAC: no rules authorize user %q with groups %q to %s %s, checked %s", requestAttributes.GetUser().GetName(),
+			requestAttributes.GetUser().GetGroups(), operation, scope, strings.Join(bindingKindsFor(requestAttributes.GetResourceScope()), ", "))
 	}
 
 	reason := ""
 	if len(ruleCheckingVisitor.errors) > 0 {
 		reason = fmt.Sprintf("RBAC: %v", utilerrors.NewAggregate(ruleCheckingVisitor.errors))
 	}
-	return authorizer.DecisionNoOpinion, reason, nil
+	return authorizer.DecisionNoOpinion, reason, utilerrors.NewAggregate(ruleCheckingVisitor.policyErrors)
 }
 
-func NewRBACAuthorizer(am am.AccessManagementInterface) *RBACAuthorizer {
-	return &RBACAuthorizer{am: am}
+// NewRBACAuthorizer returns the RBAC authorizer, the rego policies are compiled once
+// and recompiled when the roles are changed through the informers of factory.
+func NewRBACAuthorizer(am am.AccessManagementInterface, factory informers.InformerFactory) *RBACAuthorizer {
+	policies := newRegoPolicyCache()
+	policies.watch(factory)
+	return &RBACAuthorizer{am: am, policies: policies}
 }
 
 func ruleAllows(requestAttributes authorizer.Attributes, rule *rbacv1.PolicyRule) bool {
@@ -147,35 +173,51 @@ func ruleAllows(requestAttributes authorizer.Attributes, rule *rbacv1.PolicyRule
 		NonResourceURLMatches(rule, requestAttributes.GetPath())
 }
 
-func regoPolicyAllows(requestAttributes authorizer.Attributes, regoPolicy string) bool {
-	// Call the rego.New function to create an object that can be prepared or evaluated
-	//  After constructing a new rego.Rego object you can call PrepareForEval() to obtain an executable query
-	query, err := rego.New(rego.Query(defaultRegoQuery), rego.Module(defaultRegoFileName, regoPolicy)).PrepareForEval(context.Background())
-
-	if err != nil {
-		klog.Warningf("syntax error:%s, content: %s", err, regoPolicy)
-		return false
-	}
+// RulesFor returns the rules granted to the user in the namespace, or the global and cluster wide rules
+// if the namespace is empty. The rules are incomplete if any of the roles carries a rego policy,
+// which may allow more requests than the rules, see RegoPoliciesFor.
+func (r *RBACAuthorizer) RulesFor(user user.Info, namespace string) ([]authorizer.ResourceRuleInfo, []authorizer.NonResourceRuleInfo, bool, error) {
+	visitor := r.accumulateRulesFor(user, namespace)
 
-	// The policy decision is contained in the results returned by the Eval() call. You can inspect the decision and handle it accordingly.
-	results, err := query.Eval(context.Background(), rego.EvalInput(requestAttributes))
-
-	if err != nil {
-		klog.Warningf("syntax error:%s, content: %s", err, regoPolicy)
-		return false
-	}
-
-	if len(results) > 0 && results[0].Expressions[0].Value == true {
-		return true
+	var (
+		resourceRules    []authorizer.ResourceRuleInfo
+		nonResourceRules []authorizer.NonResourceRuleInfo
+	)
+	for _, rule := range visitor.rules {
+		if len(rule.Resources) > 0 {
+			resourceRules = append(resourceRules, &authorizer.DefaultResourceRuleInfo{
+				Verbs:         rule.Verbs,
+				APIGroups:     rule.APIGroups,
+				Resources:     rule.Resources,
+				ResourceNames: rule.ResourceNames,
+			})
+		}
+		if len(rule.NonResourceURLs) > 0 {
+			nonResourceRules = append(nonResourceRules, &authorizer.DefaultNonResourceRuleInfo{
+				Verbs:           rule.Verbs,
+				NonResourceURLs: rule.NonResourceURLs,
+			})
+		}
 	}
+	incomplete := len(visitor.errors) > 0 || len(visitor.regoPolicies) > 0
+	return resourceRules, nonResourceRules, incomplete, utilerrors.NewAggregate(visitor.errors)
+}
 
-	return false
+// RegoPoliciesFor returns the rego policies of the roles granted to the user in the namespace,
+// or of the global and cluster wide roles if the namespace is empty.
+func (r *RBACAuthorizer) RegoPoliciesFor(user user.Info, namespace string) ([]string, error) {
+	visitor := r.accumulateRulesFor(user, namespace)
+	return visitor.regoPolicies, utilerrors.NewAggregate(visitor.errors)
 }
 
-func (r *RBACAuthorizer) rulesFor(requestAttributes authorizer.Attributes) ([]rbacv1.PolicyRule, error) {
+func (r *RBACAuthorizer) accumulateRulesFor(user user.Info, namespace string) *ruleAccumulator {
+	requestAttributes := authorizer.AttributesRecord{User: user, Namespace: namespace, ResourceScope: request.ClusterScope}
+	if namespace != "" {
+		requestAttributes.ResourceScope = request.NamespaceScope
+	}
 	visitor := &ruleAccumulator{}
 	r.visitRulesFor(requestAttributes, visitor.visit)
-	return visitor.rules, utilerrors.NewAggregate(visitor.errors)
+	return visitor
 }
 
 func (r *RBACAuthorizer) visitRulesFor(requestAttributes authorizer.Attributes, visitor func(source fmt.Stringer, regoPolicy string, rule *rbacv1.PolicyRule, err error) bool) {
@@ -213,6 +255,38 @@ func (r *RBACAuthorizer) visitRulesFor(requestAttributes authorizer.Attributes,
 		}
 	}
 
+	// the ClusterRoleBindings apply to the cluster-scoped resources and the resources in all namespaces
+	if scope := requestAttributes.GetResourceScope(); scope == request.ClusterScope || scope == request.NamespaceScope {
+		if clusterRoleBindings, err := r.am.ListClusterRoleBindings(requestAttributes.GetUser().GetName()); err != nil {
+			if !visitor(nil, "", nil, err) {
+				return
+			}
+		} else {
+			sourceDescriber := &clusterRoleBindingDescriber{}
+			for _, clusterRoleBinding := range clusterRoleBindings {
+				subjectIndex, applies := appliesTo(requestAttributes.GetUser(), clusterRoleBinding.Subjects, "")
+				if !applies {
+					continue
+				}
+				regoPolicy, rules, err := r.am.GetRoleReferenceRules(clusterRoleBinding.RoleRef, "")
+				if err != nil {
+					visitor(nil, "", nil, err)
+					continue
+				}
+				sourceDescriber.binding = clusterRoleBinding
+				sourceDescriber.subject = &clusterRoleBinding.Subjects[subjectIndex]
+				if !visitor(sourceDescriber, regoPolicy, nil, nil) {
+					return
+				}
+				for i := range rules {
+					if !visitor(sourceDescriber, "", &rules[i], nil) {
+						return
+					}
+				}
+			}
+		}
+	}
+
 	if requestAttributes.GetResourceScope() == request.NamespaceScope {
 
 		namespace := requestAttributes.GetNamespace()
@@ -248,6 +322,18 @@ func (r *RBACAuthorizer) visitRulesFor(requestAttributes authorizer.Attributes,
 	}
 }
 
+// bindingKindsFor returns the kinds of the bindings visited for the requests of the scope
+func bindingKindsFor(scope string) []string {
+	switch scope {
+	case request.ClusterScope:
+		return []string{"GlobalRoleBindings", "ClusterRoleBindings"}
+	case request.NamespaceScope:
+		return []string{"GlobalRoleBindings", "ClusterRoleBindings", "RoleBindings"}
+	default:
+		return []string{"GlobalRoleBindings"}
+	}
+}
+
 // appliesTo returns whether any of the bindingSubjects applies to the specified subject,
 // and if true, the index of the first subject that applies
 func appliesTo(user user.Info, bindingSubjects []rbacv1.Subject, namespace string) (int, bool) {
@@ -0,0 +1,123 @@
+package rbac
+
+import (
+	"testing"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authorization/authorizer"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/request"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	aifake "github.com/wongearl/go-restful-template/pkg/client/ai/clientset/versioned/fake"
+	"github.com/wongearl/go-restful-template/pkg/client/informers"
+	"github.com/wongearl/go-restful-template/pkg/middles/iam/am"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestRBACAuthorizer(t *testing.T, aiClient *aifake.Clientset, k8sClient *fake.Clientset) *RBACAuthorizer {
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	factory := informers.NewInformerFactories(k8sClient, nil, aiClient)
+	// the informers of the bindings must be requested before the factory is started
+	factory.AiSharedInformerFactory().Iam().V1().GlobalRoleBindings().Informer()
+	factory.KubernetesSharedInformerFactory().Rbac().V1().ClusterRoleBindings().Informer()
+	factory.KubernetesSharedInformerFactory().Rbac().V1().RoleBindings().Informer()
+	rbacAuthorizer := NewRBACAuthorizer(am.NewReadOnlyOperator(factory), factory)
+	factory.Start(stopCh)
+	factory.AiSharedInformerFactory().WaitForCacheSync(stopCh)
+	factory.KubernetesSharedInformerFactory().WaitForCacheSync(stopCh)
+	return rbacAuthorizer
+}
+
+func newGlobalRoleBinding(username, globalRole string) *iamv1.GlobalRoleBinding {
+	return &iamv1.GlobalRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: username + "-" + globalRole},
+		Subjects: []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: username}},
+		RoleRef:  rbacv1.RoleRef{APIGroup: iamv1.SchemeGroupVersion.Group, Kind: iamv1.ResourceKindGlobalRole, Name: globalRole}}
+}
+
+func TestRBACAuthorizerClusterRoleBindings(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset(
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "view"},
+			Rules: []rbacv1.PolicyRule{{Verbs: []string{"get", "list"}, APIGroups: []string{"*"}, Resources: []string{"*"}}}},
+		&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "alice-view"},
+			Subjects: []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "alice"}},
+			RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: iamv1.ResourceKindClusterRole, Name: "view"}})
+	rbacAuthorizer := newTestRBACAuthorizer(t, aifake.NewSimpleClientset(), k8sClient)
+
+	alice := &user.DefaultInfo{Name: "alice"}
+	tests := []struct {
+		name       string
+		attributes authorizer.AttributesRecord
+		allowed    bool
+	}{
+		{"cluster-scoped", authorizer.AttributesRecord{User: alice, Verb: "list", Resource: "nodes",
+			ResourceRequest: true, ResourceScope: request.ClusterScope}, true},
+		{"namespaced", authorizer.AttributesRecord{User: alice, Verb: "get", Resource: "pods", Namespace: "default",
+			ResourceRequest: true, ResourceScope: request.NamespaceScope}, true},
+		{"verb not allowed", authorizer.AttributesRecord{User: alice, Verb: "delete", Resource: "nodes",
+			ResourceRequest: true, ResourceScope: request.ClusterScope}, false},
+		// the global resources are only granted by the GlobalRoleBindings
+		{"global", authorizer.AttributesRecord{User: alice, Verb: "list", APIGroup: "iam.ai.io", Resource: "users",
+			ResourceRequest: true, ResourceScope: request.GlobalScope}, false},
+		{"other user", authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "bob"}, Verb: "list", Resource: "nodes",
+			ResourceRequest: true, ResourceScope: request.ClusterScope}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, reason, err := rbacAuthorizer.Authorize(tt.attributes)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.allowed, decision == authorizer.DecisionAllow, reason)
+			if tt.allowed {
+				assert.Equal(t, `RBAC: allowed by ClusterRoleBinding "alice-view" of ClusterRole "view" to User "alice"`, reason)
+			}
+		})
+	}
+}
+
+func TestRBACAuthorizerRulesFor(t *testing.T) {
+	regoPolicy := `package authz
+default allow = false
+allow {
+  input.Verb == "watch"
+}`
+	k8sClient := fake.NewSimpleClientset(
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "view", Annotations: map[string]string{iamv1.RegoOverrideAnnotation: regoPolicy}},
+			Rules: []rbacv1.PolicyRule{{Verbs: []string{"get", "list"}, APIGroups: []string{"*"}, Resources: []string{"*"}},
+				{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz"}}}},
+		&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "alice-view"},
+			Subjects: []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "alice"}},
+			RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: iamv1.ResourceKindClusterRole, Name: "view"}},
+		&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "edit"},
+			Rules: []rbacv1.PolicyRule{{Verbs: []string{"create"}, APIGroups: []string{""}, Resources: []string{"pods"}}}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "alice-edit"},
+			Subjects: []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "alice"}},
+			RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: iamv1.ResourceKindRole, Name: "edit"}})
+	rbacAuthorizer := newTestRBACAuthorizer(t, aifake.NewSimpleClientset(), k8sClient)
+	alice := &user.DefaultInfo{Name: "alice"}
+
+	resourceRules, nonResourceRules, incomplete, err := rbacAuthorizer.RulesFor(alice, "")
+	assert.Nil(t, err)
+	assert.True(t, incomplete, "the rego policy may allow more than the rules")
+	assert.Len(t, resourceRules, 1)
+	assert.Equal(t, []string{"get", "list"}, resourceRules[0].GetVerbs())
+	assert.Len(t, nonResourceRules, 1)
+	assert.Equal(t, []string{"/healthz"}, nonResourceRules[0].GetNonResourceURLs())
+
+	resourceRules, _, _, err = rbacAuthorizer.RulesFor(alice, "default")
+	assert.Nil(t, err)
+	assert.Len(t, resourceRules, 2)
+	assert.Equal(t, []string{"create"}, resourceRules[1].GetVerbs())
+
+	regoPolicies, err := rbacAuthorizer.RegoPoliciesFor(alice, "default")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{regoPolicy}, regoPolicies)
+
+	resourceRules, nonResourceRules, incomplete, err = rbacAuthorizer.RulesFor(&user.DefaultInfo{Name: "bob"}, "default")
+	assert.Nil(t, err)
+	assert.False(t, incomplete)
+	assert.Empty(t, resourceRules)
+	assert.Empty(t, nonResourceRules)
+}
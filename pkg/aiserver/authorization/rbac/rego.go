@@ -0,0 +1,105 @@
+package rbac
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authorization/authorizer"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	"github.com/wongearl/go-restful-template/pkg/client/informers"
+
+	"github.com/open-policy-agent/opa/rego"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// preparedPolicy is the compiled rego policy, the compile error is kept as well
+// so the broken policies are not compiled again for every request
+type preparedPolicy struct {
+	query rego.PreparedEvalQuery
+	err   error
+}
+
+// regoPolicyCache keeps the prepared queries of the rego policies by the hash of the policy content,
+// the entries are dropped when the roles carrying the policies are changed or deleted.
+type regoPolicyCache struct {
+	sync.RWMutex
+	policies map[[sha256.Size]byte]*preparedPolicy
+}
+
+func newRegoPolicyCache() *regoPolicyCache {
+	return &regoPolicyCache{policies: make(map[[sha256.Size]byte]*preparedPolicy)}
+}
+
+// allows evaluates the policy with the request attributes as input
+func (c *regoPolicyCache) allows(requestAttributes authorizer.Attributes, regoPolicy string) (bool, error) {
+	prepared := c.prepare(regoPolicy)
+	if prepared.err != nil {
+		return false, prepared.err
+	}
+
+	// The policy decision is contained in the results returned by the Eval() call
+	results, err := prepared.query.Eval(context.Background(), rego.EvalInput(requestAttributes))
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate rego policy: %v", err)
+	}
+	return len(results) > 0 && results[0].Expressions[0].Value == true, nil
+}
+
+func (c *regoPolicyCache) prepare(regoPolicy string) *preparedPolicy {
+	key := sha256.Sum256([]byte(regoPolicy))
+	c.RLock()
+	prepared, ok := c.policies[key]
+	c.RUnlock()
+	if ok {
+		return prepared
+	}
+
+	prepared = &preparedPolicy{}
+	prepared.query, prepared.err = rego.New(rego.Query(defaultRegoQuery), rego.Module(defaultRegoFileName, regoPolicy)).PrepareForEval(context.Background())
+	if prepared.err != nil {
+		prepared.err = fmt.Errorf("failed to compile rego policy: %v", prepared.err)
+	}
+	c.Lock()
+	c.policies[key] = prepared
+	c.Unlock()
+	return prepared
+}
+
+func (c *regoPolicyCache) forget(regoPolicy string) {
+	if regoPolicy == "" {
+		return
+	}
+	c.Lock()
+	delete(c.policies, sha256.Sum256([]byte(regoPolicy)))
+	c.Unlock()
+}
+
+// watch drops the policies of the GlobalRoles, ClusterRoles and Roles once they are changed
+func (c *regoPolicyCache) watch(factory informers.InformerFactory) {
+	handler := cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if oldPolicy := regoPolicyOf(oldObj); oldPolicy != regoPolicyOf(newObj) {
+				c.forget(oldPolicy)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			c.forget(regoPolicyOf(obj))
+		},
+	}
+	factory.AiSharedInformerFactory().Iam().V1().GlobalRoles().Informer().AddEventHandler(handler)
+	factory.KubernetesSharedInformerFactory().Rbac().V1().ClusterRoles().Informer().AddEventHandler(handler)
+	factory.KubernetesSharedInformerFactory().Rbac().V1().Roles().Informer().AddEventHandler(handler)
+}
+
+func regoPolicyOf(obj interface{}) string {
+	if object, ok := obj.(metav1.Object); ok {
+		return object.GetAnnotations()[iamv1.RegoOverrideAnnotation]
+	}
+	return ""
+}
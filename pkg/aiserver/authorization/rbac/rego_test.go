@@ -0,0 +1,126 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authorization/authorizer"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/request"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	aifake "github.com/wongearl/go-restful-template/pkg/client/ai/clientset/versioned/fake"
+
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testRegoPolicy = `package authz
+default allow = false
+allow {
+  input.Verb == "get"
+}`
+
+var testRegoAttributes = authorizer.AttributesRecord{
+	User:            &user.DefaultInfo{Name: "alice"},
+	Verb:            "get",
+	Resource:        "users",
+	ResourceRequest: true,
+	ResourceScope:   request.GlobalScope,
+}
+
+func TestRegoPolicyCache(t *testing.T) {
+	policies := newRegoPolicyCache()
+
+	allowed, err := policies.allows(testRegoAttributes, testRegoPolicy)
+	assert.Nil(t, err)
+	assert.True(t, allowed)
+	denied := testRegoAttributes
+	denied.Verb = "delete"
+	allowed, err = policies.allows(denied, testRegoPolicy)
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+	// the policy is compiled once
+	assert.Same(t, policies.prepare(testRegoPolicy), policies.prepare(testRegoPolicy))
+	assert.Len(t, policies.policies, 1)
+
+	_, err = policies.allows(testRegoAttributes, "package authz\nallow {")
+	assert.NotNil(t, err)
+	assert.Len(t, policies.policies, 2)
+
+	policies.forget(testRegoPolicy)
+	assert.Len(t, policies.policies, 1)
+}
+
+func TestRBACAuthorizerRegoPolicy(t *testing.T) {
+	aiClient := aifake.NewSimpleClientset(
+		&iamv1.GlobalRole{ObjectMeta: metav1.ObjectMeta{Name: "rego",
+			Annotations: map[string]string{iamv1.RegoOverrideAnnotation: testRegoPolicy}}},
+		newGlobalRoleBinding("alice", "rego"))
+	rbacAuthorizer := newTestRBACAuthorizer(t, aiClient, fake.NewSimpleClientset())
+
+	decision, _, err := rbacAuthorizer.Authorize(testRegoAttributes)
+	assert.Nil(t, err)
+	assert.Equal(t, authorizer.DecisionAllow, decision)
+	assert.Len(t, rbacAuthorizer.policies.policies, 1)
+
+	// the changed policy is compiled again, and the compile error is returned
+	globalRole, err := aiClient.IamV1().GlobalRoles().Get(context.Background(), "rego", metav1.GetOptions{})
+	assert.Nil(t, err)
+	globalRole.Annotations[iamv1.RegoOverrideAnnotation] = "package authz\nallow {"
+	_, err = aiClient.IamV1().GlobalRoles().Update(context.Background(), globalRole, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		decision, _, err = rbacAuthorizer.Authorize(testRegoAttributes)
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, authorizer.DecisionNoOpinion, decision)
+	assert.Contains(t, err.Error(), "failed to compile rego policy")
+	// the outdated policy is dropped
+	assert.Len(t, rbacAuthorizer.policies.policies, 1)
+}
+
+// regoPolicyAllowsUncached compiles the policy for every request, which is how the policies were evaluated before
+func regoPolicyAllowsUncached(requestAttributes authorizer.Attributes, regoPolicy string) bool {
+	query, err := rego.New(rego.Query(defaultRegoQuery), rego.Module(defaultRegoFileName, regoPolicy)).PrepareForEval(context.Background())
+	if err != nil {
+		return false
+	}
+	results, err := query.Eval(context.Background(), rego.EvalInput(requestAttributes))
+	if err != nil {
+		return false
+	}
+	return len(results) > 0 && results[0].Expressions[0].Value == true
+}
+
+func BenchmarkRegoPolicyUncached(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if !regoPolicyAllowsUncached(testRegoAttributes, testRegoPolicy) {
+			b.Fatal("the request should be allowed")
+		}
+	}
+}
+
+func BenchmarkRegoPolicyCached(b *testing.B) {
+	policies := newRegoPolicyCache()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if allowed, err := policies.allows(testRegoAttributes, testRegoPolicy); err != nil || !allowed {
+			b.Fatal("the request should be allowed")
+		}
+	}
+}
+
+func BenchmarkRegoPolicyCachedParallel(b *testing.B) {
+	policies := newRegoPolicyCache()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if allowed, err := policies.allows(testRegoAttributes, testRegoPolicy); err != nil || !allowed {
+				b.Fatal("the request should be allowed")
+			}
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package rbac
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authorization/authorizer"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/request"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+// EncodeScopes encodes the scopes of the access token into the extra of the authenticated user
+func EncodeScopes(scopes []rbacv1.PolicyRule) []string {
+	encoded := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		data, _ := json.Marshal(scope)
+		encoded = append(encoded, string(data))
+	}
+	return encoded
+}
+
+// scopesAllow returns true if the user is not restricted by scopes or any of the scopes allows the request.
+// The request is denied if the scopes are malformed.
+func scopesAllow(requestAttributes authorizer.Attributes) bool {
+	if requestAttributes.GetUser() == nil {
+		return true
+	}
+	encoded, restricted := requestAttributes.GetUser().GetExtra()[iamv1.AccessTokenScopesExtraKey]
+	if !restricted {
+		return true
+	}
+	for _, data := range encoded {
+		var scope rbacv1.PolicyRule
+		if err := json.Unmarshal([]byte(data), &scope); err != nil {
+			return false
+		}
+		if ruleAllows(requestAttributes, &scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopesCovered checks every permission granted by the scopes is granted to the user globally,
+// so that the access token never has more permissions than its owner.
+func ScopesCovered(a authorizer.Authorizer, user user.Info, scopes []rbacv1.PolicyRule) error {
+	for _, scope := range scopes {
+		for _, attributes := range breakdownRule(user, scope) {
+			decision, _, err := a.Authorize(attributes)
+			if err != nil {
+				return err
+			}
+			if decision != authorizer.DecisionAllow {
+				return fmt.Errorf("scope %s exceeds the permissions of user %s", CompactString(scope), user.GetName())
+			}
+		}
+	}
+	return nil
+}
+
+// breakdownRule expands the rule into the attributes of every request it allows,
+// wildcards are checked as is so that they are only covered by wildcards.
+func breakdownRule(user user.Info, rule rbacv1.PolicyRule) []authorizer.AttributesRecord {
+	var records []authorizer.AttributesRecord
+	for _, verb := range rule.Verbs {
+		for _, url := range rule.NonResourceURLs {
+			records = append(records, authorizer.AttributesRecord{User: user, Verb: verb, Path: url})
+		}
+		// the cluster scope covers the rules of both GlobalRoleBindings and ClusterRoleBindings
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				resource, subresource, _ := strings.Cut(resource, "/")
+				names := rule.ResourceNames
+				if len(names) == 0 {
+					names = []string{""}
+				}
+				for _, name := range names {
+					records = append(records, authorizer.AttributesRecord{
+						User:            user,
+						Verb:            verb,
+						APIGroup:        group,
+						Resource:        resource,
+						Subresource:     subresource,
+						Name:            name,
+						ResourceRequest: true,
+						ResourceScope:   request.ClusterScope,
+					})
+				}
+			}
+		}
+	}
+	return records
+}
@@ -0,0 +1,84 @@
+package rbac
+
+import (
+	"testing"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authorization/authorizer"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+// rulesAuthorizer allows the requests matching any of the rules
+type rulesAuthorizer []rbacv1.PolicyRule
+
+func (r rulesAuthorizer) Authorize(a authorizer.Attributes) (authorizer.Decision, string, error) {
+	for i := range r {
+		if ruleAllows(a, &r[i]) {
+			return authorizer.DecisionAllow, "", nil
+		}
+	}
+	return authorizer.DecisionNoOpinion, "", nil
+}
+
+func TestScopesAllow(t *testing.T) {
+	scopes := EncodeScopes([]rbacv1.PolicyRule{
+		{Verbs: []string{"get", "list"}, APIGroups: []string{"iam.ai.io"}, Resources: []string{"users"}},
+		{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz"}},
+	})
+	scoped := &user.DefaultInfo{Name: "alice", Extra: map[string][]string{iamv1.AccessTokenScopesExtraKey: scopes}}
+
+	tests := []struct {
+		name       string
+		attributes authorizer.AttributesRecord
+		allowed    bool
+	}{
+		{"allowed resource", authorizer.AttributesRecord{User: scoped, Verb: "list", APIGroup: "iam.ai.io", Resource: "users", ResourceRequest: true}, true},
+		{"allowed in namespace", authorizer.AttributesRecord{User: scoped, Verb: "get", APIGroup: "iam.ai.io", Resource: "users", Namespace: "default", ResourceRequest: true}, true},
+		{"verb out of scopes", authorizer.AttributesRecord{User: scoped, Verb: "delete", APIGroup: "iam.ai.io", Resource: "users", ResourceRequest: true}, false},
+		{"subresource out of scopes", authorizer.AttributesRecord{User: scoped, Verb: "get", APIGroup: "iam.ai.io", Resource: "users", Subresource: "password", ResourceRequest: true}, false},
+		{"allowed url", authorizer.AttributesRecord{User: scoped, Verb: "get", Path: "/healthz"}, true},
+		{"url out of scopes", authorizer.AttributesRecord{User: scoped, Verb: "get", Path: "/metrics"}, false},
+		{"not scoped", authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "alice"}, Verb: "delete", Resource: "users", ResourceRequest: true}, true},
+		{"malformed scopes", authorizer.AttributesRecord{User: &user.DefaultInfo{Name: "alice",
+			Extra: map[string][]string{iamv1.AccessTokenScopesExtraKey: {"{"}}}, Verb: "get", Path: "/healthz"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, scopesAllow(tt.attributes))
+		})
+	}
+}
+
+func TestScopesCovered(t *testing.T) {
+	owner := rulesAuthorizer{
+		{Verbs: []string{"get", "list"}, APIGroups: []string{"*"}, Resources: []string{"users", "users/loginrecords"}},
+		{Verbs: []string{"*"}, APIGroups: []string{"iam.ai.io"}, Resources: []string{"globalroles"}, ResourceNames: []string{"regular"}},
+		{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz/*"}},
+	}
+	alice := &user.DefaultInfo{Name: "alice"}
+
+	tests := []struct {
+		name    string
+		scope   rbacv1.PolicyRule
+		covered bool
+	}{
+		{"subset", rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{"iam.ai.io"}, Resources: []string{"users"}}, true},
+		{"subresource", rbacv1.PolicyRule{Verbs: []string{"list"}, APIGroups: []string{""}, Resources: []string{"users/loginrecords"}}, true},
+		{"resource names", rbacv1.PolicyRule{Verbs: []string{"delete"}, APIGroups: []string{"iam.ai.io"}, Resources: []string{"globalroles"}, ResourceNames: []string{"regular"}}, true},
+		{"url", rbacv1.PolicyRule{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz/ready"}}, true},
+		{"extra verb", rbacv1.PolicyRule{Verbs: []string{"get", "delete"}, APIGroups: []string{"iam.ai.io"}, Resources: []string{"users"}}, false},
+		{"wildcard verb", rbacv1.PolicyRule{Verbs: []string{"*"}, APIGroups: []string{"iam.ai.io"}, Resources: []string{"users"}}, false},
+		{"wildcard resource", rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{"iam.ai.io"}, Resources: []string{"*"}}, false},
+		{"all names", rbacv1.PolicyRule{Verbs: []string{"delete"}, APIGroups: []string{"iam.ai.io"}, Resources: []string{"globalroles"}}, false},
+		{"url out of rules", rbacv1.PolicyRule{Verbs: []string{"get"}, NonResourceURLs: []string{"/metrics"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ScopesCovered(owner, alice, []rbacv1.PolicyRule{tt.scope})
+			assert.Equal(t, tt.covered, err == nil, err)
+		})
+	}
+}
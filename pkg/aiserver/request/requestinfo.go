@@ -240,7 +240,9 @@ type RequestInfoFactory struct {
 }
 
 const (
-	GlobalScope             = "Global"
+	GlobalScope = "Global"
+	// ClusterScope is the scope of the cluster-scoped resources except the GlobalResources, e.g. nodes
+	ClusterScope            = "Cluster"
 	WorkspaceScope          = "Workspace"
 	NamespaceScope          = "Namespace"
 	workspaceSelectorPrefix = constants.WorkspaceLabelKey + "="
@@ -256,7 +258,20 @@ func (r *RequestInfoFactory) resolveResourceScope(request RequestInfo) string {
 		return WorkspaceScope
 	}
 
-	return GlobalScope
+	if r.isGlobalResource(request.APIGroup, request.Resource) {
+		return GlobalScope
+	}
+
+	return ClusterScope
+}
+
+func (r *RequestInfoFactory) isGlobalResource(group, resource string) bool {
+	for _, globalResource := range r.GlobalResources {
+		if globalResource.Group == group && globalResource.Resource == resource {
+			return true
+		}
+	}
+	return false
 }
 
 func RequestInfoFrom(ctx context.Context) (*RequestInfo, bool) {
@@ -0,0 +1,39 @@
+package request
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestResolveResourceScope(t *testing.T) {
+	factory := &RequestInfoFactory{
+		APIPrefixes:          sets.NewString("api", "apis", "ai-apis"),
+		GrouplessAPIPrefixes: sets.NewString("api"),
+		GlobalResources:      []schema.GroupResource{{Group: "iam.ai.io", Resource: "users"}},
+	}
+
+	tests := []struct {
+		path  string
+		scope string
+	}{
+		{"/ai-apis/iam.ai.io/v1/users", GlobalScope},
+		{"/ai-apis/iam.ai.io/v1/users/admin", GlobalScope},
+		{"/api/v1/nodes", ClusterScope},
+		{"/apis/rbac.authorization.k8s.io/v1/clusterroles/view", ClusterScope},
+		{"/api/v1/namespaces/default/pods", NamespaceScope},
+		{"/ai-apis/iam.ai.io/v1/workspaces/system/members", WorkspaceScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tt.path, nil)
+			assert.Nil(t, err)
+			requestInfo, err := factory.NewRequestInfo(req)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.scope, requestInfo.ResourceScope)
+		})
+	}
+}
@@ -18,6 +18,14 @@ var (
 
 	// AddToScheme adds the types in this group-version to the given scheme.
 	AddToScheme = SchemeBuilder.AddToScheme
+
+	// GlobalResources are the cluster-scoped resources of the platform, which are only granted by the GlobalRoleBindings
+	GlobalResources = []schema.GroupResource{
+		Resource(ResourcePluralUser),
+		Resource(ResourcePluralGlobalRole),
+		Resource(ResourcePluralGlobalRoleBinding),
+		Resource(ResourcePluralAccessToken),
+	}
 )
 
 // Resource is required by pkg/client/listers/...
@@ -34,6 +34,15 @@ const (
 	GlobalRoleAnnotation                = "iam.ai.io/globalrole"
 	ResourcesSingularUser               = "user"
 	FieldEmail                          = "email"
+	IdentifyProviderLabel               = "iam.ai.io/identify-provider"
+	OriginUIDLabel                      = "iam.ai.io/origin-uid"
+	// MFASecretAnnotation refers to the secret of the TOTP second factor, MFA is enabled if it is set
+	MFASecretAnnotation = "iam.ai.io/mfa-secret"
+	// PasswordHistoryAnnotation keeps the hashes of the previous passwords, which can not be reused
+	PasswordHistoryAnnotation = "iam.ai.io/password-history"
+	ResourcePluralAccessToken = "accesstokens"
+	// AccessTokenScopesExtraKey carries the scopes of the access token in the extra of the authenticated user
+	AccessTokenScopesExtraKey = "iam.ai.io/scopes"
 )
 
 // +genclient
@@ -87,6 +96,10 @@ const (
 	UserAuthLimitExceeded UserState = "AuthLimitExceeded"
 
 	AuthenticatedSuccessfully = "authenticated successfully"
+	// SessionSuperseded means the user logged in successfully, and the earlier sessions were invalidated
+	SessionSuperseded = "authenticated successfully, earlier sessions were superseded"
+	// RefreshTokenReused means a rotated refresh token was presented again, the sessions refreshed from it were revoked
+	RefreshTokenReused = "refresh token reused, the sessions refreshed from it were revoked"
 )
 
 // UserStatus defines the observed state of User
@@ -101,6 +114,12 @@ type UserStatus struct {
 	// Last login attempt timestamp
 	// +optional
 	LastLoginTime *metav1.Time `json:"lastLoginTime,omitempty"`
+	// The time when the password was changed, the password expires after the max age of the password policy
+	// +optional
+	LastPasswordChangeTime *metav1.Time `json:"lastPasswordChangeTime,omitempty"`
+	// MustChangePassword means the password has expired, no tokens are issued until it is changed
+	// +optional
+	MustChangePassword bool `json:"mustChangePassword,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -127,6 +146,13 @@ type GlobalRole struct {
 	// Rules holds all the PolicyRules for this GlobalRole
 	// +optional
 	Rules []rbacv1.PolicyRule `json:"rules" protobuf:"bytes,2,rep,name=rules"`
+
+	// AggregationRule is an optional field that describes how to build the Rules for this GlobalRole,
+	// the clusterRoleSelectors select the GlobalRoles whose rules are aggregated.
+	// If AggregationRule is set, then the Rules are controller managed and direct changes to Rules will be
+	// stomped by the controller.
+	// +optional
+	AggregationRule *rbacv1.AggregationRule `json:"aggregationRule,omitempty" protobuf:"bytes,3,opt,name=aggregationRule"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -207,6 +233,8 @@ const (
 	BasicAuth LoginType = "Basic"
 	OAuth     LoginType = "OAuth"
 	Token     LoginType = "Token"
+	// ClientCredentials is the token issued to the service identity of an OAuth client
+	ClientCredentials LoginType = "ClientCredentials"
 )
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -218,6 +246,54 @@ type LoginRecordList struct {
 	Items           []LoginRecord `json:"items"`
 }
 
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:printcolumn:name="User",type="string",JSONPath=".spec.username"
+// +kubebuilder:printcolumn:name="Name",type="string",JSONPath=".spec.displayName"
+// +kubebuilder:printcolumn:name="Expiration",type="date",JSONPath=".spec.expirationTime"
+// +kubebuilder:printcolumn:name="Last Used",type="date",JSONPath=".status.lastUsedTime"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+// +kubebuilder:resource:categories="iam",scope="Cluster"
+// +kubebuilder:subresource:status
+// AccessToken is a personal access token of the user, only the hash of the secret is stored
+type AccessToken struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              AccessTokenSpec   `json:"spec"`
+	Status            AccessTokenStatus `json:"status,omitempty"`
+}
+
+type AccessTokenSpec struct {
+	// The user the token belongs to
+	Username string `json:"username"`
+	// The name given by the user
+	DisplayName string `json:"displayName,omitempty"`
+	// Scopes restrict the token to a subset of the rules of the user, the token has all the rules of the user if it is empty
+	// +optional
+	Scopes []rbacv1.PolicyRule `json:"scopes,omitempty"`
+	// The token never expires if it is nil
+	// +optional
+	ExpirationTime *metav1.Time `json:"expirationTime,omitempty"`
+	// The SHA-256 hash of the secret
+	TokenHash string `json:"tokenHash"`
+}
+
+type AccessTokenStatus struct {
+	// The last time the token was used
+	// +optional
+	LastUsedTime *metav1.Time `json:"lastUsedTime,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// AccessTokenList contains a list of AccessToken
+type AccessTokenList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AccessToken `json:"items"`
+}
+
 func init() {
 	SchemeBuilder.Register(
 		&User{},
@@ -228,5 +304,7 @@ func init() {
 		&GlobalRoleList{},
 		&GlobalRoleBinding{},
 		&GlobalRoleBindingList{},
+		&AccessToken{},
+		&AccessTokenList{},
 	)
 }
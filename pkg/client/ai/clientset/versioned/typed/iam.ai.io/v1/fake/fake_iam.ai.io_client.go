@@ -12,6 +12,10 @@ type FakeIamV1 struct {
 	*testing.Fake
 }
 
+func (c *FakeIamV1) AccessTokens() v1.AccessTokenInterface {
+	return &FakeAccessTokens{c}
+}
+
 func (c *FakeIamV1) GlobalRoles() v1.GlobalRoleInterface {
 	return &FakeGlobalRoles{c}
 }
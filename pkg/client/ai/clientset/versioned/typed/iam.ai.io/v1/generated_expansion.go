@@ -2,6 +2,8 @@
 
 package v1
 
+type AccessTokenExpansion interface{}
+
 type GlobalRoleExpansion interface{}
 
 type GlobalRoleBindingExpansion interface{}
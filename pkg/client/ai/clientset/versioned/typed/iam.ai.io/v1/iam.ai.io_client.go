@@ -12,6 +12,7 @@ import (
 
 type IamV1Interface interface {
 	RESTClient() rest.Interface
+	AccessTokensGetter
 	GlobalRolesGetter
 	GlobalRoleBindingsGetter
 	LoginRecordsGetter
@@ -23,6 +24,10 @@ type IamV1Client struct {
 	restClient rest.Interface
 }
 
+func (c *IamV1Client) AccessTokens() AccessTokenInterface {
+	return newAccessTokens(c)
+}
+
 func (c *IamV1Client) GlobalRoles() GlobalRoleInterface {
 	return newGlobalRoles(c)
 }
@@ -42,6 +42,8 @@ func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Core().V1().Healths().Informer()}, nil
 
 		// Group=iam.ai.io, Version=v1
+	case iamaiiov1.SchemeGroupVersion.WithResource("accesstokens"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Iam().V1().AccessTokens().Informer()}, nil
 	case iamaiiov1.SchemeGroupVersion.WithResource("globalroles"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Iam().V1().GlobalRoles().Informer()}, nil
 	case iamaiiov1.SchemeGroupVersion.WithResource("globalrolebindings"):
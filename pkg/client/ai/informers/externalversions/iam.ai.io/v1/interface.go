@@ -8,6 +8,8 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// AccessTokens returns a AccessTokenInformer.
+	AccessTokens() AccessTokenInformer
 	// GlobalRoles returns a GlobalRoleInformer.
 	GlobalRoles() GlobalRoleInformer
 	// GlobalRoleBindings returns a GlobalRoleBindingInformer.
@@ -29,6 +31,11 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// AccessTokens returns a AccessTokenInformer.
+func (v *version) AccessTokens() AccessTokenInformer {
+	return &accessTokenInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
 // GlobalRoles returns a GlobalRoleInformer.
 func (v *version) GlobalRoles() GlobalRoleInformer {
 	return &globalRoleInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
@@ -2,6 +2,10 @@
 
 package v1
 
+// AccessTokenListerExpansion allows custom methods to be added to
+// AccessTokenLister.
+type AccessTokenListerExpansion interface{}
+
 // GlobalRoleListerExpansion allows custom methods to be added to
 // GlobalRoleLister.
 type GlobalRoleListerExpansion interface{}
@@ -27,6 +27,14 @@ type Interface interface {
 	// Del deletes the given key, no error returned if the key doesn't exists
 	Del(keys ...string) error
 
+	// GetDel retrieves the value of the given key and deletes it atomically, return error if key doesn't exist,
+	// so only one of the concurrent callers gets the value
+	GetDel(key string) (string, error)
+
+	// SetNX sets the value and living duration of the given key only if the key doesn't exist,
+	// return true if the value is set, so only one of the concurrent callers sets the value
+	SetNX(key string, value string, duration time.Duration) (bool, error)
+
 	// Exists checks the existence of a give key
 	Exists(keys ...string) (bool, error)
 
@@ -35,7 +43,7 @@ type Interface interface {
 }
 
 // DynamicOptions the options of the cache. For redis, options key can be  "host", "port", "db", "password".
-// For InMemoryCache, options key can be "cleanupperiod"
+// For InMemoryCache, options key can be "cleanupperiod", "maxentries"
 type DynamicOptions map[string]interface{}
 
 func (o DynamicOptions) MarshalJSON() ([]byte, error) {
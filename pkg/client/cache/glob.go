@@ -0,0 +1,134 @@
+package cache
+
+import "strings"
+
+// globMatch reports whether str matches the redis style glob pattern.
+// Supported patterns are the same as the KEYS command of redis:
+//
+//	h?llo matches hello, hallo and hxllo
+//	h*llo matches hllo and heeeello
+//	h[ae]llo matches hello and hallo, but not hillo
+//	h[^e]llo matches hallo, hbllo, ... but not hello
+//	h[a-b]llo matches hallo and hbllo
+//
+// Use \ to escape special characters.
+func globMatch(pattern, str string) bool {
+	p, s := 0, 0
+	// position to resume from when the last '*' needs to consume one more byte
+	starP, starS := -1, 0
+
+	for s < len(str) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				// collapse consecutive stars
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+				if p == len(pattern) {
+					return true
+				}
+				starP, starS = p, s
+				continue
+			case '?':
+				p++
+				s++
+				continue
+			case '[':
+				if next, ok := matchClass(pattern, p, str[s]); ok {
+					p = next
+					s++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) {
+					if pattern[p+1] == str[s] {
+						p += 2
+						s++
+						continue
+					}
+					break
+				}
+				// a trailing backslash matches itself
+				fallthrough
+			default:
+				if pattern[p] == str[s] {
+					p++
+					s++
+					continue
+				}
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		// let the last star consume one more byte and retry
+		starS++
+		p, s = starP, starS
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches c against the character class starting at pattern[start] == '[',
+// it returns the index right after the class and whether c is matched.
+func matchClass(pattern string, start int, c byte) (int, bool) {
+	p := start + 1
+	not := false
+	if p < len(pattern) && pattern[p] == '^' {
+		not = true
+		p++
+	}
+
+	matched := false
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if pattern[p] == c {
+				matched = true
+			}
+			p++
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			p += 3
+		default:
+			if pattern[p] == c {
+				matched = true
+			}
+			p++
+		}
+	}
+	// an unterminated class runs to the end of pattern, the same as redis
+	if p < len(pattern) {
+		p++
+	}
+
+	if not {
+		matched = !matched
+	}
+	return p, matched
+}
+
+// EscapePattern escapes the special characters of the glob pattern in str,
+// so the result matches str literally when it is a part of a pattern.
+func EscapePattern(str string) string {
+	var b strings.Builder
+	for i := 0; i < len(str); i++ {
+		switch str[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(str[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,199 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/mitchellh/mapstructure"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+)
+
+const (
+	typeRedis = "redis"
+
+	defaultRedisPort     = 6379
+	defaultRedisPoolSize = 10
+	// redisScanCount is the hint passed to SCAN, redis may return more or less keys per batch
+	redisScanCount = 100
+)
+
+// RedisOptions used to create a redis client.
+// Redis can be shared by multi-replicas apiserver, tokens issued by one replica
+// can be validated by the others.
+type RedisOptions struct {
+	Host     string `json:"host" yaml:"host" mapstructure:"host"`
+	Port     int    `json:"port" yaml:"port" mapstructure:"port"`
+	Password string `json:"password" yaml:"password" mapstructure:"password"`
+	DB       int    `json:"db" yaml:"db" mapstructure:"db"`
+	// PoolSize is the maximum number of socket connections, default is 10
+	PoolSize int `json:"poolSize" yaml:"poolSize" mapstructure:"poolsize"`
+	// MinIdleConns is the minimum number of idle connections kept in the pool
+	MinIdleConns int `json:"minIdleConns" yaml:"minIdleConns" mapstructure:"minidleconns"`
+	// IdleTimeout is the amount of time after which client closes idle connections
+	IdleTimeout time.Duration `json:"idleTimeout" yaml:"idleTimeout" mapstructure:"idletimeout"`
+}
+
+// redisClient implements cache.Interface backed by redis
+type redisClient struct {
+	client *redis.Client
+}
+
+func NewRedisClient(options *RedisOptions, stopCh <-chan struct{}) (Interface, error) {
+	if options == nil || options.Host == "" {
+		return nil, fmt.Errorf("redis host is empty")
+	}
+	port := options.Port
+	if port == 0 {
+		port = defaultRedisPort
+	}
+	poolSize := options.PoolSize
+	if poolSize == 0 {
+		poolSize = defaultRedisPoolSize
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:         fmt.Sprintf("%s:%d", options.Host, port),
+		Password:     options.Password,
+		DB:           options.DB,
+		PoolSize:     poolSize,
+		MinIdleConns: options.MinIdleConns,
+		IdleTimeout:  options.IdleTimeout,
+	})
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		klog.Errorf("unable to reach redis host %s:%d, error: %v", options.Host, port, err)
+		_ = client.Close()
+		return nil, err
+	}
+
+	if stopCh != nil {
+		go func() {
+			<-stopCh
+			if err := client.Close(); err != nil {
+				klog.Error(err)
+			}
+		}()
+	}
+
+	return &redisClient{client: client}, nil
+}
+
+func (r *redisClient) Keys(pattern string) ([]string, error) {
+	ctx := context.Background()
+	// SCAN may return a key more than once, so the keys are deduplicated
+	keys := sets.NewString()
+	var cursor uint64
+	// SCAN does not block the server like KEYS does
+	for {
+		batch, next, err := r.client.Scan(ctx, cursor, pattern, redisScanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys.Insert(batch...)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	return keys.List(), nil
+}
+
+func (r *redisClient) Get(key string) (string, error) {
+	value, err := r.client.Get(context.Background(), key).Result()
+	if err == redis.Nil {
+		return "", ErrNoSuchKey
+	}
+	return value, err
+}
+
+// GetDel uses GETDEL, which requires redis 6.2 or later
+func (r *redisClient) GetDel(key string) (string, error) {
+	value, err := r.client.GetDel(context.Background(), key).Result()
+	if err == redis.Nil {
+		return "", ErrNoSuchKey
+	}
+	return value, err
+}
+
+func (r *redisClient) Set(key string, value string, duration time.Duration) error {
+	// zero expiration means the key has no expiration time in redis, same as NeverExpire
+	return r.client.Set(context.Background(), key, value, duration).Err()
+}
+
+func (r *redisClient) SetNX(key string, value string, duration time.Duration) (bool, error) {
+	return r.client.SetNX(context.Background(), key, value, duration).Result()
+}
+
+func (r *redisClient) Del(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return r.client.Del(context.Background(), keys...).Err()
+}
+
+func (r *redisClient) Exists(keys ...string) (bool, error) {
+	if len(keys) == 0 {
+		return true, nil
+	}
+	existedKeys, err := r.client.Exists(context.Background(), keys...).Result()
+	if err != nil {
+		return false, err
+	}
+	return existedKeys == int64(len(keys)), nil
+}
+
+func (r *redisClient) Expire(key string, duration time.Duration) error {
+	var ok bool
+	var err error
+	if duration == NeverExpire {
+		ok, err = r.client.Persist(context.Background(), key).Result()
+		if err == nil && !ok {
+			// PERSIST returns false for keys without a timeout as well
+			var exists bool
+			if exists, err = r.Exists(key); err == nil {
+				ok = exists
+			}
+		}
+	} else {
+		ok, err = r.client.Expire(context.Background(), key, duration).Result()
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNoSuchKey
+	}
+	return nil
+}
+
+type redisFactory struct {
+}
+
+func (rf *redisFactory) Type() string {
+	return typeRedis
+}
+
+func (rf *redisFactory) Create(options DynamicOptions, stopCh <-chan struct{}) (Interface, error) {
+	var rOptions RedisOptions
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+		WeaklyTypedInput: true,
+		Result:           &rOptions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := decoder.Decode(options); err != nil {
+		return nil, err
+	}
+
+	return NewRedisClient(&rOptions, stopCh)
+}
+
+func init() {
+	RegisterCacheFactory(&redisFactory{})
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, Interface) {
+	server := miniredis.RunT(t)
+	port, err := strconv.Atoi(server.Port())
+	assert.Nil(t, err)
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	client, err := New(&Options{
+		Type: typeRedis,
+		Options: DynamicOptions{
+			"host":     server.Host(),
+			"port":     port,
+			"db":       0,
+			"poolsize": "4",
+		},
+	}, stopCh)
+	assert.Nil(t, err)
+	return server, client
+}
+
+func TestRedisFactory(t *testing.T) {
+	_, err := New(&Options{Type: typeRedis, Options: DynamicOptions{}}, nil)
+	assert.NotNil(t, err)
+
+	// nothing listens on this port
+	_, err = New(&Options{Type: typeRedis, Options: DynamicOptions{"host": "127.0.0.1", "port": 1}}, nil)
+	assert.NotNil(t, err)
+
+	server := miniredis.RunT(t)
+	server.RequireAuth("secret")
+	port, _ := strconv.Atoi(server.Port())
+	_, err = New(&Options{Type: typeRedis, Options: DynamicOptions{"host": server.Host(), "port": port}}, nil)
+	assert.NotNil(t, err)
+	_, err = New(&Options{Type: typeRedis, Options: DynamicOptions{"host": server.Host(), "port": port, "password": "secret"}}, nil)
+	assert.Nil(t, err)
+}
+
+func TestRedisClient(t *testing.T) {
+	server, client := newTestRedis(t)
+
+	_, err := client.Get("ai:user:foo:token:a")
+	assert.Equal(t, ErrNoSuchKey, err)
+
+	assert.Nil(t, client.Set("ai:user:foo:token:a", "a", time.Minute))
+	assert.Nil(t, client.Set("ai:user:foo:token:b", "b", NeverExpire))
+	assert.Nil(t, client.Set("ai:user:bar:token:c", "c", time.Minute))
+
+	value, err := client.Get("ai:user:foo:token:a")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", value)
+
+	keys, err := client.Keys("ai:user:foo:token:*")
+	assert.Nil(t, err)
+	sort.Strings(keys)
+	assert.Equal(t, []string{"ai:user:foo:token:a", "ai:user:foo:token:b"}, keys)
+
+	exists, err := client.Exists("ai:user:foo:token:a", "ai:user:bar:token:c")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	exists, err = client.Exists("ai:user:foo:token:a", "ai:user:bar:token:d")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	// ttl is honoured
+	server.FastForward(2 * time.Minute)
+	exists, err = client.Exists("ai:user:foo:token:a")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	exists, err = client.Exists("ai:user:foo:token:b")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	// expire
+	assert.Equal(t, ErrNoSuchKey, client.Expire("ai:user:foo:token:a", time.Minute))
+	assert.Nil(t, client.Expire("ai:user:foo:token:b", time.Minute))
+	assert.Equal(t, time.Minute, server.TTL("ai:user:foo:token:b"))
+	assert.Nil(t, client.Expire("ai:user:foo:token:b", NeverExpire))
+	assert.Equal(t, time.Duration(0), server.TTL("ai:user:foo:token:b"))
+	assert.Nil(t, client.Expire("ai:user:foo:token:b", NeverExpire))
+
+	// del
+	assert.Nil(t, client.Del())
+	assert.Nil(t, client.Del("ai:user:foo:token:b", "ai:user:foo:token:none"))
+	_, err = client.Get("ai:user:foo:token:b")
+	assert.Equal(t, ErrNoSuchKey, err)
+	// get and del
+	assert.Nil(t, client.Set("ai:user:foo:token:d", "d", time.Minute))
+	value, err = client.GetDel("ai:user:foo:token:d")
+	assert.Nil(t, err)
+	assert.Equal(t, "d", value)
+	_, err = client.GetDel("ai:user:foo:token:d")
+	assert.Equal(t, ErrNoSuchKey, err)
+	// set if not exists
+	set, err := client.SetNX("ai:user:foo:token:f", "f", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, set)
+	set, err = client.SetNX("ai:user:foo:token:f", "g", time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, set)
+	value, _ = client.Get("ai:user:foo:token:f")
+	assert.Equal(t, "f", value)
+	assert.Equal(t, time.Minute, server.TTL("ai:user:foo:token:f"))
+}
+
+func TestRedisClientKeysScan(t *testing.T) {
+	_, client := newTestRedis(t)
+
+	for i := 0; i < redisScanCount*3; i++ {
+		assert.Nil(t, client.Set("ai:user:foo:token:"+strconv.Itoa(i), "", NeverExpire))
+	}
+	assert.Nil(t, client.Set("ai:user:bar:token:0", "", NeverExpire))
+
+	keys, err := client.Keys("ai:user:foo:*")
+	assert.Nil(t, err)
+	assert.Len(t, keys, redisScanCount*3)
+}
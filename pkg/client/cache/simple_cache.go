@@ -1,9 +1,10 @@
 package cache
 
 import (
+	"container/list"
 	"errors"
-	"regexp"
-	"strings"
+	"hash/fnv"
+	"sync"
 	"time"
 
 	"github.com/mitchellh/mapstructure"
@@ -17,115 +18,252 @@ const (
 	DefaultCacheType  = typeInMemoryCache
 
 	defaultCleanupPeriod = 2 * time.Hour
+	// shardCount is the number of independently locked shards, must be a power of two
+	shardCount = 32
 )
 
 type simpleObject struct {
+	key         string
 	value       string
 	neverExpire bool
 	expiredAt   time.Time
 }
 
-func (so *simpleObject) IsExpired() bool {
+func (so *simpleObject) IsExpired(now time.Time) bool {
 	if so.neverExpire {
 		return false
 	}
-	if time.Now().After(so.expiredAt) {
-		return true
-	}
-	return false
+	return !now.Before(so.expiredAt)
 }
 
 // InMemoryCacheOptions used to create inMemoryCache in memory.
 // CleanupPeriod specifies cleans up expired token every period.
+// MaxEntries limits the number of keys kept in memory, the least recently used keys
+// are evicted once the limit is reached, zero means no limit.
 // Note the SimpleCache cannot be used in multi-replicas apiserver,
-// which will lead to data inconsistency.
+// which will lead to data inconsistency, use redis instead.
 type InMemoryCacheOptions struct {
 	CleanupPeriod time.Duration `json:"cleanupPeriod" yaml:"cleanupPeriod" mapstructure:"cleanupperiod"`
+	MaxEntries    int           `json:"maxEntries" yaml:"maxEntries" mapstructure:"maxentries"`
+}
+
+// cacheShard is a LRU list of objects guarded by its own lock,
+// the front of the list is the most recently used object.
+type cacheShard struct {
+	sync.Mutex
+	items      map[string]*list.Element
+	lru        *list.List
+	maxEntries int
 }
 
-// imMemoryCache implements cache.Interface use memory objects, it should be used only for testing
+// imMemoryCache implements cache.Interface use memory objects,
+// keys are spread over shards to reduce lock contention between requests.
 type inMemoryCache struct {
-	store map[string]simpleObject
+	shards [shardCount]*cacheShard
+	// mask selects the shards in use by the hash of the keys
+	mask uint32
+	// now returns the current time, it is replaced in tests
+	now func() time.Time
 }
 
 func NewInMemoryCache(options *InMemoryCacheOptions, stopCh <-chan struct{}) (Interface, error) {
 	var cleanupPeriod time.Duration
-	cache := &inMemoryCache{
-		store: make(map[string]simpleObject),
-	}
-
+	var maxEntries int
 	if options == nil || options.CleanupPeriod == 0 {
 		cleanupPeriod = defaultCleanupPeriod
 	} else {
 		cleanupPeriod = options.CleanupPeriod
 	}
+	if options != nil && options.MaxEntries > 0 {
+		maxEntries = options.MaxEntries
+	}
+
+	cache := newInMemoryCache(maxEntries, time.Now)
 	go wait.Until(cache.cleanInvalidToken, cleanupPeriod, stopCh)
 
 	return cache, nil
 }
 
-func (s *inMemoryCache) cleanInvalidToken() {
-	for k, v := range s.store {
-		if v.IsExpired() {
-			delete(s.store, k)
+// newInMemoryCache spreads maxEntries over the shards so that the shards hold at most maxEntries in total,
+// fewer shards are used if maxEntries is less than shardCount, zero means no limit.
+func newInMemoryCache(maxEntries int, now func() time.Time) *inMemoryCache {
+	shards := shardCount
+	for maxEntries > 0 && shards > maxEntries {
+		shards /= 2
+	}
+	cache := &inMemoryCache{now: now, mask: uint32(shards - 1)}
+	for i := range cache.shards {
+		cache.shards[i] = &cacheShard{
+			items: make(map[string]*list.Element),
+			lru:   list.New(),
+		}
+		if maxEntries > 0 && i < shards {
+			cache.shards[i].maxEntries = maxEntries / shards
+			if i < maxEntries%shards {
+				cache.shards[i].maxEntries++
+			}
 		}
 	}
+	return cache
 }
 
-func (s *inMemoryCache) Keys(pattern string) ([]string, error) {
-	// There is a little difference between go regexp and redis key pattern
-	// In redis, * means any character, while in go . means match everything.
-	pattern = strings.Replace(pattern, "*", ".", -1)
+func (s *inMemoryCache) shard(key string) *cacheShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return s.shards[h.Sum32()&s.mask]
+}
 
-	re, err := regexp.Compile(pattern)
-	if err != nil {
-		return nil, err
+// cleanInvalidToken reclaims the memory of expired objects, expired objects are
+// invisible to readers even before they are removed here.
+func (s *inMemoryCache) cleanInvalidToken() {
+	now := s.now()
+	for _, shard := range s.shards {
+		shard.Lock()
+		for _, element := range shard.items {
+			if element.Value.(*simpleObject).IsExpired(now) {
+				shard.remove(element)
+			}
+		}
+		shard.Unlock()
+	}
+}
+
+// lookup returns the live object of the given key, expired object will be removed.
+// The caller must hold the lock of the shard.
+func (c *cacheShard) lookup(key string, now time.Time) (*list.Element, bool) {
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
 	}
+	if element.Value.(*simpleObject).IsExpired(now) {
+		c.remove(element)
+		return nil, false
+	}
+	return element, true
+}
+
+func (c *cacheShard) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.items, element.Value.(*simpleObject).key)
+}
+
+func (s *inMemoryCache) Keys(pattern string) ([]string, error) {
+	now := s.now()
 	var keys []string
-	for k := range s.store {
-		if re.MatchString(k) {
-			keys = append(keys, k)
+	for _, shard := range s.shards {
+		shard.Lock()
+		for k, element := range shard.items {
+			if element.Value.(*simpleObject).IsExpired(now) {
+				shard.remove(element)
+				continue
+			}
+			if globMatch(pattern, k) {
+				keys = append(keys, k)
+			}
 		}
+		shard.Unlock()
 	}
 
 	return keys, nil
 }
 
 func (s *inMemoryCache) Set(key string, value string, duration time.Duration) error {
-	sobject := simpleObject{
+	sobject := s.newObject(key, value, duration)
+
+	shard := s.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if element, ok := shard.items[key]; ok {
+		element.Value = sobject
+		shard.lru.MoveToFront(element)
+		return nil
+	}
+	shard.push(sobject)
+	return nil
+}
+
+func (s *inMemoryCache) SetNX(key string, value string, duration time.Duration) (bool, error) {
+	shard := s.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if _, ok := shard.lookup(key, s.now()); ok {
+		return false, nil
+	}
+	shard.push(s.newObject(key, value, duration))
+	return true, nil
+}
+
+func (s *inMemoryCache) newObject(key string, value string, duration time.Duration) *simpleObject {
+	sobject := &simpleObject{
+		key:         key,
 		value:       value,
 		neverExpire: false,
-		expiredAt:   time.Now().Add(duration),
+		expiredAt:   s.now().Add(duration),
 	}
 
 	if duration == NeverExpire {
 		sobject.neverExpire = true
 	}
+	return sobject
+}
 
-	s.store[key] = sobject
-	return nil
+// push adds the object of the absent key, the least recently used object is evicted if the shard is full.
+// The caller must hold the lock of the shard.
+func (c *cacheShard) push(sobject *simpleObject) {
+	c.items[sobject.key] = c.lru.PushFront(sobject)
+	if c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
 }
 
 func (s *inMemoryCache) Del(keys ...string) error {
 	for _, key := range keys {
-		delete(s.store, key)
+		shard := s.shard(key)
+		shard.Lock()
+		if element, ok := shard.items[key]; ok {
+			shard.remove(element)
+		}
+		shard.Unlock()
 	}
 	return nil
 }
 
 func (s *inMemoryCache) Get(key string) (string, error) {
-	if sobject, ok := s.store[key]; ok {
-		if sobject.neverExpire || time.Now().Before(sobject.expiredAt) {
-			return sobject.value, nil
-		}
+	shard := s.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if element, ok := shard.lookup(key, s.now()); ok {
+		shard.lru.MoveToFront(element)
+		return element.Value.(*simpleObject).value, nil
+	}
+
+	return "", ErrNoSuchKey
+}
+
+func (s *inMemoryCache) GetDel(key string) (string, error) {
+	shard := s.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	if element, ok := shard.lookup(key, s.now()); ok {
+		shard.remove(element)
+		return element.Value.(*simpleObject).value, nil
 	}
 
 	return "", ErrNoSuchKey
 }
 
 func (s *inMemoryCache) Exists(keys ...string) (bool, error) {
+	now := s.now()
 	for _, key := range keys {
-		if _, ok := s.store[key]; !ok {
+		shard := s.shard(key)
+		shard.Lock()
+		_, ok := shard.lookup(key, now)
+		shard.Unlock()
+		if !ok {
 			return false, nil
 		}
 	}
@@ -134,22 +272,30 @@ func (s *inMemoryCache) Exists(keys ...string) (bool, error) {
 }
 
 func (s *inMemoryCache) Expire(key string, duration time.Duration) error {
-	value, err := s.Get(key)
-	if err != nil {
-		return err
+	now := s.now()
+	shard := s.shard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	element, ok := shard.lookup(key, now)
+	if !ok {
+		return ErrNoSuchKey
 	}
 
-	sobject := simpleObject{
-		value:       value,
+	old := element.Value.(*simpleObject)
+	sobject := &simpleObject{
+		key:         key,
+		value:       old.value,
 		neverExpire: false,
-		expiredAt:   time.Now().Add(duration),
+		expiredAt:   now.Add(duration),
 	}
 
 	if duration == NeverExpire {
 		sobject.neverExpire = true
 	}
 
-	s.store[key] = sobject
+	element.Value = sobject
+	shard.lru.MoveToFront(element)
 	return nil
 }
 
@@ -0,0 +1,287 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	sync.Mutex
+	time time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.Lock()
+	defer f.Unlock()
+	return f.time
+}
+
+func (f *fakeClock) Step(d time.Duration) {
+	f.Lock()
+	defer f.Unlock()
+	f.time = f.time.Add(d)
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		matched bool
+	}{
+		{pattern: "*", str: "", matched: true},
+		{pattern: "*", str: "anything", matched: true},
+		{pattern: "h?llo", str: "hello", matched: true},
+		{pattern: "h?llo", str: "hllo", matched: false},
+		{pattern: "h*llo", str: "hllo", matched: true},
+		{pattern: "h*llo", str: "heeeello", matched: true},
+		{pattern: "h*llo", str: "heeeellox", matched: false},
+		{pattern: "h[ae]llo", str: "hallo", matched: true},
+		{pattern: "h[ae]llo", str: "hillo", matched: false},
+		{pattern: "h[^e]llo", str: "hallo", matched: true},
+		{pattern: "h[^e]llo", str: "hello", matched: false},
+		{pattern: "h[a-b]llo", str: "hbllo", matched: true},
+		{pattern: "h[a-b]llo", str: "hcllo", matched: false},
+		{pattern: "h[b-a]llo", str: "hallo", matched: true},
+		{pattern: `h\*llo`, str: "h*llo", matched: true},
+		{pattern: `h\*llo`, str: "hello", matched: false},
+		{pattern: `h[\]]llo`, str: "h]llo", matched: true},
+		{pattern: "ai:user:*:token:*", str: "ai:user:admin:token:abc", matched: true},
+		{pattern: "ai:user:admin:token:*", str: "ai:user:admin2:token:abc", matched: false},
+		// the regexp based implementation treated "." as a wildcard
+		{pattern: "ai.user", str: "aiXuser", matched: false},
+		{pattern: "a**b", str: "ab", matched: true},
+		{pattern: "[abc", str: "a", matched: true},
+		// the escaped patterns only match themselves
+		{pattern: "ai:user:" + EscapePattern("a*[b]?\\") + ":token:*", str: "ai:user:a*[b]?\\:token:abc", matched: true},
+		{pattern: "ai:user:" + EscapePattern("*") + ":token:*", str: "ai:user:admin:token:abc", matched: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.str, func(t *testing.T) {
+			assert.Equal(t, tt.matched, globMatch(tt.pattern, tt.str))
+		})
+	}
+}
+
+func TestInMemoryCache(t *testing.T) {
+	clock := &fakeClock{time: time.Now()}
+	cache := newInMemoryCache(0, clock.Now)
+
+	assert.Nil(t, cache.Set("ai:user:foo:token:a", "a", time.Minute))
+	assert.Nil(t, cache.Set("ai:user:foo:token:b", "b", NeverExpire))
+	assert.Nil(t, cache.Set("ai:user:bar:token:c", "c", time.Minute))
+
+	value, err := cache.Get("ai:user:foo:token:a")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", value)
+
+	keys, err := cache.Keys("ai:user:foo:token:*")
+	assert.Nil(t, err)
+	sort.Strings(keys)
+	assert.Equal(t, []string{"ai:user:foo:token:a", "ai:user:foo:token:b"}, keys)
+
+	exists, err := cache.Exists("ai:user:foo:token:a", "ai:user:bar:token:c")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+
+	// expired objects are invisible without waiting for the cleanup
+	clock.Step(time.Minute)
+	exists, err = cache.Exists("ai:user:foo:token:a")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	_, err = cache.Get("ai:user:bar:token:c")
+	assert.Equal(t, ErrNoSuchKey, err)
+	keys, err = cache.Keys("*")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ai:user:foo:token:b"}, keys)
+	assert.Equal(t, ErrNoSuchKey, cache.Expire("ai:user:foo:token:a", time.Minute))
+
+	// expire
+	assert.Nil(t, cache.Expire("ai:user:foo:token:b", time.Minute))
+	clock.Step(30 * time.Second)
+	assert.Nil(t, cache.Expire("ai:user:foo:token:b", time.Minute))
+	clock.Step(45 * time.Second)
+	value, err = cache.Get("ai:user:foo:token:b")
+	assert.Nil(t, err)
+	assert.Equal(t, "b", value)
+	assert.Nil(t, cache.Expire("ai:user:foo:token:b", NeverExpire))
+	clock.Step(time.Hour)
+	exists, _ = cache.Exists("ai:user:foo:token:b")
+	assert.True(t, exists)
+
+	// del
+	assert.Nil(t, cache.Del("ai:user:foo:token:b", "ai:user:foo:token:none"))
+	_, err = cache.Get("ai:user:foo:token:b")
+	assert.Equal(t, ErrNoSuchKey, err)
+
+	// get and del
+	assert.Nil(t, cache.Set("ai:user:foo:token:d", "d", time.Minute))
+	value, err = cache.GetDel("ai:user:foo:token:d")
+	assert.Nil(t, err)
+	assert.Equal(t, "d", value)
+	_, err = cache.GetDel("ai:user:foo:token:d")
+	assert.Equal(t, ErrNoSuchKey, err)
+	assert.Nil(t, cache.Set("ai:user:foo:token:e", "e", time.Minute))
+	clock.Step(time.Minute)
+	_, err = cache.GetDel("ai:user:foo:token:e")
+	assert.Equal(t, ErrNoSuchKey, err)
+
+	// set if not exists, the expired key is absent
+	set, err := cache.SetNX("ai:user:foo:token:f", "f", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, set)
+	set, err = cache.SetNX("ai:user:foo:token:f", "g", time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, set)
+	value, _ = cache.Get("ai:user:foo:token:f")
+	assert.Equal(t, "f", value)
+	clock.Step(time.Minute)
+	set, err = cache.SetNX("ai:user:foo:token:f", "g", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, set)
+}
+
+func TestInMemoryCacheCleanup(t *testing.T) {
+	clock := &fakeClock{time: time.Now()}
+	cache := newInMemoryCache(0, clock.Now)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, cache.Set(strconv.Itoa(i), "", time.Duration(i+1)*time.Second))
+	}
+	clock.Step(50 * time.Second)
+	cache.cleanInvalidToken()
+
+	total := 0
+	for _, shard := range cache.shards {
+		assert.Equal(t, len(shard.items), shard.lru.Len())
+		total += len(shard.items)
+	}
+	assert.Equal(t, 50, total)
+}
+
+func TestInMemoryCacheEviction(t *testing.T) {
+	cache, err := NewInMemoryCache(&InMemoryCacheOptions{MaxEntries: shardCount}, make(chan struct{}))
+	assert.Nil(t, err)
+	inMemory := cache.(*inMemoryCache)
+
+	// find three keys falling into the same shard, each shard holds one object
+	var keys []string
+	target := inMemory.shard("0")
+	for i := 0; len(keys) < 3; i++ {
+		if key := strconv.Itoa(i); inMemory.shard(key) == target {
+			keys = append(keys, key)
+		}
+	}
+
+	assert.Nil(t, cache.Set(keys[0], "0", NeverExpire))
+	assert.Nil(t, cache.Set(keys[1], "1", NeverExpire))
+	exists, _ := cache.Exists(keys[0])
+	assert.False(t, exists)
+	exists, _ = cache.Exists(keys[1])
+	assert.True(t, exists)
+	assert.Equal(t, 1, target.lru.Len())
+
+	// the recently used object survives
+	target.maxEntries = 2
+	assert.Nil(t, cache.Set(keys[0], "0", NeverExpire))
+	_, _ = cache.Get(keys[1])
+	assert.Nil(t, cache.Set(keys[2], "2", NeverExpire))
+	exists, _ = cache.Exists(keys[0])
+	assert.False(t, exists)
+	exists, _ = cache.Exists(keys[1], keys[2])
+	assert.True(t, exists)
+}
+
+func TestInMemoryCacheConcurrency(t *testing.T) {
+	cache, err := NewInMemoryCache(&InMemoryCacheOptions{CleanupPeriod: time.Millisecond, MaxEntries: 1000}, make(chan struct{}))
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("ai:user:%d:token:%d", g, i)
+				assert.Nil(t, cache.Set(key, key, time.Millisecond*time.Duration(i%5)))
+				_, _ = cache.Get(key)
+				_, _ = cache.Exists(key)
+				_ = cache.Expire(key, time.Second)
+				if i%50 == 0 {
+					_, err := cache.Keys(fmt.Sprintf("ai:user:%d:*", g))
+					assert.Nil(t, err)
+				}
+				if i%3 == 0 {
+					assert.Nil(t, cache.Del(key))
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	keys, err := cache.Keys("*")
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, len(keys), 1000)
+}
+
+func TestInMemoryCacheMaxEntries(t *testing.T) {
+	for _, maxEntries := range []int{1, 3, 31, 32, 33, 100} {
+		t.Run(strconv.Itoa(maxEntries), func(t *testing.T) {
+			cache := newInMemoryCache(maxEntries, time.Now)
+			total := 0
+			for _, shard := range cache.shards {
+				total += shard.maxEntries
+			}
+			assert.Equal(t, maxEntries, total)
+
+			for i := 0; i < 10*shardCount; i++ {
+				assert.Nil(t, cache.Set(strconv.Itoa(i), "value", NeverExpire))
+			}
+			keys, err := cache.Keys("*")
+			assert.Nil(t, err)
+			assert.LessOrEqual(t, len(keys), maxEntries)
+		})
+	}
+}
+
+func BenchmarkInMemoryCacheSet(b *testing.B) {
+	cache, _ := NewInMemoryCache(&InMemoryCacheOptions{MaxEntries: 100000}, make(chan struct{}))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			_ = cache.Set("ai:user:admin:token:"+strconv.Itoa(i), "", time.Hour)
+			i++
+		}
+	})
+}
+
+func BenchmarkInMemoryCacheGet(b *testing.B) {
+	cache, _ := NewInMemoryCache(nil, make(chan struct{}))
+	for i := 0; i < 10000; i++ {
+		_ = cache.Set("ai:user:admin:token:"+strconv.Itoa(i), "", time.Hour)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			_, _ = cache.Get("ai:user:admin:token:" + strconv.Itoa(i%10000))
+			i++
+		}
+	})
+}
+
+func BenchmarkInMemoryCacheKeys(b *testing.B) {
+	cache, _ := NewInMemoryCache(nil, make(chan struct{}))
+	for i := 0; i < 10000; i++ {
+		_ = cache.Set(fmt.Sprintf("ai:user:user%d:token:%d", i%100, i), "", time.Hour)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = cache.Keys("ai:user:user1:token:*")
+	}
+}
@@ -0,0 +1,70 @@
+package informers
+
+import (
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	aiinformers "github.com/wongearl/go-restful-template/pkg/client/ai/informers/externalversions"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8sinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// SubjectUserIndex indexes the role bindings by the names of the User subjects
+	SubjectUserIndex = "subject.user"
+	// SubjectGroupIndex indexes the role bindings by the names of the Group subjects
+	SubjectGroupIndex = "subject.group"
+	// UserEmailIndex indexes the users by email
+	UserEmailIndex = "user.email"
+)
+
+var subjectIndexers = cache.Indexers{
+	SubjectUserIndex:  subjectIndexFunc(rbacv1.UserKind),
+	SubjectGroupIndex: subjectIndexFunc(rbacv1.GroupKind),
+}
+
+// AddAiIndexers registers the indexers of the users and the global role bindings,
+// it must be called before the factory is started.
+func AddAiIndexers(factory aiinformers.SharedInformerFactory) error {
+	if err := factory.Iam().V1().GlobalRoleBindings().Informer().AddIndexers(subjectIndexers); err != nil {
+		return err
+	}
+	return factory.Iam().V1().Users().Informer().AddIndexers(cache.Indexers{UserEmailIndex: userEmailIndexFunc})
+}
+
+// AddKubernetesIndexers registers the indexers of the cluster role bindings and the role bindings,
+// it must be called before the factory is started.
+func AddKubernetesIndexers(factory k8sinformers.SharedInformerFactory) error {
+	if err := factory.Rbac().V1().ClusterRoleBindings().Informer().AddIndexers(subjectIndexers); err != nil {
+		return err
+	}
+	return factory.Rbac().V1().RoleBindings().Informer().AddIndexers(subjectIndexers)
+}
+
+func subjectIndexFunc(kind string) cache.IndexFunc {
+	return func(obj interface{}) ([]string, error) {
+		var subjects []rbacv1.Subject
+		switch binding := obj.(type) {
+		case *iamv1.GlobalRoleBinding:
+			subjects = binding.Subjects
+		case *rbacv1.ClusterRoleBinding:
+			subjects = binding.Subjects
+		case *rbacv1.RoleBinding:
+			subjects = binding.Subjects
+		}
+		var names []string
+		for _, subject := range subjects {
+			if subject.Kind == kind {
+				names = append(names, subject.Name)
+			}
+		}
+		return names, nil
+	}
+}
+
+func userEmailIndexFunc(obj interface{}) ([]string, error) {
+	if user, ok := obj.(*iamv1.User); ok && user.Spec.Email != "" {
+		return []string{user.Spec.Email}, nil
+	}
+	return nil, nil
+}
@@ -10,6 +10,7 @@ import (
 	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	"k8s.io/apiextensions-apiserver/pkg/client/informers/externalversions"
 	apiextensionsinformers "k8s.io/apiextensions-apiserver/pkg/client/informers/externalversions"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/informers"
 	k8sinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
@@ -41,6 +42,7 @@ func NewInformerFactories(client kubernetes.Interface, apiextensionsClient apiex
 
 	if client != nil {
 		factory.informerFactory = k8sinformers.NewSharedInformerFactory(client, defaultResync)
+		utilruntime.Must(AddKubernetesIndexers(factory.informerFactory))
 	}
 
 	if apiextensionsClient != nil {
@@ -49,6 +51,7 @@ func NewInformerFactories(client kubernetes.Interface, apiextensionsClient apiex
 
 	if aiClient != nil {
 		factory.alSharedInformerFactory = aiinformers.NewSharedInformerFactory(aiClient, defaultResync)
+		utilruntime.Must(AddAiIndexers(factory.alSharedInformerFactory))
 	}
 
 	return factory
@@ -2,16 +2,27 @@ package globalrole
 
 import (
 	"context"
+	"sort"
 
 	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
 
 	"github.com/go-logr/logr"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
+// GlobalRoleReconciler fills in the rules of the GlobalRoles with an aggregation rule
+// from the GlobalRoles matching its selectors, like the ClusterRole aggregation of Kubernetes.
 type GlobalRoleReconciler struct {
 	client.Client
 	Log    logr.Logger
@@ -19,7 +30,6 @@ type GlobalRoleReconciler struct {
 }
 
 func (r *GlobalRoleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = context.Background()
 	log := r.Log.WithValues("globalrole", req.NamespacedName)
 	globalRole := new(iamv1.GlobalRole)
 	var err error
@@ -31,12 +41,103 @@ func (r *GlobalRoleReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		log.Error(err, "unable to fetch globalrole")
 		return ctrl.Result{}, err
 	}
+
+	if globalRole.AggregationRule == nil {
+		return ctrl.Result{}, nil
+	}
+
+	var globalRoles iamv1.GlobalRoleList
+	if err = r.List(ctx, &globalRoles); err != nil {
+		log.Error(err, "unable to list globalroles")
+		return ctrl.Result{}, err
+	}
+	rules, err := aggregateRules(globalRole, globalRoles.Items)
+	if err != nil {
+		// the selectors are invalid, which can not be fixed by retrying
+		log.Error(err, "invalid aggregation rule")
+		return ctrl.Result{}, nil
+	}
+	if equality.Semantic.DeepEqual(globalRole.Rules, rules) {
+		return ctrl.Result{}, nil
+	}
+
+	patch := client.MergeFrom(globalRole.DeepCopy())
+	globalRole.Rules = rules
+	if err = r.Patch(ctx, globalRole, patch); err != nil {
+		log.Error(err, "unable to update the aggregated rules")
+		return ctrl.Result{}, err
+	}
+	log.V(1).Info("aggregated rules updated", "rules", len(rules))
 	return ctrl.Result{}, nil
+}
+
+// aggregateRules returns the rules of the GlobalRoles selected by the aggregation rule of the globalRole,
+// the GlobalRoles are visited by name so that the result is stable, and the duplicated rules are dropped.
+func aggregateRules(globalRole *iamv1.GlobalRole, globalRoles []iamv1.GlobalRole) ([]rbacv1.PolicyRule, error) {
+	var selectors []labels.Selector
+	for i := range globalRole.AggregationRule.ClusterRoleSelectors {
+		selector, err := metav1.LabelSelectorAsSelector(&globalRole.AggregationRule.ClusterRoleSelectors[i])
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, selector)
+	}
 
+	sort.Slice(globalRoles, func(i, j int) bool {
+		return globalRoles[i].Name < globalRoles[j].Name
+	})
+
+	rules := make([]rbacv1.PolicyRule, 0)
+	for _, aggregated := range globalRoles {
+		if aggregated.Name == globalRole.Name {
+			continue
+		}
+		for _, selector := range selectors {
+			if !selector.Matches(labels.Set(aggregated.Labels)) {
+				continue
+			}
+			for _, rule := range aggregated.Rules {
+				if !ruleExists(rules, rule) {
+					rules = append(rules, rule)
+				}
+			}
+			break
+		}
+	}
+	return rules, nil
 }
 
+func ruleExists(rules []rbacv1.PolicyRule, rule rbacv1.PolicyRule) bool {
+	for _, existing := range rules {
+		if equality.Semantic.DeepEqual(existing, rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetupWithManager sets up the controller with the Manager.
 func (r *GlobalRoleReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&iamv1.GlobalRole{}).
+		// the rules or the labels of any GlobalRole may change the aggregated rules
+		Watches(&source.Kind{Type: &iamv1.GlobalRole{}},
+			handler.EnqueueRequestsFromMapFunc(r.aggregatingGlobalRoles)).
 		Complete(r)
 }
+
+// aggregatingGlobalRoles maps the GlobalRole to all the GlobalRoles with an aggregation rule
+func (r *GlobalRoleReconciler) aggregatingGlobalRoles(obj client.Object) []reconcile.Request {
+	var globalRoles iamv1.GlobalRoleList
+	if err := r.List(context.Background(), &globalRoles); err != nil {
+		r.Log.Error(err, "unable to list globalroles")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, globalRole := range globalRoles.Items {
+		if globalRole.AggregationRule != nil && globalRole.Name != obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: globalRole.Name}})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,85 @@
+package globalrole
+
+import (
+	"context"
+	"testing"
+
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const aggregateToRegular = "iam.ai.io/aggregate-to-platform-regular"
+
+func newTestReconciler(t *testing.T, objects ...client.Object) *GlobalRoleReconciler {
+	scheme := runtime.NewScheme()
+	assert.Nil(t, iamv1.AddToScheme(scheme))
+	return &GlobalRoleReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Log:    logr.Discard(),
+		Scheme: scheme,
+	}
+}
+
+func newGlobalRole(name string, labels map[string]string, rules ...rbacv1.PolicyRule) *iamv1.GlobalRole {
+	return &iamv1.GlobalRole{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}, Rules: rules}
+}
+
+func reconcileGlobalRole(t *testing.T, r *GlobalRoleReconciler, name string) *iamv1.GlobalRole {
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: name}})
+	assert.Nil(t, err)
+	globalRole := &iamv1.GlobalRole{}
+	assert.Nil(t, r.Get(context.Background(), types.NamespacedName{Name: name}, globalRole))
+	return globalRole
+}
+
+func TestAggregateGlobalRoles(t *testing.T) {
+	viewUsers := rbacv1.PolicyRule{Verbs: []string{"get", "list"}, APIGroups: []string{"iam.ai.io"}, Resources: []string{"users"}}
+	viewDisks := rbacv1.PolicyRule{Verbs: []string{"get", "list"}, APIGroups: []string{"core.ai.io"}, Resources: []string{"disks"}}
+	regular := newGlobalRole(iamv1.PlatformRegular, nil,
+		rbacv1.PolicyRule{Verbs: []string{"delete"}, APIGroups: []string{"*"}, Resources: []string{"*"}})
+	regular.AggregationRule = &rbacv1.AggregationRule{ClusterRoleSelectors: []metav1.LabelSelector{
+		{MatchLabels: map[string]string{aggregateToRegular: "true"}},
+	}}
+	r := newTestReconciler(t, regular,
+		newGlobalRole("users-view", map[string]string{aggregateToRegular: "true"}, viewUsers),
+		newGlobalRole("disks-view", map[string]string{aggregateToRegular: "true"}, viewDisks, viewUsers),
+		newGlobalRole("disks-edit", nil, rbacv1.PolicyRule{Verbs: []string{"*"}, APIGroups: []string{"core.ai.io"}, Resources: []string{"disks"}}))
+
+	// the rules are replaced by the rules of the selected roles, sorted by the role name and without duplicates
+	globalRole := reconcileGlobalRole(t, r, iamv1.PlatformRegular)
+	assert.Equal(t, []rbacv1.PolicyRule{viewDisks, viewUsers}, globalRole.Rules)
+
+	// the roles without an aggregation rule are left alone
+	assert.Len(t, reconcileGlobalRole(t, r, "disks-view").Rules, 2)
+
+	// the rules are dropped once the role is not selected anymore
+	disksView := &iamv1.GlobalRole{}
+	assert.Nil(t, r.Get(context.Background(), types.NamespacedName{Name: "disks-view"}, disksView))
+	disksView.Labels = nil
+	assert.Nil(t, r.Update(context.Background(), disksView))
+	globalRole = reconcileGlobalRole(t, r, iamv1.PlatformRegular)
+	assert.Equal(t, []rbacv1.PolicyRule{viewUsers}, globalRole.Rules)
+
+	// every change of the GlobalRoles enqueues the aggregating roles
+	requests := r.aggregatingGlobalRoles(disksView)
+	assert.Len(t, requests, 1)
+	assert.Equal(t, iamv1.PlatformRegular, requests[0].Name)
+}
+
+func TestAggregateGlobalRolesWithInvalidSelector(t *testing.T) {
+	admin := newGlobalRole(iamv1.PlatformAdmin, nil)
+	admin.AggregationRule = &rbacv1.AggregationRule{ClusterRoleSelectors: []metav1.LabelSelector{
+		{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: aggregateToRegular, Operator: "Unknown"}}},
+	}}
+	r := newTestReconciler(t, admin)
+	assert.Empty(t, reconcileGlobalRole(t, r, iamv1.PlatformAdmin).Rules)
+}
@@ -39,9 +39,12 @@ func (r *LoginRecordController) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, nil
 	}
 
-	if err = r.updateUserLastLoginTime(loginRecord); err != nil {
-		log.Error(err, "updateUserLastLoginTime loginRecord:"+req.Name)
-		return ctrl.Result{}, err
+	// the service identities of the OAuth clients are not users
+	if loginRecord.Spec.Type != iamv1.ClientCredentials {
+		if err = r.updateUserLastLoginTime(loginRecord); err != nil {
+			log.Error(err, "updateUserLastLoginTime loginRecord:"+req.Name)
+			return ctrl.Result{}, err
+		}
 	}
 
 	now := time.Now()
@@ -5,18 +5,26 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/hasher"
+	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
 	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
 	"github.com/wongearl/go-restful-template/pkg/utils/sliceutil"
 
 	"github.com/go-logr/logr"
-	"golang.org/x/crypto/bcrypt"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
@@ -28,6 +36,10 @@ type UserReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+	// AuthenticationOptions provides the limits of failed login attempts
+	AuthenticationOptions *authoptions.AuthenticationOptions
+	// now returns the current time, replaced in tests
+	now func() time.Time
 }
 
 func (r *UserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -77,26 +89,59 @@ func (r *UserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		return ctrl.Result{}, nil
 	}
 
-	if err = r.encryptPassword(user); err != nil {
+	encrypted, err := r.encryptPassword(user)
+	if err != nil {
 		log.Error(err, "encryptPassword user:"+req.Name)
 		return ctrl.Result{}, err
 	}
+	// the user is synced again on the update event
+	if encrypted {
+		return ctrl.Result{}, nil
+	}
 
-	if err = r.syncUserStatus(user); err != nil {
+	expiresIn, err := r.syncPasswordExpiry(user)
+	if err != nil {
+		log.Error(err, "syncPasswordExpiry user:"+req.Name)
+		return ctrl.Result{}, err
+	}
+
+	requeueAfter, err := r.syncUserStatus(user)
+	if err != nil {
 		log.Error(err, "syncUserStatus user:"+req.Name)
 		return ctrl.Result{}, err
 	}
+	if requeueAfter == 0 || (expiresIn > 0 && expiresIn < requeueAfter) {
+		requeueAfter = expiresIn
+	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *UserReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&iamv1.User{}).
+		// failed login attempts may block the user
+		Watches(&source.Kind{Type: &iamv1.LoginRecord{}},
+			handler.EnqueueRequestsFromMapFunc(loginRecordToUser),
+			builder.WithPredicates(predicate.Funcs{
+				CreateFunc:  func(event.CreateEvent) bool { return true },
+				UpdateFunc:  func(event.UpdateEvent) bool { return false },
+				DeleteFunc:  func(event.DeleteEvent) bool { return false },
+				GenericFunc: func(event.GenericEvent) bool { return false },
+			})).
 		Complete(r)
 }
 
+// loginRecordToUser maps the login record to the user it belongs to
+func loginRecordToUser(obj client.Object) []reconcile.Request {
+	username := obj.GetLabels()[iamv1.UserReferenceLabel]
+	if username == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: username}}}
+}
+
 func (r *UserReconciler) deleteRoleBindings(user *iamv1.User) error {
 	var err error
 
@@ -127,11 +172,13 @@ func (r *UserReconciler) deleteLoginRecords(user *iamv1.User) error {
 	return nil
 }
 
-func (r *UserReconciler) encryptPassword(user *iamv1.User) error {
+// encryptPassword encrypts the plain text password and records the password change, true is returned if the password
+// is encrypted. The API hashes the passwords before they are stored, this is the fallback for the users applied directly.
+func (r *UserReconciler) encryptPassword(user *iamv1.User) (bool, error) {
 	if user.Spec.EncryptedPassword != "" && !isEncrypted(user.Spec.EncryptedPassword) {
-		password, err := encrypt(user.Spec.EncryptedPassword)
+		password, err := r.encrypt(user.Spec.EncryptedPassword)
 		if err != nil {
-			return err
+			return false, err
 		}
 		user = user.DeepCopy()
 		user.Spec.EncryptedPassword = password
@@ -140,79 +187,146 @@ func (r *UserReconciler) encryptPassword(user *iamv1.User) error {
 		}
 		// ensure plain text password won't be kept anywhere
 		delete(user.Annotations, corev1.LastAppliedConfigAnnotation)
-		return r.Update(context.Background(), user)
+		if err = r.Update(context.Background(), user); err != nil {
+			return false, err
+		}
+		user.Status.LastPasswordChangeTime = &metav1.Time{Time: r.clock()}
+		user.Status.MustChangePassword = false
+		return true, r.Status().Update(context.Background(), user)
 	}
-	return nil
+	return false, nil
+}
+
+// syncPasswordExpiry requires the user to change the password older than the max age of the password policy,
+// the duration until the password expires is returned.
+func (r *UserReconciler) syncPasswordExpiry(user *iamv1.User) (time.Duration, error) {
+	if !isEncrypted(user.Spec.EncryptedPassword) {
+		return 0, nil
+	}
+	now := r.clock()
+	changed := false
+	// the passwords set before the changes are recorded expire after the max age from now
+	if user.Status.LastPasswordChangeTime == nil {
+		user.Status.LastPasswordChangeTime = &metav1.Time{Time: now}
+		changed = true
+	}
+	var expiresIn time.Duration
+	if options := r.AuthenticationOptions; options != nil && options.PasswordPolicy != nil &&
+		options.PasswordPolicy.MaxAge > 0 && !user.Status.MustChangePassword {
+		expireAt := user.Status.LastPasswordChangeTime.Add(options.PasswordPolicy.MaxAge)
+		if now.Before(expireAt) {
+			expiresIn = expireAt.Sub(now)
+		} else {
+			user.Status.MustChangePassword = true
+			changed = true
+		}
+	}
+	if changed {
+		if err := r.Status().Update(context.Background(), user); err != nil {
+			return 0, err
+		}
+	}
+	return expiresIn, nil
 }
 
-func (r *UserReconciler) syncUserStatus(user *iamv1.User) error {
+// syncUserStatus activates the new users, and blocks the users whose failed login attempts reach
+// AuthenticateRateLimiterMaxTries in AuthenticateRateLimiterDuration for AuthenticateRateLimiterDuration.
+// The duration after which the user should be synced again is returned.
+func (r *UserReconciler) syncUserStatus(user *iamv1.User) (time.Duration, error) {
 	if user.Status.State != nil && *user.Status.State == iamv1.UserDisabled {
-		return nil
+		return 0, nil
 	}
 
-	if isEncrypted(user.Spec.EncryptedPassword) &&
-		user.Status.State == nil {
+	now := r.clock()
+	// the users provisioned by identity providers have no password
+	if user.Status.State == nil &&
+		(isEncrypted(user.Spec.EncryptedPassword) || user.Labels[iamv1.IdentifyProviderLabel] != "") {
 		expected := user.DeepCopy()
 		active := iamv1.UserActive
-		expected.Status = iamv1.UserStatus{
-			State:              &active,
-			LastTransitionTime: &metav1.Time{Time: time.Now()},
-		}
-		return r.Status().Update(context.Background(), expected)
+		expected.Status.State = &active
+		expected.Status.LastTransitionTime = &metav1.Time{Time: now}
+		return 0, r.Status().Update(context.Background(), expected)
+	}
+
+	options := r.AuthenticationOptions
+	if options == nil {
+		options = authoptions.NewAuthenticateOptions()
+	}
+	if options.AuthenticateRateLimiterMaxTries <= 0 || options.AuthenticateRateLimiterDuration <= 0 {
+		return 0, nil
 	}
 
 	if user.Status.State != nil && *user.Status.State == iamv1.UserAuthLimitExceeded {
-		if user.Status.LastTransitionTime != nil &&
-			user.Status.LastTransitionTime.Add(time.Minute*10).Before(time.Now()) {
-			expected := user.DeepCopy()
-			// unblock user
-			active := iamv1.UserActive
-			expected.Status = iamv1.UserStatus{
-				State:              &active,
-				LastTransitionTime: &metav1.Time{Time: time.Now()},
-			}
-			return r.Status().Update(context.Background(), expected)
+		var blockedAt time.Time
+		if user.Status.LastTransitionTime != nil {
+			blockedAt = user.Status.LastTransitionTime.Time
 		}
+		if unblockAt := blockedAt.Add(options.AuthenticateRateLimiterDuration); now.Before(unblockAt) {
+			return unblockAt.Sub(now), nil
+		}
+		expected := user.DeepCopy()
+		// unblock user
+		active := iamv1.UserActive
+		expected.Status.State = &active
+		expected.Status.Reason = ""
+		expected.Status.LastTransitionTime = &metav1.Time{Time: now}
+		return 0, r.Status().Update(context.Background(), expected)
 	}
 
-	var records iamv1.LoginRecordList
+	if user.Status.State == nil || *user.Status.State != iamv1.UserActive {
+		return 0, nil
+	}
 
+	var records iamv1.LoginRecordList
 	err := r.List(context.Background(), &records, client.MatchingLabels{iamv1.UserReferenceLabel: user.Name})
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	now := time.Now()
+	// the attempts before the user is activated or unblocked are not counted
+	since := now.Add(-options.AuthenticateRateLimiterDuration)
+	if user.Status.LastTransitionTime != nil && user.Status.LastTransitionTime.After(since) {
+		since = user.Status.LastTransitionTime.Time
+	}
 	failedLoginAttempts := 0
 	for _, loginRecord := range records.Items {
-		if !loginRecord.Spec.Success &&
-			loginRecord.CreationTimestamp.Add(time.Minute*10).After(now) {
+		if !loginRecord.Spec.Success && !loginRecord.CreationTimestamp.Time.Before(since) {
 			failedLoginAttempts++
 		}
 	}
 
-	if failedLoginAttempts >= 10 {
+	if failedLoginAttempts >= options.AuthenticateRateLimiterMaxTries {
 		expect := user.DeepCopy()
 		limitExceed := iamv1.UserAuthLimitExceeded
-		expect.Status = iamv1.UserStatus{
-			State:              &limitExceed,
-			Reason:             fmt.Sprintf("Failed login attempts exceed %d in last 10min", failedLoginAttempts),
-			LastTransitionTime: &metav1.Time{Time: time.Now()},
+		expect.Status.State = &limitExceed
+		expect.Status.Reason = fmt.Sprintf("Failed login attempts exceed %d in last %s", failedLoginAttempts, options.AuthenticateRateLimiterDuration)
+		expect.Status.LastTransitionTime = &metav1.Time{Time: now}
+		if err = r.Status().Update(context.Background(), expect); err != nil {
+			return 0, err
 		}
-		return r.Status().Update(context.Background(), expect)
+		return options.AuthenticateRateLimiterDuration, nil
 	}
-	return nil
+	return 0, nil
+}
 
+func (r *UserReconciler) clock() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
 }
 
-func encrypt(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+// encrypt hashes the password with the configured algorithm
+func (r *UserReconciler) encrypt(password string) (string, error) {
+	var options *hasher.Options
+	if r.AuthenticationOptions != nil {
+		options = r.AuthenticationOptions.PasswordHashing
+	}
+	return hasher.New(options).Hash(password)
 }
 
+// isEncrypted returns true if the password is a well-formed hash, the malformed hashes and the hashes of
+// unbounded costs are encrypted as plain text. The plain text like a hash is refused by auth.ValidatePassword.
 func isEncrypted(password string) bool {
-	// bcrypt.Cost returns the hashing cost used to create the given hashed
-	cost, _ := bcrypt.Cost([]byte(password))
-	// cost > 0 means the password has been encrypted
-	return cost > 0
+	return hasher.IsHash(password)
 }
@@ -0,0 +1,246 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/hasher"
+	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var now = time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestReconciler(t *testing.T, objects ...client.Object) *UserReconciler {
+	scheme := runtime.NewScheme()
+	assert.Nil(t, iamv1.AddToScheme(scheme))
+	options := authoptions.NewAuthenticateOptions()
+	options.AuthenticateRateLimiterMaxTries = 3
+	options.AuthenticateRateLimiterDuration = 10 * time.Minute
+	return &UserReconciler{
+		Client:                fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Log:                   logr.Discard(),
+		Scheme:                scheme,
+		AuthenticationOptions: options,
+		now:                   func() time.Time { return now },
+	}
+}
+
+func newUser(name string, state iamv1.UserState, transition time.Time) *iamv1.User {
+	encrypted, _ := bcrypt.GenerateFromPassword([]byte("P@88w0rd"), bcrypt.MinCost)
+	return &iamv1.User{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Finalizers: []string{finalizer}},
+		Spec:       iamv1.UserSpec{EncryptedPassword: string(encrypted)},
+		Status: iamv1.UserStatus{
+			State:              &state,
+			LastTransitionTime: &metav1.Time{Time: transition},
+		},
+	}
+}
+
+func newLoginRecords(username string, success bool, created ...time.Time) []client.Object {
+	var records []client.Object
+	for i, creationTimestamp := range created {
+		records = append(records, &iamv1.LoginRecord{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              fmt.Sprintf("%s-%t-%d", username, success, i),
+				Labels:            map[string]string{iamv1.UserReferenceLabel: username},
+				CreationTimestamp: metav1.Time{Time: creationTimestamp},
+			},
+			Spec: iamv1.LoginRecordSpec{Type: iamv1.Token, Success: success},
+		})
+	}
+	return records
+}
+
+func reconcileUser(t *testing.T, r *UserReconciler, name string) (ctrl.Result, *iamv1.User) {
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: name}})
+	assert.Nil(t, err)
+	user := &iamv1.User{}
+	assert.Nil(t, r.Get(context.Background(), types.NamespacedName{Name: name}, user))
+	return result, user
+}
+
+func TestActivateUser(t *testing.T) {
+	created := newUser("admin", "", now)
+	created.Status = iamv1.UserStatus{}
+	provisioned := &iamv1.User{ObjectMeta: metav1.ObjectMeta{Name: "alice", Finalizers: []string{finalizer},
+		Labels: map[string]string{iamv1.IdentifyProviderLabel: "github"}}}
+	pending := &iamv1.User{ObjectMeta: metav1.ObjectMeta{Name: "bob", Finalizers: []string{finalizer}}}
+	r := newTestReconciler(t, created, provisioned, pending)
+
+	_, user := reconcileUser(t, r, "admin")
+	assert.Equal(t, iamv1.UserActive, *user.Status.State)
+	assert.Equal(t, now, user.Status.LastTransitionTime.Time.UTC())
+	_, user = reconcileUser(t, r, "alice")
+	assert.Equal(t, iamv1.UserActive, *user.Status.State)
+	_, user = reconcileUser(t, r, "bob")
+	assert.Nil(t, user.Status.State)
+}
+
+func TestBlockUser(t *testing.T) {
+	tests := []struct {
+		name         string
+		user         *iamv1.User
+		records      []client.Object
+		state        iamv1.UserState
+		requeueAfter time.Duration
+	}{{
+		name:    "failed attempts below the limit",
+		user:    newUser("admin", iamv1.UserActive, now.Add(-time.Hour)),
+		records: newLoginRecords("admin", false, now.Add(-time.Minute), now.Add(-2*time.Minute)),
+		state:   iamv1.UserActive,
+	}, {
+		name:         "failed attempts reach the limit",
+		user:         newUser("admin", iamv1.UserActive, now.Add(-time.Hour)),
+		records:      newLoginRecords("admin", false, now, now.Add(-time.Minute), now.Add(-10*time.Minute)),
+		state:        iamv1.UserAuthLimitExceeded,
+		requeueAfter: 10 * time.Minute,
+	}, {
+		name:    "failed attempts out of the duration",
+		user:    newUser("admin", iamv1.UserActive, now.Add(-time.Hour)),
+		records: newLoginRecords("admin", false, now, now.Add(-time.Minute), now.Add(-11*time.Minute)),
+		state:   iamv1.UserActive,
+	}, {
+		name:    "successful attempts",
+		user:    newUser("admin", iamv1.UserActive, now.Add(-time.Hour)),
+		records: append(newLoginRecords("admin", false, now, now.Add(-time.Minute)), newLoginRecords("admin", true, now)...),
+		state:   iamv1.UserActive,
+	}, {
+		name:    "failed attempts before unlocked",
+		user:    newUser("admin", iamv1.UserActive, now.Add(-time.Minute)),
+		records: newLoginRecords("admin", false, now, now.Add(-2*time.Minute), now.Add(-3*time.Minute)),
+		state:   iamv1.UserActive,
+	}, {
+		name:         "blocked",
+		user:         newUser("admin", iamv1.UserAuthLimitExceeded, now.Add(-4*time.Minute)),
+		records:      newLoginRecords("admin", false, now, now.Add(-time.Minute), now.Add(-2*time.Minute)),
+		state:        iamv1.UserAuthLimitExceeded,
+		requeueAfter: 6 * time.Minute,
+	}, {
+		name:    "block expired",
+		user:    newUser("admin", iamv1.UserAuthLimitExceeded, now.Add(-10*time.Minute)),
+		records: newLoginRecords("admin", false, now.Add(-10*time.Minute), now.Add(-10*time.Minute), now.Add(-10*time.Minute)),
+		state:   iamv1.UserActive,
+	}, {
+		name:    "disabled",
+		user:    newUser("admin", iamv1.UserDisabled, now.Add(-time.Hour)),
+		records: newLoginRecords("admin", false, now, now, now),
+		state:   iamv1.UserDisabled,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReconciler(t, append(tt.records, tt.user)...)
+			result, user := reconcileUser(t, r, tt.user.Name)
+			assert.Equal(t, tt.state, *user.Status.State)
+			assert.Equal(t, tt.requeueAfter, result.RequeueAfter)
+		})
+	}
+}
+
+func TestBlockUserWithConfiguredLimits(t *testing.T) {
+	records := newLoginRecords("admin", false, now, now.Add(-time.Minute), now.Add(-2*time.Minute))
+	r := newTestReconciler(t, append(records, newUser("admin", iamv1.UserActive, now.Add(-time.Hour)))...)
+	r.AuthenticationOptions.AuthenticateRateLimiterMaxTries = 5
+	_, user := reconcileUser(t, r, "admin")
+	assert.Equal(t, iamv1.UserActive, *user.Status.State)
+
+	r.AuthenticationOptions.AuthenticateRateLimiterMaxTries = 2
+	r.AuthenticationOptions.AuthenticateRateLimiterDuration = time.Minute
+	result, user := reconcileUser(t, r, "admin")
+	assert.Equal(t, iamv1.UserAuthLimitExceeded, *user.Status.State)
+	assert.Equal(t, time.Minute, result.RequeueAfter)
+
+	// unblocked exactly when the block expires
+	r.now = func() time.Time { return now.Add(time.Minute - time.Second) }
+	result, user = reconcileUser(t, r, "admin")
+	assert.Equal(t, iamv1.UserAuthLimitExceeded, *user.Status.State)
+	assert.Equal(t, time.Second, result.RequeueAfter)
+	r.now = func() time.Time { return now.Add(time.Minute) }
+	_, user = reconcileUser(t, r, "admin")
+	assert.Equal(t, iamv1.UserActive, *user.Status.State)
+
+	// the lockout is disabled
+	r.AuthenticationOptions.AuthenticateRateLimiterMaxTries = 0
+	for _, record := range newLoginRecords("admin", false, now.Add(time.Minute), now.Add(time.Minute)) {
+		record.SetName(record.GetName() + "-unlocked")
+		assert.Nil(t, r.Create(context.Background(), record))
+	}
+	_, user = reconcileUser(t, r, "admin")
+	assert.Equal(t, iamv1.UserActive, *user.Status.State)
+}
+
+func TestLoginRecordToUser(t *testing.T) {
+	records := newLoginRecords("admin", false, now)
+	assert.Equal(t, []ctrl.Request{{NamespacedName: types.NamespacedName{Name: "admin"}}}, loginRecordToUser(records[0]))
+	assert.Empty(t, loginRecordToUser(&iamv1.LoginRecord{}))
+}
+
+func TestPasswordExpiry(t *testing.T) {
+	user := newUser("admin", iamv1.UserActive, now.Add(-time.Hour))
+	r := newTestReconciler(t, user)
+	r.AuthenticationOptions.PasswordPolicy.MaxAge = 24 * time.Hour
+
+	// the password set before is considered changed now
+	result, user := reconcileUser(t, r, "admin")
+	assert.Equal(t, now, user.Status.LastPasswordChangeTime.Time.UTC())
+	assert.Equal(t, 24*time.Hour, result.RequeueAfter)
+	assert.Equal(t, iamv1.UserActive, *user.Status.State)
+
+	r.now = func() time.Time { return now.Add(24 * time.Hour) }
+	result, user = reconcileUser(t, r, "admin")
+	assert.True(t, user.Status.MustChangePassword)
+	assert.Equal(t, time.Duration(0), result.RequeueAfter)
+	assert.Equal(t, iamv1.UserActive, *user.Status.State)
+
+	// the password is changed
+	user.Spec.EncryptedPassword = "P@88w0rd-1"
+	assert.Nil(t, r.Update(context.Background(), user))
+	_, user = reconcileUser(t, r, "admin")
+	assert.Nil(t, bcrypt.CompareHashAndPassword([]byte(user.Spec.EncryptedPassword), []byte("P@88w0rd-1")))
+	assert.False(t, user.Status.MustChangePassword)
+	assert.Equal(t, now.Add(24*time.Hour), user.Status.LastPasswordChangeTime.Time.UTC())
+	result, _ = reconcileUser(t, r, "admin")
+	assert.Equal(t, 24*time.Hour, result.RequeueAfter)
+}
+
+func TestEncryptPasswordWithArgon2id(t *testing.T) {
+	user := newUser("admin", iamv1.UserActive, now.Add(-time.Hour))
+	user.Spec.EncryptedPassword = "P@88w0rd"
+	r := newTestReconciler(t, user)
+	r.AuthenticationOptions.PasswordHashing = &hasher.Options{
+		Algorithm: hasher.AlgorithmArgon2id,
+		Argon2id:  &hasher.Argon2idOptions{Time: 1, Memory: 1024, Threads: 1, KeyLength: 32, SaltLength: 16},
+	}
+
+	_, user = reconcileUser(t, r, "admin")
+	assert.True(t, strings.HasPrefix(user.Spec.EncryptedPassword, "$argon2id$"))
+	assert.Nil(t, hasher.Verify(user.Spec.EncryptedPassword, "P@88w0rd"))
+	// the hash is not encrypted again
+	_, encrypted := reconcileUser(t, r, "admin")
+	assert.Equal(t, user.Spec.EncryptedPassword, encrypted.Spec.EncryptedPassword)
+}
+
+func TestEncryptPasswordLikeHash(t *testing.T) {
+	// the argon2id parameters of the plain text are out of bounds, the verification would panic
+	plaintext := "$argon2id$v=19$m=99999999,t=1,p=0$c2FsdA$aGFzaA"
+	user := newUser("admin", iamv1.UserActive, now.Add(-time.Hour))
+	user.Spec.EncryptedPassword = plaintext
+	r := newTestReconciler(t, user)
+
+	_, user = reconcileUser(t, r, "admin")
+	assert.Nil(t, bcrypt.CompareHashAndPassword([]byte(user.Spec.EncryptedPassword), []byte(plaintext)))
+}
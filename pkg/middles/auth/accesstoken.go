@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	ai "github.com/wongearl/go-restful-template/pkg/client/ai/clientset/versioned"
+	iamv1listers "github.com/wongearl/go-restful-template/pkg/client/ai/listers/iam.ai.io/v1"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/klog"
+)
+
+const (
+	// AccessTokenPrefix distinguishes the personal access tokens from the jwt tokens,
+	// the token looks like aipat_<name>_<secret>
+	AccessTokenPrefix = "aipat_"
+	// accessTokenSecretLength is the number of the random bytes of the secret
+	accessTokenSecretLength = 32
+	// accessTokenUsageInterval throttles the updates of the last used time
+	accessTokenUsageInterval = time.Minute
+)
+
+var (
+	InvalidAccessTokenError = fmt.Errorf("access token is invalid")
+	AccessTokenExpiredError = fmt.Errorf("access token has expired")
+)
+
+// AccessTokenRequest describes the personal access token to create
+type AccessTokenRequest struct {
+	DisplayName string `json:"displayName"`
+	// Scopes must be a subset of the rules of the user, the token has all the rules of the user if it is empty
+	Scopes []rbacv1.PolicyRule `json:"scopes,omitempty"`
+	// ExpirationTime defaults to the maximum lifetime of the tokens
+	ExpirationTime *metav1.Time `json:"expirationTime,omitempty"`
+}
+
+// IssuedAccessToken is returned only once on creation, the token can not be retrieved later
+type IssuedAccessToken struct {
+	Token       string             `json:"token"`
+	AccessToken *iamv1.AccessToken `json:"accessToken"`
+}
+
+// AccessTokenManagementInterface manages the personal access tokens of the users
+type AccessTokenManagementInterface interface {
+	Create(username string, request *AccessTokenRequest) (*IssuedAccessToken, error)
+	List(username string) ([]iamv1.AccessToken, error)
+	Revoke(username string, name string) error
+	// Verify returns the access token of the bearer token and records the usage
+	Verify(token string) (*iamv1.AccessToken, error)
+}
+
+type accessTokenOperator struct {
+	aiClient          ai.Interface
+	accessTokenLister iamv1listers.AccessTokenLister
+	authOptions       *authoptions.AuthenticationOptions
+	now               func() time.Time
+}
+
+func NewAccessTokenOperator(aiClient ai.Interface, accessTokenLister iamv1listers.AccessTokenLister,
+	authOptions *authoptions.AuthenticationOptions) AccessTokenManagementInterface {
+	return &accessTokenOperator{
+		aiClient:          aiClient,
+		accessTokenLister: accessTokenLister,
+		authOptions:       authOptions,
+		now:               time.Now,
+	}
+}
+
+// IsAccessToken returns true if the bearer token is a personal access token
+func IsAccessToken(token string) bool {
+	return strings.HasPrefix(token, AccessTokenPrefix)
+}
+
+func (o *accessTokenOperator) Create(username string, request *AccessTokenRequest) (*IssuedAccessToken, error) {
+	if _, err := o.aiClient.IamV1().Users().Get(context.Background(), username, metav1.GetOptions{}); err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	if request.DisplayName == "" {
+		return nil, errors.NewBadRequest("the name of the access token is required")
+	}
+	if err := validateScopes(request.Scopes); err != nil {
+		return nil, err
+	}
+
+	now := o.now()
+	expirationTime := request.ExpirationTime
+	maxAge := o.authOptions.PersonalAccessTokenMaxAge
+	if expirationTime == nil && maxAge > 0 {
+		expirationTime = &metav1.Time{Time: now.Add(maxAge)}
+	}
+	if expirationTime != nil {
+		if !expirationTime.After(now) {
+			return nil, errors.NewBadRequest("the expiration time must be in the future")
+		}
+		if maxAge > 0 && expirationTime.After(now.Add(maxAge)) {
+			return nil, errors.NewBadRequest(fmt.Sprintf("the lifetime of access tokens must not exceed %s", maxAge))
+		}
+	}
+
+	secret := make([]byte, accessTokenSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	encodedSecret := hex.EncodeToString(secret)
+	accessToken := &iamv1.AccessToken{
+		ObjectMeta: metav1.ObjectMeta{
+			// the name is part of the token, '@' is not allowed in Kubernetes object name
+			Name:   fmt.Sprintf("%s-%s", strings.Replace(username, "@", "-", -1), utilrand.String(5)),
+			Labels: map[string]string{iamv1.UserReferenceLabel: username},
+		},
+		Spec: iamv1.AccessTokenSpec{
+			Username:       username,
+			DisplayName:    request.DisplayName,
+			Scopes:         request.Scopes,
+			ExpirationTime: expirationTime,
+			TokenHash:      hashAccessTokenSecret(encodedSecret),
+		},
+	}
+	created, err := o.aiClient.IamV1().AccessTokens().Create(context.Background(), accessToken, metav1.CreateOptions{})
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	return &IssuedAccessToken{
+		Token:       fmt.Sprintf("%s%s_%s", AccessTokenPrefix, created.Name, encodedSecret),
+		AccessToken: created,
+	}, nil
+}
+
+func (o *accessTokenOperator) List(username string) ([]iamv1.AccessToken, error) {
+	accessTokens, err := o.accessTokenLister.List(labels.SelectorFromSet(labels.Set{iamv1.UserReferenceLabel: username}))
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	result := make([]iamv1.AccessToken, 0, len(accessTokens))
+	for _, accessToken := range accessTokens {
+		result = append(result, *accessToken)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreationTimestamp.After(result[j].CreationTimestamp.Time)
+	})
+	return result, nil
+}
+
+func (o *accessTokenOperator) Revoke(username string, name string) error {
+	accessToken, err := o.accessTokenLister.Get(name)
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+	if accessToken.Spec.Username != username {
+		return errors.NewNotFound(iamv1.Resource(iamv1.ResourcePluralAccessToken), name)
+	}
+	err = o.aiClient.IamV1().AccessTokens().Delete(context.Background(), name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		klog.Error(err)
+		return err
+	}
+	return nil
+}
+
+func (o *accessTokenOperator) Verify(token string) (*iamv1.AccessToken, error) {
+	name, secret, ok := strings.Cut(strings.TrimPrefix(token, AccessTokenPrefix), "_")
+	if !IsAccessToken(token) || !ok || name == "" || secret == "" {
+		return nil, InvalidAccessTokenError
+	}
+	accessToken, err := o.accessTokenLister.Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, InvalidAccessTokenError
+		}
+		klog.Error(err)
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAccessTokenSecret(secret)), []byte(accessToken.Spec.TokenHash)) != 1 {
+		return nil, InvalidAccessTokenError
+	}
+	now := o.now()
+	if accessToken.Spec.ExpirationTime != nil && !now.Before(accessToken.Spec.ExpirationTime.Time) {
+		return nil, AccessTokenExpiredError
+	}
+	o.recordUsage(accessToken, now)
+	return accessToken, nil
+}
+
+// recordUsage updates the last used time at most once per accessTokenUsageInterval,
+// the failure is logged only since the token is valid.
+func (o *accessTokenOperator) recordUsage(accessToken *iamv1.AccessToken, now time.Time) {
+	if lastUsed := accessToken.Status.LastUsedTime; lastUsed != nil && now.Sub(lastUsed.Time) < accessTokenUsageInterval {
+		return
+	}
+	accessToken = accessToken.DeepCopy()
+	accessToken.Status.LastUsedTime = &metav1.Time{Time: now}
+	if _, err := o.aiClient.IamV1().AccessTokens().UpdateStatus(context.Background(), accessToken, metav1.UpdateOptions{}); err != nil {
+		klog.Warningf("failed to record the usage of access token %s: %s", accessToken.Name, err)
+	}
+}
+
+func validateScopes(scopes []rbacv1.PolicyRule) error {
+	for _, scope := range scopes {
+		if len(scope.Verbs) == 0 {
+			return errors.NewBadRequest("the verbs of the scope are required")
+		}
+		if len(scope.NonResourceURLs) > 0 {
+			if len(scope.Resources) > 0 || len(scope.APIGroups) > 0 || len(scope.ResourceNames) > 0 {
+				return errors.NewBadRequest("the scope can not apply to both resources and non-resource urls")
+			}
+			continue
+		}
+		if len(scope.Resources) == 0 || len(scope.APIGroups) == 0 {
+			return errors.NewBadRequest("the resources and the api groups of the scope are required")
+		}
+	}
+	return nil
+}
+
+func hashAccessTokenSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	aifake "github.com/wongearl/go-restful-template/pkg/client/ai/clientset/versioned/fake"
+	aiinformers "github.com/wongearl/go-restful-template/pkg/client/ai/informers/externalversions"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestAccessTokenOperator(t *testing.T) (*accessTokenOperator, *aifake.Clientset) {
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	aiClient := aifake.NewSimpleClientset(
+		&iamv1.User{ObjectMeta: metav1.ObjectMeta{Name: "alice"}},
+		&iamv1.User{ObjectMeta: metav1.ObjectMeta{Name: "bob"}})
+	informerFactory := aiinformers.NewSharedInformerFactory(aiClient, 0)
+	accessTokenLister := informerFactory.Iam().V1().AccessTokens().Lister()
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+
+	authOptions := authoptions.NewAuthenticateOptions()
+	authOptions.PersonalAccessTokenMaxAge = 24 * time.Hour
+	operator := NewAccessTokenOperator(aiClient, accessTokenLister, authOptions).(*accessTokenOperator)
+	return operator, aiClient
+}
+
+// createAccessToken waits until the token is synced to the lister
+func createAccessToken(t *testing.T, operator *accessTokenOperator, username string, request *AccessTokenRequest) *IssuedAccessToken {
+	issued, err := operator.Create(username, request)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := operator.accessTokenLister.Get(issued.AccessToken.Name)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	return issued
+}
+
+func TestAccessToken(t *testing.T) {
+	operator, aiClient := newTestAccessTokenOperator(t)
+	now := time.Now()
+	operator.now = func() time.Time { return now }
+
+	scopes := []rbacv1.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{"*"}, Resources: []string{"users"}}}
+	issued := createAccessToken(t, operator, "alice", &AccessTokenRequest{DisplayName: "ci", Scopes: scopes})
+	assert.True(t, IsAccessToken(issued.Token))
+	assert.True(t, strings.HasPrefix(issued.Token, AccessTokenPrefix+"alice-"))
+	assert.Equal(t, "alice", issued.AccessToken.Spec.Username)
+	assert.Equal(t, "alice", issued.AccessToken.Labels[iamv1.UserReferenceLabel])
+	assert.Equal(t, scopes, issued.AccessToken.Spec.Scopes)
+	// the expiration time defaults to the max age
+	assert.Equal(t, now.Add(24*time.Hour).Unix(), issued.AccessToken.Spec.ExpirationTime.Unix())
+	// only the hash of the secret is stored
+	assert.NotContains(t, issued.Token, issued.AccessToken.Spec.TokenHash)
+	assert.Len(t, issued.AccessToken.Spec.TokenHash, 64)
+
+	accessToken, err := operator.Verify(issued.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, issued.AccessToken.Name, accessToken.Name)
+	stored, err := aiClient.IamV1().AccessTokens().Get(context.Background(), accessToken.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, now.Unix(), stored.Status.LastUsedTime.Unix())
+
+	invalid := []string{
+		"",
+		issued.Token + "0",
+		AccessTokenPrefix + issued.AccessToken.Name,
+		AccessTokenPrefix + "carol-abcde_" + strings.Repeat("0", 64),
+		strings.TrimPrefix(issued.Token, AccessTokenPrefix),
+	}
+	for _, token := range invalid {
+		_, err = operator.Verify(token)
+		assert.Equal(t, InvalidAccessTokenError, err, token)
+	}
+
+	operator.now = func() time.Time { return now.Add(24 * time.Hour) }
+	_, err = operator.Verify(issued.Token)
+	assert.Equal(t, AccessTokenExpiredError, err)
+}
+
+func TestCreateAccessTokenValidation(t *testing.T) {
+	operator, _ := newTestAccessTokenOperator(t)
+	tomorrow := metav1.NewTime(time.Now().Add(24 * time.Hour))
+	yesterday := metav1.NewTime(time.Now().Add(-24 * time.Hour))
+	nextWeek := metav1.NewTime(time.Now().Add(7 * 24 * time.Hour))
+
+	tests := []struct {
+		name     string
+		username string
+		request  AccessTokenRequest
+		valid    bool
+	}{
+		{"valid", "alice", AccessTokenRequest{DisplayName: "ci", ExpirationTime: &tomorrow}, true},
+		{"user not found", "carol", AccessTokenRequest{DisplayName: "ci"}, false},
+		{"name required", "alice", AccessTokenRequest{}, false},
+		{"expired", "alice", AccessTokenRequest{DisplayName: "ci", ExpirationTime: &yesterday}, false},
+		{"exceeds max age", "alice", AccessTokenRequest{DisplayName: "ci", ExpirationTime: &nextWeek}, false},
+		{"verbs required", "alice", AccessTokenRequest{DisplayName: "ci",
+			Scopes: []rbacv1.PolicyRule{{APIGroups: []string{"*"}, Resources: []string{"users"}}}}, false},
+		{"resources required", "alice", AccessTokenRequest{DisplayName: "ci",
+			Scopes: []rbacv1.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{"*"}}}}, false},
+		{"mixed urls and resources", "alice", AccessTokenRequest{DisplayName: "ci",
+			Scopes: []rbacv1.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{"*"}, Resources: []string{"users"}, NonResourceURLs: []string{"/healthz"}}}}, false},
+		{"non-resource urls", "alice", AccessTokenRequest{DisplayName: "ci",
+			Scopes: []rbacv1.PolicyRule{{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz"}}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := operator.Create(tt.username, &tt.request)
+			assert.Equal(t, tt.valid, err == nil, err)
+		})
+	}
+
+	// the tokens never expire without the max age
+	operator.authOptions.PersonalAccessTokenMaxAge = 0
+	issued, err := operator.Create("alice", &AccessTokenRequest{DisplayName: "ci"})
+	assert.Nil(t, err)
+	assert.Nil(t, issued.AccessToken.Spec.ExpirationTime)
+}
+
+func TestListAndRevokeAccessTokens(t *testing.T) {
+	operator, _ := newTestAccessTokenOperator(t)
+	first := createAccessToken(t, operator, "alice", &AccessTokenRequest{DisplayName: "first"})
+	createAccessToken(t, operator, "alice", &AccessTokenRequest{DisplayName: "second"})
+	bobs := createAccessToken(t, operator, "bob", &AccessTokenRequest{DisplayName: "first"})
+
+	accessTokens, err := operator.List("alice")
+	assert.Nil(t, err)
+	assert.Len(t, accessTokens, 2)
+	for _, accessToken := range accessTokens {
+		assert.Equal(t, "alice", accessToken.Spec.Username)
+	}
+
+	// the token of another user can not be revoked
+	assert.NotNil(t, operator.Revoke("alice", bobs.AccessToken.Name))
+	assert.Nil(t, operator.Revoke("alice", first.AccessToken.Name))
+	assert.Eventually(t, func() bool {
+		accessTokens, _ := operator.List("alice")
+		return len(accessTokens) == 1
+	}, time.Second, 10*time.Millisecond)
+	_, err = operator.Verify(first.Token)
+	assert.Equal(t, InvalidAccessTokenError, err)
+	_, err = operator.Verify(bobs.Token)
+	assert.Nil(t, err)
+}
+
+func TestAccessTokenUsageThrottled(t *testing.T) {
+	operator, aiClient := newTestAccessTokenOperator(t)
+	now := time.Now()
+	operator.now = func() time.Time { return now }
+	issued := createAccessToken(t, operator, "alice", &AccessTokenRequest{DisplayName: "ci"})
+
+	lastUsed := metav1.NewTime(now.Add(-30 * time.Second))
+	issued.AccessToken.Status.LastUsedTime = &lastUsed
+	_, err := aiClient.IamV1().AccessTokens().UpdateStatus(context.Background(), issued.AccessToken, metav1.UpdateOptions{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		accessToken, _ := operator.accessTokenLister.Get(issued.AccessToken.Name)
+		return accessToken.Status.LastUsedTime != nil
+	}, time.Second, 10*time.Millisecond)
+	actions := len(aiClient.Actions())
+
+	_, err = operator.Verify(issued.Token)
+	assert.Nil(t, err)
+	assert.Len(t, aiClient.Actions(), actions)
+
+	now = now.Add(time.Minute)
+	_, err = operator.Verify(issued.Token)
+	assert.Nil(t, err)
+	assert.Len(t, aiClient.Actions(), actions+1)
+}
@@ -4,18 +4,22 @@ import (
 	"context"
 	"fmt"
 	"net/mail"
+	"time"
 
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/hasher"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/identityprovider"
 	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/config"
 	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
 	ai "github.com/wongearl/go-restful-template/pkg/client/ai/clientset/versioned"
+	iamv1informers "github.com/wongearl/go-restful-template/pkg/client/ai/informers/externalversions/iam.ai.io/v1"
 	iamv1listers "github.com/wongearl/go-restful-template/pkg/client/ai/listers/iam.ai.io/v1"
+	"github.com/wongearl/go-restful-template/pkg/client/informers"
 
-	"golang.org/x/crypto/bcrypt"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
 	authuser "k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
 )
 
@@ -34,21 +38,29 @@ type passwordAuthenticator struct {
 	userGetter  *userGetter
 	authOptions *authoptions.AuthenticationOptions
 	alOptions   *config.AiOptions
+	hasher      hasher.Hasher
 }
 
 type userGetter struct {
 	userLister iamv1listers.UserLister
+	// userIndexer looks up the users by email, see informers.UserEmailIndex
+	userIndexer cache.Indexer
+}
+
+func newUserGetter(userInformer iamv1informers.UserInformer) *userGetter {
+	return &userGetter{userLister: userInformer.Lister(), userIndexer: userInformer.Informer().GetIndexer()}
 }
 
 func NewPasswordAuthenticator(aiClient ai.Interface,
-	userLister iamv1listers.UserLister,
+	userInformer iamv1informers.UserInformer,
 	authOptions *authoptions.AuthenticationOptions,
 	alOptions *config.AiOptions) PasswordAuthenticator {
 	passwordAuthenticator := &passwordAuthenticator{
 		aiClient:    aiClient,
-		userGetter:  &userGetter{userLister: userLister},
+		userGetter:  newUserGetter(userInformer),
 		authOptions: authOptions,
 		alOptions:   alOptions,
+		hasher:      hasher.New(authOptions.PasswordHashing),
 	}
 	return passwordAuthenticator
 }
@@ -70,14 +82,9 @@ func (p *passwordAuthenticator) Authenticate(username, password string) (authuse
 	}
 
 	// check user status
-	if user != nil && (user.Status.State == nil || *user.Status.State != iamv1.UserActive) {
-		if user.Status.State != nil && *user.Status.State == iamv1.UserAuthLimitExceeded {
-			klog.Errorf("%s, username: %s", RateLimitExceededError, username)
-			return nil, "", RateLimitExceededError
-		} else {
-			// state not active
-			klog.Errorf("%s, username: %s", AccountIsNotActiveError, username)
-			return nil, "", AccountIsNotActiveError
+	if user != nil {
+		if err = CheckUserState(user); err != nil {
+			return nil, "", err
 		}
 	}
 
@@ -87,6 +94,11 @@ func (p *passwordAuthenticator) Authenticate(username, password string) (authuse
 			klog.Error(err)
 			return nil, "", err
 		}
+		if PasswordExpired(user, p.authOptions.PasswordPolicy, time.Now()) {
+			klog.Errorf("%s, username: %s", PasswordExpiredError, username)
+			return nil, "", PasswordExpiredError
+		}
+		p.rehashPassword(user, password)
 		globalrole := p.findGlobalRole(username)
 		u := &authuser.DefaultInfo{
 			Name: user.Name,
@@ -97,33 +109,89 @@ func (p *passwordAuthenticator) Authenticate(username, password string) (authuse
 		return u, "", nil
 	}
 
+	// the user without a local password is authenticated by the generic identity providers
+	for i := range p.authOptions.OAuthOptions.IdentityProviders {
+		providerOptions := &p.authOptions.OAuthOptions.IdentityProviders[i]
+		genericProvider, err := identityprovider.GetGenericProvider(providerOptions.Name)
+		if err != nil {
+			// not a generic identity provider
+			continue
+		}
+		identity, err := genericProvider.Authenticate(username, password)
+		if err != nil {
+			klog.V(4).Infof("failed to authenticate user %s by identity provider %s: %s", username, providerOptions.Name, err)
+			continue
+		}
+		mapped, err := p.userGetter.mapIdentity(p.aiClient, providerOptions, identity)
+		if err != nil {
+			return nil, providerOptions.Name, err
+		}
+		globalrole := p.findGlobalRole(mapped.Name)
+		u := &authuser.DefaultInfo{
+			Name:   mapped.Name,
+			Groups: mapped.Spec.Groups,
+			Extra: map[string][]string{
+				iamv1.ResourcesSingularGlobalRole: {globalrole},
+			},
+		}
+		return u, providerOptions.Name, nil
+	}
+
 	return nil, "", IncorrectPasswordError
 }
 
+// CheckUserState returns the error if the user is not active, e.g. blocked by failed login attempts
+func CheckUserState(user *iamv1.User) error {
+	if user.Status.State != nil && *user.Status.State == iamv1.UserActive {
+		return nil
+	}
+	if user.Status.State != nil && *user.Status.State == iamv1.UserAuthLimitExceeded {
+		klog.Errorf("%s, username: %s", RateLimitExceededError, user.Name)
+		return RateLimitExceededError
+	}
+	klog.Errorf("%s, username: %s", AccountIsNotActiveError, user.Name)
+	return AccountIsNotActiveError
+}
+
 func PasswordVerify(encryptedPassword, password string) error {
-	if err := bcrypt.CompareHashAndPassword([]byte(encryptedPassword), []byte(password)); err != nil {
+	if err := hasher.Verify(encryptedPassword, password); err != nil {
 		return IncorrectPasswordError
 	}
 	return nil
 }
 
-// findUser
+// rehashPassword updates the password hashed by a weaker algorithm or cost than the configured one,
+// the login succeeds even if it fails.
+func (p *passwordAuthenticator) rehashPassword(user *iamv1.User, password string) {
+	if !p.hasher.NeedsRehash(user.Spec.EncryptedPassword) {
+		return
+	}
+	encrypted, err := p.hasher.Hash(password)
+	if err != nil {
+		klog.Error(err)
+		return
+	}
+	user = user.DeepCopy()
+	user.Spec.EncryptedPassword = encrypted
+	if _, err = p.aiClient.IamV1().Users().Update(context.Background(), user, metav1.UpdateOptions{}); err != nil {
+		klog.Errorf("failed to rehash password of user %s: %v", user.Name, err)
+	}
+}
+
+// findUser finds the user by name, or by email if the username is an email address
 func (u *userGetter) findUser(username string) (*iamv1.User, error) {
 	if _, err := mail.ParseAddress(username); err != nil {
 		return u.userLister.Get(username)
-	} else {
-		users, err := u.userLister.List(labels.Everything())
-		if err != nil {
-			klog.Error(err)
-			return nil, err
-		}
-		for _, find := range users {
-			if find.Spec.Email == username {
-				return find, nil
-			}
-		}
 	}
 
+	users, err := u.userIndexer.ByIndex(informers.UserEmailIndex, username)
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	if len(users) > 0 {
+		return users[0].(*iamv1.User), nil
+	}
 	return nil, errors.NewNotFound(iamv1.Resource("user"), username)
 }
 
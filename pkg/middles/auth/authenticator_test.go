@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/hasher"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/identityprovider"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/config"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	aifake "github.com/wongearl/go-restful-template/pkg/client/ai/clientset/versioned/fake"
+	aiinformers "github.com/wongearl/go-restful-template/pkg/client/ai/informers/externalversions"
+	"github.com/wongearl/go-restful-template/pkg/client/informers"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type fakeIdentity struct {
+	username string
+	groups   []string
+}
+
+func (f fakeIdentity) GetUserID() string   { return f.username }
+func (f fakeIdentity) GetUsername() string { return f.username }
+func (f fakeIdentity) GetEmail() string    { return f.username + "@ai.io" }
+func (f fakeIdentity) GetGroups() []string { return f.groups }
+
+// fakeGenericProvider authenticates the users with the password "P@88w0rd"
+type fakeGenericProvider struct {
+	groups []string
+}
+
+func (f *fakeGenericProvider) Authenticate(username string, password string) (identityprovider.Identity, error) {
+	if password != "P@88w0rd" {
+		return nil, fmt.Errorf("incorrect password")
+	}
+	return fakeIdentity{username: username, groups: f.groups}, nil
+}
+
+type fakeGenericProviderFactory struct{}
+
+func (f *fakeGenericProviderFactory) Type() string {
+	return "FakeGenericProvider"
+}
+
+func (f *fakeGenericProviderFactory) Create(options oauth.DynamicOptions) (identityprovider.GenericProvider, error) {
+	groups, _ := options["groups"].([]string)
+	return &fakeGenericProvider{groups: groups}, nil
+}
+
+func init() {
+	identityprovider.RegisterGenericProvider(&fakeGenericProviderFactory{})
+}
+
+func newTestPasswordAuthenticator(t *testing.T, mappingMethod oauth.MappingMethod, users ...runtime.Object) (PasswordAuthenticator, *aifake.Clientset) {
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	aiClient := aifake.NewSimpleClientset(users...)
+	informerFactory := aiinformers.NewSharedInformerFactory(aiClient, 0)
+	assert.Nil(t, informers.AddAiIndexers(informerFactory))
+	userInformer := informerFactory.Iam().V1().Users()
+	informerFactory.Start(stopCh)
+	informerFactory.WaitForCacheSync(stopCh)
+
+	authOptions := authoptions.NewAuthenticateOptions()
+	authOptions.OAuthOptions.IdentityProviders = []oauth.IdentityProviderOptions{
+		{Name: "ldap", Type: "FakeGenericProvider", MappingMethod: mappingMethod,
+			Provider: oauth.DynamicOptions{"groups": []string{"developers"}}},
+	}
+	assert.Nil(t, identityprovider.SetupWithOptions(authOptions.OAuthOptions.IdentityProviders))
+	t.Cleanup(func() { _ = identityprovider.SetupWithOptions(nil) })
+	return NewPasswordAuthenticator(aiClient, userInformer, authOptions, &config.AiOptions{}), aiClient
+}
+
+func TestPasswordAuthenticatorWithGenericProvider(t *testing.T) {
+	active := iamv1.UserActive
+	encrypted, err := bcrypt.GenerateFromPassword([]byte("P@ssw0rd"), bcrypt.MinCost)
+	assert.Nil(t, err)
+	local := &iamv1.User{
+		ObjectMeta: metav1.ObjectMeta{Name: "local"},
+		Spec:       iamv1.UserSpec{EncryptedPassword: string(encrypted)},
+		Status:     iamv1.UserStatus{State: &active},
+	}
+	mapped := &iamv1.User{
+		ObjectMeta: metav1.ObjectMeta{Name: "mapped", Labels: map[string]string{
+			iamv1.IdentifyProviderLabel: "ldap",
+			iamv1.OriginUIDLabel:        "mapped",
+		}},
+		Status: iamv1.UserStatus{State: &active},
+	}
+	authenticator, aiClient := newTestPasswordAuthenticator(t, oauth.MappingMethodAuto, local, mapped)
+
+	// the local password takes precedence
+	authenticated, provider, err := authenticator.Authenticate("local", "P@ssw0rd")
+	assert.Nil(t, err)
+	assert.Equal(t, "local", authenticated.GetName())
+	assert.Equal(t, "", provider)
+	_, _, err = authenticator.Authenticate("local", "P@88w0rd")
+	assert.Equal(t, IncorrectPasswordError, err)
+
+	authenticated, provider, err = authenticator.Authenticate("mapped", "P@88w0rd")
+	assert.Nil(t, err)
+	assert.Equal(t, "mapped", authenticated.GetName())
+	assert.Equal(t, []string{"developers"}, authenticated.GetGroups())
+	assert.Equal(t, "ldap", provider)
+	_, _, err = authenticator.Authenticate("mapped", "incorrect")
+	assert.Equal(t, IncorrectPasswordError, err)
+	// the groups are synced onto the user, the tokens are authenticated with them
+	user, err := aiClient.IamV1().Users().Get(context.Background(), "mapped", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"developers"}, user.Spec.Groups)
+
+	// the unknown user is provisioned
+	authenticated, provider, err = authenticator.Authenticate("alice", "P@88w0rd")
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", authenticated.GetName())
+	assert.Equal(t, "ldap", provider)
+	user, err = aiClient.IamV1().Users().Get(context.Background(), "alice", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "ldap", user.Labels[iamv1.IdentifyProviderLabel])
+	assert.Equal(t, "alice@ai.io", user.Spec.Email)
+	assert.Equal(t, []string{"developers"}, user.Spec.Groups)
+}
+
+func TestPasswordAuthenticatorWithLookupProvider(t *testing.T) {
+	authenticator, aiClient := newTestPasswordAuthenticator(t, oauth.MappingMethodLookup)
+
+	_, provider, err := authenticator.Authenticate("alice", "P@88w0rd")
+	assert.Equal(t, UserNotMappedError, err)
+	assert.Equal(t, "ldap", provider)
+	_, err = aiClient.IamV1().Users().Get(context.Background(), "alice", metav1.GetOptions{})
+	assert.NotNil(t, err)
+}
+
+func TestPasswordAuthenticatorRehash(t *testing.T) {
+	active := iamv1.UserActive
+	encrypted, err := bcrypt.GenerateFromPassword([]byte("P@ssw0rd"), bcrypt.MinCost)
+	assert.Nil(t, err)
+	local := &iamv1.User{
+		ObjectMeta: metav1.ObjectMeta{Name: "local"},
+		Spec:       iamv1.UserSpec{EncryptedPassword: string(encrypted)},
+		Status:     iamv1.UserStatus{State: &active},
+	}
+	authenticator, aiClient := newTestPasswordAuthenticator(t, oauth.MappingMethodAuto, local)
+	authenticator.(*passwordAuthenticator).hasher = hasher.New(&hasher.Options{
+		Algorithm: hasher.AlgorithmArgon2id,
+		Argon2id:  &hasher.Argon2idOptions{Time: 1, Memory: 1024, Threads: 1, KeyLength: 32, SaltLength: 16},
+	})
+
+	// the password is not rehashed if the login fails
+	_, _, err = authenticator.Authenticate("local", "incorrect")
+	assert.Equal(t, IncorrectPasswordError, err)
+	user, err := aiClient.IamV1().Users().Get(context.Background(), "local", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, string(encrypted), user.Spec.EncryptedPassword)
+
+	_, _, err = authenticator.Authenticate("local", "P@ssw0rd")
+	assert.Nil(t, err)
+	user, err = aiClient.IamV1().Users().Get(context.Background(), "local", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(user.Spec.EncryptedPassword, "$argon2id$"))
+	assert.Nil(t, PasswordVerify(user.Spec.EncryptedPassword, "P@ssw0rd"))
+}
+
+func TestPasswordAuthenticatorWithExpiredPassword(t *testing.T) {
+	active := iamv1.UserActive
+	encrypted, err := bcrypt.GenerateFromPassword([]byte("P@ssw0rd"), bcrypt.MinCost)
+	assert.Nil(t, err)
+	mustChange := &iamv1.User{
+		ObjectMeta: metav1.ObjectMeta{Name: "must-change"},
+		Spec:       iamv1.UserSpec{EncryptedPassword: string(encrypted)},
+		Status:     iamv1.UserStatus{State: &active, MustChangePassword: true},
+	}
+	// the password expired before the user controller marks the user
+	expired := &iamv1.User{
+		ObjectMeta: metav1.ObjectMeta{Name: "expired"},
+		Spec:       iamv1.UserSpec{EncryptedPassword: string(encrypted)},
+		Status:     iamv1.UserStatus{State: &active, LastPasswordChangeTime: &metav1.Time{Time: time.Now().Add(-2 * time.Hour)}},
+	}
+	authenticator, _ := newTestPasswordAuthenticator(t, oauth.MappingMethodAuto, mustChange, expired)
+	authenticator.(*passwordAuthenticator).authOptions.PasswordPolicy.MaxAge = time.Hour
+
+	_, _, err = authenticator.Authenticate("must-change", "P@ssw0rd")
+	assert.Equal(t, PasswordExpiredError, err)
+	_, _, err = authenticator.Authenticate("expired", "P@ssw0rd")
+	assert.Equal(t, PasswordExpiredError, err)
+	// the incorrect password is not told to be expired
+	_, _, err = authenticator.Authenticate("expired", "incorrect")
+	assert.Equal(t, IncorrectPasswordError, err)
+}
+
+// newBenchmarkUserGetter indexes 10k users, the users are added to the indexer directly
+func newBenchmarkUserGetter(b *testing.B) *userGetter {
+	informerFactory := aiinformers.NewSharedInformerFactory(aifake.NewSimpleClientset(), 0)
+	assert.Nil(b, informers.AddAiIndexers(informerFactory))
+	userInformer := informerFactory.Iam().V1().Users()
+	for i := 0; i < 10000; i++ {
+		user := &iamv1.User{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("user-%d", i)},
+			Spec: iamv1.UserSpec{Email: fmt.Sprintf("user-%d@ai.io", i)}}
+		assert.Nil(b, userInformer.Informer().GetIndexer().Add(user))
+	}
+	return newUserGetter(userInformer)
+}
+
+func BenchmarkFindUserByEmail(b *testing.B) {
+	getter := newBenchmarkUserGetter(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := getter.findUser(fmt.Sprintf("user-%d@ai.io", i%10000)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkFindUserByEmailScan is the lookup scanning all the users in the lister, for comparison
+func BenchmarkFindUserByEmailScan(b *testing.B) {
+	getter := newBenchmarkUserGetter(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		email := fmt.Sprintf("user-%d@ai.io", i%10000)
+		users, err := getter.userLister.List(labels.Everything())
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, user := range users {
+			if user.Spec.Email == email {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/wongearl/go-restful-template/pkg/client/cache"
+
+	"k8s.io/klog"
+)
+
+// CodeChallengeMethodS256 is the only PKCE method supported, the plain method does not protect the code
+const CodeChallengeMethodS256 = "S256"
+
+// codeVerifierPattern is the format of code verifier defined in RFC 7636 section 4.1
+var codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// AuthorizationCodeMaxAge is the lifetime of authorization codes, RFC 6749 recommends 10 minutes at most
+const AuthorizationCodeMaxAge = 5 * time.Minute
+
+var InvalidAuthorizationCodeError = fmt.Errorf("authorization code is invalid or expired")
+
+// AuthorizationCode records the authorization request approved by the user,
+// it is exchanged for tokens by the client at the token endpoint.
+type AuthorizationCode struct {
+	ClientID    string    `json:"clientID"`
+	RedirectURI string    `json:"redirectURI"`
+	Username    string    `json:"username"`
+	Groups      []string  `json:"groups,omitempty"`
+	Scopes      []string  `json:"scopes,omitempty"`
+	Nonce       string    `json:"nonce,omitempty"`
+	AuthTime    time.Time `json:"authTime"`
+	// CodeChallenge binds the code to the client instance who started the request, see RFC 7636
+	CodeChallenge       string `json:"codeChallenge,omitempty"`
+	CodeChallengeMethod string `json:"codeChallengeMethod,omitempty"`
+}
+
+// VerifyCodeVerifier checks the code verifier sent by the client against the code challenge,
+// no verifier is expected if the authorization request has no code challenge.
+func (c *AuthorizationCode) VerifyCodeVerifier(verifier string) error {
+	if c.CodeChallenge == "" {
+		if verifier != "" {
+			return fmt.Errorf("code_verifier is not expected")
+		}
+		return nil
+	}
+	if !codeVerifierPattern.MatchString(verifier) {
+		return fmt.Errorf("code_verifier is invalid")
+	}
+	if c.CodeChallengeMethod != CodeChallengeMethodS256 {
+		return fmt.Errorf("code challenge method %q is not supported", c.CodeChallengeMethod)
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(c.CodeChallenge)) != 1 {
+		return fmt.Errorf("code_verifier does not match the code challenge")
+	}
+	return nil
+}
+
+// AuthorizationCodeStore keeps authorization codes until they are exchanged or expired
+type AuthorizationCodeStore interface {
+	// Issue stores the approved request, and returns the code referring to it
+	Issue(code *AuthorizationCode) (string, error)
+	// Exchange returns the request of the code, a code can only be exchanged once
+	Exchange(code string) (*AuthorizationCode, error)
+}
+
+type authorizationCodeStore struct {
+	cache cache.Interface
+}
+
+func NewAuthorizationCodeStore(cache cache.Interface) AuthorizationCodeStore {
+	return &authorizationCodeStore{cache: cache}
+}
+
+func (s *authorizationCodeStore) Issue(code *AuthorizationCode) (string, error) {
+	data, err := json.Marshal(code)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		klog.Error(err)
+		return "", err
+	}
+	codeStr := base64.RawURLEncoding.EncodeToString(buf)
+	if err = s.cache.Set(authorizationCodeCacheKey(codeStr), string(data), AuthorizationCodeMaxAge); err != nil {
+		klog.Error(err)
+		return "", err
+	}
+	return codeStr, nil
+}
+
+func (s *authorizationCodeStore) Exchange(codeStr string) (*AuthorizationCode, error) {
+	// the code is taken out of the cache atomically, so the concurrent requests can not exchange it more than once
+	value, err := s.cache.GetDel(authorizationCodeCacheKey(codeStr))
+	if err != nil {
+		if err == cache.ErrNoSuchKey {
+			return nil, InvalidAuthorizationCodeError
+		}
+		klog.Error(err)
+		return nil, err
+	}
+	code := &AuthorizationCode{}
+	if err = json.Unmarshal([]byte(value), code); err != nil {
+		klog.Error(err)
+		return nil, InvalidAuthorizationCodeError
+	}
+	return code, nil
+}
+
+func authorizationCodeCacheKey(code string) string {
+	return fmt.Sprintf("ai:oauth:code:%s", code)
+}
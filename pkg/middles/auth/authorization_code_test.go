@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/wongearl/go-restful-template/pkg/client/cache"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorizationCodeStore(t *testing.T) {
+	cacheClient, err := cache.NewInMemoryCache(nil, make(chan struct{}))
+	assert.Nil(t, err)
+	store := NewAuthorizationCodeStore(cacheClient)
+
+	code, err := store.Issue(&AuthorizationCode{ClientID: "dashboard", Username: "admin"})
+	assert.Nil(t, err)
+	assert.Len(t, code, 43)
+
+	exchanged, err := store.Exchange(code)
+	assert.Nil(t, err)
+	assert.Equal(t, "dashboard", exchanged.ClientID)
+	assert.Equal(t, "admin", exchanged.Username)
+
+	_, err = store.Exchange(code)
+	assert.Equal(t, InvalidAuthorizationCodeError, err)
+	_, err = store.Exchange("invalid")
+	assert.Equal(t, InvalidAuthorizationCodeError, err)
+}
+
+func TestAuthorizationCodeExchangeConcurrently(t *testing.T) {
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	inMemory, err := cache.NewInMemoryCache(nil, stopCh)
+	assert.Nil(t, err)
+	server := miniredis.RunT(t)
+	port, err := strconv.Atoi(server.Port())
+	assert.Nil(t, err)
+	redis, err := cache.NewRedisClient(&cache.RedisOptions{Host: server.Host(), Port: port}, stopCh)
+	assert.Nil(t, err)
+
+	for name, cacheClient := range map[string]cache.Interface{"in-memory": inMemory, "redis": redis} {
+		t.Run(name, func(t *testing.T) {
+			store := NewAuthorizationCodeStore(cacheClient)
+			code, err := store.Issue(&AuthorizationCode{ClientID: "dashboard", Username: "admin"})
+			assert.Nil(t, err)
+
+			var wg sync.WaitGroup
+			var lock sync.Mutex
+			exchanged := 0
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := store.Exchange(code); err == nil {
+						lock.Lock()
+						exchanged++
+						lock.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			// the code is exchanged only once
+			assert.Equal(t, 1, exchanged)
+		})
+	}
+}
+
+func TestVerifyCodeVerifier(t *testing.T) {
+	// the example of RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	tests := []struct {
+		name     string
+		code     AuthorizationCode
+		verifier string
+		valid    bool
+	}{
+		{name: "no challenge", code: AuthorizationCode{}, verifier: "", valid: true},
+		{name: "unexpected verifier", code: AuthorizationCode{}, verifier: verifier, valid: false},
+		{name: "S256", code: AuthorizationCode{CodeChallenge: challenge, CodeChallengeMethod: CodeChallengeMethodS256}, verifier: verifier, valid: true},
+		{name: "missing verifier", code: AuthorizationCode{CodeChallenge: challenge, CodeChallengeMethod: CodeChallengeMethodS256}, verifier: "", valid: false},
+		{name: "mismatch", code: AuthorizationCode{CodeChallenge: challenge, CodeChallengeMethod: CodeChallengeMethodS256}, verifier: verifier[1:] + "a", valid: false},
+		{name: "too short", code: AuthorizationCode{CodeChallenge: challenge, CodeChallengeMethod: CodeChallengeMethodS256}, verifier: "short", valid: false},
+		{name: "plain", code: AuthorizationCode{CodeChallenge: verifier, CodeChallengeMethod: "plain"}, verifier: verifier, valid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.code.VerifyCodeVerifier(tt.verifier)
+			assert.Equal(t, tt.valid, err == nil, err)
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/wongearl/go-restful-template/pkg/client/cache"
+
+	authuser "k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/klog"
+)
+
+const (
+	// DeviceCodeMaxAge is the lifetime of the device codes and the user codes
+	DeviceCodeMaxAge = 10 * time.Minute
+	// DeviceCodeInterval is the minimum interval between the polling requests of the device,
+	// it is increased by 5 seconds every time the device polls too frequently, see RFC 8628 section 3.5
+	DeviceCodeInterval = 5 * time.Second
+	slowDownIncrement  = 5 * time.Second
+
+	// userCodeCharset excludes the vowels to avoid words and the characters easy to confuse, see RFC 8628 section 6.1
+	userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength  = 8
+)
+
+// The states of the device authorization
+const (
+	DeviceAuthorizationPending  = "Pending"
+	DeviceAuthorizationApproved = "Approved"
+	DeviceAuthorizationDenied   = "Denied"
+)
+
+var (
+	ExpiredDeviceCodeError        = fmt.Errorf("device code is invalid or expired")
+	InvalidUserCodeError          = fmt.Errorf("user code is invalid or expired")
+	DeviceCodeClientMismatchError = fmt.Errorf("device code was issued to another client")
+	AuthorizationPendingError     = fmt.Errorf("the user has not approved the authorization request yet")
+	SlowDownError                 = fmt.Errorf("the device is polling too frequently")
+	DeviceAccessDeniedError       = fmt.Errorf("the user denied the authorization request")
+)
+
+// DeviceAuthorization records the authorization request of a device, the user approves it
+// in the browser with the user code while the device polls the token endpoint with the device code.
+// The request has no scopes, the tokens issued have all the permissions of the user.
+type DeviceAuthorization struct {
+	ClientID string   `json:"clientID"`
+	UserCode string   `json:"userCode"`
+	State    string   `json:"state"`
+	Username string   `json:"username,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	// Interval is the initial polling interval of the device
+	Interval  time.Duration `json:"interval"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+}
+
+// devicePolling records the polling of the device apart from the authorization,
+// so the polls never overwrite the decision of the user.
+type devicePolling struct {
+	Interval time.Duration `json:"interval"`
+	LastPoll time.Time     `json:"lastPoll"`
+}
+
+func (d *DeviceAuthorization) User() authuser.Info {
+	return &authuser.DefaultInfo{Name: d.Username, Groups: d.Groups}
+}
+
+// DeviceAuthorizationStore keeps the device authorizations until they are exchanged for tokens or expired
+type DeviceAuthorizationStore interface {
+	// Issue stores the pending authorization, and returns the device code referring to it
+	Issue(clientID string) (deviceCode string, authorization *DeviceAuthorization, err error)
+	// Lookup returns the pending authorization of the user code
+	Lookup(userCode string) (*DeviceAuthorization, error)
+	// Decide approves the pending authorization on behalf of the user, or denies it
+	Decide(userCode string, user authuser.Info, approved bool) error
+	// Poll returns the approved authorization of the device code, which can only be exchanged once.
+	// AuthorizationPendingError and SlowDownError are returned until the user decides.
+	Poll(deviceCode string, clientID string) (*DeviceAuthorization, error)
+}
+
+type deviceAuthorizationStore struct {
+	cache cache.Interface
+	now   func() time.Time
+}
+
+func NewDeviceAuthorizationStore(cache cache.Interface) DeviceAuthorizationStore {
+	return &deviceAuthorizationStore{cache: cache, now: time.Now}
+}
+
+// NormalizeUserCode removes the separators and the case the user may type
+func NormalizeUserCode(userCode string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(userCode))
+}
+
+func (s *deviceAuthorizationStore) Issue(clientID string) (string, *DeviceAuthorization, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		klog.Error(err)
+		return "", nil, err
+	}
+	deviceCode := base64.RawURLEncoding.EncodeToString(buf)
+	userCode, err := generateUserCode()
+	if err != nil {
+		klog.Error(err)
+		return "", nil, err
+	}
+
+	authorization := &DeviceAuthorization{
+		ClientID:  clientID,
+		UserCode:  userCode,
+		State:     DeviceAuthorizationPending,
+		Interval:  DeviceCodeInterval,
+		ExpiresAt: s.now().Add(DeviceCodeMaxAge),
+	}
+	if err = s.save(deviceCode, authorization); err != nil {
+		return "", nil, err
+	}
+	if err = s.cache.Set(userCodeCacheKey(userCode), deviceCode, DeviceCodeMaxAge); err != nil {
+		klog.Error(err)
+		return "", nil, err
+	}
+	return deviceCode, authorization, nil
+}
+
+func (s *deviceAuthorizationStore) Lookup(userCode string) (*DeviceAuthorization, error) {
+	_, authorization, err := s.lookup(userCode)
+	return authorization, err
+}
+
+func (s *deviceAuthorizationStore) lookup(userCode string) (string, *DeviceAuthorization, error) {
+	deviceCode, err := s.cache.Get(userCodeCacheKey(NormalizeUserCode(userCode)))
+	if err != nil {
+		if err == cache.ErrNoSuchKey {
+			return "", nil, InvalidUserCodeError
+		}
+		klog.Error(err)
+		return "", nil, err
+	}
+	authorization, err := s.load(deviceCode)
+	if err != nil {
+		if err == ExpiredDeviceCodeError {
+			return "", nil, InvalidUserCodeError
+		}
+		return "", nil, err
+	}
+	if authorization.State != DeviceAuthorizationPending {
+		return "", nil, InvalidUserCodeError
+	}
+	return deviceCode, authorization, nil
+}
+
+func (s *deviceAuthorizationStore) Decide(userCode string, user authuser.Info, approved bool) error {
+	// the user code is taken out of the cache atomically before the authorization is loaded,
+	// so only one of the concurrent decisions is saved and it is never overwritten
+	deviceCode, err := s.cache.GetDel(userCodeCacheKey(NormalizeUserCode(userCode)))
+	if err != nil {
+		if err == cache.ErrNoSuchKey {
+			return InvalidUserCodeError
+		}
+		klog.Error(err)
+		return err
+	}
+	authorization, err := s.load(deviceCode)
+	if err != nil {
+		if err == ExpiredDeviceCodeError {
+			return InvalidUserCodeError
+		}
+		return err
+	}
+	if authorization.State != DeviceAuthorizationPending {
+		return InvalidUserCodeError
+	}
+	authorization.State = DeviceAuthorizationDenied
+	if approved {
+		authorization.State = DeviceAuthorizationApproved
+		authorization.Username = user.GetName()
+		authorization.Groups = user.GetGroups()
+	}
+	return s.save(deviceCode, authorization)
+}
+
+func (s *deviceAuthorizationStore) Poll(deviceCode string, clientID string) (*DeviceAuthorization, error) {
+	authorization, err := s.load(deviceCode)
+	if err != nil {
+		return nil, err
+	}
+	if authorization.ClientID != clientID {
+		return nil, DeviceCodeClientMismatchError
+	}
+
+	switch authorization.State {
+	case DeviceAuthorizationApproved, DeviceAuthorizationDenied:
+		// the decided authorization is taken out of the cache atomically,
+		// so the concurrent polls can not exchange it more than once
+		if authorization, err = s.take(deviceCode); err != nil {
+			return nil, err
+		}
+		if err = s.cache.Del(devicePollingCacheKey(deviceCode)); err != nil {
+			klog.Error(err)
+		}
+		// the state is checked again since it may be changed after loaded
+		if authorization.State != DeviceAuthorizationApproved {
+			return nil, DeviceAccessDeniedError
+		}
+		return authorization, nil
+	}
+
+	tooFrequent, err := s.poll(deviceCode, authorization)
+	if err != nil {
+		return nil, err
+	}
+	if tooFrequent {
+		return nil, SlowDownError
+	}
+	return nil, AuthorizationPendingError
+}
+
+// poll records the polling of the pending authorization, the interval is increased if the device polls too frequently
+func (s *deviceAuthorizationStore) poll(deviceCode string, authorization *DeviceAuthorization) (bool, error) {
+	polling := &devicePolling{Interval: authorization.Interval}
+	value, err := s.cache.Get(devicePollingCacheKey(deviceCode))
+	if err == nil {
+		// the malformed polling is started over
+		_ = json.Unmarshal([]byte(value), polling)
+	} else if err != cache.ErrNoSuchKey {
+		klog.Error(err)
+		return false, err
+	}
+
+	now := s.now()
+	tooFrequent := !polling.LastPoll.IsZero() && now.Sub(polling.LastPoll) < polling.Interval
+	polling.LastPoll = now
+	if tooFrequent {
+		polling.Interval += slowDownIncrement
+	}
+	data, err := json.Marshal(polling)
+	if err != nil {
+		return false, err
+	}
+	ttl := authorization.ExpiresAt.Sub(now)
+	if ttl <= 0 {
+		return false, ExpiredDeviceCodeError
+	}
+	if err = s.cache.Set(devicePollingCacheKey(deviceCode), string(data), ttl); err != nil {
+		klog.Error(err)
+		return false, err
+	}
+	return tooFrequent, nil
+}
+
+func (s *deviceAuthorizationStore) load(deviceCode string) (*DeviceAuthorization, error) {
+	value, err := s.cache.Get(deviceCodeCacheKey(deviceCode))
+	return s.decode(value, err)
+}
+
+// take loads the authorization and deletes it from the cache atomically
+func (s *deviceAuthorizationStore) take(deviceCode string) (*DeviceAuthorization, error) {
+	value, err := s.cache.GetDel(deviceCodeCacheKey(deviceCode))
+	return s.decode(value, err)
+}
+
+func (s *deviceAuthorizationStore) decode(value string, err error) (*DeviceAuthorization, error) {
+	if err != nil {
+		if err == cache.ErrNoSuchKey {
+			return nil, ExpiredDeviceCodeError
+		}
+		klog.Error(err)
+		return nil, err
+	}
+	authorization := &DeviceAuthorization{}
+	if err = json.Unmarshal([]byte(value), authorization); err != nil {
+		klog.Error(err)
+		return nil, ExpiredDeviceCodeError
+	}
+	if !s.now().Before(authorization.ExpiresAt) {
+		return nil, ExpiredDeviceCodeError
+	}
+	return authorization, nil
+}
+
+// save keeps the authorization until it expires
+func (s *deviceAuthorizationStore) save(deviceCode string, authorization *DeviceAuthorization) error {
+	data, err := json.Marshal(authorization)
+	if err != nil {
+		return err
+	}
+	ttl := authorization.ExpiresAt.Sub(s.now())
+	if ttl <= 0 {
+		return ExpiredDeviceCodeError
+	}
+	if err = s.cache.Set(deviceCodeCacheKey(deviceCode), string(data), ttl); err != nil {
+		klog.Error(err)
+		return err
+	}
+	return nil
+}
+
+// generateUserCode returns the code like BDFH-JKLM, which is easy to type
+func generateUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeCharset))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeCharset[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// FormatUserCode inserts a dash in the middle of the user code for readability
+func FormatUserCode(userCode string) string {
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
+
+func deviceCodeCacheKey(deviceCode string) string {
+	return fmt.Sprintf("ai:oauth:device:%s", deviceCode)
+}
+
+func devicePollingCacheKey(deviceCode string) string {
+	return fmt.Sprintf("ai:oauth:device:%s:polling", deviceCode)
+}
+
+func userCodeCacheKey(userCode string) string {
+	return fmt.Sprintf("ai:oauth:usercode:%s", userCode)
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wongearl/go-restful-template/pkg/client/cache"
+
+	"github.com/stretchr/testify/assert"
+	authuser "k8s.io/apiserver/pkg/authentication/user"
+)
+
+func newTestDeviceAuthorizationStore(t *testing.T) (*deviceAuthorizationStore, *time.Time) {
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	cacheClient, err := cache.NewInMemoryCache(nil, stopCh)
+	assert.Nil(t, err)
+	now := time.Now()
+	store := NewDeviceAuthorizationStore(cacheClient).(*deviceAuthorizationStore)
+	store.now = func() time.Time { return now }
+	return store, &now
+}
+
+func TestDeviceAuthorizationStore(t *testing.T) {
+	store, now := newTestDeviceAuthorizationStore(t)
+	admin := &authuser.DefaultInfo{Name: "admin", Groups: []string{"ops"}}
+
+	deviceCode, authorization, err := store.Issue("cli")
+	assert.Nil(t, err)
+	assert.Len(t, deviceCode, 43)
+	assert.Len(t, authorization.UserCode, 8)
+	assert.Equal(t, DeviceCodeInterval, authorization.Interval)
+	userCode := FormatUserCode(authorization.UserCode)
+	assert.Equal(t, byte('-'), userCode[4])
+
+	_, err = store.Poll(deviceCode, "cli")
+	assert.Equal(t, AuthorizationPendingError, err)
+	// polling faster than the interval slows the device down
+	*now = now.Add(time.Second)
+	_, err = store.Poll(deviceCode, "cli")
+	assert.Equal(t, SlowDownError, err)
+	*now = now.Add(DeviceCodeInterval)
+	_, err = store.Poll(deviceCode, "cli")
+	assert.Equal(t, SlowDownError, err)
+	// the interval is increased by every slow_down
+	*now = now.Add(DeviceCodeInterval + 2*slowDownIncrement)
+	_, err = store.Poll(deviceCode, "cli")
+	assert.Equal(t, AuthorizationPendingError, err)
+	_, err = store.Poll(deviceCode, "another")
+	assert.Equal(t, DeviceCodeClientMismatchError, err)
+
+	// the user code is case insensitive, and the dash is optional
+	pending, err := store.Lookup(" " + strings.ToLower(userCode))
+	assert.Nil(t, err)
+	assert.Equal(t, "cli", pending.ClientID)
+	_, err = store.Lookup(authorization.UserCode)
+	assert.Nil(t, err)
+	_, err = store.Lookup("BCDF-GHJK0")
+	assert.Equal(t, InvalidUserCodeError, err)
+
+	assert.Nil(t, store.Decide(authorization.UserCode, admin, true))
+	// the user code can only be used once
+	assert.Equal(t, InvalidUserCodeError, store.Decide(authorization.UserCode, admin, false))
+
+	approved, err := store.Poll(deviceCode, "cli")
+	assert.Nil(t, err)
+	assert.Equal(t, admin, approved.User())
+	// the device code can only be exchanged once
+	_, err = store.Poll(deviceCode, "cli")
+	assert.Equal(t, ExpiredDeviceCodeError, err)
+}
+
+func TestDeviceAuthorizationPollConcurrently(t *testing.T) {
+	store, _ := newTestDeviceAuthorizationStore(t)
+	deviceCode, authorization, err := store.Issue("cli")
+	assert.Nil(t, err)
+	assert.Nil(t, store.Decide(authorization.UserCode, &authuser.DefaultInfo{Name: "admin"}, true))
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	exchanged := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.Poll(deviceCode, "cli"); err == nil {
+				lock.Lock()
+				exchanged++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// the device code is exchanged only once
+	assert.Equal(t, 1, exchanged)
+}
+
+// interleavingCache runs the hook once right after the key is read
+type interleavingCache struct {
+	cache.Interface
+	key  string
+	hook func()
+}
+
+func (c *interleavingCache) Get(key string) (string, error) {
+	value, err := c.Interface.Get(key)
+	if key == c.key && c.hook != nil {
+		hook := c.hook
+		c.hook = nil
+		hook()
+	}
+	return value, err
+}
+
+func TestDeviceAuthorizationDecideWhilePolling(t *testing.T) {
+	store, now := newTestDeviceAuthorizationStore(t)
+	admin := &authuser.DefaultInfo{Name: "admin"}
+
+	for _, approve := range []bool{true, false} {
+		deviceCode, authorization, err := store.Issue("cli")
+		assert.Nil(t, err)
+		interleaving := &interleavingCache{Interface: store.cache, key: deviceCodeCacheKey(deviceCode)}
+		polling := &deviceAuthorizationStore{cache: interleaving, now: store.now}
+
+		// the user decides after the poll has read the pending authorization
+		interleaving.hook = func() {
+			assert.Nil(t, store.Decide(authorization.UserCode, admin, approve))
+		}
+		_, err = polling.Poll(deviceCode, "cli")
+		assert.Equal(t, AuthorizationPendingError, err)
+
+		// the pending poll does not overwrite the decision
+		*now = now.Add(DeviceCodeInterval)
+		decided, err := store.Poll(deviceCode, "cli")
+		if approve {
+			assert.Nil(t, err)
+			assert.Equal(t, admin, decided.User())
+		} else {
+			assert.Equal(t, DeviceAccessDeniedError, err)
+		}
+	}
+}
+
+func TestDeviceAuthorizationDecideConcurrently(t *testing.T) {
+	store, now := newTestDeviceAuthorizationStore(t)
+	deviceCode, authorization, err := store.Issue("cli")
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var decisions []bool
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		approve := i%2 == 0
+		go func() {
+			defer wg.Done()
+			if err := store.Decide(authorization.UserCode, &authuser.DefaultInfo{Name: "admin"}, approve); err == nil {
+				lock.Lock()
+				decisions = append(decisions, approve)
+				lock.Unlock()
+			} else {
+				assert.Equal(t, InvalidUserCodeError, err)
+			}
+		}()
+	}
+	wg.Wait()
+	// only one decision is made, and the device sees it
+	if !assert.Len(t, decisions, 1) {
+		return
+	}
+	*now = now.Add(DeviceCodeInterval)
+	_, err = store.Poll(deviceCode, "cli")
+	if decisions[0] {
+		assert.Nil(t, err)
+	} else {
+		assert.Equal(t, DeviceAccessDeniedError, err)
+	}
+}
+
+func TestDeviceAuthorizationDeniedAndExpired(t *testing.T) {
+	store, now := newTestDeviceAuthorizationStore(t)
+
+	denied, authorization, err := store.Issue("cli")
+	assert.Nil(t, err)
+	assert.Nil(t, store.Decide(authorization.UserCode, &authuser.DefaultInfo{Name: "admin"}, false))
+	_, err = store.Poll(denied, "cli")
+	assert.Equal(t, DeviceAccessDeniedError, err)
+
+	expired, authorization, err := store.Issue("cli")
+	assert.Nil(t, err)
+	*now = now.Add(DeviceCodeMaxAge)
+	_, err = store.Lookup(authorization.UserCode)
+	assert.Equal(t, InvalidUserCodeError, err)
+	_, err = store.Poll(expired, "cli")
+	assert.Equal(t, ExpiredDeviceCodeError, err)
+}
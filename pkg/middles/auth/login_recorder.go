@@ -14,6 +14,8 @@ import (
 
 type LoginRecorder interface {
 	RecordLogin(username string, loginType iamv1.LoginType, provider string, sourceIP string, userAgent string, authErr error) error
+	// RecordLoginWithReason records a login attempt with a custom reason
+	RecordLoginWithReason(username string, loginType iamv1.LoginType, provider string, sourceIP string, userAgent string, success bool, reason string) error
 }
 
 type loginRecorder struct {
@@ -27,9 +29,16 @@ func NewLoginRecorder(client ai.Interface) LoginRecorder {
 }
 
 func (l *loginRecorder) RecordLogin(username string, loginType iamv1.LoginType, provider string, sourceIP string, userAgent string, authErr error) error {
+	if authErr != nil {
+		return l.RecordLoginWithReason(username, loginType, provider, sourceIP, userAgent, false, authErr.Error())
+	}
+	return l.RecordLoginWithReason(username, loginType, provider, sourceIP, userAgent, true, iamv1.AuthenticatedSuccessfully)
+}
+
+func (l *loginRecorder) RecordLoginWithReason(username string, loginType iamv1.LoginType, provider string, sourceIP string, userAgent string, success bool, reason string) error {
 	// This is a temporary solution in case of user login with email,
-	// '@' is not allowed in Kubernetes object name.
-	username = strings.Replace(username, "@", "-", -1)
+	// '@' is not allowed in Kubernetes object name, neither is ':' of the service identities.
+	username = strings.NewReplacer("@", "-", ":", "-").Replace(username)
 
 	loginEntry := &iamv1.LoginRecord{
 		ObjectMeta: metav1.ObjectMeta{
@@ -41,18 +50,13 @@ func (l *loginRecorder) RecordLogin(username string, loginType iamv1.LoginType,
 		Spec: iamv1.LoginRecordSpec{
 			Type:      loginType,
 			Provider:  provider,
-			Success:   true,
-			Reason:    iamv1.AuthenticatedSuccessfully,
+			Success:   success,
+			Reason:    reason,
 			SourceIP:  sourceIP,
 			UserAgent: userAgent,
 		},
 	}
 
-	if authErr != nil {
-		loginEntry.Spec.Success = false
-		loginEntry.Spec.Reason = authErr.Error()
-	}
-
 	_, err := l.aiClient.IamV1().LoginRecords().Create(context.Background(), loginEntry, metav1.CreateOptions{})
 	if err != nil {
 		klog.Error(err)
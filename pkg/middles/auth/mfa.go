@@ -0,0 +1,514 @@
+package auth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/config"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	ai "github.com/wongearl/go-restful-template/pkg/client/ai/clientset/versioned"
+	"github.com/wongearl/go-restful-template/pkg/client/cache"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	authuser "k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+const (
+	// MFAChallengeMaxAge is the lifetime of the challenge token returned by the password grant
+	MFAChallengeMaxAge = 5 * time.Minute
+	// maxMFAChallengeAttempts is the number of incorrect codes after which the challenge is invalidated
+	maxMFAChallengeAttempts = 5
+	// recoveryCodeCount is the number of the recovery codes generated on enrollment
+	recoveryCodeCount = 10
+
+	mfaSecretKey        = "secret"
+	mfaRecoveryCodesKey = "recovery-codes"
+)
+
+var (
+	MFAAlreadyEnabledError   = fmt.Errorf("multi-factor authentication is already enabled")
+	MFANotEnabledError       = fmt.Errorf("multi-factor authentication is not enabled")
+	MFANotEnrolledError      = fmt.Errorf("multi-factor authentication is not enrolled")
+	InvalidMFACodeError      = fmt.Errorf("verification code is invalid")
+	InvalidMFAChallengeError = fmt.Errorf("mfa token is invalid or expired")
+	MFARequiredError         = fmt.Errorf("multi-factor authentication is required, basic authentication is not allowed")
+)
+
+// MFAStatus describes the second factor of a user
+type MFAStatus struct {
+	Enabled bool `json:"enabled"`
+	// Pending means the secret is enrolled but not verified yet
+	Pending                bool `json:"pending"`
+	RecoveryCodesRemaining int  `json:"recoveryCodesRemaining"`
+}
+
+// MFAEnrollment is returned only once on enrollment, the secret and the recovery codes can not be retrieved later
+type MFAEnrollment struct {
+	Secret        string   `json:"secret"`
+	OTPAuthURL    string   `json:"otpauthURL"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// MFAChallenge records the user who passed the password authentication, the tokens are
+// issued once the second factor is verified.
+type MFAChallenge struct {
+	Username string              `json:"username"`
+	UID      string              `json:"uid,omitempty"`
+	Groups   []string            `json:"groups,omitempty"`
+	Extra    map[string][]string `json:"extra,omitempty"`
+	Provider string              `json:"provider,omitempty"`
+	Attempts int                 `json:"attempts"`
+	// ExpiresAt is not extended by the incorrect codes
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (c *MFAChallenge) User() authuser.Info {
+	return &authuser.DefaultInfo{Name: c.Username, UID: c.UID, Groups: c.Groups, Extra: c.Extra}
+}
+
+// MFAEnabled returns true if the user has verified the TOTP second factor
+func MFAEnabled(user *iamv1.User) bool {
+	return user != nil && user.Annotations[iamv1.MFASecretAnnotation] != ""
+}
+
+// MFAManagementInterface manages the TOTP second factor of the users, see RFC 6238
+type MFAManagementInterface interface {
+	Status(username string) (*MFAStatus, error)
+	// Enroll generates a new secret and the recovery codes, which take effect once activated
+	Enroll(username string) (*MFAEnrollment, error)
+	// Activate enables MFA if the code matches the enrolled secret
+	Activate(username string, code string) error
+	// Disable removes the second factor, the code of the user is required if MFA is enabled
+	Disable(username string, code string) error
+	// Verify checks the one-time password or the recovery code of the user, recovery codes can only be used once
+	Verify(username string, code string) error
+	// Challenge returns the token which is exchanged for tokens once the second factor is verified
+	Challenge(user authuser.Info, provider string) (string, error)
+	// VerifyChallenge verifies the code of the challenge, the challenge can only be used once.
+	// The challenge is returned with InvalidMFACodeError so that the failure can be recorded.
+	VerifyChallenge(token string, code string) (*MFAChallenge, error)
+}
+
+type mfaOperator struct {
+	aiClient    ai.Interface
+	k8sClient   kubernetes.Interface
+	cache       cache.Interface
+	option      *config.AiOptions
+	authOptions *authoptions.AuthenticationOptions
+	now         func() time.Time
+}
+
+func NewMFAOperator(aiClient ai.Interface, k8sClient kubernetes.Interface, cache cache.Interface,
+	option *config.AiOptions, authOptions *authoptions.AuthenticationOptions) MFAManagementInterface {
+	return &mfaOperator{
+		aiClient:    aiClient,
+		k8sClient:   k8sClient,
+		cache:       cache,
+		option:      option,
+		authOptions: authOptions,
+		now:         time.Now,
+	}
+}
+
+func (m *mfaOperator) Status(username string) (*MFAStatus, error) {
+	user, err := m.aiClient.IamV1().Users().Get(context.Background(), username, metav1.GetOptions{})
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	secret, err := m.k8sClient.CoreV1().Secrets(m.option.Namespace).Get(context.Background(), m.secretName(username), metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return &MFAStatus{}, nil
+		}
+		klog.Error(err)
+		return nil, err
+	}
+	return &MFAStatus{
+		Enabled:                MFAEnabled(user),
+		Pending:                !MFAEnabled(user),
+		RecoveryCodesRemaining: len(recoveryCodeHashes(secret)),
+	}, nil
+}
+
+func (m *mfaOperator) Enroll(username string) (*MFAEnrollment, error) {
+	user, err := m.aiClient.IamV1().Users().Get(context.Background(), username, metav1.GetOptions{})
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	if MFAEnabled(user) {
+		return nil, errors.NewConflict(iamv1.Resource(iamv1.ResourcesSingularUser), username, MFAAlreadyEnabledError)
+	}
+
+	totpSecret, err := GenerateTOTPSecret()
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	encrypted, err := m.encrypt([]byte(totpSecret))
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	var recoveryCodes, hashes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			klog.Error(err)
+			return nil, err
+		}
+		recoveryCodes = append(recoveryCodes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	data := map[string][]byte{
+		mfaSecretKey:        encrypted,
+		mfaRecoveryCodesKey: []byte(strings.Join(hashes, "\n")),
+	}
+	// the secret of an unfinished enrollment is replaced
+	secrets := m.k8sClient.CoreV1().Secrets(m.option.Namespace)
+	secret, err := secrets.Get(context.Background(), m.secretName(username), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = secrets.Create(context.Background(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   m.secretName(username),
+				Labels: map[string]string{iamv1.UserReferenceLabel: username},
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: data,
+		}, metav1.CreateOptions{})
+	} else if err == nil {
+		secret = secret.DeepCopy()
+		secret.Data = data
+		_, err = secrets.Update(context.Background(), secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+
+	return &MFAEnrollment{
+		Secret:        totpSecret,
+		OTPAuthURL:    TOTPKeyURI(m.issuer(), username, totpSecret),
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (m *mfaOperator) Activate(username string, code string) error {
+	user, err := m.aiClient.IamV1().Users().Get(context.Background(), username, metav1.GetOptions{})
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+	if MFAEnabled(user) {
+		return errors.NewConflict(iamv1.Resource(iamv1.ResourcesSingularUser), username, MFAAlreadyEnabledError)
+	}
+	secret, err := m.k8sClient.CoreV1().Secrets(m.option.Namespace).Get(context.Background(), m.secretName(username), metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return MFANotEnrolledError
+		}
+		klog.Error(err)
+		return err
+	}
+	if err = m.verifyTOTP(username, secret, code); err != nil {
+		return err
+	}
+
+	user = user.DeepCopy()
+	if user.Annotations == nil {
+		user.Annotations = make(map[string]string)
+	}
+	user.Annotations[iamv1.MFASecretAnnotation] = secret.Name
+	if _, err = m.aiClient.IamV1().Users().Update(context.Background(), user, metav1.UpdateOptions{}); err != nil {
+		klog.Error(err)
+		return err
+	}
+	return nil
+}
+
+func (m *mfaOperator) Disable(username string, code string) error {
+	user, err := m.aiClient.IamV1().Users().Get(context.Background(), username, metav1.GetOptions{})
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+	if MFAEnabled(user) {
+		if err = m.Verify(username, code); err != nil {
+			return err
+		}
+		user = user.DeepCopy()
+		delete(user.Annotations, iamv1.MFASecretAnnotation)
+		if _, err = m.aiClient.IamV1().Users().Update(context.Background(), user, metav1.UpdateOptions{}); err != nil {
+			klog.Error(err)
+			return err
+		}
+	}
+	err = m.k8sClient.CoreV1().Secrets(m.option.Namespace).Delete(context.Background(), m.secretName(username), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		klog.Error(err)
+		return err
+	}
+	return nil
+}
+
+func (m *mfaOperator) Verify(username string, code string) error {
+	user, err := m.aiClient.IamV1().Users().Get(context.Background(), username, metav1.GetOptions{})
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+	if !MFAEnabled(user) {
+		return MFANotEnabledError
+	}
+	secret, err := m.k8sClient.CoreV1().Secrets(m.option.Namespace).Get(context.Background(), user.Annotations[iamv1.MFASecretAnnotation], metav1.GetOptions{})
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+	if isTOTPCode(code) {
+		return m.verifyTOTP(username, secret, code)
+	}
+	return m.useRecoveryCode(secret, code)
+}
+
+func (m *mfaOperator) Challenge(user authuser.Info, provider string) (string, error) {
+	challenge := &MFAChallenge{
+		Username:  user.GetName(),
+		UID:       user.GetUID(),
+		Groups:    user.GetGroups(),
+		Extra:     user.GetExtra(),
+		Provider:  provider,
+		ExpiresAt: m.now().Add(MFAChallengeMaxAge),
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		klog.Error(err)
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	if err := m.saveChallenge(token, challenge); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// VerifyChallenge takes the challenge atomically, so the concurrent requests can not verify the codes
+// against the same attempts. The challenge is saved back with the attempt counted if the code is incorrect.
+func (m *mfaOperator) VerifyChallenge(token string, code string) (*MFAChallenge, error) {
+	value, err := m.cache.GetDel(mfaChallengeCacheKey(token))
+	if err != nil {
+		if err == cache.ErrNoSuchKey {
+			return nil, InvalidMFAChallengeError
+		}
+		klog.Error(err)
+		return nil, err
+	}
+	challenge := &MFAChallenge{}
+	if err = json.Unmarshal([]byte(value), challenge); err != nil {
+		klog.Error(err)
+		return nil, InvalidMFAChallengeError
+	}
+	if !m.now().Before(challenge.ExpiresAt) {
+		return nil, InvalidMFAChallengeError
+	}
+
+	if err = m.Verify(challenge.Username, code); err != nil {
+		if err != InvalidMFACodeError {
+			return nil, err
+		}
+		challenge.Attempts++
+		if challenge.Attempts < maxMFAChallengeAttempts {
+			if err = m.saveChallenge(token, challenge); err != nil {
+				return nil, err
+			}
+		}
+		return challenge, InvalidMFACodeError
+	}
+	return challenge, nil
+}
+
+// saveChallenge keeps the challenge until it expires, the expired challenge is dropped
+func (m *mfaOperator) saveChallenge(token string, challenge *MFAChallenge) error {
+	ttl := challenge.ExpiresAt.Sub(m.now())
+	if ttl <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return err
+	}
+	if err = m.cache.Set(mfaChallengeCacheKey(token), string(data), ttl); err != nil {
+		klog.Error(err)
+		return err
+	}
+	return nil
+}
+
+// verifyTOTP checks the one-time password, a password is refused once it has been accepted
+func (m *mfaOperator) verifyTOTP(username string, secret *corev1.Secret, code string) error {
+	plaintext, err := m.decrypt(secret.Data[mfaSecretKey])
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+	step, ok := ValidateTOTP(string(plaintext), code, m.now())
+	if !ok {
+		return InvalidMFACodeError
+	}
+	// the code is marked used atomically, only one of the concurrent requests accepts it
+	unused, err := m.cache.SetNX(totpUsedCacheKey(username, step), "", (2*totpSkew+1)*TOTPPeriod)
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+	if !unused {
+		return InvalidMFACodeError
+	}
+	return nil
+}
+
+// useRecoveryCode removes the matched recovery code, concurrent uses of the same code conflict on update
+func (m *mfaOperator) useRecoveryCode(secret *corev1.Secret, code string) error {
+	hash := hashRecoveryCode(code)
+	hashes := recoveryCodeHashes(secret)
+	var remaining []string
+	for _, h := range hashes {
+		if h != hash {
+			remaining = append(remaining, h)
+		}
+	}
+	if len(remaining) == len(hashes) {
+		return InvalidMFACodeError
+	}
+	secret = secret.DeepCopy()
+	secret.Data[mfaRecoveryCodesKey] = []byte(strings.Join(remaining, "\n"))
+	if _, err := m.k8sClient.CoreV1().Secrets(m.option.Namespace).Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+		klog.Error(err)
+		return err
+	}
+	return nil
+}
+
+func (m *mfaOperator) secretName(username string) string {
+	return fmt.Sprintf("%smfa-%s", m.option.NamePrefix, username)
+}
+
+func (m *mfaOperator) issuer() string {
+	if m.authOptions.MFAOptions != nil && m.authOptions.MFAOptions.Issuer != "" {
+		return m.authOptions.MFAOptions.Issuer
+	}
+	return "ai"
+}
+
+// encryptionKey derives the AES-256 key from the configured key, the jwt secret is used if it is not set
+func (m *mfaOperator) encryptionKey() ([]byte, error) {
+	key := m.authOptions.JwtSecret
+	if m.authOptions.MFAOptions != nil && m.authOptions.MFAOptions.EncryptionKey != "" {
+		key = m.authOptions.MFAOptions.EncryptionKey
+	}
+	if key == "" {
+		return nil, fmt.Errorf("mfa encryption key is not configured")
+	}
+	sum := sha256.Sum256([]byte(key))
+	return sum[:], nil
+}
+
+func (m *mfaOperator) newAEAD() (cipher.AEAD, error) {
+	key, err := m.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (m *mfaOperator) encrypt(plaintext []byte) ([]byte, error) {
+	aead, err := m.newAEAD()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (m *mfaOperator) decrypt(ciphertext []byte) ([]byte, error) {
+	aead, err := m.newAEAD()
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("mfa secret is malformed")
+	}
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != TOTPDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// generateRecoveryCode returns a code like "abcde-fghij"
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode ignores the case and the separators of the code
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+func recoveryCodeHashes(secret *corev1.Secret) []string {
+	var hashes []string
+	for _, hash := range strings.Split(string(secret.Data[mfaRecoveryCodesKey]), "\n") {
+		if hash != "" {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
+
+func mfaChallengeCacheKey(token string) string {
+	return fmt.Sprintf("ai:mfa:challenge:%s", token)
+}
+
+func totpUsedCacheKey(username string, step uint64) string {
+	return fmt.Sprintf("ai:mfa:totp:%s:%d", username, step)
+}
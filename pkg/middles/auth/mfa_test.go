@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/config"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	aifake "github.com/wongearl/go-restful-template/pkg/client/ai/clientset/versioned/fake"
+	"github.com/wongearl/go-restful-template/pkg/client/cache"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	authuser "k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestMFAOperator(t *testing.T) (*mfaOperator, *fake.Clientset, *aifake.Clientset) {
+	cacheClient, err := cache.NewInMemoryCache(nil, make(chan struct{}))
+	assert.Nil(t, err)
+	authOptions := authoptions.NewAuthenticateOptions()
+	authOptions.JwtSecret = "secret"
+	k8sClient := fake.NewSimpleClientset()
+	aiClient := aifake.NewSimpleClientset(&iamv1.User{ObjectMeta: metav1.ObjectMeta{Name: "alice"}})
+	operator := NewMFAOperator(aiClient, k8sClient, cacheClient, &config.AiOptions{Namespace: "ai-system"}, authOptions).(*mfaOperator)
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	operator.now = func() time.Time { return now }
+	return operator, k8sClient, aiClient
+}
+
+func TestMFAEnrollment(t *testing.T) {
+	operator, k8sClient, aiClient := newTestMFAOperator(t)
+
+	status, err := operator.Status("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, &MFAStatus{}, status)
+
+	enrollment, err := operator.Enroll("alice")
+	assert.Nil(t, err)
+	assert.Len(t, enrollment.RecoveryCodes, recoveryCodeCount)
+	assert.True(t, strings.HasPrefix(enrollment.OTPAuthURL, "otpauth://totp/ai:alice?"))
+	// the secret is stored encrypted
+	secret, err := k8sClient.CoreV1().Secrets("ai-system").Get(context.Background(), "mfa-alice", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.NotContains(t, string(secret.Data[mfaSecretKey]), enrollment.Secret)
+	assert.NotContains(t, string(secret.Data[mfaRecoveryCodesKey]), enrollment.RecoveryCodes[0])
+	status, err = operator.Status("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, &MFAStatus{Pending: true, RecoveryCodesRemaining: recoveryCodeCount}, status)
+	// not enabled before activated
+	assert.Equal(t, MFANotEnabledError, operator.Verify("alice", enrollment.RecoveryCodes[0]))
+
+	// enroll again before activated
+	enrollment, err = operator.Enroll("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, InvalidMFACodeError, operator.Activate("alice", "000000"))
+	code, err := TOTPCode(enrollment.Secret, operator.now())
+	assert.Nil(t, err)
+	assert.Nil(t, operator.Activate("alice", code))
+	user, err := aiClient.IamV1().Users().Get(context.Background(), "alice", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.True(t, MFAEnabled(user))
+	assert.Equal(t, "mfa-alice", user.Annotations[iamv1.MFASecretAnnotation])
+
+	_, err = operator.Enroll("alice")
+	assert.True(t, errors.IsConflict(err))
+
+	// the code of the user is required to disable MFA, the activation code can not be replayed
+	assert.Equal(t, InvalidMFACodeError, operator.Disable("alice", ""))
+	assert.Equal(t, InvalidMFACodeError, operator.Disable("alice", code))
+	user, err = aiClient.IamV1().Users().Get(context.Background(), "alice", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.True(t, MFAEnabled(user))
+	assert.Nil(t, operator.Disable("alice", enrollment.RecoveryCodes[0]))
+	user, err = aiClient.IamV1().Users().Get(context.Background(), "alice", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.False(t, MFAEnabled(user))
+	_, err = k8sClient.CoreV1().Secrets("ai-system").Get(context.Background(), "mfa-alice", metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+	assert.Equal(t, MFANotEnrolledError, operator.Activate("alice", code))
+
+	// the enrollment not activated yet is cancelled without the code
+	_, err = operator.Enroll("alice")
+	assert.Nil(t, err)
+	assert.Nil(t, operator.Disable("alice", ""))
+	_, err = k8sClient.CoreV1().Secrets("ai-system").Get(context.Background(), "mfa-alice", metav1.GetOptions{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestMFAVerify(t *testing.T) {
+	operator, _, _ := newTestMFAOperator(t)
+	enrollment, err := operator.Enroll("alice")
+	assert.Nil(t, err)
+	code, _ := TOTPCode(enrollment.Secret, operator.now())
+	assert.Nil(t, operator.Activate("alice", code))
+
+	// the code used for activation can not be replayed
+	assert.Equal(t, InvalidMFACodeError, operator.Verify("alice", code))
+	operator.now = func() time.Time { return time.Date(2023, 1, 1, 12, 1, 0, 0, time.UTC) }
+	code, _ = TOTPCode(enrollment.Secret, operator.now())
+	assert.Nil(t, operator.Verify("alice", code))
+	assert.Equal(t, InvalidMFACodeError, operator.Verify("alice", code))
+
+	// recovery codes are case insensitive and can be used only once
+	assert.Nil(t, operator.Verify("alice", strings.ToUpper(enrollment.RecoveryCodes[0])))
+	assert.Equal(t, InvalidMFACodeError, operator.Verify("alice", enrollment.RecoveryCodes[0]))
+	assert.Equal(t, InvalidMFACodeError, operator.Verify("alice", "abcde-fghij"))
+	status, err := operator.Status("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, recoveryCodeCount-1, status.RecoveryCodesRemaining)
+
+	// the secret can not be decrypted by another key
+	operator.authOptions.MFAOptions.EncryptionKey = "another"
+	operator.now = func() time.Time { return time.Date(2023, 1, 1, 12, 2, 0, 0, time.UTC) }
+	code, _ = TOTPCode(enrollment.Secret, operator.now())
+	assert.NotNil(t, operator.Verify("alice", code))
+}
+
+func TestMFAChallenge(t *testing.T) {
+	operator, _, _ := newTestMFAOperator(t)
+	enrollment, err := operator.Enroll("alice")
+	assert.Nil(t, err)
+	code, _ := TOTPCode(enrollment.Secret, operator.now())
+	assert.Nil(t, operator.Activate("alice", code))
+
+	user := &authuser.DefaultInfo{Name: "alice", Groups: []string{"developers"}}
+	token, err := operator.Challenge(user, "ldap")
+	assert.Nil(t, err)
+	challenge, err := operator.VerifyChallenge(token, "000000")
+	assert.Equal(t, InvalidMFACodeError, err)
+	assert.Equal(t, "alice", challenge.Username)
+	challenge, err = operator.VerifyChallenge(token, enrollment.RecoveryCodes[0])
+	assert.Nil(t, err)
+	assert.Equal(t, user.Name, challenge.User().GetName())
+	assert.Equal(t, user.Groups, challenge.User().GetGroups())
+	assert.Equal(t, "ldap", challenge.Provider)
+	// a challenge can only be used once
+	_, err = operator.VerifyChallenge(token, enrollment.RecoveryCodes[1])
+	assert.Equal(t, InvalidMFAChallengeError, err)
+
+	// the challenge is invalidated after too many incorrect codes
+	token, err = operator.Challenge(user, "")
+	assert.Nil(t, err)
+	for i := 0; i < maxMFAChallengeAttempts; i++ {
+		_, err = operator.VerifyChallenge(token, "000000")
+		assert.Equal(t, InvalidMFACodeError, err)
+	}
+	_, err = operator.VerifyChallenge(token, enrollment.RecoveryCodes[1])
+	assert.Equal(t, InvalidMFAChallengeError, err)
+}
+
+func TestMFAChallengeExpiry(t *testing.T) {
+	operator, _, _ := newTestMFAOperator(t)
+	enrollment, err := operator.Enroll("alice")
+	assert.Nil(t, err)
+	code, _ := TOTPCode(enrollment.Secret, operator.now())
+	assert.Nil(t, operator.Activate("alice", code))
+	now := operator.now()
+	operator.now = func() time.Time { return now }
+	operator.cache = &fakeClockCache{now: &now, items: map[string]fakeCacheItem{}}
+
+	token, err := operator.Challenge(&authuser.DefaultInfo{Name: "alice"}, "")
+	assert.Nil(t, err)
+	now = now.Add(MFAChallengeMaxAge - time.Minute)
+	_, err = operator.VerifyChallenge(token, "000000")
+	assert.Equal(t, InvalidMFACodeError, err)
+	// the incorrect code does not extend the challenge
+	now = now.Add(time.Minute)
+	_, err = operator.VerifyChallenge(token, enrollment.RecoveryCodes[0])
+	assert.Equal(t, InvalidMFAChallengeError, err)
+}
+
+func TestMFAChallengeConcurrently(t *testing.T) {
+	operator, _, _ := newTestMFAOperator(t)
+	enrollment, err := operator.Enroll("alice")
+	assert.Nil(t, err)
+	code, _ := TOTPCode(enrollment.Secret, operator.now())
+	assert.Nil(t, operator.Activate("alice", code))
+	token, err := operator.Challenge(&authuser.DefaultInfo{Name: "alice"}, "")
+	assert.Nil(t, err)
+
+	// the incorrect codes verified in parallel are counted as the attempts
+	var wg sync.WaitGroup
+	var incorrect int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := operator.VerifyChallenge(token, "000000"); err == InvalidMFACodeError {
+				atomic.AddInt32(&incorrect, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, int(incorrect), maxMFAChallengeAttempts)
+
+	// a one-time password verified in parallel is only accepted once
+	operator.now = func() time.Time { return time.Date(2023, 1, 1, 12, 1, 0, 0, time.UTC) }
+	code, _ = TOTPCode(enrollment.Secret, operator.now())
+	var accepted int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if operator.Verify("alice", code) == nil {
+				atomic.AddInt32(&accepted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), accepted)
+}
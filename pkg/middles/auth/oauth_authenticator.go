@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/identityprovider"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
+	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	ai "github.com/wongearl/go-restful-template/pkg/client/ai/clientset/versioned"
+	iamv1informers "github.com/wongearl/go-restful-template/pkg/client/ai/informers/externalversions/iam.ai.io/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	authuser "k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/klog"
+)
+
+var (
+	UserNotMappedError        = fmt.Errorf("identity is not mapped to any user")
+	UsernameAlreadyTakenError = fmt.Errorf("username is already taken by another user")
+)
+
+// OAuthAuthenticator authenticates users by the authorization code of an OAuth identity provider
+type OAuthAuthenticator interface {
+	// Authenticate exchanges the code for an identity, and returns the user mapped to it and the provider name
+	Authenticate(provider, code string) (authuser.Info, string, error)
+}
+
+type oauthAuthenticator struct {
+	aiClient    ai.Interface
+	userGetter  *userGetter
+	authOptions *authoptions.AuthenticationOptions
+}
+
+func NewOAuthAuthenticator(aiClient ai.Interface,
+	userInformer iamv1informers.UserInformer,
+	authOptions *authoptions.AuthenticationOptions) OAuthAuthenticator {
+	return &oauthAuthenticator{
+		aiClient:    aiClient,
+		userGetter:  newUserGetter(userInformer),
+		authOptions: authOptions,
+	}
+}
+
+func (o *oauthAuthenticator) Authenticate(provider, code string) (authuser.Info, string, error) {
+	providerOptions, err := o.authOptions.OAuthOptions.IdentityProviderOptions(provider)
+	if err != nil {
+		klog.Error(err)
+		return nil, "", err
+	}
+	oauthIdentityProvider, err := identityprovider.GetOAuthProvider(providerOptions.Name)
+	if err != nil {
+		klog.Error(err)
+		return nil, "", err
+	}
+	identity, err := oauthIdentityProvider.IdentityExchange(code)
+	if err != nil {
+		klog.Error(err)
+		return nil, "", err
+	}
+
+	user, err := o.userGetter.mapIdentity(o.aiClient, providerOptions, identity)
+	if err != nil {
+		return nil, "", err
+	}
+	return &authuser.DefaultInfo{Name: user.Name}, providerOptions.Name, nil
+}
+
+// mapIdentity returns the user mapped to the identity per the mapping method of the provider,
+// the user is provisioned if it is not mapped yet and the mapping method is auto. The groups of
+// the identity are synced onto the user, as the tokens are authenticated with the groups of the user.
+func (u *userGetter) mapIdentity(aiClient ai.Interface, providerOptions *oauth.IdentityProviderOptions, identity identityprovider.Identity) (*iamv1.User, error) {
+	user, err := u.findMappedUser(providerOptions.Name, identity.GetUserID())
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+
+	if user == nil {
+		switch providerOptions.MappingMethod {
+		case oauth.MappingMethodLookup:
+			klog.Errorf("%s, provider: %s, uid: %s", UserNotMappedError, providerOptions.Name, identity.GetUserID())
+			return nil, UserNotMappedError
+		case oauth.MappingMethodAuto, "":
+			return u.provisionUser(aiClient, providerOptions.Name, identity)
+		default:
+			err = fmt.Errorf("mapping method %s is not supported", providerOptions.MappingMethod)
+			klog.Error(err)
+			return nil, err
+		}
+	}
+
+	if err = CheckUserState(user); err != nil {
+		return nil, err
+	}
+	return syncGroups(aiClient, user, identity)
+}
+
+// syncGroups updates the groups of the user to the groups of the identity,
+// the user is not changed if the identity provider does not carry groups.
+func syncGroups(aiClient ai.Interface, user *iamv1.User, identity identityprovider.Identity) (*iamv1.User, error) {
+	groupsIdentity, ok := identity.(identityprovider.GroupsIdentity)
+	if !ok || sets.NewString(groupsIdentity.GetGroups()...).Equal(sets.NewString(user.Spec.Groups...)) {
+		return user, nil
+	}
+	user = user.DeepCopy()
+	user.Spec.Groups = groupsIdentity.GetGroups()
+	updated, err := aiClient.IamV1().Users().Update(context.Background(), user, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	return updated, nil
+}
+
+// provisionUser creates the user mapped to the identity, the username suggested by the
+// identity provider is used, it fails if the username is taken by another user.
+func (u *userGetter) provisionUser(aiClient ai.Interface, provider string, identity identityprovider.Identity) (*iamv1.User, error) {
+	username := strings.ToLower(identity.GetUsername())
+	if errs := validation.IsDNS1123Subdomain(username); len(errs) > 0 {
+		err := fmt.Errorf("username %q of identity provider %s is invalid: %s", identity.GetUsername(), provider, strings.Join(errs, ", "))
+		klog.Error(err)
+		return nil, err
+	}
+	if _, err := u.userLister.Get(username); err == nil {
+		klog.Errorf("%s, username: %s, provider: %s", UsernameAlreadyTakenError, username, provider)
+		return nil, UsernameAlreadyTakenError
+	}
+
+	user := &iamv1.User{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: username,
+			Labels: map[string]string{
+				iamv1.IdentifyProviderLabel: provider,
+				iamv1.OriginUIDLabel:        originUIDLabelValue(identity.GetUserID()),
+			},
+		},
+		Spec: iamv1.UserSpec{
+			Email: identity.GetEmail(),
+		},
+	}
+	if groupsIdentity, ok := identity.(identityprovider.GroupsIdentity); ok {
+		user.Spec.Groups = groupsIdentity.GetGroups()
+	}
+	created, err := aiClient.IamV1().Users().Create(context.Background(), user, metav1.CreateOptions{})
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	return created, nil
+}
+
+// findMappedUser returns the user mapped to the identity, nil is returned if not mapped
+func (u *userGetter) findMappedUser(provider, uid string) (*iamv1.User, error) {
+	users, err := u.userLister.List(labels.SelectorFromSet(labels.Set{
+		iamv1.IdentifyProviderLabel: provider,
+		iamv1.OriginUIDLabel:        originUIDLabelValue(uid),
+	}))
+	if err != nil {
+		return nil, err
+	}
+	switch len(users) {
+	case 0:
+		return nil, nil
+	case 1:
+		return users[0], nil
+	default:
+		return nil, fmt.Errorf("identity %s of provider %s is mapped to %d users", uid, provider, len(users))
+	}
+}
+
+// originUIDLabelValue returns the uid if it is a valid label value, the checksum of it otherwise
+func originUIDLabelValue(uid string) string {
+	if len(validation.IsValidLabelValue(uid)) == 0 {
+		return uid
+	}
+	sum := sha256.Sum256([]byte(uid))
+	return hex.EncodeToString(sum[:])[:validation.LabelValueMaxLength]
+}
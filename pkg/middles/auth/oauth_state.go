@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/wongearl/go-restful-template/pkg/client/cache"
+
+	"k8s.io/klog"
+)
+
+// OAuthStateMaxAge is the time the user has to log in at the OAuth identity provider
+const OAuthStateMaxAge = 10 * time.Minute
+
+var InvalidOAuthStateError = fmt.Errorf("state is invalid or expired")
+
+// OAuthStateStore keeps the state sent to the OAuth identity providers, the callback is only accepted
+// with a state issued for the same provider, which prevents login CSRF, see RFC 6749 section 10.12.
+type OAuthStateStore interface {
+	// Issue returns a new state for the redirection to the provider
+	Issue(provider string) (string, error)
+	// Consume verifies the state returned by the provider, a state can only be consumed once
+	Consume(provider, state string) error
+}
+
+type oauthStateStore struct {
+	cache cache.Interface
+}
+
+func NewOAuthStateStore(cache cache.Interface) OAuthStateStore {
+	return &oauthStateStore{cache: cache}
+}
+
+func (s *oauthStateStore) Issue(provider string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		klog.Error(err)
+		return "", err
+	}
+	state := base64.RawURLEncoding.EncodeToString(buf)
+	if err := s.cache.Set(oauthStateCacheKey(state), provider, OAuthStateMaxAge); err != nil {
+		klog.Error(err)
+		return "", err
+	}
+	return state, nil
+}
+
+func (s *oauthStateStore) Consume(provider, state string) error {
+	if state == "" {
+		return InvalidOAuthStateError
+	}
+	issuedFor, err := s.cache.GetDel(oauthStateCacheKey(state))
+	if err != nil {
+		if err == cache.ErrNoSuchKey {
+			return InvalidOAuthStateError
+		}
+		klog.Error(err)
+		return err
+	}
+	if issuedFor != provider {
+		return InvalidOAuthStateError
+	}
+	return nil
+}
+
+func oauthStateCacheKey(state string) string {
+	return fmt.Sprintf("ai:oauth:state:%s", state)
+}
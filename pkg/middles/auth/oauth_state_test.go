@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/wongearl/go-restful-template/pkg/client/cache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOAuthStateStore(t *testing.T) {
+	cacheClient, err := cache.NewInMemoryCache(nil, make(chan struct{}))
+	assert.Nil(t, err)
+	store := NewOAuthStateStore(cacheClient)
+
+	state, err := store.Issue("github")
+	assert.Nil(t, err)
+	assert.Len(t, state, 43)
+
+	assert.Nil(t, store.Consume("github", state))
+	assert.Equal(t, InvalidOAuthStateError, store.Consume("github", state))
+
+	state, err = store.Issue("github")
+	assert.Nil(t, err)
+	assert.Equal(t, InvalidOAuthStateError, store.Consume("oidc", state))
+	// the state is consumed by a callback of another provider as well
+	assert.Equal(t, InvalidOAuthStateError, store.Consume("github", state))
+
+	assert.Equal(t, InvalidOAuthStateError, store.Consume("github", ""))
+	assert.Equal(t, InvalidOAuthStateError, store.Consume("github", "invalid"))
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/hasher"
+	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+)
+
+// PasswordExpiredError is returned by the password authenticator until the user changes the expired password
+var PasswordExpiredError = fmt.Errorf("password has expired and must be changed")
+
+// The rules of the password policy
+const (
+	PasswordRuleMinLength = "minLength"
+	PasswordRuleUppercase = "uppercase"
+	PasswordRuleLowercase = "lowercase"
+	PasswordRuleDigit     = "digit"
+	PasswordRuleSymbol    = "symbol"
+	PasswordRuleDenylist  = "denylist"
+	PasswordRuleHistory   = "history"
+	PasswordRuleHash      = "hash"
+)
+
+// PasswordPolicyViolation describes a rule the password breaks
+type PasswordPolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError is returned if the password breaks the password policy
+type PasswordPolicyError struct {
+	Violations []PasswordPolicyViolation `json:"violations"`
+}
+
+func (e *PasswordPolicyError) Error() string {
+	var messages []string
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return fmt.Sprintf("password does not meet the password policy: %s", strings.Join(messages, "; "))
+}
+
+// ValidatePassword checks the password against the policy, history contains the hashes of the recent passwords
+func ValidatePassword(policy *authoptions.PasswordPolicyOptions, password string, history []string) error {
+	if policy == nil {
+		policy = authoptions.NewPasswordPolicyOptions()
+	}
+	var violations []PasswordPolicyViolation
+	violate := func(rule string, format string, args ...interface{}) {
+		violations = append(violations, PasswordPolicyViolation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// the user controller keeps the hashes as they are, see hasher.IsHash
+	if hasher.IsHash(password) {
+		violate(PasswordRuleHash, "password must not be a password hash")
+	}
+	if len([]rune(password)) < policy.MinLength {
+		violate(PasswordRuleMinLength, "password must be at least %d characters", policy.MinLength)
+	}
+	var upper, lower, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c):
+			symbol = true
+		}
+	}
+	if policy.RequireUppercase && !upper {
+		violate(PasswordRuleUppercase, "password must contain an uppercase letter")
+	}
+	if policy.RequireLowercase && !lower {
+		violate(PasswordRuleLowercase, "password must contain a lowercase letter")
+	}
+	if policy.RequireDigit && !digit {
+		violate(PasswordRuleDigit, "password must contain a digit")
+	}
+	if policy.RequireSymbol && !symbol {
+		violate(PasswordRuleSymbol, "password must contain a symbol")
+	}
+	for _, denied := range policy.Denylist {
+		if strings.EqualFold(password, denied) {
+			violate(PasswordRuleDenylist, "password is too common")
+			break
+		}
+	}
+	for i, hash := range history {
+		if i >= policy.HistorySize {
+			break
+		}
+		if hasher.Verify(hash, password) == nil {
+			violate(PasswordRuleHistory, "password must not be one of the last %d passwords", policy.HistorySize)
+			break
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// PasswordExpired returns whether the local password of the user has to be changed, the password may
+// expire before the user controller marks the user with MustChangePassword.
+func PasswordExpired(user *iamv1.User, policy *authoptions.PasswordPolicyOptions, now time.Time) bool {
+	if user.Status.MustChangePassword {
+		return true
+	}
+	if policy == nil || policy.MaxAge <= 0 || user.Status.LastPasswordChangeTime == nil {
+		return false
+	}
+	return !now.Before(user.Status.LastPasswordChangeTime.Add(policy.MaxAge))
+}
+
+// PasswordHistory returns the hashes of the current and the previous passwords of the user, the most recent first
+func PasswordHistory(user *iamv1.User) []string {
+	var history []string
+	if hasher.IsHash(user.Spec.EncryptedPassword) {
+		history = append(history, user.Spec.EncryptedPassword)
+	}
+	var previous []string
+	if value := user.Annotations[iamv1.PasswordHistoryAnnotation]; value != "" {
+		// the malformed history is ignored
+		_ = json.Unmarshal([]byte(value), &previous)
+	}
+	return append(history, previous...)
+}
+
+// SetPasswordHistory keeps the hashes of the previous passwords the policy refuses to reuse,
+// the current password is excluded since it is stored in the spec.
+func SetPasswordHistory(user *iamv1.User, history []string, size int) {
+	if size > 1 && len(history) > 0 {
+		if len(history) > size-1 {
+			history = history[:size-1]
+		}
+		data, _ := json.Marshal(history)
+		if user.Annotations == nil {
+			user.Annotations = make(map[string]string)
+		}
+		user.Annotations[iamv1.PasswordHistoryAnnotation] = string(data)
+		return
+	}
+	delete(user.Annotations, iamv1.PasswordHistoryAnnotation)
+}
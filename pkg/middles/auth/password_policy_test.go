@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func hash(t *testing.T, password string) string {
+	encrypted, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	assert.Nil(t, err)
+	return string(encrypted)
+}
+
+func rulesOf(err error) []string {
+	var rules []string
+	if policyErr, ok := err.(*PasswordPolicyError); ok {
+		for _, violation := range policyErr.Violations {
+			rules = append(rules, violation.Rule)
+		}
+	}
+	return rules
+}
+
+func TestValidatePassword(t *testing.T) {
+	policy := &authoptions.PasswordPolicyOptions{
+		MinLength:        8,
+		RequireUppercase: true,
+		RequireLowercase: true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+		Denylist:         []string{"P@ssw0rd1"},
+		HistorySize:      2,
+	}
+	history := []string{hash(t, "P@88w0rd-1"), hash(t, "P@88w0rd-2"), hash(t, "P@88w0rd-3")}
+
+	tests := []struct {
+		password string
+		rules    []string
+	}{
+		{"P@88w0rd", nil},
+		{"P@8w0rd", []string{PasswordRuleMinLength}},
+		{"p@88w0rd", []string{PasswordRuleUppercase}},
+		{"P@88W0RD", []string{PasswordRuleLowercase}},
+		{"P@ssword", []string{PasswordRuleDigit}},
+		{"P88w0rdx", []string{PasswordRuleSymbol}},
+		{"abc", []string{PasswordRuleMinLength, PasswordRuleUppercase, PasswordRuleDigit, PasswordRuleSymbol}},
+		{"p@SSW0RD1", []string{PasswordRuleDenylist}},
+		{"P@88w0rd-1", []string{PasswordRuleHistory}},
+		{"P@88w0rd-2", []string{PasswordRuleHistory}},
+		// out of the history size
+		{"P@88w0rd-3", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			assert.Equal(t, tt.rules, rulesOf(ValidatePassword(policy, tt.password, history)))
+		})
+	}
+
+	// the default policy only limits the length
+	assert.Nil(t, ValidatePassword(nil, "password", nil))
+	assert.Equal(t, []string{PasswordRuleMinLength}, rulesOf(ValidatePassword(nil, "passwd", nil)))
+	assert.Contains(t, ValidatePassword(nil, "passwd", nil).Error(), "at least 8 characters")
+	// the hash would be kept as it is by the user controller
+	assert.Equal(t, []string{PasswordRuleHash}, rulesOf(ValidatePassword(nil, history[0], nil)))
+}
+
+func TestPasswordHistory(t *testing.T) {
+	user := &iamv1.User{ObjectMeta: metav1.ObjectMeta{Name: "admin"}, Spec: iamv1.UserSpec{EncryptedPassword: "P@88w0rd"}}
+	// the plain text password is not a hash yet
+	assert.Empty(t, PasswordHistory(user))
+
+	current := hash(t, "P@88w0rd-1")
+	user.Spec.EncryptedPassword = current
+	history := PasswordHistory(user)
+	assert.Equal(t, []string{current}, history)
+
+	SetPasswordHistory(user, history, 3)
+	user.Spec.EncryptedPassword = hash(t, "P@88w0rd-2")
+	history = PasswordHistory(user)
+	assert.Equal(t, []string{user.Spec.EncryptedPassword, current}, history)
+
+	// only the previous passwords are kept besides the current one
+	SetPasswordHistory(user, []string{"3", "2", "1"}, 3)
+	assert.Equal(t, `["3","2"]`, user.Annotations[iamv1.PasswordHistoryAnnotation])
+	SetPasswordHistory(user, history, 1)
+	assert.NotContains(t, user.Annotations, iamv1.PasswordHistoryAnnotation)
+}
+
+func TestPasswordExpired(t *testing.T) {
+	now := time.Now()
+	policy := &authoptions.PasswordPolicyOptions{MaxAge: time.Hour}
+	changedAt := func(d time.Duration) *iamv1.User {
+		return &iamv1.User{Status: iamv1.UserStatus{LastPasswordChangeTime: &metav1.Time{Time: now.Add(d)}}}
+	}
+
+	assert.False(t, PasswordExpired(changedAt(-time.Minute), policy, now))
+	assert.True(t, PasswordExpired(changedAt(-time.Hour), policy, now))
+	assert.False(t, PasswordExpired(changedAt(-time.Hour), &authoptions.PasswordPolicyOptions{}, now))
+	assert.False(t, PasswordExpired(changedAt(-time.Hour), nil, now))
+	assert.False(t, PasswordExpired(&iamv1.User{}, policy, now))
+	assert.True(t, PasswordExpired(&iamv1.User{Status: iamv1.UserStatus{MustChangePassword: true}}, nil, now))
+}
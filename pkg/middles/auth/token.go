@@ -1,7 +1,11 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/oauth"
@@ -9,28 +13,84 @@ import (
 	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/token"
 	"github.com/wongearl/go-restful-template/pkg/client/cache"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/klog"
 )
 
+var (
+	StaticTokenRevokeError = fmt.Errorf("static token can not be revoked")
+	TokenNotFoundError     = fmt.Errorf("token not found in cache")
+	NotRefreshTokenError   = fmt.Errorf("token is not a refresh token")
+	// RefreshTokenReusedError means a refresh token is presented after it was rotated,
+	// it may have been stolen so all the tokens of its family are revoked
+	RefreshTokenReusedError = fmt.Errorf("refresh token has already been used")
+)
+
 type TokenManagementInterface interface {
 	// Verify verifies a token, and return a User if it's a valid token, otherwise return error
 	Verify(token string) (user.Info, error)
-	// IssueTo issues a token a User, return error if issuing process failed
-	IssueTo(user user.Info, accessTokenMaxAge, accessTokenInactivityTimeout *time.Duration) (*oauth.Token, error)
+	// Introspect verifies a token like Verify, and returns the details of the token
+	Introspect(token string) (*TokenIntrospection, error)
+	// IssueTo issues a token a User, return error if issuing process failed. It also returns the number of
+	// the login session tokens revoked if multiple login is not allowed.
+	IssueTo(user user.Info, accessTokenMaxAge, accessTokenInactivityTimeout *time.Duration) (*oauth.Token, int, error)
+	// Refresh verifies the refresh token and issues a new token pair of the same token family,
+	// the refresh token is invalidated so it can only be used once
+	Refresh(refreshToken string) (user.Info, *oauth.Token, error)
+	// IssueAccessToken issues an access token without a refresh token to the client, the token is
+	// not bound to a login session so the other tokens of the client stay valid
+	IssueAccessToken(user user.Info, expiresIn time.Duration) (*oauth.Token, error)
+	// Revoke invalidates the given access token or refresh token, invalid tokens are ignored
+	Revoke(token string) error
+	// RevokeAllFor invalidates all the login session tokens issued to the user, and returns the number of them.
+	// The static tokens are not bound to a login session, so they are left as is.
+	RevokeAllFor(username string) (int, error)
+	// ListSessions lists the valid tokens issued to the user
+	ListSessions(username string) ([]Session, error)
+}
+
+// Session describes a token issued to a user, the token itself is never exposed
+type Session struct {
+	// ID is the sha256 checksum of the token
+	ID        string          `json:"id"`
+	TokenType token.TokenType `json:"tokenType,omitempty"`
+	IssuedAt  *metav1.Time    `json:"issuedAt,omitempty"`
+	ExpiresAt *metav1.Time    `json:"expiresAt,omitempty"`
+}
+
+// TokenIntrospection describes a valid token, ExpiresAt is zero if the token never expires
+type TokenIntrospection struct {
+	User      user.Info
+	TokenType token.TokenType
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// tokenRecord is the value of the cached token
+type tokenRecord struct {
+	TokenType token.TokenType `json:"tokenType"`
+	IssuedAt  time.Time       `json:"issuedAt"`
+	ExpiresAt time.Time       `json:"expiresAt"`
+	// Family is shared by the tokens issued at login and all the tokens refreshed from them
+	Family string `json:"family,omitempty"`
 }
 
 type tokenOperator struct {
 	issuer  token.Issuer
 	options *authoptions.AuthenticationOptions
 	cache   cache.Interface
+	// now returns the current time, it is replaced in tests
+	now func() time.Time
 }
 
-func NewTokenOperator(cache cache.Interface, options *authoptions.AuthenticationOptions) TokenManagementInterface {
+func NewTokenOperator(cache cache.Interface, keys *token.KeySet, options *authoptions.AuthenticationOptions) TokenManagementInterface {
 	operator := &tokenOperator{
-		issuer:  token.NewTokenIssuer(options.JwtSecret, options.MaximumClockSkew),
+		issuer:  token.NewTokenIssuer(options.JwtSecret, keys, options.MaximumClockSkew),
 		options: options,
 		cache:   cache,
+		now:     time.Now,
 	}
 	return operator
 }
@@ -45,14 +105,51 @@ func (t tokenOperator) Verify(tokenStr string) (user.Info, error) {
 		tokenType == token.StaticToken {
 		return authenticated, nil
 	}
-	if err := t.tokenCacheValidate(authenticated.GetName(), tokenStr); err != nil {
+	if err := t.tokenCacheValidate(authenticated.GetName(), tokenStr, tokenType); err != nil {
 		klog.Error(err)
 		return nil, err
 	}
 	return authenticated, nil
 }
 
-func (t tokenOperator) IssueTo(user user.Info, accessTokenMaxAge, accessTokenInactivityTimeout *time.Duration) (*oauth.Token, error) {
+func (t tokenOperator) Introspect(tokenStr string) (*TokenIntrospection, error) {
+	authenticated, tokenType, err := t.issuer.Verify(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	introspection := &TokenIntrospection{User: authenticated, TokenType: tokenType}
+
+	value, err := t.cache.Get(tokenCacheKey(authenticated.GetName(), tokenStr))
+	if err == cache.ErrNoSuchKey {
+		// the tokens are not cached without max age, and the static tokens are not bound to a login session
+		if t.options.OAuthOptions.AccessTokenMaxAge == 0 || tokenType == token.StaticToken {
+			return introspection, nil
+		}
+		return nil, TokenNotFoundError
+	} else if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+
+	record := &tokenRecord{}
+	if err = json.Unmarshal([]byte(value), record); err == nil {
+		introspection.IssuedAt = record.IssuedAt
+		introspection.ExpiresAt = record.ExpiresAt
+	}
+	// the resource servers introspect the tokens on use, so the idle timeout is extended as well
+	if tokenType == token.AccessToken {
+		if err = t.extendIdleTimeout(authenticated.GetName(), tokenStr, record); err != nil {
+			return nil, err
+		}
+	}
+	return introspection, nil
+}
+
+func (t tokenOperator) IssueTo(user user.Info, accessTokenMaxAge, accessTokenInactivityTimeout *time.Duration) (*oauth.Token, int, error) {
+	return t.issueTo(user, accessTokenMaxAge, accessTokenInactivityTimeout, string(uuid.NewUUID()))
+}
+
+func (t tokenOperator) issueTo(user user.Info, accessTokenMaxAge, accessTokenInactivityTimeout *time.Duration, family string) (*oauth.Token, int, error) {
 	accessTokenExpiresIn := t.options.OAuthOptions.AccessTokenMaxAge
 	refreshTokenExpiresIn := accessTokenExpiresIn + t.options.OAuthOptions.AccessTokenInactivityTimeout
 	tokenType := "Bearer"
@@ -68,13 +165,13 @@ func (t tokenOperator) IssueTo(user user.Info, accessTokenMaxAge, accessTokenIna
 	accessToken, err := t.issuer.IssueTo(user, createTokenType, accessTokenExpiresIn)
 	if err != nil {
 		klog.Error(err)
-		return nil, err
+		return nil, 0, err
 	}
 
 	refreshToken, err := t.issuer.IssueTo(user, token.RefreshToken, refreshTokenExpiresIn)
 	if err != nil {
 		klog.Error(err)
-		return nil, err
+		return nil, 0, err
 	}
 
 	result := &oauth.Token{
@@ -84,35 +181,294 @@ func (t tokenOperator) IssueTo(user user.Info, accessTokenMaxAge, accessTokenIna
 		ExpiresIn:    int(accessTokenExpiresIn.Seconds()),
 	}
 
+	revoked := 0
 	if accessTokenExpiresIn > 0 {
-		if err = t.cacheToken(user.GetName(), accessToken, accessTokenExpiresIn); err != nil {
+		// only one session is allowed, the tokens issued before are invalidated,
+		// static tokens are not bound to a login session
+		if !t.options.MultipleLogin && createTokenType != token.StaticToken {
+			if revoked, err = t.RevokeAllFor(user.GetName()); err != nil {
+				klog.Error(err)
+				return nil, 0, err
+			}
+		}
+		if err = t.cacheToken(user.GetName(), accessToken, createTokenType, accessTokenExpiresIn, family); err != nil {
+			klog.Error(err)
+			return nil, 0, err
+		}
+		if err = t.cacheToken(user.GetName(), refreshToken, token.RefreshToken, refreshTokenExpiresIn, family); err != nil {
 			klog.Error(err)
+			return nil, 0, err
+		}
+	}
+
+	return result, revoked, nil
+}
+
+func (t tokenOperator) IssueAccessToken(user user.Info, expiresIn time.Duration) (*oauth.Token, error) {
+	accessToken, err := t.issuer.IssueTo(user, token.AccessToken, expiresIn)
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	if expiresIn > 0 {
+		if err = t.cacheToken(user.GetName(), accessToken, token.AccessToken, expiresIn, ""); err != nil {
 			return nil, err
 		}
-		if err = t.cacheToken(user.GetName(), refreshToken, refreshTokenExpiresIn); err != nil {
+	}
+	return &oauth.Token{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(expiresIn.Seconds()),
+	}, nil
+}
+
+func (t tokenOperator) Refresh(refreshToken string) (user.Info, *oauth.Token, error) {
+	authenticated, tokenType, err := t.issuer.Verify(refreshToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tokenType != token.RefreshToken {
+		return nil, nil, NotRefreshTokenError
+	}
+	// the tokens are not cached without max age, so they can not be rotated
+	if t.options.OAuthOptions.AccessTokenMaxAge == 0 {
+		result, _, err := t.IssueTo(authenticated, nil, nil)
+		return authenticated, result, err
+	}
+
+	username := authenticated.GetName()
+	// the token is taken out of the cache atomically, so only one of the concurrent requests can rotate it,
+	// the others find the token missing and are treated as reuse once the used token is remembered
+	value, err := t.cache.GetDel(tokenCacheKey(username, refreshToken))
+	if err == cache.ErrNoSuchKey {
+		family, err := t.cache.Get(usedTokenCacheKey(username, refreshToken))
+		if err == cache.ErrNoSuchKey {
+			return nil, nil, TokenNotFoundError
+		} else if err != nil {
+			klog.Error(err)
+			return nil, nil, err
+		}
+		if err = t.revokeFamily(username, family); err != nil {
+			return nil, nil, err
+		}
+		return authenticated, nil, RefreshTokenReusedError
+	} else if err != nil {
+		klog.Error(err)
+		return nil, nil, err
+	}
+
+	record := &tokenRecord{}
+	if err = json.Unmarshal([]byte(value), record); err != nil {
+		klog.Error(err)
+		return nil, nil, err
+	}
+	// the tokens issued before rotation was introduced start a new family
+	if record.Family == "" {
+		record.Family = string(uuid.NewUUID())
+	}
+	// the used token is remembered until it expires to detect the reuse
+	if remaining := record.ExpiresAt.Sub(t.now()); remaining > 0 {
+		if err = t.cache.Set(usedTokenCacheKey(username, refreshToken), record.Family, remaining); err != nil {
+			klog.Error(err)
+			return nil, nil, err
+		}
+	}
+
+	result, _, err := t.issueTo(authenticated, nil, nil, record.Family)
+	return authenticated, result, err
+}
+
+// revokeFamily invalidates all the tokens of the family issued to the user
+func (t tokenOperator) revokeFamily(username, family string) error {
+	keys, err := t.cache.Keys(tokenCachePattern(username))
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+	for _, key := range keys {
+		value, err := t.cache.Get(key)
+		if err != nil {
+			if err == cache.ErrNoSuchKey {
+				continue
+			}
+			klog.Error(err)
+			return err
+		}
+		record := &tokenRecord{}
+		if err = json.Unmarshal([]byte(value), record); err != nil || record.Family != family {
+			continue
+		}
+		if err = t.cache.Del(key); err != nil {
+			klog.Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (t tokenOperator) Revoke(tokenStr string) error {
+	authenticated, tokenType, err := t.issuer.Verify(tokenStr)
+	if err != nil {
+		// invalid or expired token can not be used anyway
+		klog.V(4).Info(err)
+		return nil
+	}
+	if tokenType == token.StaticToken {
+		return StaticTokenRevokeError
+	}
+	return t.cache.Del(tokenCacheKey(authenticated.GetName(), tokenStr))
+}
+
+func (t tokenOperator) RevokeAllFor(username string) (int, error) {
+	keys, err := t.cache.Keys(tokenCachePattern(username))
+	if err != nil {
+		klog.Error(err)
+		return 0, err
+	}
+	revoked := 0
+	for _, key := range keys {
+		value, err := t.cache.Get(key)
+		if err != nil {
+			if err == cache.ErrNoSuchKey {
+				continue
+			}
+			klog.Error(err)
+			return revoked, err
+		}
+		record := &tokenRecord{}
+		if err = json.Unmarshal([]byte(value), record); err == nil && record.TokenType == token.StaticToken {
+			continue
+		}
+		// the token is counted only if it is still there, it may be expired or revoked by another login meanwhile
+		if _, err = t.cache.GetDel(key); err != nil {
+			if err == cache.ErrNoSuchKey {
+				continue
+			}
+			klog.Error(err)
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+func (t tokenOperator) ListSessions(username string) ([]Session, error) {
+	prefix := tokenCacheKey(username, "")
+	keys, err := t.cache.Keys(tokenCachePattern(username))
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(keys))
+	for _, key := range keys {
+		value, err := t.cache.Get(key)
+		if err != nil {
+			// expired between Keys and Get
+			if err == cache.ErrNoSuchKey {
+				continue
+			}
 			klog.Error(err)
 			return nil, err
 		}
+		sum := sha256.Sum256([]byte(strings.TrimPrefix(key, prefix)))
+		session := Session{ID: hex.EncodeToString(sum[:])}
+		record := &tokenRecord{}
+		if err = json.Unmarshal([]byte(value), record); err == nil {
+			session.TokenType = record.TokenType
+			session.IssuedAt = &metav1.Time{Time: record.IssuedAt}
+			if !record.ExpiresAt.IsZero() {
+				session.ExpiresAt = &metav1.Time{Time: record.ExpiresAt}
+			}
+		}
+		sessions = append(sessions, session)
 	}
+	return sessions, nil
+}
 
-	return result, nil
+func (t tokenOperator) tokenCacheValidate(username, tokenStr string, tokenType token.TokenType) error {
+	key := tokenCacheKey(username, tokenStr)
+	if tokenType != token.AccessToken || t.options.OAuthOptions.AccessTokenInactivityTimeout <= 0 {
+		if exist, err := t.cache.Exists(key); err != nil {
+			return err
+		} else if !exist {
+			return TokenNotFoundError
+		}
+		return nil
+	}
+
+	value, err := t.cache.Get(key)
+	if err == cache.ErrNoSuchKey {
+		return TokenNotFoundError
+	} else if err != nil {
+		return err
+	}
+	record := &tokenRecord{}
+	if err = json.Unmarshal([]byte(value), record); err != nil {
+		return err
+	}
+	return t.extendIdleTimeout(username, tokenStr, record)
 }
 
-func (t tokenOperator) tokenCacheValidate(username, token string) error {
-	key := fmt.Sprintf("ai:user:%s:token:%s", username, token)
-	if exist, err := t.cache.Exists(key); err != nil {
+// extendIdleTimeout slides the TTL of the cached access token on every use, the tokens idle longer than
+// AccessTokenInactivityTimeout are dropped from the cache even if they are not expired yet.
+func (t tokenOperator) extendIdleTimeout(username, tokenStr string, record *tokenRecord) error {
+	if t.options.OAuthOptions.AccessTokenInactivityTimeout <= 0 {
+		return nil
+	}
+	ttl := t.idleTTL(record.ExpiresAt)
+	if ttl <= 0 {
+		return TokenNotFoundError
+	}
+	if err := t.cache.Expire(tokenCacheKey(username, tokenStr), ttl); err != nil {
+		if err == cache.ErrNoSuchKey {
+			return TokenNotFoundError
+		}
 		return err
-	} else if !exist {
-		return fmt.Errorf("token not found in cache")
 	}
 	return nil
 }
 
-func (t tokenOperator) cacheToken(username, token string, duration time.Duration) error {
-	key := fmt.Sprintf("ai:user:%s:token:%s", username, token)
-	if err := t.cache.Set(key, token, duration); err != nil {
+// idleTTL returns the inactivity timeout, or the remaining lifetime of the token if it expires earlier
+func (t tokenOperator) idleTTL(expiresAt time.Time) time.Duration {
+	ttl := t.options.OAuthOptions.AccessTokenInactivityTimeout
+	if remaining := expiresAt.Sub(t.now()); !expiresAt.IsZero() && remaining < ttl {
+		return remaining
+	}
+	return ttl
+}
+
+func (t tokenOperator) cacheToken(username, tokenStr string, tokenType token.TokenType, duration time.Duration, family string) error {
+	now := t.now()
+	record := &tokenRecord{TokenType: tokenType, IssuedAt: now, Family: family}
+	if duration > 0 {
+		record.ExpiresAt = now.Add(duration)
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	ttl := duration
+	if tokenType == token.AccessToken && t.options.OAuthOptions.AccessTokenInactivityTimeout > 0 {
+		ttl = t.idleTTL(record.ExpiresAt)
+	}
+	if err := t.cache.Set(tokenCacheKey(username, tokenStr), string(value), ttl); err != nil {
 		klog.Error(err)
 		return err
 	}
 	return nil
 }
+
+func tokenCacheKey(username, token string) string {
+	return fmt.Sprintf("ai:user:%s:token:%s", username, token)
+}
+
+// tokenCachePattern matches the keys of all the tokens of the user, the username is escaped
+// so that it can not match the tokens of the other users
+func tokenCachePattern(username string) string {
+	return tokenCacheKey(cache.EscapePattern(username), "*")
+}
+
+// usedTokenCacheKey is not matched by the patterns of tokenCacheKey, so the used tokens are not listed as sessions
+func usedTokenCacheKey(username, token string) string {
+	return fmt.Sprintf("ai:user:%s:usedtoken:%s", username, token)
+}
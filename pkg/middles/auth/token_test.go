@@ -0,0 +1,369 @@
+package auth
+
+import (
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/token"
+	"github.com/wongearl/go-restful-template/pkg/client/cache"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+func newTestTokenOperator(t *testing.T) (TokenManagementInterface, cache.Interface) {
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	cacheClient, err := cache.NewInMemoryCache(nil, stopCh)
+	assert.Nil(t, err)
+
+	options := authoptions.NewAuthenticateOptions()
+	options.JwtSecret = "secret"
+	options.MultipleLogin = true
+	return NewTokenOperator(cacheClient, nil, options), cacheClient
+}
+
+// fakeClockCache expires the keys by the fake clock of the test
+type fakeClockCache struct {
+	now   *time.Time
+	items map[string]fakeCacheItem
+}
+
+type fakeCacheItem struct {
+	value     string
+	expiresAt time.Time
+}
+
+func (c *fakeClockCache) live(key string) (fakeCacheItem, bool) {
+	item, ok := c.items[key]
+	if ok && !item.expiresAt.IsZero() && !c.now.Before(item.expiresAt) {
+		delete(c.items, key)
+		return item, false
+	}
+	return item, ok
+}
+
+func (c *fakeClockCache) Keys(pattern string) ([]string, error) {
+	var keys []string
+	for key := range c.items {
+		if _, ok := c.live(key); ok {
+			if matched, _ := path.Match(pattern, key); matched {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+func (c *fakeClockCache) Get(key string) (string, error) {
+	if item, ok := c.live(key); ok {
+		return item.value, nil
+	}
+	return "", cache.ErrNoSuchKey
+}
+
+func (c *fakeClockCache) Set(key string, value string, duration time.Duration) error {
+	item := fakeCacheItem{value: value}
+	if duration != cache.NeverExpire {
+		item.expiresAt = c.now.Add(duration)
+	}
+	c.items[key] = item
+	return nil
+}
+
+func (c *fakeClockCache) Del(keys ...string) error {
+	for _, key := range keys {
+		delete(c.items, key)
+	}
+	return nil
+}
+
+func (c *fakeClockCache) GetDel(key string) (string, error) {
+	value, err := c.Get(key)
+	delete(c.items, key)
+	return value, err
+}
+
+func (c *fakeClockCache) SetNX(key string, value string, duration time.Duration) (bool, error) {
+	if _, ok := c.live(key); ok {
+		return false, nil
+	}
+	return true, c.Set(key, value, duration)
+}
+
+func (c *fakeClockCache) Exists(keys ...string) (bool, error) {
+	for _, key := range keys {
+		if _, ok := c.live(key); !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (c *fakeClockCache) Expire(key string, duration time.Duration) error {
+	item, ok := c.live(key)
+	if !ok {
+		return cache.ErrNoSuchKey
+	}
+	return c.Set(key, item.value, duration)
+}
+
+func TestTokenRevoke(t *testing.T) {
+	operator, _ := newTestTokenOperator(t)
+
+	issued, _, err := operator.IssueTo(&user.DefaultInfo{Name: "admin"}, nil, nil)
+	assert.Nil(t, err)
+	_, err = operator.Verify(issued.AccessToken)
+	assert.Nil(t, err)
+
+	assert.Nil(t, operator.Revoke(issued.AccessToken))
+	_, err = operator.Verify(issued.AccessToken)
+	assert.NotNil(t, err)
+	// the refresh token is still valid
+	_, err = operator.Verify(issued.RefreshToken)
+	assert.Nil(t, err)
+
+	// invalid tokens are ignored
+	assert.Nil(t, operator.Revoke("invalid"))
+	assert.Nil(t, operator.Revoke(issued.AccessToken))
+
+	maxAge := time.Duration(0)
+	static, _, err := operator.IssueTo(&user.DefaultInfo{Name: "admin"}, &maxAge, &maxAge)
+	assert.Nil(t, err)
+	assert.Equal(t, StaticTokenRevokeError, operator.Revoke(static.AccessToken))
+}
+
+func TestTokenIntrospect(t *testing.T) {
+	operator, _ := newTestTokenOperator(t)
+
+	issued, _, err := operator.IssueTo(&user.DefaultInfo{Name: "admin", Groups: []string{"ops"}}, nil, nil)
+	assert.Nil(t, err)
+	introspection, err := operator.Introspect(issued.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", introspection.User.GetName())
+	assert.Equal(t, []string{"ops"}, introspection.User.GetGroups())
+	assert.Equal(t, token.AccessToken, introspection.TokenType)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), introspection.ExpiresAt, time.Minute)
+
+	introspection, err = operator.Introspect(issued.RefreshToken)
+	assert.Nil(t, err)
+	assert.Equal(t, token.RefreshToken, introspection.TokenType)
+
+	assert.Nil(t, operator.Revoke(issued.AccessToken))
+	_, err = operator.Introspect(issued.AccessToken)
+	assert.Equal(t, TokenNotFoundError, err)
+	_, err = operator.Introspect("invalid")
+	assert.NotNil(t, err)
+}
+
+func TestTokenRefreshRotation(t *testing.T) {
+	operator, _ := newTestTokenOperator(t)
+
+	first, _, err := operator.IssueTo(&user.DefaultInfo{Name: "admin"}, nil, nil)
+	assert.Nil(t, err)
+	other, _, err := operator.IssueTo(&user.DefaultInfo{Name: "admin"}, nil, nil)
+	assert.Nil(t, err)
+
+	_, _, err = operator.Refresh(first.AccessToken)
+	assert.Equal(t, NotRefreshTokenError, err)
+
+	authenticated, second, err := operator.Refresh(first.RefreshToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", authenticated.GetName())
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	// the used refresh token is invalidated, the access token is valid until it expires
+	_, err = operator.Verify(first.RefreshToken)
+	assert.NotNil(t, err)
+	_, err = operator.Verify(first.AccessToken)
+	assert.Nil(t, err)
+
+	_, third, err := operator.Refresh(second.RefreshToken)
+	assert.Nil(t, err)
+
+	// reusing a rotated refresh token revokes the whole family
+	_, _, err = operator.Refresh(first.RefreshToken)
+	assert.Equal(t, RefreshTokenReusedError, err)
+	for _, revoked := range []string{first.AccessToken, second.AccessToken, third.AccessToken, third.RefreshToken} {
+		_, err = operator.Verify(revoked)
+		assert.NotNil(t, err)
+	}
+	// the sessions of the other logins are untouched
+	_, err = operator.Verify(other.AccessToken)
+	assert.Nil(t, err)
+	sessions, err := operator.ListSessions("admin")
+	assert.Nil(t, err)
+	assert.Len(t, sessions, 2)
+
+	// revoked refresh tokens are not reuse
+	assert.Nil(t, operator.Revoke(other.RefreshToken))
+	_, _, err = operator.Refresh(other.RefreshToken)
+	assert.Equal(t, TokenNotFoundError, err)
+}
+
+func TestTokenRefreshConcurrently(t *testing.T) {
+	operator, _ := newTestTokenOperator(t)
+	issued, _, err := operator.IssueTo(&user.DefaultInfo{Name: "admin"}, nil, nil)
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	rotated := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := operator.Refresh(issued.RefreshToken); err == nil {
+				lock.Lock()
+				rotated++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// the refresh token is rotated only once
+	assert.Equal(t, 1, rotated)
+}
+
+func TestTokenInactivityTimeout(t *testing.T) {
+	now := time.Now()
+	options := authoptions.NewAuthenticateOptions()
+	options.JwtSecret = "secret"
+	options.MultipleLogin = true
+	options.OAuthOptions.AccessTokenMaxAge = 2 * time.Hour
+	options.OAuthOptions.AccessTokenInactivityTimeout = 30 * time.Minute
+	operator := NewTokenOperator(&fakeClockCache{now: &now, items: map[string]fakeCacheItem{}}, nil, options).(*tokenOperator)
+	operator.now = func() time.Time { return now }
+
+	issued, _, err := operator.IssueTo(&user.DefaultInfo{Name: "admin"}, nil, nil)
+	assert.Nil(t, err)
+
+	// every use slides the idle timeout
+	for i := 0; i < 3; i++ {
+		now = now.Add(20 * time.Minute)
+		_, err = operator.Verify(issued.AccessToken)
+		assert.Nil(t, err)
+	}
+	// the introspection by the resource servers is a use as well
+	now = now.Add(20 * time.Minute)
+	_, err = operator.Introspect(issued.AccessToken)
+	assert.Nil(t, err)
+
+	// the idle timeout never extends the token beyond its max age
+	now = now.Add(25 * time.Minute)
+	_, err = operator.Verify(issued.AccessToken)
+	assert.Nil(t, err)
+	now = now.Add(16 * time.Minute)
+	_, err = operator.Verify(issued.AccessToken)
+	assert.Equal(t, TokenNotFoundError, err)
+
+	// idle tokens are rejected though the jwt is not expired
+	idle, _, err := operator.IssueTo(&user.DefaultInfo{Name: "admin"}, nil, nil)
+	assert.Nil(t, err)
+	now = now.Add(31 * time.Minute)
+	_, err = operator.Verify(idle.AccessToken)
+	assert.Equal(t, TokenNotFoundError, err)
+	// the refresh token does not time out with the access token
+	_, _, err = operator.Refresh(idle.RefreshToken)
+	assert.Nil(t, err)
+
+	// zero means the tokens never time out
+	options.OAuthOptions.AccessTokenInactivityTimeout = 0
+	active, _, err := operator.IssueTo(&user.DefaultInfo{Name: "admin"}, nil, nil)
+	assert.Nil(t, err)
+	now = now.Add(90 * time.Minute)
+	_, err = operator.Verify(active.AccessToken)
+	assert.Nil(t, err)
+}
+
+func TestTokenSessions(t *testing.T) {
+	operator, _ := newTestTokenOperator(t)
+
+	for i := 0; i < 2; i++ {
+		_, _, err := operator.IssueTo(&user.DefaultInfo{Name: "admin"}, nil, nil)
+		assert.Nil(t, err)
+	}
+	other, _, err := operator.IssueTo(&user.DefaultInfo{Name: "other"}, nil, nil)
+	assert.Nil(t, err)
+
+	sessions, err := operator.ListSessions("admin")
+	assert.Nil(t, err)
+	assert.Len(t, sessions, 4)
+	accessTokens := 0
+	for _, session := range sessions {
+		assert.Len(t, session.ID, 64)
+		assert.NotNil(t, session.IssuedAt)
+		assert.NotNil(t, session.ExpiresAt)
+		if session.TokenType == token.AccessToken {
+			accessTokens++
+		}
+	}
+	assert.Equal(t, 2, accessTokens)
+
+	revoked, err := operator.RevokeAllFor("admin")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, revoked)
+	sessions, err = operator.ListSessions("admin")
+	assert.Nil(t, err)
+	assert.Len(t, sessions, 0)
+
+	// the cached static tokens are not bound to a login session
+	assert.Nil(t, operator.(*tokenOperator).cacheToken("admin", "static", token.StaticToken, time.Hour, ""))
+	revoked, err = operator.RevokeAllFor("admin")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, revoked)
+	sessions, err = operator.ListSessions("admin")
+	assert.Nil(t, err)
+	assert.Len(t, sessions, 1)
+
+	// tokens of other users are untouched
+	_, err = operator.Verify(other.AccessToken)
+	assert.Nil(t, err)
+	revoked, err = operator.RevokeAllFor("nobody")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, revoked)
+
+	// the glob characters in the username do not match the other users
+	sessions, err = operator.ListSessions("*")
+	assert.Nil(t, err)
+	assert.Empty(t, sessions)
+	_, err = operator.RevokeAllFor("o*")
+	assert.Nil(t, err)
+	_, err = operator.Verify(other.AccessToken)
+	assert.Nil(t, err)
+}
+
+func TestTokenSingleLogin(t *testing.T) {
+	operator, _ := newTestTokenOperator(t)
+	operator.(*tokenOperator).options.MultipleLogin = false
+
+	first, revoked, err := operator.IssueTo(&user.DefaultInfo{Name: "admin"}, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, revoked)
+	maxAge := time.Duration(0)
+	static, _, err := operator.IssueTo(&user.DefaultInfo{Name: "admin"}, &maxAge, &maxAge)
+	assert.Nil(t, err)
+	// static token does not supersede the login session
+	_, err = operator.Verify(first.AccessToken)
+	assert.Nil(t, err)
+
+	// the access token and the refresh token of the first session are revoked
+	second, revoked, err := operator.IssueTo(&user.DefaultInfo{Name: "admin"}, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, revoked)
+
+	_, err = operator.Verify(first.AccessToken)
+	assert.NotNil(t, err)
+	_, err = operator.Verify(first.RefreshToken)
+	assert.NotNil(t, err)
+	_, err = operator.Verify(second.AccessToken)
+	assert.Nil(t, err)
+	_, err = operator.Verify(static.AccessToken)
+	assert.Nil(t, err)
+
+	sessions, err := operator.ListSessions("admin")
+	assert.Nil(t, err)
+	assert.Len(t, sessions, 2)
+}
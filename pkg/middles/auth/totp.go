@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPDigits is the length of the one-time passwords
+	TOTPDigits = 6
+	// TOTPPeriod is the time step of the one-time passwords, the default of RFC 6238
+	TOTPPeriod = 30 * time.Second
+	// totpSkew is the number of time steps accepted before and after the current one,
+	// it tolerates the clock drift of the authenticator apps
+	totpSkew = 1
+	// totpSecretSize is the length of the shared secret, RFC 4226 recommends 160 bits
+	totpSecretSize = 20
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a random shared secret encoded in base32, the format accepted by authenticator apps
+func GenerateTOTPSecret() (string, error) {
+	buf := make([]byte, totpSecretSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// TOTPKeyURI returns the otpauth URI of the secret, which is rendered as a QR code to enroll authenticator apps,
+// see https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func TOTPKeyURI(issuer, account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(TOTPDigits))
+	params.Set("period", fmt.Sprint(int(TOTPPeriod.Seconds())))
+	label := url.PathEscape(issuer + ":" + account)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode())
+}
+
+// TOTPCode returns the one-time password of the secret at the given time
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, totpStep(t), TOTPDigits), nil
+}
+
+// ValidateTOTP checks the one-time password against the steps around the given time,
+// the matched step is returned so that callers can refuse to accept it again.
+func ValidateTOTP(secret, code string, t time.Time) (uint64, bool) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(code) != TOTPDigits {
+		return 0, false
+	}
+	current := totpStep(t)
+	for i := -totpSkew; i <= totpSkew; i++ {
+		step := current + uint64(i)
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step, TOTPDigits)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	return totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+}
+
+func totpStep(t time.Time) uint64 {
+	return uint64(t.Unix() / int64(TOTPPeriod.Seconds()))
+}
+
+// hotp is the HMAC-based one-time password algorithm defined in RFC 4226 section 5.3
+func hotp(key []byte, counter uint64, digits int) string {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(buf)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
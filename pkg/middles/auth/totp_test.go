@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHOTP(t *testing.T) {
+	// test vectors of RFC 6238 appendix B, SHA1 with 8 digits
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.code, hotp(key, totpStep(time.Unix(tt.unix, 0)), 8))
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	code, err := TOTPCode(secret, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "050471", code)
+
+	step, ok := ValidateTOTP(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, totpStep(now), step)
+	// the clock drift of one step is tolerated
+	_, ok = ValidateTOTP(secret, code, now.Add(TOTPPeriod))
+	assert.True(t, ok)
+	_, ok = ValidateTOTP(secret, code, now.Add(-TOTPPeriod))
+	assert.True(t, ok)
+	_, ok = ValidateTOTP(secret, code, now.Add(2*TOTPPeriod))
+	assert.False(t, ok)
+	_, ok = ValidateTOTP(secret, "000000", now)
+	assert.False(t, ok)
+	_, ok = ValidateTOTP(secret, "50471", now)
+	assert.False(t, ok)
+	_, ok = ValidateTOTP("invalid!", code, now)
+	assert.False(t, ok)
+}
+
+func TestTOTPKeyURI(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.Nil(t, err)
+	assert.Len(t, secret, 32)
+
+	uri, err := url.Parse(TOTPKeyURI("ai", "alice", secret))
+	assert.Nil(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/ai:alice", uri.Path)
+	assert.Equal(t, secret, uri.Query().Get("secret"))
+	assert.Equal(t, "ai", uri.Query().Get("issuer"))
+	assert.Equal(t, "30", uri.Query().Get("period"))
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	goerrors "errors"
 	"fmt"
+	"sort"
 
 	"github.com/wongearl/go-restful-template/pkg/aiserver/query"
 	"github.com/wongearl/go-restful-template/pkg/api"
@@ -14,19 +15,18 @@ import (
 	"github.com/wongearl/go-restful-template/pkg/constants"
 	resourcev1alpha3 "github.com/wongearl/go-restful-template/pkg/middles/resources/v1alpha3"
 	"github.com/wongearl/go-restful-template/pkg/middles/resources/v1alpha3/clusterrole"
-	"github.com/wongearl/go-restful-template/pkg/middles/resources/v1alpha3/clusterrolebinding"
 	"github.com/wongearl/go-restful-template/pkg/middles/resources/v1alpha3/globalrole"
-	"github.com/wongearl/go-restful-template/pkg/middles/resources/v1alpha3/globalrolebinding"
 	"github.com/wongearl/go-restful-template/pkg/middles/resources/v1alpha3/role"
-	"github.com/wongearl/go-restful-template/pkg/middles/resources/v1alpha3/rolebinding"
-	"github.com/wongearl/go-restful-template/pkg/utils/sliceutil"
 
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	listersv1 "k8s.io/client-go/listers/core/v1"
+	rbaclisters "k8s.io/client-go/listers/rbac/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
 )
 
@@ -66,27 +66,31 @@ type AccessManagementInterface interface {
 }
 
 type amOperator struct {
-	globalRoleBindingGetter  resourcev1alpha3.Interface
-	clusterRoleBindingGetter resourcev1alpha3.Interface
-	roleBindingGetter        resourcev1alpha3.Interface
-	globalRoleGetter         resourcev1alpha3.Interface
-	clusterRoleGetter        resourcev1alpha3.Interface
-	roleGetter               resourcev1alpha3.Interface
-	namespaceLister          listersv1.NamespaceLister
-	aiclient                 ai.Interface
-	k8sclient                kubernetes.Interface
+	globalRoleGetter  resourcev1alpha3.Interface
+	clusterRoleGetter resourcev1alpha3.Interface
+	roleGetter        resourcev1alpha3.Interface
+	namespaceLister   listersv1.NamespaceLister
+	roleBindingLister rbaclisters.RoleBindingLister
+	aiclient          ai.Interface
+	k8sclient         kubernetes.Interface
+
+	// the indexers look up the bindings by the subjects, see informers.SubjectUserIndex
+	globalRoleBindingIndexer  cache.Indexer
+	clusterRoleBindingIndexer cache.Indexer
+	roleBindingIndexer        cache.Indexer
 }
 
 func NewReadOnlyOperator(factory informers.InformerFactory) AccessManagementInterface {
 	return &amOperator{
-		globalRoleBindingGetter: globalrolebinding.New(factory.AiSharedInformerFactory()),
+		globalRoleGetter:  globalrole.New(factory.AiSharedInformerFactory()),
+		clusterRoleGetter: clusterrole.New(factory.KubernetesSharedInformerFactory()),
+		roleGetter:        role.New(factory.KubernetesSharedInformerFactory()),
+		namespaceLister:   factory.KubernetesSharedInformerFactory().Core().V1().Namespaces().Lister(),
+		roleBindingLister: factory.KubernetesSharedInformerFactory().Rbac().V1().RoleBindings().Lister(),
 
-		clusterRoleBindingGetter: clusterrolebinding.New(factory.KubernetesSharedInformerFactory()),
-		roleBindingGetter:        rolebinding.New(factory.KubernetesSharedInformerFactory()),
-		globalRoleGetter:         globalrole.New(factory.AiSharedInformerFactory()),
-		clusterRoleGetter:        clusterrole.New(factory.KubernetesSharedInformerFactory()),
-		roleGetter:               role.New(factory.KubernetesSharedInformerFactory()),
-		namespaceLister:          factory.KubernetesSharedInformerFactory().Core().V1().Namespaces().Lister(),
+		globalRoleBindingIndexer:  factory.AiSharedInformerFactory().Iam().V1().GlobalRoleBindings().Informer().GetIndexer(),
+		clusterRoleBindingIndexer: factory.KubernetesSharedInformerFactory().Rbac().V1().ClusterRoleBindings().Informer().GetIndexer(),
+		roleBindingIndexer:        factory.KubernetesSharedInformerFactory().Rbac().V1().RoleBindings().Informer().GetIndexer(),
 	}
 }
 
@@ -188,88 +192,81 @@ func (am *amOperator) GetClusterRoleOfUser(username string) (*rbacv1.ClusterRole
 }
 
 func (am *amOperator) ListClusterRoleBindings(username string) ([]*rbacv1.ClusterRoleBinding, error) {
-
-	roleBindings, err := am.clusterRoleBindingGetter.List("", query.New())
-	if err != nil {
-		return nil, err
-	}
-
-	result := make([]*rbacv1.ClusterRoleBinding, 0)
-	for _, obj := range roleBindings.Items {
-		roleBinding := obj.(*rbacv1.ClusterRoleBinding)
-		if contains(roleBinding.Subjects, username, nil) {
-			result = append(result, roleBinding)
-		}
-	}
-
-	return result, nil
+	return listBindingsBySubject[*rbacv1.ClusterRoleBinding](am.clusterRoleBindingIndexer, "", username, nil)
 }
 
 func (am *amOperator) ListGlobalRoleBindings(username string) ([]*iamv1.GlobalRoleBinding, error) {
-	roleBindings, err := am.globalRoleBindingGetter.List("", query.New())
-	if err != nil {
-		return nil, err
-	}
-
-	result := make([]*iamv1.GlobalRoleBinding, 0)
-	for _, obj := range roleBindings.Items {
-		roleBinding := obj.(*iamv1.GlobalRoleBinding)
-		if contains(roleBinding.Subjects, username, nil) {
-			result = append(result, roleBinding)
-		}
-	}
-
-	return result, nil
+	return listBindingsBySubject[*iamv1.GlobalRoleBinding](am.globalRoleBindingIndexer, "", username, nil)
 }
 
 func (am *amOperator) ListRoleBindings(username string, groups []string, namespace string) ([]*rbacv1.RoleBinding, error) {
-	roleBindings, err := am.roleBindingGetter.List(namespace, query.New())
+	roleBindings, err := listBindingsBySubject[*rbacv1.RoleBinding](am.roleBindingIndexer, namespace, username, groups)
 	if err != nil {
 		klog.Error(err)
 		return nil, err
 	}
-
-	result := make([]*rbacv1.RoleBinding, 0)
-	for _, obj := range roleBindings.Items {
-		roleBinding := obj.(*rbacv1.RoleBinding)
-		if contains(roleBinding.Subjects, username, groups) {
-			result = append(result, roleBinding)
-		}
-	}
-	return result, nil
+	return roleBindings, nil
 }
 
+// GetRoleBindingOfUser returns the role bindings created for the user, which are labeled with the user
 func (am *amOperator) GetRoleBindingOfUser(username string) ([]*rbacv1.RoleBinding, error) {
-	roleBindings, err := am.roleBindingGetter.List("", query.New())
+	roleBindings, err := am.roleBindingLister.List(labels.SelectorFromSet(labels.Set{iamv1.UserReferenceLabel: username}))
 	if err != nil {
 		klog.Error(err)
 		return nil, err
 	}
-
-	result := make([]*rbacv1.RoleBinding, 0)
-	for _, obj := range roleBindings.Items {
-		roleBinding := obj.(*rbacv1.RoleBinding)
-		if roleBinding.Labels[iamv1.UserReferenceLabel] == username {
-			result = append(result, roleBinding)
-		}
-	}
-	return result, nil
+	sortByCreationTimestamp(roleBindings)
+	return roleBindings, nil
 }
 
-func contains(subjects []rbacv1.Subject, username string, groups []string) bool {
-	// if username is nil means list all role bindings
+// listBindingsBySubject looks up the bindings of the user or the groups in the namespace by the subject indexes,
+// all the bindings are returned if the username is empty. The bindings are sorted like the lister results.
+func listBindingsBySubject[T metav1.Object](indexer cache.Indexer, namespace, username string, groups []string) ([]T, error) {
+	var objects []interface{}
 	if username == "" {
-		return true
+		objects = indexer.List()
+	} else {
+		byUser, err := indexer.ByIndex(informers.SubjectUserIndex, username)
+		if err != nil {
+			return nil, err
+		}
+		objects = byUser
+		for _, group := range groups {
+			byGroup, err := indexer.ByIndex(informers.SubjectGroupIndex, group)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, byGroup...)
+		}
 	}
-	for _, subject := range subjects {
-		if subject.Kind == rbacv1.UserKind && subject.Name == username {
-			return true
+
+	result := make([]T, 0, len(objects))
+	seen := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		binding := obj.(T)
+		if namespace != "" && binding.GetNamespace() != namespace {
+			continue
 		}
-		if subject.Kind == rbacv1.GroupKind && sliceutil.HasString(groups, subject.Name) {
-			return true
+		// the binding of both the user and the groups is only returned once
+		if key := binding.GetNamespace() + "/" + binding.GetName(); !seen[key] {
+			seen[key] = true
+			result = append(result, binding)
 		}
 	}
-	return false
+
+	sortByCreationTimestamp(result)
+	return result, nil
+}
+
+// sortByCreationTimestamp sorts the bindings like the lister results, the newest first
+func sortByCreationTimestamp[T metav1.Object](bindings []T) {
+	sort.Slice(bindings, func(i, j int) bool {
+		left, right := bindings[i].GetCreationTimestamp(), bindings[j].GetCreationTimestamp()
+		if left.Equal(&right) {
+			return bindings[i].GetName() > bindings[j].GetName()
+		}
+		return left.After(right.Time)
+	})
 }
 
 func (am *amOperator) ListRoles(namespace string, query *query.Query) (*rbacv1.RoleList, error) {
@@ -0,0 +1,177 @@
+package am
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
+	aifake "github.com/wongearl/go-restful-template/pkg/client/ai/clientset/versioned/fake"
+	"github.com/wongearl/go-restful-template/pkg/client/informers"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newTestOperator returns the operator of the factory, the objects are added to the indexers
+// of the informers directly, so the factory does not need to be started.
+func newTestOperator(t testing.TB, globalRoleBindings []*iamv1.GlobalRoleBinding, roleBindings []*rbacv1.RoleBinding) (*amOperator, informers.InformerFactory) {
+	factory := informers.NewInformerFactories(fake.NewSimpleClientset(), nil, aifake.NewSimpleClientset())
+	for _, globalRoleBinding := range globalRoleBindings {
+		assert.Nil(t, factory.AiSharedInformerFactory().Iam().V1().GlobalRoleBindings().Informer().GetIndexer().Add(globalRoleBinding))
+	}
+	for _, roleBinding := range roleBindings {
+		assert.Nil(t, factory.KubernetesSharedInformerFactory().Rbac().V1().RoleBindings().Informer().GetIndexer().Add(roleBinding))
+	}
+	return NewReadOnlyOperator(factory).(*amOperator), factory
+}
+
+func newRoleBinding(namespace, name string, created time.Time, subjects ...rbacv1.Subject) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, CreationTimestamp: metav1.NewTime(created)},
+		Subjects:   subjects,
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: iamv1.ResourceKindRole, Name: "viewer"},
+	}
+}
+
+func TestListRoleBindings(t *testing.T) {
+	now := time.Now()
+	alice := rbacv1.Subject{Kind: rbacv1.UserKind, Name: "alice"}
+	developers := rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "developers"}
+	userRef := newRoleBinding("default", "alice-viewer", now, alice)
+	userRef.Labels = map[string]string{iamv1.UserReferenceLabel: "alice"}
+	operator, _ := newTestOperator(t, nil, []*rbacv1.RoleBinding{
+		userRef,
+		newRoleBinding("default", "developers-viewer", now.Add(time.Minute), developers),
+		newRoleBinding("default", "both-viewer", now.Add(2*time.Minute), alice, developers),
+		newRoleBinding("kube-system", "alice-viewer", now, alice),
+		newRoleBinding("default", "bob-viewer", now, rbacv1.Subject{Kind: rbacv1.UserKind, Name: "bob"}),
+	})
+
+	roleBindings, err := operator.ListRoleBindings("alice", []string{"developers"}, "default")
+	assert.Nil(t, err)
+	var names []string
+	for _, roleBinding := range roleBindings {
+		names = append(names, roleBinding.Name)
+	}
+	// the newest first, and the binding of both the user and the group only once
+	assert.Equal(t, []string{"both-viewer", "developers-viewer", "alice-viewer"}, names)
+
+	roleBindings, err = operator.ListRoleBindings("alice", nil, "")
+	assert.Nil(t, err)
+	assert.Len(t, roleBindings, 3)
+
+	roleBindings, err = operator.ListRoleBindings("", nil, "default")
+	assert.Nil(t, err)
+	assert.Len(t, roleBindings, 4)
+
+	roleBindings, err = operator.GetRoleBindingOfUser("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, []*rbacv1.RoleBinding{userRef}, roleBindings)
+}
+
+func TestGetRoleBindingOfUser(t *testing.T) {
+	now := time.Now()
+	// the bindings labeled with the user are returned even if they bind a group of the user only
+	admin := newRoleBinding("project", "alice-admin", now, rbacv1.Subject{Kind: rbacv1.GroupKind, Name: "developers"})
+	admin.Labels = map[string]string{iamv1.UserReferenceLabel: "alice"}
+	viewer := newRoleBinding("default", "alice-viewer", now.Add(-time.Minute))
+	viewer.Labels = map[string]string{iamv1.UserReferenceLabel: "alice"}
+	operator, _ := newTestOperator(t, nil, []*rbacv1.RoleBinding{
+		admin,
+		viewer,
+		newRoleBinding("default", "unlabeled", now, rbacv1.Subject{Kind: rbacv1.UserKind, Name: "alice"}),
+	})
+
+	roleBindings, err := operator.GetRoleBindingOfUser("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, []*rbacv1.RoleBinding{admin, viewer}, roleBindings)
+
+	roleBindings, err = operator.GetRoleBindingOfUser("bob")
+	assert.Nil(t, err)
+	assert.Empty(t, roleBindings)
+}
+
+func TestListGlobalRoleBindings(t *testing.T) {
+	operator, _ := newTestOperator(t, []*iamv1.GlobalRoleBinding{
+		{ObjectMeta: metav1.ObjectMeta{Name: "alice-platform-regular"}, Subjects: []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "alice"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "developers-platform-regular"}, Subjects: []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "alice"}}},
+	}, nil)
+
+	globalRoleBindings, err := operator.ListGlobalRoleBindings("alice")
+	assert.Nil(t, err)
+	assert.Len(t, globalRoleBindings, 1)
+	assert.Equal(t, "alice-platform-regular", globalRoleBindings[0].Name)
+
+	globalRoleBindings, err = operator.ListGlobalRoleBindings("bob")
+	assert.Nil(t, err)
+	assert.Empty(t, globalRoleBindings)
+}
+
+const (
+	benchmarkUsers    = 10000
+	benchmarkBindings = 50000
+)
+
+// newBenchmarkOperator binds 10k users to 50k role bindings in 100 namespaces
+func newBenchmarkOperator(b *testing.B) (*amOperator, informers.InformerFactory) {
+	globalRoleBindings := make([]*iamv1.GlobalRoleBinding, 0, benchmarkUsers)
+	roleBindings := make([]*rbacv1.RoleBinding, 0, benchmarkBindings)
+	for i := 0; i < benchmarkUsers; i++ {
+		globalRoleBindings = append(globalRoleBindings, &iamv1.GlobalRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("user-%d-platform-regular", i)},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: fmt.Sprintf("user-%d", i)}},
+		})
+	}
+	for i := 0; i < benchmarkBindings; i++ {
+		roleBindings = append(roleBindings, newRoleBinding(fmt.Sprintf("namespace-%d", i%100), fmt.Sprintf("binding-%d", i), time.Now(),
+			rbacv1.Subject{Kind: rbacv1.UserKind, Name: fmt.Sprintf("user-%d", i%benchmarkUsers)}))
+	}
+	return newTestOperator(b, globalRoleBindings, roleBindings)
+}
+
+func BenchmarkListRoleBindings(b *testing.B) {
+	operator, _ := newBenchmarkOperator(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := operator.ListRoleBindings(fmt.Sprintf("user-%d", i%benchmarkUsers), nil, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkListRoleBindingsScan is the lookup scanning all the role bindings in the lister, for comparison
+func BenchmarkListRoleBindingsScan(b *testing.B) {
+	_, factory := newBenchmarkOperator(b)
+	roleBindingLister := factory.KubernetesSharedInformerFactory().Rbac().V1().RoleBindings().Lister()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		username := fmt.Sprintf("user-%d", i%benchmarkUsers)
+		roleBindings, err := roleBindingLister.List(labels.Everything())
+		if err != nil {
+			b.Fatal(err)
+		}
+		result := make([]*rbacv1.RoleBinding, 0)
+		for _, roleBinding := range roleBindings {
+			for _, subject := range roleBinding.Subjects {
+				if subject.Kind == rbacv1.UserKind && subject.Name == username {
+					result = append(result, roleBinding)
+					break
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkListGlobalRoleBindings(b *testing.B) {
+	operator, _ := newBenchmarkOperator(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := operator.ListGlobalRoleBindings(fmt.Sprintf("user-%d", i%benchmarkUsers)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -3,7 +3,9 @@ package im
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"github.com/wongearl/go-restful-template/pkg/aiserver/authentication/hasher"
 	authoptions "github.com/wongearl/go-restful-template/pkg/aiserver/authentication/options"
 	"github.com/wongearl/go-restful-template/pkg/aiserver/query"
 	iamv1 "github.com/wongearl/go-restful-template/pkg/api/iam.ai.io/v1"
@@ -24,6 +26,7 @@ type IdentityManagementInterface interface {
 	ModifyPassword(username string, password string) error
 	ListLoginRecords(username string, query *query.Query) (*iamv1.LoginRecordList, error)
 	PasswordVerify(username string, password string) error
+	UnlockUser(username string) (*iamv1.User, error)
 }
 
 func NewOperator(aiClient ai.Interface, userGetter resources.Interface, loginRecordGetter resources.Interface, options *authoptions.AuthenticationOptions) IdentityManagementInterface {
@@ -33,6 +36,11 @@ func NewOperator(aiClient ai.Interface, userGetter resources.Interface, loginRec
 		loginRecordGetter: loginRecordGetter,
 		options:           options,
 	}
+	var hashing *hasher.Options
+	if options != nil {
+		hashing = options.PasswordHashing
+	}
+	im.hasher = hasher.New(hashing)
 	return im
 }
 
@@ -41,6 +49,7 @@ type imOperator struct {
 	userGetter        resources.Interface
 	loginRecordGetter resources.Interface
 	options           *authoptions.AuthenticationOptions
+	hasher            hasher.Hasher
 }
 
 // UpdateUser returns user information after update.
@@ -79,15 +88,57 @@ func (im *imOperator) ModifyPassword(username string, password string) error {
 		klog.Error(err)
 		return err
 	}
-	user.Spec.EncryptedPassword = password
-	_, err = im.aiClient.IamV1().Users().Update(context.Background(), user, metav1.UpdateOptions{})
+	policy := im.passwordPolicy()
+	history := auth.PasswordHistory(user)
+	if err = auth.ValidatePassword(policy, password, history); err != nil {
+		return err
+	}
+	// the password is hashed before it is stored, so the plain text is never visible to the readers of the users
+	encrypted, err := im.hasher.Hash(password)
+	if err != nil {
+		klog.Error(err)
+		return err
+	}
+	// the current password becomes the latest in the history
+	auth.SetPasswordHistory(user, history, policy.HistorySize)
+	user.Spec.EncryptedPassword = encrypted
+	updated, err := im.aiClient.IamV1().Users().Update(context.Background(), user, metav1.UpdateOptions{})
 	if err != nil {
 		klog.Error(err)
 		return err
 	}
+	// the password change is recorded here since the user controller only records the plain text passwords it encrypts
+	updated.Status.LastPasswordChangeTime = &metav1.Time{Time: time.Now()}
+	updated.Status.MustChangePassword = false
+	if _, err = im.aiClient.IamV1().Users().UpdateStatus(context.Background(), updated, metav1.UpdateOptions{}); err != nil {
+		klog.Error(err)
+		return err
+	}
 	return nil
 }
 
+// UnlockUser activates the user blocked by failed login attempts before the block expires
+func (im *imOperator) UnlockUser(username string) (*iamv1.User, error) {
+	user, err := im.fetch(username)
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	if user.Status.State == nil || *user.Status.State != iamv1.UserAuthLimitExceeded {
+		return ensurePasswordNotOutput(user), nil
+	}
+	active := iamv1.UserActive
+	user.Status.State = &active
+	user.Status.Reason = ""
+	user.Status.LastTransitionTime = &metav1.Time{Time: time.Now()}
+	updated, err := im.aiClient.IamV1().Users().UpdateStatus(context.Background(), user, metav1.UpdateOptions{})
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	return ensurePasswordNotOutput(updated), nil
+}
+
 func (im *imOperator) ListUsers(query *query.Query) (list *iamv1.UserList, err error) {
 	result, err := im.userGetter.List("", query)
 	if err != nil {
@@ -133,6 +184,19 @@ func (im *imOperator) DeleteUser(username string) error {
 }
 
 func (im *imOperator) CreateUser(user *iamv1.User) (*iamv1.User, error) {
+	// the users without password are authenticated by the identity providers
+	if user.Spec.EncryptedPassword != "" {
+		if err := auth.ValidatePassword(im.passwordPolicy(), user.Spec.EncryptedPassword, nil); err != nil {
+			return nil, err
+		}
+		encrypted, err := im.hasher.Hash(user.Spec.EncryptedPassword)
+		if err != nil {
+			klog.Error(err)
+			return nil, err
+		}
+		user = user.DeepCopy()
+		user.Spec.EncryptedPassword = encrypted
+	}
 	user, err := im.aiClient.IamV1().Users().Create(context.Background(), user, metav1.CreateOptions{})
 	if err != nil {
 		klog.Error(err)
@@ -158,9 +222,17 @@ func (im *imOperator) ListLoginRecords(username string, q *query.Query) (*iamv1.
 	return list, nil
 }
 
+func (im *imOperator) passwordPolicy() *authoptions.PasswordPolicyOptions {
+	if im.options == nil || im.options.PasswordPolicy == nil {
+		return authoptions.NewPasswordPolicyOptions()
+	}
+	return im.options.PasswordPolicy
+}
+
 func ensurePasswordNotOutput(user *iamv1.User) *iamv1.User {
 	out := user.DeepCopy()
 	// ensure encrypted password will not be output
 	out.Spec.EncryptedPassword = ""
+	delete(out.Annotations, iamv1.PasswordHistoryAnnotation)
 	return out
 }
@@ -10,6 +10,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+
+	"k8s.io/klog"
 )
 
 const ansi = "[\u001B\u009B][[\\]()#;?]*(?:(?:(?:[a-zA-Z\\d]*(?:;[a-zA-Z\\d]*)*)?\u0007)|(?:(?:\\d{1,4}(?:;\\d{0,4})*)?[\\dA-PRZcf-ntqry=><~]))"
@@ -238,13 +240,17 @@ func AesDecrypt(cryted string, key string) string {
 	crytedByte, _ := base64.RawURLEncoding.DecodeString(cryted)
 	k := []byte(key)
 
-	// 分组秘钥
+	// 分组秘钥, 解密的内容来自请求, 无法解密时返回空字符串而不是 panic
 	block, err := aes.NewCipher(k)
 	if err != nil {
-		panic(fmt.Sprintf("key 长度必须 16/24/32长度: %s", err.Error()))
+		klog.Errorf("key 长度必须 16/24/32长度: %s", err.Error())
+		return ""
 	}
 	// 获取秘钥块的长度
 	blockSize := block.BlockSize()
+	if len(crytedByte) == 0 || len(crytedByte)%blockSize != 0 {
+		return ""
+	}
 	// 加密模式
 	blockMode := cipher.NewCBCDecrypter(block, k[:blockSize])
 	// 创建数组
@@ -267,5 +273,8 @@ func pkcs7Padding(ciphertext []byte, blocksize int) []byte {
 func pkcs7UnPadding(origData []byte) []byte {
 	length := len(origData)
 	unpadding := int(origData[length-1])
+	if unpadding > length {
+		return nil
+	}
 	return origData[:(length - unpadding)]
 }